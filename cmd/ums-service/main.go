@@ -37,6 +37,24 @@ func main() {
 		cancel()
 	}()
 
+	// SIGUSR1 and SIGUSR2 switch to UMS and normal mode without going
+	// through Redis, e.g. `systemctl kill -s USR1 ums-service`.
+	modeChan := make(chan os.Signal, 1)
+	signal.Notify(modeChan, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range modeChan {
+			enter, mode := svc.EnterUMS, "ums"
+			if sig == syscall.SIGUSR2 {
+				enter, mode = svc.EnterNormal, "normal"
+			}
+			log.Printf("Received %v, switching to %s mode", sig, mode)
+			if err := enter(ctx); err != nil {
+				log.Printf("Manual switch to %s mode failed: %v", mode, err)
+			}
+		}
+	}()
+
 	if err := svc.Run(ctx); err != nil {
 		log.Fatalf("Service error: %v", err)
 	}
@@ -6,10 +6,12 @@ require (
 	github.com/BurntSushi/toml v1.6.0
 	github.com/librescoot/redis-ipc v0.10.3
 	github.com/redis/go-redis/v9 v9.18.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 )
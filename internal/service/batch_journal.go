@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// batchJournalName is the file in the state directory that records the
+// progress of post-UMS processing. It exists from the moment processing
+// starts until the drive has been cleaned, so finding it at startup means
+// a reboot or crash cut a batch short; the drive image still holds the
+// unprocessed files.
+const batchJournalName = "batch"
+
+// saveBatch writes b to path via a temporary file, so an interruption
+// mid-write leaves the previous journal rather than a truncated one.
+func saveBatch(path string, b batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadBatch returns the journalled batch, or ok=false if there is none or
+// it can't be read.
+func loadBatch(path string) (batch, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return batch{}, false
+	}
+	var b batch
+	if err := json.Unmarshal(data, &b); err != nil {
+		return batch{}, false
+	}
+	return b, true
+}
+
+func clearBatch(path string) {
+	os.Remove(path)
+}
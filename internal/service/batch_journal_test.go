@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+func TestBatchJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ums-service", "batch")
+
+	if _, ok := loadBatch(path); ok {
+		t.Fatal("loadBatch found a batch before any was saved")
+	}
+
+	b := batch{
+		Done:           []string{"settings", "updates"},
+		Result:         cycleResult{WireGuard: true, Updates: true},
+		SettingsStaged: true,
+		Queued: update.Queued{
+			MDB:           true,
+			PendingPushes: []update.PendingPush{{Channel: "scooter:update", Value: "update-from-file:/data/ota/a.mender"}},
+		},
+	}
+	if err := saveBatch(path, b); err != nil {
+		t.Fatalf("saveBatch: %v", err)
+	}
+	got, ok := loadBatch(path)
+	if !ok || !reflect.DeepEqual(got, b) {
+		t.Errorf("loadBatch = %+v, %v; want %+v", got, ok, b)
+	}
+
+	clearBatch(path)
+	if _, ok := loadBatch(path); ok {
+		t.Error("batch still present after clearBatch")
+	}
+
+	if err := os.WriteFile(path, []byte("{truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadBatch(path); ok {
+		t.Error("loadBatch accepted a corrupt journal")
+	}
+}
+
+// TestRunProcessingResumesAfterInterruption simulates a reboot in the
+// middle of a batch: the goroutine running it dies inside the maps step.
+// A fresh run from the journal must skip what was done, redo maps, and
+// keep what the earlier steps found.
+func TestRunProcessingResumesAfterInterruption(t *testing.T) {
+	s, _, _ := newTestService()
+	journal := filepath.Join(t.TempDir(), "batch")
+
+	var ran []string
+	interrupt := true
+	s.procOrder = []string{"settings", "updates", "maps", "scripts"}
+	s.procSteps = map[string]processingStep{
+		"settings": func(c *normalCycle) {
+			ran = append(ran, "settings")
+			c.SettingsStaged = true
+		},
+		"updates": func(c *normalCycle) {
+			ran = append(ran, "updates")
+			c.Queued = update.Queued{DBC: true}
+			c.Result.Updates = true
+		},
+		"maps": func(c *normalCycle) {
+			ran = append(ran, "maps")
+			if interrupt {
+				runtime.Goexit()
+			}
+			c.Result.Maps = true
+		},
+		"scripts": func(*normalCycle) { ran = append(ran, "scripts") },
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runProcessing(&normalCycle{ctx: context.Background(), journal: journal})
+	}()
+	<-done
+
+	if want := []string{"settings", "updates", "maps"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("before interruption ran %v, want %v", ran, want)
+	}
+
+	b, ok := loadBatch(journal)
+	if !ok {
+		t.Fatal("no journal left by the interrupted batch")
+	}
+	if want := []string{"settings", "updates"}; !reflect.DeepEqual(b.Done, want) {
+		t.Errorf("journalled done = %v, want %v", b.Done, want)
+	}
+
+	ran = nil
+	interrupt = false
+	c := &normalCycle{ctx: context.Background(), journal: journal, batch: b}
+	s.runProcessing(c)
+
+	if want := []string{"maps", "scripts"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("after resume ran %v, want %v", ran, want)
+	}
+	if !c.SettingsStaged || !c.Queued.DBC || !c.Result.Updates || !c.Result.Maps {
+		t.Errorf("resumed batch lost earlier findings: %+v", c.batch)
+	}
+	if b, _ := loadBatch(journal); len(b.Done) != len(s.procOrder) {
+		t.Errorf("journal after resume has done = %v, want all steps", b.Done)
+	}
+}
+
+// TestRunProcessingStopsOnShutdown checks that a step cut short by the
+// service shutting down isn't journalled as done, and nothing after it
+// runs.
+func TestRunProcessingStopsOnShutdown(t *testing.T) {
+	s, _, _ := newTestService()
+	journal := filepath.Join(t.TempDir(), "batch")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ran []string
+	s.procOrder = []string{"settings", "maps", "scripts"}
+	s.procSteps = map[string]processingStep{
+		"settings": func(*normalCycle) { ran = append(ran, "settings") },
+		"maps": func(*normalCycle) {
+			ran = append(ran, "maps")
+			cancel()
+		},
+		"scripts": func(*normalCycle) { ran = append(ran, "scripts") },
+	}
+
+	c := &normalCycle{ctx: ctx, journal: journal}
+	s.runProcessing(c)
+
+	if want := []string{"settings", "maps"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if b, _ := loadBatch(journal); !reflect.DeepEqual(b.Done, []string{"settings"}) {
+		t.Errorf("journalled done = %v, want [settings]", b.Done)
+	}
+}
+
+func TestResumeBatchDiscardsUnreadableJournal(t *testing.T) {
+	s, _, _ := newTestService()
+	s.batchPath = filepath.Join(t.TempDir(), "batch")
+	if err := os.WriteFile(s.batchPath, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.resumeBatch() {
+		t.Error("resumeBatch resumed an unreadable journal")
+	}
+	if _, err := os.Stat(s.batchPath); !os.IsNotExist(err) {
+		t.Errorf("unreadable journal not removed: %v", err)
+	}
+}
@@ -8,20 +8,12 @@ import (
 
 func (s *Service) startBrakeExitListener() error {
 	_, err := ipc.Subscribe(s.client, "input-events", func(event string) error {
-		s.mu.Lock()
-		currentMode := s.usbCtrl.GetCurrentMode()
-		s.mu.Unlock()
-
-		if currentMode != "ums" {
+		if s.usbCtrl.GetCurrentMode() != "ums" {
 			return nil
 		}
 
 		log.Println("Left brake hold detected, exiting UMS mode")
-
-		s.mu.Lock()
-		s.doSwitchToNormal()
-		s.mu.Unlock()
-
+		s.modeQueue.push("normal")
 		return nil
 	})
 	return err
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// cycleResult records what a UMS session changed on the scooter, so
+// consumers of the completion event can react selectively (e.g. reload
+// only wireguard).
+type cycleResult struct {
+	Settings      bool `json:"settings"`
+	WireGuard     bool `json:"wireguard"`
+	RadioGaga     bool `json:"radio-gaga"`
+	Uplink        bool `json:"uplink-service"`
+	Onboot        bool `json:"onboot"`
+	Maps          bool `json:"maps"`
+	Updates       bool `json:"updates"`
+	RebootPending bool `json:"reboot-pending"`
+}
+
+// fields returns the flags as usb:result hash fields.
+func (r cycleResult) fields() map[string]any {
+	return map[string]any{
+		"settings":       strconv.FormatBool(r.Settings),
+		"wireguard":      strconv.FormatBool(r.WireGuard),
+		"radio-gaga":     strconv.FormatBool(r.RadioGaga),
+		"uplink-service": strconv.FormatBool(r.Uplink),
+		"onboot":         strconv.FormatBool(r.Onboot),
+		"maps":           strconv.FormatBool(r.Maps),
+		"updates":        strconv.FormatBool(r.Updates),
+		"reboot-pending": strconv.FormatBool(r.RebootPending),
+	}
+}
+
+// publishCycleResult replaces the usb:result hash once processing is
+// done. The hash's change notification is the completion event.
+func (s *Service) publishCycleResult(r cycleResult) {
+	fields := r.fields()
+	fields["completed-at"] = time.Now().Unix()
+	if err := s.resultPub.ReplaceAll(fields); err != nil {
+		log.Printf("Error publishing usb:result: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/disk"
+	"github.com/librescoot/ums-service/pkg/logbundles"
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+func TestPublishCycleResult(t *testing.T) {
+	s, _, _ := newTestService()
+	pub := s.resultPub.(*fakePublisher)
+
+	s.publishCycleResult(cycleResult{
+		WireGuard:     true,
+		Updates:       true,
+		RebootPending: true,
+	})
+
+	want := map[string]string{
+		"settings":       "false",
+		"wireguard":      "true",
+		"radio-gaga":     "false",
+		"uplink-service": "false",
+		"onboot":         "false",
+		"maps":           "false",
+		"updates":        "true",
+		"reboot-pending": "true",
+	}
+	for field, v := range want {
+		if got := pub.fields[field]; got != v {
+			t.Errorf("%s = %q, want %q", field, got, v)
+		}
+	}
+	if pub.fields["completed-at"] == "" {
+		t.Error("completed-at not set")
+	}
+
+	// A later session replaces, not merges, the previous result.
+	s.publishCycleResult(cycleResult{Maps: true})
+	if pub.fields["wireguard"] != "false" || pub.fields["maps"] != "true" {
+		t.Errorf("second result = %v", pub.fields)
+	}
+}
+
+// TestProcessDrivePublishesResult runs processing and checks that each
+// category's flag in usb:result says whether its step changed something.
+func TestProcessDrivePublishesResult(t *testing.T) {
+	s, _, _ := newTestService()
+	pub := s.resultPub.(*fakePublisher)
+	dir := t.TempDir()
+	mount := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mount, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.diskMgr = disk.NewManager(filepath.Join(dir, "usb.drive"), mount, 0, &commandtest.Recorder{})
+	s.updateLdr = update.New(nil, nil, filepath.Join(dir, "ota"))
+	s.logBundlesMgr = logbundles.New()
+	s.dryRun = true
+
+	changed := map[string]func(*cycleResult){
+		"wireguard": func(r *cycleResult) { r.WireGuard = true },
+		"onboot":    func(r *cycleResult) { r.Onboot = true },
+		"maps":      func(r *cycleResult) { r.Maps = true },
+	}
+	s.procOrder = defaultProcessingOrder
+	s.procSteps = make(map[string]processingStep)
+	for _, name := range s.procOrder {
+		set := changed[name]
+		s.procSteps[name] = func(c *normalCycle) {
+			if set != nil {
+				set(&c.Result)
+			}
+		}
+	}
+
+	s.processDrive(&normalCycle{ctx: context.Background(), mountPoint: mount, logger: umslog.New(nil)}, true)
+
+	want := map[string]string{
+		"settings":       "false",
+		"wireguard":      "true",
+		"radio-gaga":     "false",
+		"uplink-service": "false",
+		"onboot":         "true",
+		"maps":           "true",
+		"updates":        "false",
+		"reboot-pending": "false",
+	}
+	for field, v := range want {
+		if got := pub.fields[field]; got != v {
+			t.Errorf("%s = %q, want %q", field, got, v)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/fsutil"
+)
+
+// backupMarker at the drive root asks for a backup of /data, as does
+// backup_data in ums-manifest.toml.
+const backupMarker = "BACKUP_REQUESTED"
+
+// backupRequestName is the file in the state directory that remembers a
+// backup requested on the drive until UMS mode is next entered: processing
+// cleans the request off the drive, and the backup is only written when
+// the drive is prepared for the host.
+const backupRequestName = "backup-requested"
+
+// noteBackupRequest records a backup request found on the drive c
+// processes, so writeDataBackup honours it when UMS mode is next
+// entered.
+func (s *Service) noteBackupRequest(c *normalCycle) {
+	_, err := os.Stat(filepath.Join(c.mountPoint, backupMarker))
+	if err != nil && !c.plan.BackupRequested() {
+		return
+	}
+	if s.backupRequest == "" {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(s.backupRequest), 0755)
+	if err == nil {
+		err = fsutil.WriteFileAtomic(s.backupRequest, nil, 0644)
+	}
+	if err != nil {
+		c.logger.Error("backup", "failed to record request: %v", err)
+		log.Printf("Error recording backup request: %v", err)
+		return
+	}
+	c.logger.Logf("backup", "requested; written to backup/ the next time UMS mode is entered")
+	log.Println("Backup of /data requested")
+}
+
+// writeDataBackup writes a backup of /data to the drive if one was
+// requested, either during an earlier session or by a marker still on
+// the drive. The request is dropped once the backup is written.
+func (s *Service) writeDataBackup(mountPoint string) {
+	marker := filepath.Join(mountPoint, backupMarker)
+	_, markerErr := os.Stat(marker)
+	_, requestErr := os.Stat(s.backupRequest)
+	if markerErr != nil && (s.backupRequest == "" || requestErr != nil) {
+		return
+	}
+
+	path, err := s.dataBackup.ExportToUSB(mountPoint)
+	if err != nil {
+		// Keep the request, so the next session tries again.
+		log.Printf("Error writing backup of /data: %v", err)
+		return
+	}
+	log.Printf("Backup of /data written to %s", path)
+	os.Remove(marker)
+	if s.backupRequest != "" {
+		os.Remove(s.backupRequest)
+	}
+}
+
+// restoreDir is where the drive takes a backup archive to restore.
+const restoreDir = "restore"
+
+// restoreUnits are the services to restart when a path below /data is
+// restored.
+var restoreUnits = map[string]string{
+	"settings.toml":  settingsUnit,
+	"wireguard":      settingsUnit,
+	"radio-gaga":     "radio-gaga.service",
+	"uplink-service": "librescoot-uplink.service",
+}
+
+// restoreData restores the newest restore/data-*.tar.gz on the drive c
+// processes into /data, logging each file, and returns the units to
+// restart for what it restored. It runs after the other steps, so the
+// backup wins over copies of the same files the drive still holds.
+func (s *Service) restoreData(c *normalCycle) []string {
+	archives, _ := filepath.Glob(filepath.Join(c.mountPoint, restoreDir, "data-*.tar.gz"))
+	if len(archives) == 0 {
+		return nil
+	}
+	sort.Strings(archives)
+	archive := archives[len(archives)-1]
+	name := filepath.Join(restoreDir, filepath.Base(archive))
+	for _, other := range archives[:len(archives)-1] {
+		c.logger.Logf("restore", "ignoring %s, restoring the newer %s", filepath.Base(other), name)
+	}
+
+	restored, err := s.dataBackup.Restore(archive)
+	for _, file := range restored.Files {
+		c.logger.Logf("restore", "restored %s", file)
+	}
+	if err != nil {
+		c.logger.Error("restore", "%s: %v", name, err)
+		log.Printf("Error restoring %s: %v", name, err)
+	}
+	if len(restored.Paths) > 0 {
+		log.Printf("Restored %s from %s", strings.Join(restored.Paths, ", "), name)
+	}
+
+	var units []string
+	seen := make(map[string]bool)
+	for _, p := range restored.Paths {
+		if unit, ok := restoreUnits[p]; ok && !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+	return units
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/databackup"
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/umsmanifest"
+)
+
+func TestDataBackupRequest(t *testing.T) {
+	data := t.TempDir()
+	if err := os.WriteFile(filepath.Join(data, "settings.toml"), []byte("[scooter]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		marker bool
+		plan   *umsmanifest.Manifest
+		want   bool
+	}{
+		{name: "marker", marker: true, want: true},
+		{name: "manifest", plan: &umsmanifest.Manifest{Backup: true}, want: true},
+		{name: "not requested", plan: &umsmanifest.Manifest{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, rdb, _ := newTestService()
+			s.dataBackup = databackup.New(data)
+			s.backupRequest = filepath.Join(t.TempDir(), "ums-service", "backup-requested")
+			drive := t.TempDir()
+			if c.marker {
+				if err := os.WriteFile(filepath.Join(drive, backupMarker), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s.noteBackupRequest(&normalCycle{mountPoint: drive, logger: umslog.New(rdb), plan: c.plan})
+			if _, err := os.Stat(s.backupRequest); (err == nil) != c.want {
+				t.Fatalf("request recorded = %v, want %v", err == nil, c.want)
+			}
+
+			// The next UMS entry finds the drive cleaned.
+			next := t.TempDir()
+			s.writeDataBackup(next)
+			backups, _ := filepath.Glob(filepath.Join(next, "backup", "data-*.tar.gz"))
+			if (len(backups) == 1) != c.want {
+				t.Errorf("backups written = %v, want one: %v", backups, c.want)
+			}
+			if _, err := os.Stat(s.backupRequest); err == nil {
+				t.Error("request kept after the backup was written")
+			}
+		})
+	}
+}
+
+func TestRestoreData(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"settings.toml":      "[scooter]\nspeed_limit = 20\n",
+		"wireguard/wg0.conf": "[Interface]\n",
+	} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drive := t.TempDir()
+	archive, err := databackup.New(src).ExportToUSB(drive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(drive, restoreDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(drive, archive), filepath.Join(drive, restoreDir, filepath.Base(archive))); err != nil {
+		t.Fatal(err)
+	}
+
+	s, rdb, _ := newTestService()
+	dst := t.TempDir()
+	s.dataBackup = databackup.New(dst)
+	units := s.restoreData(&normalCycle{mountPoint: drive, logger: umslog.New(rdb)})
+
+	if len(units) != 1 || units[0] != settingsUnit {
+		t.Errorf("units = %v, want settings-service restarted once", units)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "settings.toml")); string(data) != "[scooter]\nspeed_limit = 20\n" {
+		t.Errorf("settings.toml = %q after restore", data)
+	}
+	logged := strings.Join(rdb.pushed("usb:log"), "\n")
+	for _, file := range []string{"settings.toml", "wireguard/wg0.conf"} {
+		if !strings.Contains(logged, "restored "+file) {
+			t.Errorf("usb:log doesn't mention %s:\n%s", file, logged)
+		}
+	}
+}
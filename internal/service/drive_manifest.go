@@ -0,0 +1,104 @@
+package service
+
+import (
+	"log"
+
+	"github.com/librescoot/ums-service/pkg/manifest"
+)
+
+// categoryAreas describes the drive area each processing category reads
+// from. Accepts must match what the category's processor picks up.
+var categoryAreas = map[string]manifest.Area{
+	"settings": {
+		Path:    "settings.toml",
+		Purpose: "Scooter settings; applied and settings-service restarted if changed",
+		Accepts: []string{"settings.toml"},
+	},
+	"wireguard": {
+		Path:    "wireguard",
+		Dir:     true,
+		Purpose: "WireGuard configs, optionally in profile subdirectories; synced to the scooter, configs missing here are removed",
+		Accepts: []string{"*.conf", "*.conf.tmpl"},
+	},
+	"radio-gaga": {
+		Path:    "radio-gaga",
+		Dir:     true,
+		Purpose: "radio-gaga telemetry config",
+		Accepts: []string{"config.yaml"},
+	},
+	"uplink-service": {
+		Path:    "uplink-service",
+		Dir:     true,
+		Purpose: "uplink-service config",
+		Accepts: []string{"config.yaml"},
+	},
+	"onboot": {
+		Path:    "onboot.sh",
+		Purpose: "Script run at every boot; installed if its syntax checks out",
+		Accepts: []string{"onboot.sh"},
+	},
+	"updates": {
+		Path:    "system-update",
+		Dir:     true,
+		Purpose: "Firmware updates for the MDB (-mdb) and DBC (-dbc)",
+		Accepts: []string{"librescoot-*.mender", "librescoot-*.delta", "*.sha256"},
+	},
+	"maps": {
+		Path:    "maps",
+		Dir:     true,
+		Purpose: "Navigation maps and routing tiles for the DBC",
+		Accepts: []string{"*.mbtiles", "*tiles.tar", "valhalla_tiles_*.tar"},
+	},
+	"rpms": {
+		Path:    "rpms",
+		Dir:     true,
+		Purpose: "Packages installed on the MDB (mdb/) or DBC (dbc/)",
+		Accepts: []string{"mdb/*.rpm", "dbc/*.rpm"},
+	},
+	"scripts": {
+		Path:    "scripts",
+		Dir:     true,
+		Purpose: "One-off scripts run on the MDB (mdb.sh) or DBC (dbc.sh)",
+		Accepts: []string{"mdb.sh", "dbc.sh"},
+	},
+}
+
+// exportAreas are written for the user to copy off; nothing in them is
+// read back.
+var exportAreas = []manifest.Area{
+	{Path: "log-bundles", Dir: true, Purpose: "Saved log bundles", ReadOnly: true},
+	{Path: "diagnostics", Dir: true, Purpose: "Diagnostics bundle (MDB and DBC logs, system info, settings, versions) captured when UMS mode was entered", ReadOnly: true},
+	{Path: "logs", Dir: true, Purpose: "Recent journal output for bug reports", ReadOnly: true},
+	{Path: "backup", Dir: true, Purpose: "Requested backups of /data, restorable with tar -xzf <file> -C /data", ReadOnly: true},
+	{Path: "ums_log.txt", Purpose: "Log of the last time the drive's contents were processed", ReadOnly: true},
+	{Path: "ums-result.json", Purpose: "Summary of the last time the drive's contents were processed: each step's outcome and files, queued updates and errors", ReadOnly: true},
+}
+
+// manifestAreas lists the drive areas in the order their categories are
+// processed, followed by the export-only ones. Categories without a
+// processing step aren't listed.
+func (s *Service) manifestAreas() []manifest.Area {
+	var areas []manifest.Area
+	for _, name := range s.procOrder {
+		if s.procSteps[name] == nil {
+			continue
+		}
+		if a, ok := categoryAreas[name]; ok {
+			areas = append(areas, a)
+		}
+	}
+	return append(areas, exportAreas...)
+}
+
+// writeDriveManifest writes manifest.json once the drive has been
+// prepared, so it lists what the host will see.
+func (s *Service) writeDriveManifest(mountPoint string) {
+	m, err := manifest.Build(mountPoint, s.manifestAreas(), s.checksumAlgo)
+	if err != nil {
+		log.Printf("Error building drive manifest: %v", err)
+		return
+	}
+	if err := manifest.Write(mountPoint, m); err != nil {
+		log.Printf("Error writing drive manifest: %v", err)
+	}
+}
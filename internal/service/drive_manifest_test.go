@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/manifest"
+)
+
+func TestCategoryAreasCoverProcessing(t *testing.T) {
+	for _, name := range defaultProcessingOrder {
+		if _, ok := categoryAreas[name]; !ok {
+			t.Errorf("no manifest area for category %q", name)
+		}
+	}
+	if len(categoryAreas) != len(defaultProcessingOrder) {
+		t.Errorf("%d manifest areas for %d categories", len(categoryAreas), len(defaultProcessingOrder))
+	}
+	for _, a := range append(areasOf(categoryAreas), exportAreas...) {
+		if !contains(driveCategories, a.Path) {
+			t.Errorf("manifest area %q isn't a drive category", a.Path)
+		}
+	}
+}
+
+func TestManifestAreasFollowProcessing(t *testing.T) {
+	s, _, _ := newTestService()
+	s.procOrder = []string{"maps", "settings", "updates"}
+	s.procSteps = map[string]processingStep{
+		"maps":     func(*normalCycle) {},
+		"settings": func(*normalCycle) {},
+		// updates has no step: disabled.
+	}
+
+	var got []string
+	for _, a := range s.manifestAreas() {
+		got = append(got, a.Path)
+	}
+	want := []string{"maps", "settings.toml"}
+	for _, a := range exportAreas {
+		want = append(want, a.Path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("areas = %v, want %v", got, want)
+	}
+}
+
+func TestWriteDriveManifest(t *testing.T) {
+	s, _, _ := newTestService()
+	s.procOrder = defaultProcessingOrder
+	s.procSteps = s.defaultProcessingSteps()
+	s.checksumAlgo = checksum.SHA256
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "system-update"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	update := filepath.Join(root, "system-update", "librescoot-unu-mdb-v1.0.0.mender")
+	if err := os.WriteFile(update, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.writeDriveManifest(root)
+
+	data, err := os.ReadFile(filepath.Join(root, manifest.FileName))
+	if err != nil {
+		t.Fatalf("no manifest written: %v", err)
+	}
+	var m manifest.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("manifest doesn't parse: %v", err)
+	}
+	if len(m.Areas) != len(defaultProcessingOrder)+len(exportAreas) {
+		t.Fatalf("%d areas, want %d", len(m.Areas), len(defaultProcessingOrder)+len(exportAreas))
+	}
+
+	want, err := checksum.SHA256.File(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range m.Areas {
+		switch a.Path {
+		case "system-update":
+			if len(a.Files) != 1 || a.Files[0].Name != filepath.Base(update) || a.Files[0].Size != 8 || a.Files[0].Hash != want {
+				t.Errorf("system-update files = %+v", a.Files)
+			}
+		default:
+			if len(a.Files) != 0 {
+				t.Errorf("%s: unexpected files %+v", a.Path, a.Files)
+			}
+		}
+	}
+}
+
+func areasOf(m map[string]manifest.Area) []manifest.Area {
+	var out []manifest.Area
+	for _, a := range m {
+		out = append(out, a)
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/disk"
+)
+
+const (
+	// driveAttempts is how often mounting or unmounting the drive around
+	// a mode switch is tried; the wait between tries starts at
+	// defaultDriveRetryDelay and doubles.
+	driveAttempts          = 3
+	defaultDriveRetryDelay = 250 * time.Millisecond
+)
+
+// retryDrive runs op, the mount or unmount that brackets a mode switch,
+// until it succeeds or driveAttempts is used up, and returns its last
+// error. Right after g_mass_storage is loaded or unloaded the image can
+// still be held for a moment, which fails the first try for no lasting
+// reason. A drive that was detached lazily isn't retried: the unmount
+// already waited for it and the mount point is free.
+func (s *Service) retryDrive(what string, op func() error) error {
+	delay := s.driveRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == driveAttempts || errors.Is(err, disk.ErrDriveBusy) {
+			return err
+		}
+		log.Printf("Warning: %s failed (attempt %d of %d), retrying in %s: %v", what, attempt, driveAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
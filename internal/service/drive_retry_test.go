@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/disk"
+)
+
+func TestRetryDrive(t *testing.T) {
+	transient := errors.New("mount: /data/usb.drive: failed to set up loop device")
+	busy := fmt.Errorf("failed to unmount drive: %w", disk.ErrDriveBusy)
+	cases := []struct {
+		name     string
+		errs     []error // returned by successive tries; nil after they run out
+		wantErr  error
+		wantRuns int
+	}{
+		{"first try", nil, nil, 1},
+		{"transient", []error{transient}, nil, 2},
+		{"persistent", []error{transient, transient, transient, transient}, transient, driveAttempts},
+		{"detached lazily", []error{busy}, busy, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _, _ := newTestService()
+			runs := 0
+			err := s.retryDrive("mounting drive", func() error {
+				runs++
+				if runs <= len(tc.errs) {
+					return tc.errs[runs-1]
+				}
+				return nil
+			})
+			if err != tc.wantErr {
+				t.Errorf("err = %v, want %v", err, tc.wantErr)
+			}
+			if runs != tc.wantRuns {
+				t.Errorf("ran %d times, want %d", runs, tc.wantRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/librescoot/ums-service/pkg/statusapi"
+)
+
+// readiness is the body of /readyz. The service is ready once the drive
+// image is initialized, for as long as Redis is reachable.
+type readiness struct {
+	Ready      bool   `json:"ready"`
+	Redis      bool   `json:"redis"`
+	Disk       bool   `json:"disk"`
+	Mode       string `json:"mode"`
+	DBCEnabled bool   `json:"dbc-enabled"`
+	LastError  string `json:"last-error,omitempty"`
+}
+
+// mountHealth adds /healthz and /readyz for systemd and fleet monitoring,
+// and /metrics for fleet analytics, to the status server. They answer
+// without Redis.
+func (s *Service) mountHealth(srv *statusapi.Server) {
+	srv.Handle("/healthz", s.handleHealthz)
+	srv.Handle("/readyz", s.handleReadyz)
+	srv.Handle("/metrics", s.handleMetrics)
+}
+
+// handleHealthz answers as long as the process does.
+func (s *Service) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports readiness, with 503 while not ready.
+func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	rd := readiness{
+		Redis:      !s.redisDown.Load(),
+		Disk:       s.diskReady.Load(),
+		Mode:       s.usbCtrl.GetCurrentMode(),
+		DBCEnabled: s.dbcInterface != nil && s.dbcInterface.IsEnabled(),
+		LastError:  s.ops.lastError(),
+	}
+	rd.Ready = rd.Redis && rd.Disk
+	code := http.StatusOK
+	if !rd.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthJSON(w, code, rd)
+}
+
+// handleMetrics reports the counters kept since the service started.
+func (s *Service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, s.metrics.Snapshot())
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Status server: write response: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/metrics"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name      string
+		diskReady bool
+		redisDown bool
+		opErr     error
+		wantCode  int
+		want      readiness
+	}{
+		{
+			name:     "starting",
+			wantCode: http.StatusServiceUnavailable,
+			want:     readiness{Redis: true, Mode: "normal"},
+		},
+		{
+			name:      "ready",
+			diskReady: true,
+			wantCode:  http.StatusOK,
+			want:      readiness{Ready: true, Redis: true, Disk: true, Mode: "normal"},
+		},
+		{
+			name:      "redis lost",
+			diskReady: true,
+			redisDown: true,
+			wantCode:  http.StatusServiceUnavailable,
+			want:      readiness{Disk: true, Mode: "normal"},
+		},
+		{
+			name:      "last switch failed",
+			diskReady: true,
+			opErr:     errors.New("failed to mount drive"),
+			wantCode:  http.StatusOK,
+			want:      readiness{Ready: true, Redis: true, Disk: true, Mode: "normal", LastError: "failed to mount drive"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService()
+			s.diskReady.Store(tt.diskReady)
+			s.redisDown.Store(tt.redisDown)
+			if tt.opErr != nil {
+				s.ops.fail(tt.opErr)
+			}
+			rec := httptest.NewRecorder()
+			s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			var got readiness
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s, _, _ := newTestService()
+	s.metrics.UMSEntries.Inc()
+	s.metrics.MapsTransferred.Add(2)
+	s.noteError("maps", "DBC storage full")
+	s.endSwitch(errors.New("failed to mount drive"))
+	// Errors in usb:log outside processing count too.
+	s.newLogger().Error("updates", "mdb install failed")
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("code = %d, want 200", rec.Code)
+	}
+	var got metrics.Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UMSEntries != 1 || got.MapsTransferred != 2 || got.NormalReturns != 0 {
+		t.Errorf("counters = %+v", got)
+	}
+	want := map[string]int64{"maps": 1, "mode-switch": 1, "updates": 1}
+	if !reflect.DeepEqual(got.Failures, want) {
+		t.Errorf("failures = %v, want %v", got.Failures, want)
+	}
+}
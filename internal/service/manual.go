@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	ipc "github.com/librescoot/redis-ipc"
+)
+
+// EnterUMS switches to UMS mode as if "ums" had been written to the usb
+// hash, for diagnosing the scooter when Redis itself is the problem. It
+// takes the same lock as requests from Redis, so the two never overlap;
+// ctx bounds only the wait for a switch already in progress. Redis
+// being down doesn't stop it, though a mode precondition that needs
+// Redis does.
+func (s *Service) EnterUMS(ctx context.Context) error {
+	return s.enterMode(ctx, "ums")
+}
+
+// EnterNormal switches back to normal mode, processing the drive if it
+// was in UMS mode; see EnterUMS.
+func (s *Service) EnterNormal(ctx context.Context) error {
+	return s.enterMode(ctx, "normal")
+}
+
+func (s *Service) enterMode(ctx context.Context, mode string) error {
+	if !s.watching.Load() {
+		return errors.New("service not running")
+	}
+	if !s.lockWithin(ctx) {
+		return fmt.Errorf("mode switch still in progress: %w", ctx.Err())
+	}
+	defer s.mu.Unlock()
+
+	log.Printf("Manual mode change to %s", mode)
+	if err := s.applyModeChangeLocked(mode); err != nil {
+		return err
+	}
+	// The usb hash still holds the mode from before; left alone, the
+	// resync after Redis comes back would switch straight back to it.
+	// The switch to normal publishes itself.
+	if mode != "normal" {
+		if err := s.publisher.Set("mode", mode, ipc.Sync()); err != nil {
+			log.Printf("Error updating Redis usb mode: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnterMode(t *testing.T) {
+	t.Run("not running", func(t *testing.T) {
+		s, _, _ := newTestService()
+		if err := s.EnterUMS(context.Background()); err == nil {
+			t.Error("EnterUMS succeeded before Run")
+		}
+	})
+
+	t.Run("switch in progress", func(t *testing.T) {
+		s, _, _ := newTestService()
+		s.watching.Store(true)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := s.EnterNormal(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("EnterNormal = %v, want a timeout", err)
+		}
+	})
+
+	t.Run("same policy as Redis", func(t *testing.T) {
+		s, _, pub := newTestService()
+		s.watching.Store(true)
+		policy, err := parseModePolicy("normal", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.modePolicy = policy
+		if err := s.EnterUMS(context.Background()); err == nil || !strings.Contains(err.Error(), "not permitted") {
+			t.Errorf("EnterUMS = %v, want not permitted", err)
+		}
+		if got := pub.fields["mode"]; got != "normal" {
+			t.Errorf("usb mode = %q, want normal", got)
+		}
+	})
+
+	t.Run("already normal", func(t *testing.T) {
+		s, _, pub := newTestService()
+		s.watching.Store(true)
+		if err := s.EnterNormal(context.Background()); err != nil {
+			t.Errorf("EnterNormal: %v", err)
+		}
+		if got := pub.history(); len(got) != 0 {
+			t.Errorf("published %v for a no-op", got)
+		}
+	})
+}
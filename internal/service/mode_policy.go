@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// knownModes are the values handleModeChange can act on.
+var knownModes = []string{"ums", "ums-by-dbc", "ums-ro", "normal"}
+
+// precondition is a Redis hash field that must hold a given value before
+// a UMS mode is entered, e.g. a keycard being present.
+type precondition struct {
+	hash, field, value string
+}
+
+func (p precondition) String() string {
+	return fmt.Sprintf("%s.%s=%s", p.hash, p.field, p.value)
+}
+
+// modePolicy decides which modes Redis may request. The zero value
+// permits everything.
+type modePolicy struct {
+	// allowed is nil when no allowlist is configured. normal is always
+	// permitted: a detach or brake exit is applied as a request for it.
+	allowed map[string]bool
+	// precondition gates the UMS modes only; returning to normal must
+	// never depend on it.
+	precondition *precondition
+}
+
+// parseModePolicy builds a policy from a comma-separated allowlist (empty
+// allows all known modes) and an optional "hash.field=value" precondition.
+func parseModePolicy(allowed, cond string) (modePolicy, error) {
+	var p modePolicy
+	if strings.TrimSpace(allowed) != "" {
+		p.allowed = make(map[string]bool)
+		for _, m := range strings.Split(allowed, ",") {
+			m = strings.TrimSpace(m)
+			if m == "" {
+				continue
+			}
+			if !isKnownMode(m) {
+				return modePolicy{}, fmt.Errorf("unknown mode %q in allowlist", m)
+			}
+			p.allowed[m] = true
+		}
+	}
+
+	if cond = strings.TrimSpace(cond); cond != "" {
+		key, value, ok := strings.Cut(cond, "=")
+		hash, field, fok := splitHashField(key)
+		if !ok || !fok {
+			return modePolicy{}, fmt.Errorf("precondition %q: expected hash.field=value", cond)
+		}
+		p.precondition = &precondition{hash: hash, field: field, value: value}
+	}
+	return p, nil
+}
+
+// splitHashField splits "hash.field" at the last dot, since hash names
+// commonly contain colons and dots are rare in field names.
+func splitHashField(s string) (hash, field string, ok bool) {
+	dot := strings.LastIndex(s, ".")
+	if dot <= 0 || dot == len(s)-1 {
+		return "", "", false
+	}
+	return s[:dot], s[dot+1:], true
+}
+
+func isKnownMode(mode string) bool {
+	for _, m := range knownModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// permit returns an error explaining why mode may not be entered, or nil.
+func (p modePolicy) permit(mode string, rdb redisClient) error {
+	if p.allowed != nil && !p.allowed[mode] && mode != "normal" {
+		return fmt.Errorf("mode %q is not in the allowlist", mode)
+	}
+	if p.precondition == nil || mode == "normal" {
+		return nil
+	}
+	got, err := rdb.HGet(p.precondition.hash, p.precondition.field)
+	if err != nil {
+		return fmt.Errorf("precondition %s: %w", p.precondition, err)
+	}
+	if got != p.precondition.value {
+		return fmt.Errorf("precondition %s not met (got %q)", p.precondition, got)
+	}
+	return nil
+}
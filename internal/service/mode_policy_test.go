@@ -0,0 +1,104 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseModePolicy(t *testing.T) {
+	cases := []struct {
+		allowed, cond string
+		wantErr       bool
+	}{
+		{"", "", false},
+		{"normal", "", false},
+		{" ums , normal ", "", false},
+		{"ums,bogus", "", true},
+		{"", "keycard.present=true", false},
+		{"", "scooter:keycard.state=present", false},
+		{"", "keycard=true", true},
+		{"", "keycard.present", true},
+		{"", ".present=true", true},
+	}
+	for _, c := range cases {
+		_, err := parseModePolicy(c.allowed, c.cond)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseModePolicy(%q, %q) err = %v, wantErr %v", c.allowed, c.cond, err, c.wantErr)
+		}
+	}
+}
+
+func TestModePolicyPermit(t *testing.T) {
+	cases := []struct {
+		name      string
+		allowed   string
+		cond      string
+		keycard   string
+		permitted map[string]bool
+	}{
+		{
+			name:      "default allows all",
+			permitted: map[string]bool{"ums": true, "ums-by-dbc": true, "normal": true},
+		},
+		{
+			name:      "ums disabled",
+			allowed:   "normal",
+			permitted: map[string]bool{"ums": false, "ums-by-dbc": false, "normal": true},
+		},
+		{
+			name:      "normal not listed",
+			allowed:   "ums",
+			permitted: map[string]bool{"ums": true, "ums-by-dbc": false, "normal": true},
+		},
+		{
+			name:      "keycard present",
+			cond:      "keycard.present=true",
+			keycard:   "true",
+			permitted: map[string]bool{"ums": true, "ums-by-dbc": true, "normal": true},
+		},
+		{
+			name:      "keycard absent",
+			cond:      "keycard.present=true",
+			keycard:   "",
+			permitted: map[string]bool{"ums": false, "ums-by-dbc": false, "normal": true},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := parseModePolicy(c.allowed, c.cond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdb := newFakeRedis()
+			if c.keycard != "" {
+				rdb.HSet("keycard", "present", c.keycard)
+			}
+			for mode, want := range c.permitted {
+				if got := p.permit(mode, rdb) == nil; got != want {
+					t.Errorf("permit(%s) = %v, want %v", mode, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyModeChangeRefused(t *testing.T) {
+	s, _, pub := newTestService()
+	p, err := parseModePolicy("normal", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.modePolicy = p
+
+	err = s.applyModeChange("ums")
+	if err == nil || !strings.Contains(err.Error(), "not permitted") {
+		t.Fatalf("applyModeChange(ums) = %v, want not permitted", err)
+	}
+	want := []string{"status=mode-not-permitted", "mode=normal"}
+	if got := pub.history(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("writes = %v, want %v", got, want)
+	}
+	if s.usbCtrl.GetCurrentMode() != "normal" {
+		t.Errorf("gadget mode changed to %s", s.usbCtrl.GetCurrentMode())
+	}
+}
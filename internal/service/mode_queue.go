@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// modeQueue is a bounded queue of requested modes between the hash
+// watcher and the single worker that applies them. Only the latest
+// requested state matters, so when the queue is full the oldest pending
+// request is dropped instead of blocking the watcher.
+type modeQueue struct {
+	mu sync.Mutex
+	ch chan string
+}
+
+func newModeQueue(size int) *modeQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &modeQueue{ch: make(chan string, size)}
+}
+
+// push enqueues mode without blocking and reports whether an older
+// request had to be dropped to make room.
+func (q *modeQueue) push(mode string) (dropped bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		select {
+		case q.ch <- mode:
+			return dropped
+		default:
+		}
+		select {
+		case old := <-q.ch:
+			log.Printf("Mode queue full, dropping pending %q in favour of %q", old, mode)
+			dropped = true
+		default:
+			// The worker drained it between our two selects; retry.
+		}
+	}
+}
+
+// runModeWorker applies queued modes one at a time until ctx is done.
+func runModeWorker(ctx context.Context, q *modeQueue, apply func(string) error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case mode := <-q.ch:
+			if err := apply(mode); err != nil {
+				log.Printf("Mode change to %q failed: %v", mode, err)
+			}
+		}
+	}
+}
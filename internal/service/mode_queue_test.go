@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestModeQueueCoalesces(t *testing.T) {
+	q := newModeQueue(2)
+	for i := 0; i < 1000; i++ {
+		q.push(fmt.Sprintf("mode-%d", i))
+	}
+	if len(q.ch) != 2 {
+		t.Fatalf("queued %d, want 2", len(q.ch))
+	}
+	if got := <-q.ch; got != "mode-998" {
+		t.Errorf("first = %q, want mode-998", got)
+	}
+	if got := <-q.ch; got != "mode-999" {
+		t.Errorf("second = %q, want mode-999", got)
+	}
+}
+
+func TestModeWorkerFlood(t *testing.T) {
+	q := newModeQueue(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var applied []string
+	done := make(chan struct{}, 16)
+	apply := func(mode string) error {
+		if mode == "first" {
+			<-release
+		}
+		mu.Lock()
+		applied = append(applied, mode)
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}
+	go runModeWorker(ctx, q, apply)
+
+	q.push("first")
+	// Wait for the worker to pick up "first" and block in apply.
+	for len(q.ch) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A flood while the worker is busy: handlers never block, and only
+	// the latest request survives.
+	for i := 0; i < 10000; i++ {
+		q.push(fmt.Sprintf("mode-%d", i))
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("worker did not finish")
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("worker applied more requests than the queue can hold")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"first", "mode-9999"}
+	if fmt.Sprint(applied) != fmt.Sprint(want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+}
+
+func TestHandleModeChangeQueues(t *testing.T) {
+	s, _, pub := newTestService()
+	s.modeQueue = newModeQueue(1)
+
+	if err := s.handleModeChange("bogus"); err != nil {
+		t.Fatalf("handleModeChange: %v", err)
+	}
+	if got := <-s.modeQueue.ch; got != "bogus" {
+		t.Errorf("queued %q", got)
+	}
+	if len(pub.history()) != 0 {
+		t.Error("handleModeChange acted on the request itself")
+	}
+}
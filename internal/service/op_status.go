@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	ipc "github.com/librescoot/redis-ipc"
+)
+
+// Operation states published in usb:status.
+const (
+	opMounting  = "mounting"
+	opCopying   = "copying"
+	opSwitching = "switching"
+	opDone      = "done"
+	opError     = "error"
+)
+
+// opStatus publishes how the mode switch in flight is going to the
+// usb:status hash: state, last-error and updated-at. Every write also
+// publishes on the usb:status channel, so a dashboard can re-read the
+// hash instead of polling. last-error is cleared when an operation
+// begins and keeps the latest failure until the next one, including
+// failures the switch only logs and carries on past; a switch that
+// returns an error ends in state=error.
+type opStatus struct {
+	pub hashPublisher
+	now func() time.Time
+
+	mu      sync.Mutex
+	state   string
+	lastErr string
+}
+
+func newOpStatus(pub hashPublisher) *opStatus {
+	return &opStatus{pub: pub, now: time.Now}
+}
+
+// begin starts a new operation in state, clearing the previous error.
+func (o *opStatus) begin(state string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.state, o.lastErr = state, ""
+	o.publish()
+}
+
+func (o *opStatus) set(state string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.state = state
+	o.publish()
+}
+
+// noteError records a failure the operation carries on past.
+func (o *opStatus) noteError(category, msg string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lastErr = fmt.Sprintf("%s: %s", category, msg)
+	o.publish()
+}
+
+// fail ends the operation with err.
+func (o *opStatus) fail(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.state, o.lastErr = opError, err.Error()
+	o.publish()
+}
+
+// lastError returns the latest failure, or "" if the current operation
+// has had none.
+func (o *opStatus) lastError() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.lastErr
+}
+
+// end finishes the operation: done, or error if err is set.
+func (o *opStatus) end(err error) {
+	if err != nil {
+		o.fail(err)
+		return
+	}
+	o.set(opDone)
+}
+
+// setRebootRequired flags in usb:status that a reboot of target ("mdb"
+// or "dbc") is owed and left to another component; "" clears the flag.
+func (o *opStatus) setRebootRequired(target string) {
+	required := ""
+	if target != "" {
+		required = "1"
+	}
+	if err := o.pub.SetMany(map[string]any{
+		"reboot-required": required,
+		"reboot-target":   target,
+		"updated-at":      o.now().Unix(),
+	}, ipc.Sync()); err != nil {
+		log.Printf("Error publishing usb:status: %v", err)
+	}
+}
+
+func (o *opStatus) publish() {
+	if err := o.pub.SetMany(map[string]any{
+		"state":      o.state,
+		"last-error": o.lastErr,
+		"updated-at": o.now().Unix(),
+	}, ipc.Sync()); err != nil {
+		log.Printf("Error publishing usb:status: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+)
+
+func TestOpStatus(t *testing.T) {
+	pub := newFakePublisher()
+	o := newOpStatus(pub)
+	o.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	check := func(step, state, lastErr string) {
+		t.Helper()
+		if pub.fields["state"] != state || pub.fields["last-error"] != lastErr || pub.fields["updated-at"] != "1700000000" {
+			t.Errorf("%s: usb:status = %v, want state=%q last-error=%q", step, pub.fields, state, lastErr)
+		}
+	}
+
+	o.begin(opSwitching)
+	check("begin", opSwitching, "")
+
+	// Failures the switch logs and carries on past still surface.
+	logger := umslog.New(nil)
+	logger.OnError(o.noteError)
+	o.set(opCopying)
+	logger.Error("maps", "transfer failed")
+	check("logged error", opCopying, "maps: transfer failed")
+
+	o.end(nil)
+	check("done", opDone, "maps: transfer failed")
+
+	o.begin(opMounting)
+	check("next operation", opMounting, "")
+
+	o.end(errors.New("failed to mount drive"))
+	check("failed", opError, "failed to mount drive")
+}
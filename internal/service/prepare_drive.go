@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// prepareSteps put the scooter's configs and the managed directories on
+// a freshly mounted drive. Each touches only its own directory or file at
+// the drive root, so they can run at once; each is best-effort and logs
+// its own failures.
+func (s *Service) prepareSteps() []func(mountPoint string) error {
+	return []func(string) error{
+		func(mp string) error {
+			return bestEffort("copying settings to USB", s.settingsLdr.CopyToUSB(mp))
+		},
+		func(mp string) error {
+			return bestEffort("preparing update directory", s.updateLdr.PrepareUSB(mp))
+		},
+		func(mp string) error {
+			return bestEffort("preparing maps directory", s.mapsUpdater.PrepareUSB(mp))
+		},
+		func(mp string) error {
+			return errors.Join(
+				bestEffort("preparing wireguard directory", s.wgManager.PrepareUSB(mp)),
+				bestEffort("copying wireguard configs to USB", s.wgManager.CopyToUSB(mp)))
+		},
+		func(mp string) error {
+			return errors.Join(
+				bestEffort("preparing radio-gaga directory", s.radioGagaMgr.PrepareUSB(mp)),
+				bestEffort("copying radio-gaga config to USB", s.radioGagaMgr.CopyToUSB(mp)))
+		},
+		func(mp string) error {
+			return errors.Join(
+				bestEffort("preparing uplink-service directory", s.uplinkMgr.PrepareUSB(mp)),
+				bestEffort("copying uplink-service config to USB", s.uplinkMgr.CopyToUSB(mp)))
+		},
+		func(mp string) error {
+			return bestEffort("copying onboot.sh to USB", s.onbootMgr.CopyToUSB(mp))
+		},
+		func(mp string) error {
+			return errors.Join(
+				bestEffort("preparing log-bundles directory", s.logBundlesMgr.PrepareUSB(mp)),
+				bestEffort("copying log bundles to USB", s.logBundlesMgr.CopyToUSB(mp)))
+		},
+	}
+}
+
+// bestEffort logs err, if any, as an error while what was being done,
+// and returns it saying so.
+func bestEffort(what string, err error) error {
+	if err == nil {
+		return nil
+	}
+	log.Printf("Error %s: %v", what, err)
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// runPrepareSteps runs steps on the drive at mountPoint concurrently and
+// returns once every one of them has, so nothing is still writing when
+// the drive is unmounted. A failing step doesn't stop the others; the
+// failures are returned joined.
+func runPrepareSteps(mountPoint string, steps []func(mountPoint string) error) error {
+	errs := make([]error, len(steps))
+	var wg sync.WaitGroup
+	for n, step := range steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[n] = step(mountPoint)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRunPrepareSteps checks the steps run at once, all of them even when
+// one fails, and that the call returns only once every step is done.
+func TestRunPrepareSteps(t *testing.T) {
+	// Each of the first two steps waits for the other to start, which
+	// they only both do if they run concurrently.
+	a, b := make(chan struct{}), make(chan struct{})
+	rendezvous := func(mine, theirs chan struct{}) func(string) error {
+		return func(string) error {
+			close(mine)
+			select {
+			case <-theirs:
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("ran alone")
+			}
+		}
+	}
+	var finished atomic.Int32
+	slow := func(string) error {
+		time.Sleep(50 * time.Millisecond)
+		finished.Add(1)
+		return nil
+	}
+	failing := func(string) error {
+		return bestEffort("preparing maps directory", errors.New("read-only file system"))
+	}
+
+	err := runPrepareSteps(t.TempDir(), []func(string) error{
+		rendezvous(a, b), rendezvous(b, a), failing, slow,
+	})
+	if err == nil || !strings.Contains(err.Error(), "preparing maps directory: read-only file system") {
+		t.Fatalf("err = %v, want the failing step's error", err)
+	}
+	if strings.Contains(err.Error(), "ran alone") {
+		t.Errorf("steps ran one after another: %v", err)
+	}
+	if finished.Load() != 1 {
+		t.Error("returned before the slow step finished")
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/umsmanifest"
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+// defaultProcessingOrder is the order switchToNormal applies the drive's
+// contents in unless UMS_PROCESSING_ORDER says otherwise.
+var defaultProcessingOrder = []string{
+	"settings",
+	"wireguard",
+	"radio-gaga",
+	"uplink-service",
+	"onboot",
+	"updates",
+	"maps",
+	"rpms",
+	"scripts",
+}
+
+// normalCycle carries what the processing steps of one switchToNormal
+// found, for the restarts, reboot and result that follow.
+type normalCycle struct {
+	ctx        context.Context
+	mountPoint string
+	logger     *umslog.Logger
+	// plan is the drive's ums-manifest.toml, nil if it has none.
+	plan *umsmanifest.Manifest
+	// journal, if set, is where batch is persisted after every step.
+	journal string
+	batch
+}
+
+// batch is the part of a normalCycle that survives an interruption: the
+// steps already run and what they found.
+type batch struct {
+	Done           []string      `json:"done"`
+	Result         cycleResult   `json:"result"`
+	SettingsStaged bool          `json:"settings-staged"`
+	Queued         update.Queued `json:"queued"`
+	UpdatesFailed  bool          `json:"updates-failed"`
+	Errors         []cycleError  `json:"errors"`
+}
+
+func (b *batch) done(name string) bool {
+	for _, d := range b.Done {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+type processingStep func(c *normalCycle)
+
+// parseProcessingOrder parses a comma-separated list of categories. It
+// must name every category exactly once; empty means the default order.
+func parseProcessingOrder(raw string) ([]string, error) {
+	order := splitList(raw)
+	if len(order) == 0 {
+		return defaultProcessingOrder, nil
+	}
+
+	known := make(map[string]bool, len(defaultProcessingOrder))
+	for _, name := range defaultProcessingOrder {
+		known[name] = true
+	}
+	seen := make(map[string]bool, len(order))
+	for _, name := range order {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown category %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("category %q listed twice", name)
+		}
+		seen[name] = true
+	}
+	var missing []string
+	for _, name := range defaultProcessingOrder {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing categories: %s", strings.Join(missing, ", "))
+	}
+	return order, nil
+}
+
+func (s *Service) defaultProcessingSteps() map[string]processingStep {
+	return map[string]processingStep{
+		"settings":       s.processSettings,
+		"wireguard":      s.processWireGuard,
+		"radio-gaga":     s.processRadioGaga,
+		"uplink-service": s.processUplink,
+		"onboot":         s.processOnboot,
+		"updates":        s.processUpdates,
+		"maps":           s.processMaps,
+		"rpms":           s.processRPMs,
+		"scripts":        s.processScripts,
+	}
+}
+
+// runProcessing runs each category's step in the configured order,
+// skipping those a resumed batch already ran, and journals progress.
+func (s *Service) runProcessing(c *normalCycle) {
+	for _, name := range s.procOrder {
+		if c.done(name) {
+			continue
+		}
+		if !c.plan.Enabled(name) {
+			c.logger.Logf(name, "skipped (disabled in %s)", umsmanifest.FileName)
+			continue
+		}
+		s.setStep(name)
+		s.procSteps[name](c)
+		if c.ctx.Err() != nil {
+			// Shutting down: the step may have been cut short, so it
+			// isn't journalled as done and runs again on resume.
+			return
+		}
+		c.Done = append(c.Done, name)
+		if c.journal != "" {
+			if err := saveBatch(c.journal, c.batch); err != nil {
+				log.Printf("Warning: failed to journal batch progress: %v", err)
+			}
+		}
+	}
+}
+
+// readPlan loads the drive's ums-manifest.toml into c and logs what it
+// switches on and off. A manifest that can't be read is reported and
+// ignored, so the drive is processed as if it had none.
+func (s *Service) readPlan(c *normalCycle) {
+	plan, err := umsmanifest.Read(c.mountPoint)
+	if err != nil {
+		c.logger.Error("manifest", "%v; processing everything found", err)
+		log.Printf("Error reading %s: %v", umsmanifest.FileName, err)
+		return
+	}
+	if plan == nil {
+		return
+	}
+	c.plan = plan
+	enabled, disabled := plan.Summary()
+	c.logger.Logf("manifest", "enabled: %s; disabled: %s", listOrNone(enabled), listOrNone(disabled))
+	if files := plan.MapFiles(); files != nil {
+		c.logger.Logf("manifest", "maps limited to: %s", listOrNone(files))
+	}
+	if mode := plan.SettingsMode(); mode != "" {
+		c.logger.Logf("manifest", "settings mode: %s", mode)
+	}
+	log.Printf("%s: enabled %s, disabled %s", umsmanifest.FileName, listOrNone(enabled), listOrNone(disabled))
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func (s *Service) processSettings(c *normalCycle) {
+	var changed bool
+	var err error
+	if mode := c.plan.SettingsMode(); mode != "" {
+		changed, err = s.settingsLdr.StageFromUSB(c.mountPoint, mode)
+	} else {
+		changed, err = s.settingsLdr.CopyFromUSB(c.mountPoint)
+	}
+	if err != nil {
+		c.logger.Error("settings", "%v", err)
+		log.Printf("Error processing settings: %v", err)
+	} else {
+		c.logger.Logf("settings", "done (changed=%v)", changed)
+		c.SettingsStaged = changed
+	}
+}
+
+func (s *Service) processWireGuard(c *normalCycle) {
+	if changed, err := s.wgManager.SyncFromUSB(c.mountPoint); err != nil {
+		c.logger.Error("wireguard", "%v", err)
+		log.Printf("Error processing wireguard configs: %v", err)
+	} else {
+		c.logger.Logf("wireguard", "done (changed=%v)", changed)
+		c.Result.WireGuard = changed
+	}
+}
+
+func (s *Service) processRadioGaga(c *normalCycle) {
+	if changed, err := s.radioGagaMgr.CopyFromUSB(c.mountPoint); err != nil {
+		c.logger.Error("radio-gaga", "%v", err)
+		log.Printf("Error processing radio-gaga config: %v", err)
+	} else {
+		c.logger.Logf("radio-gaga", "done (changed=%v)", changed)
+		c.Result.RadioGaga = changed
+	}
+}
+
+func (s *Service) processUplink(c *normalCycle) {
+	if changed, err := s.uplinkMgr.CopyFromUSB(c.mountPoint); err != nil {
+		c.logger.Error("uplink-service", "%v", err)
+		log.Printf("Error processing uplink-service config: %v", err)
+	} else {
+		c.logger.Logf("uplink-service", "done (changed=%v)", changed)
+		c.Result.Uplink = changed
+	}
+}
+
+func (s *Service) processOnboot(c *normalCycle) {
+	if changed, err := s.onbootMgr.CopyFromUSB(c.mountPoint); err != nil {
+		c.logger.Error("onboot", "%v", err)
+		log.Printf("Error processing onboot.sh: %v", err)
+	} else {
+		c.logger.Logf("onboot", "done (changed=%v)", changed)
+		c.Result.Onboot = changed
+	}
+}
+
+func (s *Service) processUpdates(c *normalCycle) {
+	queued, err := s.updateLdr.ProcessUpdates(c.ctx, s.config.MenderTransferTimeout, c.logger, c.mountPoint)
+	if err != nil {
+		c.logger.Error("updates", "%v", err)
+		log.Printf("Error processing updates: %v", err)
+		reportDBCDiskFull(c.logger, err)
+	} else {
+		c.logger.Logf("updates", "done")
+	}
+	c.Queued, c.UpdatesFailed = queued, err != nil
+	c.Result.Updates = queued.MDB || queued.DBC
+	c.logger.ClearProgress()
+}
+
+func (s *Service) processMaps(c *normalCycle) {
+	transferred, err := s.mapsUpdater.ProcessMaps(c.ctx, s.config.MapTransferTimeout, c.logger, c.mountPoint, c.plan.MapFiles())
+	if err != nil {
+		c.logger.Error("maps", "%v", err)
+		log.Printf("Error processing maps: %v", err)
+		reportDBCDiskFull(c.logger, err)
+	} else {
+		c.logger.Logf("maps", "done")
+	}
+	c.Result.Maps = transferred
+	c.logger.ClearProgress()
+}
+
+func (s *Service) processRPMs(c *normalCycle) {
+	if err := s.rpmInstaller.ProcessRPMs(c.ctx, s.config.RPMTransferTimeout, c.logger, c.mountPoint); err != nil {
+		c.logger.Error("rpms", "%v", err)
+		log.Printf("Error processing RPMs: %v", err)
+	} else {
+		c.logger.Logf("rpms", "done")
+	}
+	c.logger.ClearProgress()
+}
+
+func (s *Service) processScripts(c *normalCycle) {
+	if err := s.scriptRunner.ProcessScripts(c.ctx, s.config.ScriptTransferTimeout, c.logger, c.mountPoint); err != nil {
+		c.logger.Error("scripts", "%v", err)
+		log.Printf("Error processing scripts: %v", err)
+	}
+	c.logger.ClearProgress()
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/umsmanifest"
+)
+
+func TestParseProcessingOrder(t *testing.T) {
+	all := strings.Join(defaultProcessingOrder, ",")
+
+	if got, err := parseProcessingOrder(""); err != nil || !reflect.DeepEqual(got, defaultProcessingOrder) {
+		t.Errorf("empty: got %v, %v; want the default order", got, err)
+	}
+
+	custom := "updates, settings,wireguard,radio-gaga,uplink-service,onboot,rpms,scripts,maps"
+	want := []string{"updates", "settings", "wireguard", "radio-gaga", "uplink-service", "onboot", "rpms", "scripts", "maps"}
+	if got, err := parseProcessingOrder(custom); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("custom: got %v, %v; want %v", got, err, want)
+	}
+
+	invalid := map[string]string{
+		"unknown category": all + ",firmware",
+		"duplicate":        all + ",maps",
+		"missing category": strings.TrimSuffix(all, ",scripts"),
+	}
+	for name, raw := range invalid {
+		if _, err := parseProcessingOrder(raw); err == nil {
+			t.Errorf("%s: %q accepted", name, raw)
+		}
+	}
+}
+
+func TestRunProcessingHonorsOrder(t *testing.T) {
+	s, _, pub := newTestService()
+
+	var ran []string
+	s.procOrder = []string{"maps", "updates", "settings"}
+	s.procSteps = make(map[string]processingStep)
+	for _, name := range s.procOrder {
+		name := name
+		s.procSteps[name] = func(*normalCycle) { ran = append(ran, name) }
+	}
+
+	s.runProcessing(&normalCycle{ctx: context.Background()})
+
+	if !reflect.DeepEqual(ran, s.procOrder) {
+		t.Errorf("ran %v, want %v", ran, s.procOrder)
+	}
+	want := []string{"step=maps", "step=updates", "step=settings"}
+	if got := pub.history(); !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+// TestRunProcessingHonorsManifest checks that steps a ums-manifest.toml
+// disables are skipped, and the others run.
+func TestRunProcessingHonorsManifest(t *testing.T) {
+	s, _, _ := newTestService()
+	mount := t.TempDir()
+	manifest := "apply_settings = false\nprocess_updates = true\n"
+	if err := os.WriteFile(filepath.Join(mount, umsmanifest.FileName), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []string
+	s.procOrder = []string{"settings", "updates", "maps"}
+	s.procSteps = make(map[string]processingStep)
+	for _, name := range s.procOrder {
+		name := name
+		s.procSteps[name] = func(*normalCycle) { ran = append(ran, name) }
+	}
+
+	c := &normalCycle{ctx: context.Background(), mountPoint: mount, logger: umslog.New(nil)}
+	s.readPlan(c)
+	s.runProcessing(c)
+
+	if want := []string{"updates", "maps"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestDefaultProcessingStepsCoverOrder(t *testing.T) {
+	s, _, _ := newTestService()
+	steps := s.defaultProcessingSteps()
+	if len(steps) != len(defaultProcessingOrder) {
+		t.Errorf("%d steps for %d categories", len(steps), len(defaultProcessingOrder))
+	}
+	for _, name := range defaultProcessingOrder {
+		if steps[name] == nil {
+			t.Errorf("no step for category %q", name)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	ipc "github.com/librescoot/redis-ipc"
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+// rebootMinInterval is the least time between two reboots this service
+// triggers, so back-to-back UMS cycles can't power-cycle the DBC (or the
+// MDB, if the service outlives the request) over and over.
+const rebootMinInterval = 10 * time.Minute
+
+// RebootController owns the reboot that follows installed updates. Every
+// request folds into a single scheduled reboot: a new attempt supersedes
+// the previous one and inherits its target, so an MDB reboot already owed
+// isn't downgraded to a DBC power cycle. The attempt waits for the
+// maintenance window and the rate limit, checks the vehicle state, and
+// publishes what it's about to do in the usb hash (reboot, reboot-at).
+// Once installs are done the intent is persisted, so it survives a
+// service restart or a UMS session in between; Cancel drops it.
+type RebootController struct {
+	redis      redisClient
+	publisher  hashPublisher
+	window     maintenanceWindow
+	minGap     time.Duration
+	intentPath string
+	bootID     func() string
+	now        func() time.Time
+	setStatus  func(string)
+	// inhibitPath and updateLock hold the reboot back while either exists;
+	// see holdReason.
+	inhibitPath string
+	updateLock  string
+	holdPoll    time.Duration
+	// dryRun logs the reboot instead of triggering it.
+	dryRun bool
+	// handOff, if set, replaces the reboot: Fire calls it with the target
+	// right away and leaves the rest to another component, and Cancel
+	// calls it with "".
+	handOff func(target string)
+
+	mu        sync.Mutex
+	cancel    context.CancelFunc // cancels the active attempt; nil if none
+	gen       int                // increments per Begin; lets a stale attempt know it's been superseded
+	mdb       bool               // target of the active attempt
+	lastFired time.Time
+}
+
+func newRebootController(rdb redisClient, pub hashPublisher, window maintenanceWindow, setStatus func(string)) *RebootController {
+	return &RebootController{
+		redis:     rdb,
+		publisher: pub,
+		window:    window,
+		minGap:    rebootMinInterval,
+		bootID:    currentBootID,
+		now:       time.Now,
+		setStatus: setStatus,
+
+		inhibitPath: rebootInhibitFile,
+		updateLock:  update.LockFile,
+		holdPoll:    rebootHoldPoll,
+	}
+}
+
+// Begin starts a reboot attempt and returns its context and generation,
+// to be passed to End when the attempt's goroutine exits. A previous
+// attempt is cancelled, and its target as well as any persisted intent is
+// merged into this one.
+func (rc *RebootController) Begin(parent context.Context, mdb bool) (context.Context, int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.cancel != nil {
+		rc.cancel()
+		mdb = mdb || rc.mdb
+	}
+	if intent, ok := loadRebootIntent(rc.intentPath, rc.bootID()); ok {
+		mdb = mdb || intent.MDB
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	rc.cancel = cancel
+	rc.gen++
+	rc.mdb = mdb
+	return ctx, rc.gen
+}
+
+// End releases attempt gen and reports whether it was still the current
+// one; false means it was superseded or suspended and someone else owns
+// the status.
+func (rc *RebootController) End(gen int) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.gen != gen {
+		return false
+	}
+	rc.cancel = nil
+	return true
+}
+
+// Suspend stops the active attempt but keeps a persisted intent, so the
+// reboot is picked up again after the next UMS session. It reports
+// whether an attempt was running.
+func (rc *RebootController) Suspend() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.cancel == nil {
+		return false
+	}
+	rc.cancel()
+	rc.cancel = nil
+	rc.gen++
+	return true
+}
+
+// Cancel stops the active attempt, forgets the intent and clears the
+// published one. It reports whether there was anything to cancel.
+func (rc *RebootController) Cancel() bool {
+	rc.mu.Lock()
+	active := rc.cancel != nil
+	if active {
+		rc.cancel()
+		rc.cancel = nil
+		rc.gen++
+	}
+	rc.mu.Unlock()
+
+	_, persisted := loadRebootIntent(rc.intentPath, rc.bootID())
+	clearRebootIntent(rc.intentPath)
+	rc.publishIntent("", time.Time{})
+	if rc.handOff != nil {
+		rc.handOff("")
+	}
+	return active || persisted
+}
+
+// Pending returns the persisted intent for this boot, if any.
+func (rc *RebootController) Pending() (rebootIntent, bool) {
+	intent, ok := loadRebootIntent(rc.intentPath, rc.bootID())
+	if !ok {
+		clearRebootIntent(rc.intentPath)
+	}
+	return intent, ok
+}
+
+// delay returns how long from now until a reboot may fire: the later of
+// the maintenance window opening and the rate limit expiring.
+func (rc *RebootController) delay(now time.Time) time.Duration {
+	wait := rc.window.delay(now)
+	if !rc.lastFired.IsZero() {
+		if gap := rc.lastFired.Add(rc.minGap).Sub(now); gap > wait {
+			wait = gap
+		}
+	}
+	return wait
+}
+
+// Fire persists and publishes the intent of the attempt running under
+// ctx, waits until the reboot is allowed and nothing holds it back,
+// checks the vehicle state and triggers an MDB reboot or a DBC power
+// cycle. mdb adds an MDB reboot to
+// whatever the attempt already owes. With handOff set, none of that
+// happens: the reboot is only announced.
+func (rc *RebootController) Fire(ctx context.Context, logger *umslog.Logger, mdb bool) {
+	rc.mu.Lock()
+	rc.mdb = rc.mdb || mdb
+	mdb = rc.mdb
+	rc.mu.Unlock()
+
+	if rc.handOff != nil {
+		clearRebootIntent(rc.intentPath)
+		rc.handOff(rebootTarget(mdb))
+		logger.Logf("reboot", "%s reboot required, left to another component", rebootTarget(mdb))
+		log.Printf("awaiter: %s reboot required, automatic reboot is off", rebootTarget(mdb))
+		return
+	}
+
+	if err := saveRebootIntent(rc.intentPath, rebootIntent{MDB: mdb, BootID: rc.bootID()}); err != nil {
+		log.Printf("awaiter: failed to persist reboot intent: %v", err)
+	}
+
+	var held string
+	for {
+		rc.mu.Lock()
+		now := rc.now()
+		wait := rc.delay(now)
+		rc.mu.Unlock()
+
+		hold := ""
+		if wait <= 0 {
+			if hold = rc.holdReason(); hold != "" {
+				wait = rc.holdPoll
+			}
+		}
+
+		at := now.Add(wait)
+		rc.publishIntent(rebootTarget(mdb), at)
+		if wait <= 0 {
+			if held != "" {
+				log.Println("awaiter: reboot no longer held back")
+			}
+			break
+		}
+
+		rc.setStatus("reboot-deferred")
+		switch {
+		case hold == "":
+			logger.Logf("reboot", "deferred until %s", at.Format("15:04"))
+			log.Printf("awaiter: reboot deferred until %s", at.Format(time.RFC3339))
+		case hold != held:
+			// Checked again every holdPoll; only a change is worth a line.
+			logger.Logf("reboot", "deferred: %s", hold)
+			log.Printf("awaiter: reboot deferred, %s", hold)
+		}
+		held = hold
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	defer rc.publishIntent("", time.Time{})
+	defer clearRebootIntent(rc.intentPath)
+
+	state, err := rc.redis.HGet("vehicle", "state")
+	if err != nil {
+		logger.Error("reboot", "skip: failed to read vehicle state: %v", err)
+		log.Printf("awaiter: failed to read vehicle state: %v", err)
+		return
+	}
+	if !rebootAllowedVehicleStates[state] {
+		logger.Logf("reboot", "skip: vehicle state %q not in allowed set", state)
+		log.Printf("awaiter: skip reboot, vehicle state is %q", state)
+		return
+	}
+
+	rc.mu.Lock()
+	rc.lastFired = rc.now()
+	rc.mu.Unlock()
+
+	if rc.dryRun {
+		logger.Logf("reboot", "dry run: %s reboot skipped", rebootTarget(mdb))
+		log.Printf("awaiter: would trigger %s reboot", rebootTarget(mdb))
+		return
+	}
+	if mdb {
+		if _, err := rc.redis.LPush("scooter:power", "reboot"); err != nil {
+			logger.Error("reboot", "LPush scooter:power reboot failed: %v", err)
+			log.Printf("awaiter: failed to trigger MDB reboot: %v", err)
+			return
+		}
+		logger.Logf("reboot", "MDB reboot triggered")
+		log.Println("awaiter: MDB reboot triggered")
+		return
+	}
+
+	// DBC-only: power-cycle the dashboard.
+	for _, cmd := range []string{"dashboard:off", "dashboard:on"} {
+		if _, err := rc.redis.LPush("scooter:hardware", cmd); err != nil {
+			logger.Error("reboot", "LPush scooter:hardware %s failed: %v", cmd, err)
+			log.Printf("awaiter: failed to send %s: %v", cmd, err)
+			return
+		}
+	}
+	logger.Logf("reboot", "DBC power cycle triggered")
+	log.Println("awaiter: DBC power cycle triggered")
+}
+
+func rebootTarget(mdb bool) string {
+	if mdb {
+		return "mdb"
+	}
+	return "dbc"
+}
+
+// publishIntent sets reboot ("mdb", "dbc", or "" for none) and reboot-at
+// (Unix time of the next attempt) in the usb hash.
+func (rc *RebootController) publishIntent(target string, at time.Time) {
+	fields := map[string]any{"reboot": target, "reboot-at": ""}
+	if target != "" {
+		fields["reboot-at"] = strconv.FormatInt(at.Unix(), 10)
+	}
+	if err := rc.publisher.SetMany(fields, ipc.Sync()); err != nil {
+		log.Printf("Error publishing reboot intent: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+)
+
+func newTestRebootController(t *testing.T, window string, now time.Time) (*RebootController, *fakeRedis, *fakePublisher, *[]string) {
+	t.Helper()
+	w, err := parseMaintenanceWindow(window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := newFakeRedis()
+	pub := newFakePublisher()
+	var statuses []string
+	rc := newRebootController(rdb, pub, w, func(s string) { statuses = append(statuses, s) })
+	dir := t.TempDir()
+	rc.intentPath = filepath.Join(dir, "reboot-pending")
+	rc.inhibitPath = filepath.Join(dir, "no-reboot")
+	rc.updateLock = filepath.Join(dir, "update.lock")
+	rc.bootID = func() string { return "boot-1" }
+	rc.now = func() time.Time { return now }
+	return rc, rdb, pub, &statuses
+}
+
+func TestRebootControllerFire(t *testing.T) {
+	cases := []struct {
+		name      string
+		state     string
+		hgetErr   error
+		mdb       bool
+		wantPower []string
+		wantHW    []string
+	}{
+		{name: "mdb parked", state: "parked", mdb: true, wantPower: []string{"reboot"}},
+		{name: "dbc stand-by", state: "stand-by", wantHW: []string{"dashboard:off", "dashboard:on"}},
+		{name: "driving", state: "ready-to-drive", mdb: true},
+		{name: "state unreadable", hgetErr: errors.New("connection refused"), mdb: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc, rdb, pub, _ := newTestRebootController(t, "", time.Now())
+			rdb.hgetErr = c.hgetErr
+			if err := rdb.HSet("vehicle", "state", c.state); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, gen := rc.Begin(context.Background(), c.mdb)
+			rc.Fire(ctx, umslog.New(rdb), false)
+			if !rc.End(gen) {
+				t.Error("End: attempt should still be current")
+			}
+
+			if got := rdb.pushed("scooter:power"); strings.Join(got, ",") != strings.Join(c.wantPower, ",") {
+				t.Errorf("scooter:power = %v, want %v", got, c.wantPower)
+			}
+			if got := rdb.pushed("scooter:hardware"); strings.Join(got, ",") != strings.Join(c.wantHW, ",") {
+				t.Errorf("scooter:hardware = %v, want %v", got, c.wantHW)
+			}
+			if len(rdb.pushed("usb:log")) == 0 {
+				t.Error("expected the outcome to be logged to usb:log")
+			}
+			if _, err := os.Stat(rc.intentPath); !os.IsNotExist(err) {
+				t.Error("intent should be cleared once the attempt is decided")
+			}
+			if pub.fields["reboot"] != "" {
+				t.Errorf("published reboot = %q after the attempt, want empty", pub.fields["reboot"])
+			}
+		})
+	}
+}
+
+func TestRebootControllerFireAddsMDB(t *testing.T) {
+	rc, rdb, _, _ := newTestRebootController(t, "", time.Now())
+	if err := rdb.HSet("vehicle", "state", "parked"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Begun before the install outcome was known, fired once the MDB
+	// install completed.
+	ctx, _ := rc.Begin(context.Background(), false)
+	rc.Fire(ctx, umslog.New(rdb), true)
+
+	if got := rdb.pushed("scooter:power"); strings.Join(got, ",") != "reboot" {
+		t.Errorf("scooter:power = %v, want [reboot]", got)
+	}
+	if got := rdb.pushed("scooter:hardware"); len(got) != 0 {
+		t.Errorf("scooter:hardware = %v, want no DBC power cycle", got)
+	}
+}
+
+func TestRebootControllerHandOff(t *testing.T) {
+	// Outside the window, so only the hand-off can make Fire return.
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	rc, rdb, _, _ := newTestRebootController(t, "02:00-05:00", now)
+	status := newFakePublisher()
+	ops := newOpStatus(status)
+	rc.handOff = ops.setRebootRequired
+	if err := rdb.HSet("vehicle", "state", "parked"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := rc.Begin(context.Background(), false)
+	rc.Fire(ctx, umslog.New(rdb), true)
+
+	if got := append(rdb.pushed("scooter:power"), rdb.pushed("scooter:hardware")...); len(got) != 0 {
+		t.Errorf("pushed %v, want no reboot triggered", got)
+	}
+	if status.fields["reboot-required"] != "1" || status.fields["reboot-target"] != "mdb" {
+		t.Errorf("usb:status = %v, want reboot-required=1 reboot-target=mdb", status.fields)
+	}
+	if _, err := os.Stat(rc.intentPath); !os.IsNotExist(err) {
+		t.Error("no intent should be persisted for a handed-off reboot")
+	}
+
+	rc.Cancel()
+	if status.fields["reboot-required"] != "" || status.fields["reboot-target"] != "" {
+		t.Errorf("usb:status = %v after cancel, want the flag cleared", status.fields)
+	}
+}
+
+func TestRebootControllerDelay(t *testing.T) {
+	at := func(hh, mm int) time.Time {
+		return time.Date(2026, 5, 1, hh, mm, 0, 0, time.Local)
+	}
+	cases := []struct {
+		name      string
+		window    string
+		lastFired time.Time
+		now       time.Time
+		want      time.Duration
+	}{
+		{name: "no window, never fired", now: at(12, 0), want: 0},
+		{name: "outside window", window: "02:00-05:00", now: at(1, 0), want: time.Hour},
+		{name: "rate limited", lastFired: at(11, 55), now: at(12, 0), want: 5 * time.Minute},
+		{name: "rate limit expired", lastFired: at(11, 0), now: at(12, 0), want: 0},
+		{name: "window opens later than rate limit", window: "13:00-14:00", lastFired: at(11, 55), now: at(12, 0), want: time.Hour},
+		{name: "rate limit outlasts window opening", window: "12:01-14:00", lastFired: at(11, 55), now: at(12, 0), want: 5 * time.Minute},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc, _, _, _ := newTestRebootController(t, c.window, c.now)
+			rc.lastFired = c.lastFired
+			if got := rc.delay(c.now); got != c.want {
+				t.Errorf("delay = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRebootControllerConsolidates(t *testing.T) {
+	rc, _, _, _ := newTestRebootController(t, "", time.Now())
+
+	first, firstGen := rc.Begin(context.Background(), true)
+	_, secondGen := rc.Begin(context.Background(), false)
+	if first.Err() == nil {
+		t.Error("first attempt should be cancelled when superseded")
+	}
+	if rc.End(firstGen) {
+		t.Error("End(first): superseded attempt reported as current")
+	}
+	if !rc.mdb {
+		t.Error("a DBC-only request must not downgrade a pending MDB reboot")
+	}
+	rc.End(secondGen)
+
+	// A persisted intent (e.g. deferred before a UMS session) is merged
+	// into the next attempt as well.
+	if err := saveRebootIntent(rc.intentPath, rebootIntent{MDB: true, BootID: "boot-1"}); err != nil {
+		t.Fatal(err)
+	}
+	rc.Begin(context.Background(), false)
+	if !rc.mdb {
+		t.Error("persisted MDB intent not merged into the new attempt")
+	}
+}
+
+func TestRebootControllerDeferSuspendCancel(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.Local)
+	rc, rdb, pub, statuses := newTestRebootController(t, "02:00-05:00", now)
+
+	ctx, gen := rc.Begin(context.Background(), true)
+	done := make(chan struct{})
+	go func() {
+		rc.Fire(ctx, umslog.New(rdb), false)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(strings.Join(pub.history(), ","), "reboot=mdb") {
+		if time.Now().After(deadline) {
+			t.Fatal("intent never published")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Suspending (re-entering UMS) stops the wait but keeps the intent.
+	if !rc.Suspend() {
+		t.Fatal("Suspend: expected an active attempt")
+	}
+	<-done
+	if rc.End(gen) {
+		t.Error("End: suspended attempt reported as current")
+	}
+	if len(*statuses) == 0 || (*statuses)[0] != "reboot-deferred" {
+		t.Errorf("statuses = %v, want reboot-deferred", *statuses)
+	}
+	if intent, ok := rc.Pending(); !ok || !intent.MDB {
+		t.Fatalf("Pending = %+v, %v; want the MDB intent kept across a suspend", intent, ok)
+	}
+	opens := strconv.FormatInt(time.Date(2026, 5, 2, 2, 0, 0, 0, time.Local).Unix(), 10)
+	if pub.fields["reboot"] != "mdb" || pub.fields["reboot-at"] != opens {
+		t.Errorf("published %v, want reboot=mdb reboot-at=%s", pub.fields, opens)
+	}
+
+	// Cancel drops it entirely.
+	if !rc.Cancel() {
+		t.Error("Cancel: expected the persisted intent to count")
+	}
+	if _, ok := rc.Pending(); ok {
+		t.Error("intent still pending after Cancel")
+	}
+	if pub.fields["reboot"] != "" || pub.fields["reboot-at"] != "" {
+		t.Errorf("published %v after Cancel, want cleared", pub.fields)
+	}
+	if rc.Cancel() {
+		t.Error("second Cancel reported something to cancel")
+	}
+	if got := rdb.pushed("scooter:power"); len(got) != 0 {
+		t.Errorf("scooter:power = %v, want no reboot", got)
+	}
+}
+
+// TestRebootControllerHeld checks that a reboot the window allows still
+// waits while the inhibitor file exists, and goes ahead once it's gone.
+func TestRebootControllerHeld(t *testing.T) {
+	rc, rdb, pub, statuses := newTestRebootController(t, "", time.Now())
+	rc.holdPoll = 5 * time.Millisecond
+	if err := rdb.HSet("vehicle", "state", "parked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rc.inhibitPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := rc.Begin(context.Background(), true)
+	done := make(chan struct{})
+	go func() {
+		rc.Fire(ctx, umslog.New(rdb), false)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pub.history()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("held reboot never re-checked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := rdb.pushed("scooter:power"); len(got) != 0 {
+		t.Fatalf("scooter:power = %v while inhibited, want no reboot", got)
+	}
+
+	if err := os.Remove(rc.inhibitPath); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reboot still held after the inhibitor was removed")
+	}
+	if got := rdb.pushed("scooter:power"); strings.Join(got, ",") != "reboot" {
+		t.Errorf("scooter:power = %v, want [reboot]", got)
+	}
+	if len(*statuses) == 0 || (*statuses)[0] != "reboot-deferred" {
+		t.Errorf("statuses = %v, want reboot-deferred", *statuses)
+	}
+	if got := strings.Join(rdb.pushed("usb:log"), "\n"); strings.Count(got, "inhibitor") != 1 {
+		t.Errorf("usb:log = %q, want the hold logged once", got)
+	}
+}
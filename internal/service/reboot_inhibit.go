@@ -0,0 +1,36 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+// rebootInhibitFile, while it exists, holds back update-triggered
+// reboots. An operator working on the scooter can touch it to keep the
+// reboot from pulling the rug out, and remove it to let the reboot go
+// ahead.
+const rebootInhibitFile = "/run/ums-no-reboot"
+
+// rebootHoldPoll is how often a held reboot checks again whether it may
+// go ahead.
+const rebootHoldPoll = 30 * time.Second
+
+// holdReason returns why the reboot must wait even though the window and
+// rate limit allow it, or "" if nothing holds it.
+func (rc *RebootController) holdReason() string {
+	if _, err := os.Stat(rc.inhibitPath); err == nil {
+		return fmt.Sprintf("inhibitor %s present", rc.inhibitPath)
+	}
+	locked, err := update.Locked(rc.updateLock)
+	if err != nil {
+		log.Printf("Warning: update lock: %v", err)
+	}
+	if locked {
+		return fmt.Sprintf("updates in progress (%s)", rc.updateLock)
+	}
+	return ""
+}
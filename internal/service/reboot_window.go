@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pendingRebootName is the file in the state directory that persists a
+// reboot deferred to the maintenance window, so a service restart before
+// the window opens doesn't lose it.
+const pendingRebootName = "reboot-pending"
+
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// timeRange is a daily window expressed as offsets from local midnight.
+// end < start means the range wraps past midnight (e.g. 22:00-02:00).
+type timeRange struct {
+	start, end time.Duration
+}
+
+// maintenanceWindow is the set of daily ranges in which update-triggered
+// reboots may happen. An empty window means "any time".
+type maintenanceWindow []timeRange
+
+// parseMaintenanceWindow parses a comma-separated list of HH:MM-HH:MM
+// ranges in local time, e.g. "02:00-05:00,13:00-13:30".
+func parseMaintenanceWindow(spec string) (maintenanceWindow, error) {
+	var w maintenanceWindow
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("range %q: expected HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("range %q: %w", part, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("range %q: %w", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("range %q is empty", part)
+		}
+		w = append(w, timeRange{start: start, end: end})
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (r timeRange) contains(offset time.Duration) bool {
+	if r.start < r.end {
+		return offset >= r.start && offset < r.end
+	}
+	return offset >= r.start || offset < r.end
+}
+
+// delay returns how long to wait from now until a reboot is allowed: zero
+// inside the window (or with no window configured), otherwise the time
+// until the nearest range opens.
+func (w maintenanceWindow) delay(now time.Time) time.Duration {
+	if len(w) == 0 {
+		return 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	var best time.Duration = -1
+	for _, r := range w {
+		if r.contains(offset) {
+			return 0
+		}
+		next := midnight.Add(r.start)
+		if !next.After(now) {
+			next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Add(r.start)
+		}
+		if d := next.Sub(now); best < 0 || d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// rebootIntent is what pendingRebootName holds. BootID lets a later run
+// tell a service restart (intent still pending) from a full system reboot
+// (the update has been applied, intent is stale).
+type rebootIntent struct {
+	MDB    bool   `json:"mdb"`
+	BootID string `json:"boot_id"`
+}
+
+func currentBootID() string {
+	data, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func saveRebootIntent(path string, intent rebootIntent) error {
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadRebootIntent returns the persisted intent, or ok=false if there is
+// none or it predates the current boot.
+func loadRebootIntent(path, bootID string) (rebootIntent, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rebootIntent{}, false
+	}
+	var intent rebootIntent
+	if err := json.Unmarshal(data, &intent); err != nil {
+		return rebootIntent{}, false
+	}
+	if intent.BootID != bootID {
+		return rebootIntent{}, false
+	}
+	return intent, true
+}
+
+func clearRebootIntent(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to remove %s: %v", path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	valid := []string{"", "02:00-05:00", "02:00-05:00, 13:00-13:30", "22:00-02:00"}
+	for _, spec := range valid {
+		if _, err := parseMaintenanceWindow(spec); err != nil {
+			t.Errorf("parseMaintenanceWindow(%q): unexpected error %v", spec, err)
+		}
+	}
+
+	invalid := []string{"02:00", "2am-5am", "25:00-26:00", "03:00-03:00"}
+	for _, spec := range invalid {
+		if _, err := parseMaintenanceWindow(spec); err == nil {
+			t.Errorf("parseMaintenanceWindow(%q): expected error", spec)
+		}
+	}
+}
+
+func TestMaintenanceWindowDelay(t *testing.T) {
+	at := func(hh, mm int) time.Time {
+		return time.Date(2026, 5, 1, hh, mm, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		name string
+		spec string
+		now  time.Time
+		want time.Duration
+	}{
+		{"no window", "", at(12, 0), 0},
+		{"inside", "02:00-05:00", at(3, 30), 0},
+		{"start is inclusive", "02:00-05:00", at(2, 0), 0},
+		{"end is exclusive", "02:00-05:00", at(5, 0), 21 * time.Hour},
+		{"before today's window", "02:00-05:00", at(1, 0), time.Hour},
+		{"after today's window", "02:00-05:00", at(12, 0), 14 * time.Hour},
+		{"nearest of several", "02:00-05:00,13:00-13:30", at(12, 0), time.Hour},
+		{"wrap inside before midnight", "22:00-02:00", at(23, 0), 0},
+		{"wrap inside after midnight", "22:00-02:00", at(1, 0), 0},
+		{"wrap outside", "22:00-02:00", at(21, 0), time.Hour},
+	}
+	for _, c := range cases {
+		w, err := parseMaintenanceWindow(c.spec)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", c.name, err)
+		}
+		if got := w.delay(c.now); got != c.want {
+			t.Errorf("%s: delay = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRebootIntentPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ums-service", "reboot-pending")
+
+	if _, ok := loadRebootIntent(path, "boot-a"); ok {
+		t.Fatal("expected no intent before saving")
+	}
+
+	if err := saveRebootIntent(path, rebootIntent{MDB: true, BootID: "boot-a"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	intent, ok := loadRebootIntent(path, "boot-a")
+	if !ok || !intent.MDB {
+		t.Fatalf("same boot: got %+v ok=%v, want MDB intent", intent, ok)
+	}
+
+	if _, ok := loadRebootIntent(path, "boot-b"); ok {
+		t.Error("intent from a previous boot should be ignored")
+	}
+
+	clearRebootIntent(path)
+	if _, ok := loadRebootIntent(path, "boot-a"); ok {
+		t.Error("expected no intent after clearing")
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/fsutil"
+)
+
+// receiptFile is written to the drive root after processing and spared
+// by cleaning, so the user finds it the next time they mount the drive.
+const receiptFile = "ums-result.json"
+
+// receipt is the content of ums-result.json: what a session's processing
+// did with each category, and what went wrong.
+type receipt struct {
+	CompletedAt time.Time     `json:"completed-at"`
+	Result      cycleResult   `json:"result"`
+	Steps       []receiptStep `json:"steps"`
+	// UpdatesQueued lists the boards (mdb, dbc) an update was staged
+	// for; it is installed on the next reboot.
+	UpdatesQueued []string     `json:"updates-queued"`
+	Errors        []cycleError `json:"errors"`
+}
+
+// receiptStep is one processing category. Files are what the drive held
+// for it, relative to the drive root.
+type receiptStep struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"` // done, failed or skipped
+	Files  []string `json:"files,omitempty"`
+}
+
+// cycleError is an error reported to usb:log during processing.
+type cycleError struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// buildReceipt summarizes c. It lists the drive's files, so call it
+// before the drive is cleaned.
+func (s *Service) buildReceipt(c *normalCycle, result cycleResult) receipt {
+	failed := make(map[string]bool)
+	for _, e := range c.Errors {
+		failed[e.Category] = true
+	}
+	r := receipt{
+		CompletedAt:   time.Now().UTC().Truncate(time.Second),
+		Result:        result,
+		Steps:         []receiptStep{},
+		UpdatesQueued: []string{},
+		Errors:        append([]cycleError{}, c.Errors...),
+	}
+	for _, name := range s.procOrder {
+		step := receiptStep{Name: name, Status: "skipped"}
+		switch {
+		case !c.done(name):
+		case failed[name]:
+			step.Status = "failed"
+		default:
+			step.Status = "done"
+		}
+		if area, ok := categoryAreas[name]; ok {
+			step.Files = areaFiles(c.mountPoint, area.Path)
+		}
+		r.Steps = append(r.Steps, step)
+	}
+	if c.Queued.MDB {
+		r.UpdatesQueued = append(r.UpdatesQueued, "mdb")
+	}
+	if c.Queued.DBC {
+		r.UpdatesQueued = append(r.UpdatesQueued, "dbc")
+	}
+	return r
+}
+
+// areaFiles lists the regular files at or below path under root.
+func areaFiles(root, path string) []string {
+	var files []string
+	filepath.WalkDir(filepath.Join(root, path), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if rel, err := filepath.Rel(root, p); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// writeReceipt writes r to the drive root.
+func writeReceipt(mountPoint string, r receipt) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(mountPoint, receiptFile), append(data, '\n'), 0644)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/update"
+)
+
+func TestReceipt(t *testing.T) {
+	s, _, _ := newTestService()
+	s.procOrder = []string{"settings", "maps", "updates"}
+	mount := t.TempDir()
+	for _, p := range []string{"settings.toml", "maps/berlin.mbtiles", "maps/tiles.tar"} {
+		path := filepath.Join(mount, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &normalCycle{mountPoint: mount}
+	c.Done = []string{"settings", "maps"}
+	c.Errors = []cycleError{{Category: "maps", Message: "DBC storage full"}}
+	c.Queued = update.Queued{MDB: true}
+
+	if err := writeReceipt(mount, s.buildReceipt(c, cycleResult{Settings: true})); err != nil {
+		t.Fatalf("writeReceipt: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(mount, receiptFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got receipt
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%s: %v", receiptFile, err)
+	}
+
+	wantSteps := []receiptStep{
+		{Name: "settings", Status: "done", Files: []string{"settings.toml"}},
+		{Name: "maps", Status: "failed", Files: []string{"maps/berlin.mbtiles", "maps/tiles.tar"}},
+		{Name: "updates", Status: "skipped"},
+	}
+	if !reflect.DeepEqual(got.Steps, wantSteps) {
+		t.Errorf("steps = %+v, want %+v", got.Steps, wantSteps)
+	}
+	if !reflect.DeepEqual(got.UpdatesQueued, []string{"mdb"}) {
+		t.Errorf("updates-queued = %q, want [mdb]", got.UpdatesQueued)
+	}
+	if !reflect.DeepEqual(got.Errors, c.Errors) || !got.Result.Settings {
+		t.Errorf("errors = %+v, result = %+v", got.Errors, got.Result)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPingInterval is how often the Redis connection is checked. A
+// dropped connection is noticed on the next check and reported as lost;
+// the check keeps running, so the first one that succeeds again reports
+// it restored.
+const redisPingInterval = 5 * time.Second
+
+// onRedisDisconnect is called when a connection check fails. The
+// client has already logged why.
+func (s *Service) onRedisDisconnect(error) {
+	s.redisDown.Store(true)
+	log.Printf("Warning: Redis connection lost, retrying every %s", redisPingInterval)
+}
+
+// onRedisReconnect is called when a connection check succeeds after
+// failing. The usb subscription resubscribes by itself, but whatever was
+// published while it was down is gone, so the fields the watcher handles
+// are read again and handed to it as if they had just changed. Before
+// Run has started the watcher, its initial sync covers this.
+func (s *Service) onRedisReconnect() {
+	s.redisDown.Store(false)
+	if !s.watching.Load() {
+		return
+	}
+	log.Println("Redis connection restored, re-reading usb hash")
+	s.resyncWatchedFields()
+}
+
+func (s *Service) resyncWatchedFields() {
+	for _, f := range []struct {
+		field  string
+		handle func(string) error
+	}{
+		{"mode", s.handleModeChange},
+		{"reboot", s.handleRebootField},
+	} {
+		value, err := s.redis.HGet("usb", f.field)
+		if errors.Is(err, redis.Nil) || (err == nil && value == "") {
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: re-reading usb %s: %v", f.field, err)
+			continue
+		}
+		if err := f.handle(value); err != nil {
+			log.Printf("Error handling usb %s=%s: %v", f.field, value, err)
+		}
+	}
+}
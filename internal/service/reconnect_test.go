@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+)
+
+// TestRedisReconnectResync checks that a mode requested while Redis was
+// unreachable is picked up once the connection is back, and only after
+// Run has started watching.
+func TestRedisReconnectResync(t *testing.T) {
+	s, rdb, _ := newTestService()
+	s.modeQueue = newModeQueue(1)
+	if err := rdb.HSet("usb", "mode", "ums"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.onRedisReconnect()
+	if len(s.modeQueue.ch) != 0 {
+		t.Fatalf("queued %d modes before the watcher started, want 0", len(s.modeQueue.ch))
+	}
+
+	s.watching.Store(true)
+	s.onRedisReconnect()
+	if len(s.modeQueue.ch) != 1 {
+		t.Fatalf("queued %d modes after reconnect, want 1", len(s.modeQueue.ch))
+	}
+	if got := <-s.modeQueue.ch; got != "ums" {
+		t.Errorf("queued mode = %q, want ums", got)
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	ipc "github.com/librescoot/redis-ipc"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+)
+
+// redisClient is the subset of *ipc.Client that Service issues plain
+// commands through. Watchers, subscriptions and the OTA source still need
+// the concrete client; everything else goes through this so tests can
+// substitute a fake.
+type redisClient interface {
+	umslog.Client
+	HGet(key, field string) (string, error)
+	Del(keys ...string) (int64, error)
+}
+
+// hashPublisher is the subset of *ipc.HashPublisher used for the usb and
+// usb:usage hashes.
+type hashPublisher interface {
+	Set(field string, value any, opts ...ipc.SetOption) error
+	SetMany(fields map[string]any, opts ...ipc.SetOption) error
+	ReplaceAll(fields map[string]any, opts ...ipc.SetOption) error
+}
+
+var (
+	_ redisClient   = (*ipc.Client)(nil)
+	_ hashPublisher = (*ipc.HashPublisher)(nil)
+)
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	ipc "github.com/librescoot/redis-ipc"
+)
+
+// fakeRedis is an in-memory redisClient. Lists are stored head-first, as
+// LPush leaves them.
+type fakeRedis struct {
+	mu      sync.Mutex
+	hashes  map[string]map[string]string
+	lists   map[string][]string
+	hgetErr error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{
+		hashes: make(map[string]map[string]string),
+		lists:  make(map[string][]string),
+	}
+}
+
+func (f *fakeRedis) LPush(key string, values ...interface{}) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, v := range values {
+		f.lists[key] = append([]string{fmt.Sprint(v)}, f.lists[key]...)
+	}
+	return int64(len(f.lists[key])), nil
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func (f *fakeRedis) HSet(key, field string, value interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string]string)
+	}
+	f.hashes[key][field] = fmt.Sprint(value)
+	return nil
+}
+
+func (f *fakeRedis) HGet(key, field string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hgetErr != nil {
+		return "", f.hgetErr
+	}
+	return f.hashes[key][field], nil
+}
+
+func (f *fakeRedis) Del(keys ...string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, k := range keys {
+		if _, ok := f.lists[k]; ok {
+			delete(f.lists, k)
+			n++
+		}
+		if _, ok := f.hashes[k]; ok {
+			delete(f.hashes, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// pushed returns the values pushed to key in push order.
+func (f *fakeRedis) pushed(key string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := f.lists[key]
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[len(list)-1-i] = v
+	}
+	return out
+}
+
+// fakePublisher records every field write in order.
+type fakePublisher struct {
+	mu     sync.Mutex
+	fields map[string]string
+	writes []string
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{fields: make(map[string]string)}
+}
+
+func (p *fakePublisher) Set(field string, value any, opts ...ipc.SetOption) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v := fmt.Sprint(value)
+	p.fields[field] = v
+	p.writes = append(p.writes, field+"="+v)
+	return nil
+}
+
+func (p *fakePublisher) SetMany(fields map[string]any, opts ...ipc.SetOption) error {
+	for k, v := range fields {
+		if err := p.Set(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *fakePublisher) ReplaceAll(fields map[string]any, opts ...ipc.SetOption) error {
+	p.mu.Lock()
+	p.fields = make(map[string]string)
+	p.mu.Unlock()
+	return p.SetMany(fields)
+}
+
+func (p *fakePublisher) history() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.writes...)
+}
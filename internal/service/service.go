@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -11,21 +12,31 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ipc "github.com/librescoot/redis-ipc"
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/command"
 	"github.com/librescoot/ums-service/pkg/config"
+	"github.com/librescoot/ums-service/pkg/databackup"
 	"github.com/librescoot/ums-service/pkg/dbc"
 	"github.com/librescoot/ums-service/pkg/diagnostics"
 	"github.com/librescoot/ums-service/pkg/disk"
+	"github.com/librescoot/ums-service/pkg/hooks"
 	"github.com/librescoot/ums-service/pkg/logbundles"
+	"github.com/librescoot/ums-service/pkg/logexport"
+	"github.com/librescoot/ums-service/pkg/logging"
 	"github.com/librescoot/ums-service/pkg/maps"
+	"github.com/librescoot/ums-service/pkg/metrics"
 	"github.com/librescoot/ums-service/pkg/onboot"
 	"github.com/librescoot/ums-service/pkg/radiogaga"
 	"github.com/librescoot/ums-service/pkg/rpm"
 	"github.com/librescoot/ums-service/pkg/scripts"
 	"github.com/librescoot/ums-service/pkg/settings"
+	"github.com/librescoot/ums-service/pkg/statusapi"
 	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/umsmanifest"
 	"github.com/librescoot/ums-service/pkg/update"
 	"github.com/librescoot/ums-service/pkg/uplink"
 	"github.com/librescoot/ums-service/pkg/usb"
@@ -36,6 +47,45 @@ const logBundleKeepCount = 10
 
 const installAwaitTimeout = 10 * time.Minute
 
+// driveCategories are the top-level drive entries we own. Anything else the
+// host leaves behind is reported as "other" in usb:usage.
+var driveCategories = []string{
+	"settings.toml",
+	"onboot.sh",
+	"ums_log.txt",
+	"ums-result.json",
+	"system-update",
+	"maps",
+	"wireguard",
+	"radio-gaga",
+	"uplink-service",
+	"rpms",
+	"scripts",
+	"log-bundles",
+	"diagnostics",
+	"logs",
+	"backup",
+	backupMarker,
+	restoreDir,
+	"manifest.json",
+	"ums-manifest.toml",
+	"lost+found",
+}
+
+const settingsUnit = "librescoot-settings.service"
+
+// driveBusyHint is reported when the drive couldn't be unmounted because
+// something still had it open.
+const driveBusyHint = "USB drive still in use: if a computer has it open, safely eject it there, then try again"
+
+// driveNearlyFullDivisor: the drive counts as nearly full with less than
+// 1/10 of it free.
+const driveNearlyFullDivisor = 10
+
+// settingsHealthSettle is how long settings-service gets to choke on new
+// settings before it is considered healthy.
+const settingsHealthSettle = 5 * time.Second
+
 var rebootAllowedVehicleStates = map[string]bool{
 	"stand-by":      true,
 	"parked":        true,
@@ -45,8 +95,12 @@ var rebootAllowedVehicleStates = map[string]bool{
 type Service struct {
 	config        *config.Config
 	client        *ipc.Client
+	redis         redisClient
 	watcher       *ipc.HashWatcher
-	publisher     *ipc.HashPublisher
+	publisher     hashPublisher
+	usagePub      hashPublisher
+	resultPub     hashPublisher
+	lastUpdatePub hashPublisher
 	usbCtrl       *usb.Controller
 	diskMgr       *disk.Manager
 	dbcInterface  *dbc.Interface
@@ -61,46 +115,258 @@ type Service struct {
 	radioGagaMgr  *radiogaga.Manager
 	uplinkMgr     *uplink.Manager
 	onbootMgr     *onboot.Manager
+	logExporter   *logexport.Exporter
+	dataBackup    *databackup.Exporter
+	statusBoard   *statusapi.Board
+	logHub        *statusapi.LogHub
+	checksumAlgo  checksum.Algorithm
+	driveManifest bool // write manifest.json on UMS entry
 	mu            sync.Mutex
 	detachCount   int
 	umsModeType   string
-	serviceCtx    context.Context    // set in Run; parent for reboot goroutine
-	rebootWatcher context.CancelFunc // cancel pending reboot goroutine; nil if none
-	rebootGen     int                // increments per startRebootWatcher; lets a stale goroutine know it's been superseded
+	reboots       *RebootController
+	ops           *opStatus
+	metrics       *metrics.Metrics
+	modePolicy    modePolicy
+	modeQueue     *modeQueue
+	serviceCtx    context.Context // set in Run; parent for reboot goroutine
+	// populate fills the drive on the way into UMS mode; see
+	// populateDrive.
+	populate func(mountPoint string)
+	// procOrder is the order procSteps run in after a UMS session.
+	procOrder []string
+	procSteps map[string]processingStep
+	batchPath string      // journal of the batch in progress
+	watching  atomic.Bool // usb hash watcher started; resync on reconnect
+	dryRun    bool        // log service restarts instead of running them
+	// backupRequest remembers a requested /data backup; see
+	// noteBackupRequest.
+	backupRequest string
+	// secureClean recreates the drive image instead of deleting files
+	// from it after a session.
+	secureClean bool
+	// driveRetryDelay is the first wait before mounting or unmounting
+	// the drive again around a mode switch; see retryDrive.
+	driveRetryDelay time.Duration
+	// hooks runs integrators' scripts around mode changes; with
+	// strictHooks a failing pre hook refuses the change.
+	hooks       *hooks.Runner
+	strictHooks bool
+	// redisDown and diskReady feed /readyz; see health.go.
+	redisDown atomic.Bool
+	diskReady atomic.Bool
 }
 
 func New(cfg *config.Config) (*Service, error) {
+	logLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+	logger := logging.Setup(logLevel)
+
 	redisHost, redisPort, err := parseRedisAddr(cfg.RedisAddr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid REDIS_ADDR %q: %w", cfg.RedisAddr, err)
 	}
 
+	// The connection callbacks run on the client's monitor goroutine and
+	// may fire before svc exists.
+	var self atomic.Pointer[Service]
 	client, err := ipc.New(
 		ipc.WithAddress(redisHost),
 		ipc.WithPort(redisPort),
 		ipc.WithCodec(ipc.StringCodec{}),
+		ipc.WithLogger(logger.With("component", "redis")),
+		ipc.WithRetryInterval(redisPingInterval),
+		ipc.WithOnDisconnect(func(err error) {
+			if s := self.Load(); s != nil {
+				s.onRedisDisconnect(err)
+			}
+		}),
+		ipc.WithOnConnect(func() {
+			if s := self.Load(); s != nil {
+				s.onRedisReconnect()
+			}
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Redis client: %w", err)
 	}
 
-	usbCtrl := usb.NewController(cfg.USBDriveFile)
-	diskMgr := disk.NewManager(cfg.USBDriveFile, cfg.USBDriveSize)
+	rebootWindow, err := parseMaintenanceWindow(cfg.RebootWindow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_REBOOT_WINDOW %q: %w", cfg.RebootWindow, err)
+	}
+
+	modePolicy, err := parseModePolicy(cfg.AllowedModes, cfg.ModePrecondition)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mode policy: %w", err)
+	}
+
+	checksumAlgo, err := checksum.Parse(cfg.ChecksumAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_CHECKSUM_ALGORITHM: %w", err)
+	}
+
+	processingOrder, err := parseProcessingOrder(cfg.ProcessingOrder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_PROCESSING_ORDER %q: %w", cfg.ProcessingOrder, err)
+	}
 
-	dbcInterface := dbc.New("/data/dbc", client)
-	settingsLdr := settings.New()
-	mapsUpdater := maps.New(dbcInterface)
-	wgManager := wireguard.New()
+	normalGadget, err := usb.ParseGadget(cfg.NormalGadget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_NORMAL_GADGET %q: %w", cfg.NormalGadget, err)
+	}
+	usbIdentity := usb.Identity{VendorID: cfg.USBVendorID, ProductID: cfg.USBProductID, Serial: cfg.USBSerial}
+	if err := usbIdentity.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid USB identity: %w", err)
+	}
+	usbCtrl := usb.NewController(cfg.USBDriveFile, usbIdentity, command.Exec{})
+	usbCtrl.SetLogger(logging.New(logger, "usb"))
+	usbCtrl.SetNormalGadget(normalGadget)
+	usbCtrl.SetLinkCheckInterval(cfg.LinkCheckInterval)
+	extraDrives, err := usb.ParseLUNs(cfg.ExtraDrives)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_EXTRA_DRIVES: %w", err)
+	}
+	if err := usbCtrl.SetExtraLUNs(extraDrives); err != nil {
+		return nil, fmt.Errorf("invalid UMS_EXTRA_DRIVES: %w", err)
+	}
+	driveFS, err := disk.ParseFilesystem(cfg.DriveFilesystem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_DRIVE_FILESYSTEM: %w", err)
+	}
+	driveSize, err := disk.ParseSize(cfg.USBDriveSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_DRIVE_SIZE: %w", err)
+	}
+	if !filepath.IsAbs(cfg.MountPoint) || filepath.Clean(cfg.MountPoint) == "/" {
+		return nil, fmt.Errorf("invalid UMS_MOUNT_POINT %q: must be an absolute path below /", cfg.MountPoint)
+	}
+	diskMgr := disk.NewManager(cfg.USBDriveFile, cfg.MountPoint, driveSize, command.Exec{})
+	diskMgr.SetLogger(logging.New(logger, "disk"))
+	diskMgr.SetStartupCheck(cfg.DriveCheck)
+	diskMgr.SetFilesystem(driveFS)
+	extraImages := make([]string, len(extraDrives))
+	for n, lun := range extraDrives {
+		extraImages[n] = lun.File
+	}
+	diskMgr.SetExtraImages(extraImages)
+	switch cfg.UnknownDirs {
+	case "clean":
+	case "preserve":
+		diskMgr.PreserveUnknownDirs(driveCategories)
+	default:
+		return nil, fmt.Errorf("invalid UMS_UNKNOWN_DIRS %q: expected clean or preserve", cfg.UnknownDirs)
+	}
+	switch cfg.CleanMode {
+	case "delete":
+	case "reformat":
+		if cfg.UnknownDirs == "preserve" {
+			return nil, fmt.Errorf("UMS_UNKNOWN_DIRS=preserve needs UMS_CLEAN_MODE=delete")
+		}
+		if cfg.KeepPatterns != "" {
+			return nil, fmt.Errorf("UMS_KEEP_PATTERNS needs UMS_CLEAN_MODE=delete")
+		}
+	default:
+		return nil, fmt.Errorf("invalid UMS_CLEAN_MODE %q: expected delete or reformat", cfg.CleanMode)
+	}
+	if err := diskMgr.SetKeepPatterns(splitList(cfg.KeepPatterns)); err != nil {
+		return nil, fmt.Errorf("invalid UMS_KEEP_PATTERNS: %w", err)
+	}
 
-	updateLdr := update.New(client, dbcInterface)
-	rpmInstaller := rpm.New(dbcInterface)
-	scriptRunner := scripts.New(dbcInterface)
+	dbcInterface := dbc.New(cfg.DBCDataDir, client, command.Exec{})
+	dbcInterface.SetLogger(logging.New(logger, "dbc"))
+	dbcFileMode, err := parseFileMode(cfg.DBCFileMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_DBC_FILE_MODE %q: %w", cfg.DBCFileMode, err)
+	}
+	if err := dbcInterface.SetFileOwnership(cfg.DBCFileOwner, dbcFileMode); err != nil {
+		return nil, fmt.Errorf("invalid UMS_DBC_FILE_OWNER: %w", err)
+	}
+	if cfg.DBCPort <= 0 || cfg.DBCPort > 65535 {
+		return nil, fmt.Errorf("invalid UMS_DBC_PORT %d", cfg.DBCPort)
+	}
+	dbcInterface.SetAddresses(cfg.DBCHost, cfg.DBCHTTPHost, cfg.DBCPort)
+	dbcInterface.SetVerifyCommand(cfg.DBCVerifyCommand)
+	dbcInterface.SetChecksumAlgorithm(checksumAlgo)
+	dbcInterface.SetVerifyCopies(cfg.DBCVerifyCopies)
+	dbcInterface.SetRsync(cfg.DBCRsync)
+	dbcInterface.SetRetryPolicy(cfg.DBCRetryAttempts, cfg.DBCRetryDelay)
+	dbcInterface.SetTimeouts(cfg.DBCCopyTimeout, cfg.DBCCommandTimeout)
+	dbcInterface.SetHostKeyPinning(cfg.DBCKnownHosts, cfg.DBCLearnHostKey)
+	if err := dbcInterface.CheckHostKeyPinning(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid UMS_DBC_KNOWN_HOSTS: %w", err)
+	}
+	if cfg.DBCReadyCheck == "off" {
+		dbcInterface.SetReadyCheck("")
+	} else {
+		dbcInterface.SetReadyCheck(cfg.DBCReadyCheck)
+	}
+	settingsLdr := settings.New(cfg.SettingsFile)
+	settingsLdr.SetLogger(logging.New(logger, "settings"))
+	settingsLdr.SetBackupCount(cfg.SettingsBackups)
+	settingsMode, err := settings.ParseMode(cfg.SettingsMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_SETTINGS_MODE: %w", err)
+	}
+	settingsLdr.SetMode(settingsMode)
+	settingsLdr.SetMaxSize(int64(cfg.SettingsMaxBytes))
+	counters := metrics.New()
+	mapsUpdater := maps.New(dbcInterface, cfg.DBCMapsDir, cfg.DBCValhallaDir)
+	mapsUpdater.SetLogger(logging.New(logger, "maps"))
+	mapsUpdater.SetTransferCounter(&counters.MapsTransferred)
+	wgManager := wireguard.New(cfg.WireGuardDir)
+	wgManager.SetLogger(logging.New(logger, "wireguard"))
+	wgManager.SetChangeCounter(&counters.WireGuardChanges)
+	wgManager.SetMaxSize(int64(cfg.WireGuardMaxBytes))
+	wgVars, err := parseTemplateVars(cfg.WireGuardTemplateVars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UMS_WG_TEMPLATE_VARS: %w", err)
+	}
+
+	updateLdr := update.New(client, dbcInterface, cfg.OTADir)
+	updateLdr.SetLogger(logging.New(logger, "update"))
+	rpmInstaller := rpm.New(dbcInterface, command.Exec{})
+	scriptRunner := scripts.New(dbcInterface, command.Exec{})
+	diagCollector := diagnostics.New(dbcInterface, cfg.SettingsFile)
+	diagCollector.SetDBCHost(cfg.DBCHost)
+	dataBackup := databackup.New(databackup.DataRoot)
+	dataBackup.SetChecksumAlgorithm(checksumAlgo)
+	if err := dataBackup.SetPaths(splitList(cfg.BackupPaths)); err != nil {
+		return nil, fmt.Errorf("invalid UMS_BACKUP_PATHS: %w", err)
+	}
+	if err := dataBackup.SetExclude(splitList(cfg.BackupExclude)); err != nil {
+		return nil, fmt.Errorf("invalid UMS_BACKUP_EXCLUDE: %w", err)
+	}
+	if cfg.DryRun {
+		log.Println("Dry run: external commands are logged, not run")
+		usbCtrl.SetDryRun(true)
+		diskMgr.SetDryRun(true)
+		dbcInterface.SetDryRun(true)
+		updateLdr.SetDryRun(true)
+		rpmInstaller.SetDryRun(true)
+		scriptRunner.SetDryRun(true)
+	}
+	var hookRunner *hooks.Runner
+	if cfg.Hooks {
+		hookRunner = hooks.New(cfg.HooksDir, command.Exec{})
+		hookRunner.SetLogger(logging.New(logger, "hooks"))
+		hookRunner.SetTimeout(cfg.HookTimeout)
+		hookRunner.SetDryRun(cfg.DryRun)
+	}
 
 	svc := &Service{
 		config:        cfg,
 		client:        client,
+		redis:         client,
 		watcher:       client.NewHashWatcher("usb"),
 		publisher:     client.NewHashPublisher("usb"),
+		usagePub:      client.NewHashPublisher("usb:usage"),
+		resultPub:     client.NewHashPublisher("usb:result"),
+		lastUpdatePub: client.NewHashPublisher("usb:last-update"),
+		ops:           newOpStatus(client.NewHashPublisher("usb:status")),
+		metrics:       counters,
 		usbCtrl:       usbCtrl,
 		diskMgr:       diskMgr,
 		dbcInterface:  dbcInterface,
@@ -108,16 +374,43 @@ func New(cfg *config.Config) (*Service, error) {
 		updateLdr:     updateLdr,
 		mapsUpdater:   mapsUpdater,
 		wgManager:     wgManager,
-		diagnostics:   diagnostics.New(),
+		diagnostics:   diagCollector,
 		rpmInstaller:  rpmInstaller,
 		scriptRunner:  scriptRunner,
 		logBundlesMgr: logbundles.New(),
 		radioGagaMgr:  radiogaga.New(),
 		uplinkMgr:     uplink.New(),
-		onbootMgr:     onboot.New(),
-	}
-
+		onbootMgr:     onboot.New(command.Exec{}),
+		logExporter:   logexport.New(splitList(cfg.LogExportUnits), cfg.LogExportBytes),
+		dataBackup:    dataBackup,
+		modePolicy:    modePolicy,
+		modeQueue:     newModeQueue(cfg.ModeQueueSize),
+		procOrder:     processingOrder,
+		statusBoard:   statusapi.NewBoard(),
+		logHub:        statusapi.NewLogHub(),
+		checksumAlgo:  checksumAlgo,
+		driveManifest: cfg.DriveManifest,
+		dryRun:        cfg.DryRun,
+		secureClean:   cfg.CleanMode == "reformat",
+		hooks:         hookRunner,
+		strictHooks:   cfg.HooksStrict,
+	}
+	svc.driveRetryDelay = defaultDriveRetryDelay
+
+	svc.procSteps = svc.defaultProcessingSteps()
+	svc.populate = svc.populateDrive
+	svc.batchPath = filepath.Join(cfg.StateDir, batchJournalName)
+	svc.backupRequest = filepath.Join(cfg.StateDir, backupRequestName)
+	svc.reboots = newRebootController(svc.redis, svc.publisher, rebootWindow, svc.setStatus)
+	svc.reboots.intentPath = filepath.Join(cfg.StateDir, pendingRebootName)
+	svc.reboots.dryRun = cfg.DryRun
+	if !cfg.AutoReboot {
+		svc.reboots.handOff = svc.ops.setRebootRequired
+	}
+	wgManager.SetTemplateVars(templateVars(svc.redis, wgVars))
 	svc.watcher.OnField("mode", svc.handleModeChange)
+	svc.watcher.OnField("reboot", svc.handleRebootField)
+	self.Store(svc)
 
 	return svc, nil
 }
@@ -141,16 +434,89 @@ func parseRedisAddr(addr string) (string, int, error) {
 	return "", 0, err
 }
 
+// splitList splits a comma-separated config value, dropping blanks.
+func splitList(raw string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// parseFileMode parses an octal permission string such as "0644". Empty
+// means "leave unchanged" and yields 0.
+func parseFileMode(raw string) (os.FileMode, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("expected octal permissions such as 0644")
+	}
+	if v > 0777 {
+		return 0, fmt.Errorf("only permission bits (0000-0777) are allowed")
+	}
+	return os.FileMode(v), nil
+}
+
+// runContext is Run's context, cancelled when the service shuts down, or
+// the background context before Run.
+func (s *Service) runContext() context.Context {
+	if s.serviceCtx == nil {
+		return context.Background()
+	}
+	return s.serviceCtx
+}
+
 func (s *Service) Run(ctx context.Context) error {
 	log.Println("Starting UMS service...")
 	s.serviceCtx = ctx
 
+	// Up before the disk is initialized, so /readyz can say it isn't.
+	if s.config.StatusAddr != "" {
+		srv := statusapi.NewServer(s.config.StatusAddr, s.statusBoard, s.logHub)
+		srv.SetFileLister(s.listDrive)
+		s.mountHealth(srv)
+		srv.Start()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
+	}
+
+	// Mass storage still loaded means the service restarted mid-session
+	// and the host may still have the drive. Carry the session on rather
+	// than pulling the drive from under it; it is processed on detach.
+	// The drive check must leave such an image alone.
+	resumingUMS := s.usbCtrl.GetCurrentMode() == "ums"
+	s.diskMgr.SetExported(resumingUMS)
+
 	if err := s.diskMgr.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize disk manager: %w", err)
 	}
+	s.diskReady.Store(true)
+	driveCheck := s.diskMgr.StartupCheck()
+	if driveCheck.Recovered() {
+		log.Printf("Warning: USB drive image was corrupted and has been %s", driveCheck)
+	}
 
+	s.validateDataPaths()
 	s.runStartupCleanup()
 
+	if err := s.usbCtrl.ReconcileGadget(); err != nil {
+		log.Printf("Warning: gadget self-check failed: %v", err)
+	}
+
+	status := "idle"
+	if resumingUMS {
+		log.Println("Mass storage still loaded from a previous run, resuming UMS session")
+		s.umsModeType = "ums"
+		status = "ums-ready"
+	}
 	s.usbCtrl.StartMonitoring()
 
 	go s.detachLoop(ctx)
@@ -166,32 +532,72 @@ func (s *Service) Run(ctx context.Context) error {
 
 	// Seed the usb hash with the baseline state so readers (e.g. `lsc usb
 	// status`) see a real value instead of an empty hash on a boot where no
-	// mode change has happened yet. Writing mode=normal also reconciles any
-	// stale mode=ums left in Redis if the scooter rebooted mid-session, since
-	// the controller always starts in normal mode. NoPublish keeps boot from
-	// emitting a spurious change notification; the watcher's StartWithSync
-	// below reads the hash directly regardless.
-	if err := s.publisher.SetMany(map[string]any{
-		"mode":   s.usbCtrl.GetCurrentMode(),
-		"status": "idle",
-	}, ipc.Sync(), ipc.NoPublish()); err != nil {
+	// mode change has happened yet. Writing the controller's mode also
+	// reconciles a stale mode=ums left in Redis if the scooter rebooted
+	// mid-session: the controller takes its mode from the loaded modules,
+	// so it is normal after a reboot. NoPublish keeps boot from emitting a
+	// spurious change notification; the watcher's StartWithSync below
+	// reads the hash directly regardless.
+	seed := map[string]any{
+		"mode":        s.usbCtrl.GetCurrentMode(),
+		"active-mode": s.activeMode(),
+		"status":      status,
+		"drive-check": string(driveCheck),
+	}
+	if driveCheck.Recovered() {
+		seed["warning"] = "drive-recovered"
+	}
+	if err := s.publisher.SetMany(seed, ipc.Sync(), ipc.NoPublish()); err != nil {
 		return fmt.Errorf("failed to seed usb hash: %w", err)
 	}
+	s.showStatus(status)
+
+	// A batch cut short by a reboot is finished before any new mode
+	// request: the worker blocks on s.mu until it's done.
+	s.mu.Lock()
+	_, err := os.Stat(s.batchPath)
+	resuming := err == nil
+	if resuming {
+		go func() {
+			defer s.mu.Unlock()
+			s.resumeBatch()
+		}()
+	} else {
+		s.mu.Unlock()
+	}
+
+	// The worker must be running before StartWithSync delivers the
+	// current mode.
+	go runModeWorker(ctx, s.modeQueue, s.applyModeChange)
 
 	// StartWithSync is non-blocking: it subscribes to the Redis channel,
 	// syncs current hash state, then processes messages in a goroutine.
 	if err := s.watcher.StartWithSync(); err != nil {
 		return fmt.Errorf("failed to start hash watcher: %w", err)
 	}
+	s.watching.Store(true)
+
+	// A resumed batch picks up any deferred reboot once it's done.
+	if !resuming {
+		s.resumeDeferredReboot()
+	}
 
 	log.Println("UMS service running, waiting for mode changes...")
 	<-ctx.Done()
+
+	log.Println("Shutting down, waiting for any mode switch in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: shutdown: %v", err)
+	}
 	return nil
 }
 
-// detachLoop reads USB detach signals from the controller and handles
+// detachLoop reads USB detach signals from the controller and queues
 // the mode transition back to normal. Running in its own goroutine
-// ensures the service mutex is acquired cleanly without reentrancy.
+// ensures the service mutex is acquired cleanly without reentrancy. It
+// also reports links the controller recovered.
 func (s *Service) detachLoop(ctx context.Context) {
 	for {
 		select {
@@ -199,322 +605,493 @@ func (s *Service) detachLoop(ctx context.Context) {
 			return
 		case <-s.usbCtrl.DetachCh():
 			s.onDeviceDetached()
+		case fault := <-s.usbCtrl.RecoveredCh():
+			s.onLinkRecovered(fault)
 		}
 	}
 }
 
+// onLinkRecovered publishes event=ums-link-recovered in the usb hash
+// after the controller brought a degraded UMS link back.
+func (s *Service) onLinkRecovered(fault string) {
+	log.Printf("UMS link recovered: %s", fault)
+	s.newLogger().Logf("usb", "link recovered (%s)", fault)
+	if err := s.publisher.Set("event", "ums-link-recovered", ipc.Sync()); err != nil {
+		log.Printf("Error publishing link recovery: %v", err)
+	}
+}
+
+// handleModeChange is the usb.mode watcher callback. It only queues the
+// request; the mode worker applies it, so a burst of notifications costs
+// a bounded queue rather than blocked handlers piling up on s.mu.
 func (s *Service) handleModeChange(mode string) error {
+	s.modeQueue.push(mode)
+	return nil
+}
+
+// applyModeChange switches to mode. Runs on the mode worker.
+func (s *Service) applyModeChange(mode string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.applyModeChangeLocked(mode)
+}
 
+// applyModeChangeLocked is applyModeChange with s.mu held.
+func (s *Service) applyModeChangeLocked(mode string) error {
 	prevMode := s.usbCtrl.GetCurrentMode()
-	if prevMode == mode {
+	active := s.activeMode()
+	if active == mode {
 		return nil
 	}
 
-	switch mode {
-	case "ums", "ums-by-dbc":
-		return s.switchToUMS(mode)
-	case "normal":
-		return s.switchToNormal(prevMode)
-	default:
+	if !isKnownMode(mode) {
 		return fmt.Errorf("unknown mode: %s", mode)
 	}
-}
-
-func (s *Service) switchToUMS(mode string) error {
-	s.setStatus("preparing")
-
-	if s.rebootWatcher != nil {
-		log.Println("Cancelling pending reboot watcher (re-entering UMS)")
-		s.rebootWatcher()
-		// Don't nil rebootWatcher here — the goroutine's defer
-		// handles cleanup under the generation check. Nilling
-		// here would also be safe but is redundant.
+	// The host has the image in every UMS flavour; another flavour would
+	// mean preparing the drive under it. It has to go through normal,
+	// which also processes what the host wrote.
+	if prevMode == "ums" && mode != "normal" {
+		log.Printf("Refusing mode change to %s: already in %s mode", mode, active)
+		s.restoreModeField(active)
+		return fmt.Errorf("mode %s refused: already in %s mode, switch to normal first", mode, active)
 	}
-
-	if _, err := s.client.Del("usb:log"); err != nil {
-		log.Printf("Warning: failed to clear usb:log: %v", err)
+	if err := s.modePolicy.permit(mode, s.redis); err != nil {
+		log.Printf("Refusing mode change to %s: %v", mode, err)
+		s.setStatus("mode-not-permitted")
+		s.restoreModeField(active)
+		return fmt.Errorf("mode %s not permitted: %w", mode, err)
 	}
-
-	if err := s.diskMgr.Mount(); err != nil {
-		s.setStatus("idle")
-		return fmt.Errorf("failed to mount drive: %w", err)
+	if err := s.runHooks("pre", mode); err != nil && s.strictHooks {
+		log.Printf("Refusing mode change to %s: %v", mode, err)
+		s.restoreModeField(active)
+		return fmt.Errorf("mode %s refused by hook: %w", mode, err)
 	}
 
-	mountPoint := s.diskMgr.GetMountPoint()
-
-	if err := s.settingsLdr.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying settings to USB: %v", err)
+	var err error
+	switch mode {
+	case "ums", "ums-by-dbc", "ums-ro":
+		err = s.switchToUMS(mode)
+	case "normal":
+		err = s.doSwitchToNormal(prevMode)
+	default:
+		return fmt.Errorf("unknown mode: %s", mode)
 	}
-
-	if err := s.updateLdr.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing update directory: %v", err)
+	// Whether the switch succeeded or not, mode only says what was asked
+	// for; active-mode says what the gadget ended up in.
+	s.publishActiveMode()
+	if err == nil {
+		s.runHooks("post", mode)
 	}
+	return err
+}
 
-	if err := s.mapsUpdater.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing maps directory: %v", err)
+// restoreModeField puts mode back after a refused request, so the hash
+// reflects reality; the resulting notification is a no-op since it
+// matches the active mode.
+func (s *Service) restoreModeField(active string) {
+	if err := s.publisher.Set("mode", active, ipc.Sync()); err != nil {
+		log.Printf("Error restoring usb mode: %v", err)
 	}
+}
 
-	if err := s.wgManager.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing wireguard directory: %v", err)
-	}
-	if err := s.wgManager.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying wireguard configs to USB: %v", err)
+// runHooks runs the integrator hooks for phase ("pre" or "post") of a
+// switch to mode. All UMS modes share the ums hooks; the mode itself is
+// passed to the scripts. Failures are logged and counted, and returned
+// for a strict pre hook to refuse the switch. Must be called with s.mu
+// held.
+func (s *Service) runHooks(phase, mode string) error {
+	target := "ums"
+	if mode == "normal" {
+		target = "normal"
+	}
+	err := s.hooks.Run(s.runContext(), phase+"-"+target, mode)
+	if err != nil {
+		log.Printf("Error running %s-%s hooks: %v", phase, target, err)
+		s.metrics.Fail("hooks")
 	}
+	return err
+}
 
-	if err := s.radioGagaMgr.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing radio-gaga directory: %v", err)
-	}
-	if err := s.radioGagaMgr.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying radio-gaga config to USB: %v", err)
-	}
+func (s *Service) switchToUMS(mode string) (err error) {
+	s.setStatus("preparing")
+	s.ops.begin(opMounting)
+	defer func() { s.endSwitch(err) }()
 
-	if err := s.uplinkMgr.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing uplink-service directory: %v", err)
-	}
-	if err := s.uplinkMgr.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying uplink-service config to USB: %v", err)
+	if s.reboots.Suspend() {
+		log.Println("Suspending pending reboot (re-entering UMS)")
 	}
 
-	if err := s.onbootMgr.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying onboot.sh to USB: %v", err)
+	if _, err := s.redis.Del("usb:log"); err != nil {
+		log.Printf("Warning: failed to clear usb:log: %v", err)
 	}
 
-	if err := s.logBundlesMgr.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing log-bundles directory: %v", err)
-	}
-	if err := s.logBundlesMgr.CopyToUSB(mountPoint); err != nil {
-		log.Printf("Error copying log bundles to USB: %v", err)
+	if err := s.diskMgr.Mount(); err != nil {
+		s.setStatus("idle")
+		return fmt.Errorf("failed to mount drive: %w", err)
 	}
 
-	s.diagnostics.CollectToUSB(mountPoint)
-
-	if err := s.rpmInstaller.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing rpms directory: %v", err)
+	if err := s.diskMgr.EnsureWritable(); err != nil {
+		if uerr := s.diskMgr.Unmount(); uerr != nil {
+			log.Printf("Error unmounting USB drive: %v", uerr)
+		}
+		s.setStatus("idle")
+		return err
 	}
 
-	if err := s.scriptRunner.PrepareUSB(mountPoint); err != nil {
-		log.Printf("Error preparing scripts directory: %v", err)
-	}
+	s.ops.set(opCopying)
+	s.populate(s.diskMgr.GetMountPoint())
 
-	if err := s.diskMgr.Unmount(); err != nil {
+	if err := s.retryDrive("unmounting drive", s.diskMgr.Unmount); err != nil {
 		s.setStatus("idle")
+		// Even if it was detached lazily, its filesystem is still live
+		// and must not be handed to the host.
+		if errors.Is(err, disk.ErrDriveBusy) {
+			return fmt.Errorf("%s: %w", driveBusyHint, err)
+		}
 		return fmt.Errorf("failed to unmount drive: %w", err)
 	}
 
 	// Publish status BEFORE switching USB — DBC can still read Redis via g_ether
+	s.ops.set(opSwitching)
 	s.setStatus("active")
 	s.setLEDs(ledsUMSActive)
 
+	s.usbCtrl.SetReadOnly(mode == "ums-ro")
 	if err := s.usbCtrl.SwitchMode("ums"); err != nil {
 		s.setStatus("idle")
 		s.setLEDs(ledsOff)
 		return fmt.Errorf("failed to switch to UMS mode: %w", err)
 	}
+	s.diskMgr.SetExported(true)
+
+	// g_mass_storage is loaded with the drive image as its LUN, so the host
+	// can enumerate and mount it from here on. "active" above only means
+	// we've committed to the switch; "ums-ready" is the "connect now" signal
+	// for MDB-local readers (the DBC has lost its g_ether link by now).
+	s.setStatus("ums-ready")
 
 	s.umsModeType = mode
 	s.detachCount = 0
+	s.metrics.UMSEntries.Inc()
 	log.Printf("Switched to UMS mode (type: %s)", mode)
 	return nil
 }
 
-func (s *Service) switchToNormal(prevMode string) error {
+// populateDrive fills the mounted drive for a UMS session: the current
+// settings and configs, diagnostics, logs, a requested backup and the
+// directories the host can drop files into. Failures are logged as they
+// happen and don't hold up the switch.
+func (s *Service) populateDrive(mountPoint string) {
+	s.warnIfDriveNearlyFull()
+
+	runPrepareSteps(mountPoint, s.prepareSteps())
+
+	s.diagnostics.CollectToUSB(mountPoint)
+
+	if err := s.logExporter.ExportToUSB(mountPoint); err != nil {
+		log.Printf("Error exporting logs to USB: %v", err)
+	}
+
+	s.writeDataBackup(mountPoint)
+
+	if t, err := s.diskMgr.ProbeWriteSpeed(); err != nil {
+		log.Printf("Warning: cannot measure drive throughput: %v", err)
+	} else {
+		s.publishWriteThroughput(t)
+	}
+
+	if err := s.rpmInstaller.PrepareUSB(mountPoint); err != nil {
+		log.Printf("Error preparing rpms directory: %v", err)
+	}
+
+	if err := s.scriptRunner.PrepareUSB(mountPoint); err != nil {
+		log.Printf("Error preparing scripts directory: %v", err)
+	}
+
+	if s.driveManifest {
+		s.writeDriveManifest(mountPoint)
+	}
+}
+
+func (s *Service) switchToNormal(prevMode string) (err error) {
+	s.ops.begin(opSwitching)
+	defer func() { s.endSwitch(err) }()
 	s.setLEDs(ledsOff)
 
 	if err := s.usbCtrl.SwitchMode("normal"); err != nil {
 		return fmt.Errorf("failed to switch to normal mode: %w", err)
 	}
+	s.diskMgr.SetExported(false)
 
 	if prevMode != "ums" {
 		s.setStep("")
 		s.setStatus("idle")
 		return nil
 	}
+	s.metrics.NormalReturns.Inc()
+
+	// Nothing the host could have left for us: the drive still holds
+	// what switchToUMS put there, which the next session replaces.
+	skip := ""
+	switch {
+	case s.umsModeType == "ums-ro":
+		skip = "Drive was exported read-only"
+	case !s.usbCtrl.HadActivity():
+		skip = "Host didn't write to the drive"
+	}
+	if skip != "" {
+		log.Printf("%s, skipping processing", skip)
+		s.umsModeType = ""
+		s.setStep("")
+		if !s.resumeDeferredReboot() {
+			s.setStatus("idle")
+		}
+		return nil
+	}
 
 	s.setStatus("processing")
+	s.ops.set(opMounting)
 
-	if err := s.diskMgr.Mount(); err != nil {
+	if err := s.retryDrive("mounting drive", s.diskMgr.Mount); err != nil {
 		s.setStep("")
 		s.setStatus("idle")
 		return fmt.Errorf("failed to mount drive: %w", err)
 	}
 
-	ctx := context.Background()
-	mountPoint := s.diskMgr.GetMountPoint()
-	logger := umslog.New(s.client)
+	c := &normalCycle{
+		ctx:        s.runContext(),
+		mountPoint: s.diskMgr.GetMountPoint(),
+		logger:     s.newLogger(),
+		journal:    s.batchPath,
+	}
+	s.processDrive(c, true)
+	log.Println("Switched to normal mode and processed files")
+	log.Printf("Metrics: %s", s.metrics.Snapshot())
 
-	needDBC := s.checkIfDBCNeeded(mountPoint)
+	return nil
+}
 
-	if needDBC {
-		if err := s.dbcInterface.Enable(ctx); err != nil {
-			logger.Error("dbc", "Failed to enable: %v", err)
-			log.Printf("Warning: failed to enable DBC: %v", err)
-		} else {
-			logger.Logf("dbc", "enabled")
+// resumeBatch finishes a batch that an earlier run journalled but didn't
+// complete, typically because the scooter rebooted mid-processing. The
+// steps already done are skipped; the interrupted one runs again. It
+// reports whether a batch was found, and must be called with s.mu held.
+func (s *Service) resumeBatch() bool {
+	b, ok := loadBatch(s.batchPath)
+	if !ok {
+		if _, err := os.Stat(s.batchPath); err == nil {
+			log.Printf("Warning: discarding unreadable batch journal %s", s.batchPath)
+			clearBatch(s.batchPath)
 		}
+		return false
 	}
 
-	s.setStep("settings")
-	settingsChanged := false
-	if changed, err := s.settingsLdr.CopyFromUSB(mountPoint); err != nil {
-		logger.Error("settings", "%v", err)
-		log.Printf("Error processing settings: %v", err)
-	} else {
-		logger.Logf("settings", "done (changed=%v)", changed)
-		settingsChanged = changed
-	}
+	log.Printf("Resuming interrupted batch (done: %s)", strings.Join(b.Done, ", "))
+	s.setStatus("processing")
+	s.ops.begin(opMounting)
 
-	s.setStep("wireguard")
-	wgChanged := false
-	if changed, err := s.wgManager.SyncFromUSB(mountPoint); err != nil {
-		logger.Error("wireguard", "%v", err)
-		log.Printf("Error processing wireguard configs: %v", err)
-	} else {
-		logger.Logf("wireguard", "done (changed=%v)", changed)
-		wgChanged = changed
+	if err := s.diskMgr.Mount(); err != nil {
+		// Keep the journal: the next start may have better luck.
+		log.Printf("Error mounting drive to resume batch: %v", err)
+		s.ops.fail(fmt.Errorf("failed to mount drive: %w", err))
+		s.setStatus("idle")
+		return true
 	}
 
-	s.setStep("radio-gaga")
-	radioGagaChanged := false
-	if changed, err := s.radioGagaMgr.CopyFromUSB(mountPoint); err != nil {
-		logger.Error("radio-gaga", "%v", err)
-		log.Printf("Error processing radio-gaga config: %v", err)
-	} else {
-		logger.Logf("radio-gaga", "done (changed=%v)", changed)
-		radioGagaChanged = changed
+	c := &normalCycle{
+		ctx:        s.runContext(),
+		mountPoint: s.diskMgr.GetMountPoint(),
+		logger:     s.newLogger(),
+		journal:    s.batchPath,
+		batch:      b,
 	}
+	c.logger.Logf("batch", "resuming after interruption")
+	s.processDrive(c, false)
+	s.ops.end(nil)
+	log.Println("Resumed batch processed")
+	return true
+}
 
-	s.setStep("uplink-service")
-	uplinkChanged := false
-	if changed, err := s.uplinkMgr.CopyFromUSB(mountPoint); err != nil {
-		logger.Error("uplink-service", "%v", err)
-		log.Printf("Error processing uplink-service config: %v", err)
-	} else {
-		logger.Logf("uplink-service", "done (changed=%v)", changed)
-		uplinkChanged = changed
+// endSwitch finishes the mode switch's operation status, counting it as
+// a failure if err is set.
+func (s *Service) endSwitch(err error) {
+	if err != nil {
+		s.metrics.Fail("mode-switch")
 	}
+	s.ops.end(err)
+}
 
-	s.setStep("onboot")
-	if changed, err := s.onbootMgr.CopyFromUSB(mountPoint); err != nil {
-		logger.Error("onboot", "%v", err)
-		log.Printf("Error processing onboot.sh: %v", err)
-	} else {
-		logger.Logf("onboot", "done (changed=%v)", changed)
-	}
+// noteError records a failure processing carries on past in usb:status
+// and counts it.
+func (s *Service) noteError(category, msg string) {
+	s.ops.noteError(category, msg)
+	s.metrics.Fail(category)
+}
 
-	s.setStep("updates")
-	queued, err := s.updateLdr.ProcessUpdates(ctx, s.config.MenderTransferTimeout, logger, mountPoint)
-	if err != nil {
-		logger.Error("updates", "%v", err)
-		log.Printf("Error processing updates: %v", err)
-	} else {
-		logger.Logf("updates", "done")
+// processDrive applies the mounted drive's contents, cleans and unmounts
+// it, and hands off to the reboot watcher if updates were queued. fresh
+// is false when c resumes a journalled batch.
+func (s *Service) processDrive(c *normalCycle, fresh bool) {
+	logger := c.logger
+	logger.OnError(func(category, msg string) {
+		s.noteError(category, msg)
+		c.Errors = append(c.Errors, cycleError{Category: category, Message: msg})
+	})
+	s.ops.set(opCopying)
+
+	// The host's files can still be read from a read-only drive, so carry
+	// on; only the log file and cleanup will fail.
+	if err := s.diskMgr.EnsureWritable(); err != nil {
+		logger.Error("drive", "%v", err)
+		log.Printf("Warning: %v", err)
+	}
+
+	if fresh {
+		s.publishSessionUsage()
+		if c.journal != "" {
+			if err := saveBatch(c.journal, c.batch); err != nil {
+				log.Printf("Warning: failed to journal batch progress: %v", err)
+			}
+		}
 	}
-	logger.ClearProgress()
 
-	s.setStep("maps")
-	if err := s.mapsUpdater.ProcessMaps(ctx, s.config.MapTransferTimeout, logger, mountPoint); err != nil {
-		logger.Error("maps", "%v", err)
-		log.Printf("Error processing maps: %v", err)
-	} else {
-		logger.Logf("maps", "done")
-	}
-	logger.ClearProgress()
+	s.readPlan(c)
+	s.noteBackupRequest(c)
+	needDBC := s.checkIfDBCNeeded(c.mountPoint, c.plan)
 
-	if err := s.rpmInstaller.ProcessRPMs(ctx, s.config.RPMTransferTimeout, logger, mountPoint); err != nil {
-		logger.Error("rpms", "%v", err)
-		log.Printf("Error processing RPMs: %v", err)
-	} else {
-		logger.Logf("rpms", "done")
+	if needDBC {
+		if err := s.dbcInterface.Enable(c.ctx); err != nil {
+			logger.Error("dbc", "Failed to enable: %v", err)
+			log.Printf("Warning: failed to enable DBC: %v", err)
+		} else {
+			logger.Logf("dbc", "enabled")
+		}
 	}
-	logger.ClearProgress()
 
-	if err := s.scriptRunner.ProcessScripts(ctx, s.config.ScriptTransferTimeout, logger, mountPoint); err != nil {
-		logger.Error("scripts", "%v", err)
-		log.Printf("Error processing scripts: %v", err)
+	s.runProcessing(c)
+	if err := c.ctx.Err(); err != nil {
+		s.abandonCycle(c, needDBC)
+		return
 	}
-	logger.ClearProgress()
+	result := c.Result
 
-	if settingsChanged || wgChanged {
-		restartUnit(logger, "librescoot-settings.service")
+	if c.SettingsStaged {
+		result.Settings = s.commitSettings(logger)
+		if result.Settings {
+			s.metrics.SettingsChanges.Inc()
+		}
+	} else if result.WireGuard {
+		s.restartUnit(logger, settingsUnit)
 	}
-	if radioGagaChanged {
-		restartUnit(logger, "radio-gaga.service")
+	if result.RadioGaga {
+		s.restartUnit(logger, "radio-gaga.service")
 	}
-	if uplinkChanged {
-		restartUnit(logger, "librescoot-uplink.service")
+	if result.Uplink {
+		s.restartUnit(logger, "librescoot-uplink.service")
 	}
-
-	if err := logger.WriteToFile(filepath.Join(mountPoint, "ums_log.txt")); err != nil {
-		log.Printf("Error writing log file: %v", err)
+	for _, unit := range s.restoreData(c) {
+		s.restartUnit(logger, unit)
 	}
 
 	s.runPostCycleCleanup()
 
-	if err := s.diskMgr.CleanDrive(); err != nil {
+	queued := c.Queued
+	result.RebootPending = !c.UpdatesFailed && (queued.MDB || queued.DBC)
+	summary := s.buildReceipt(c, result)
+
+	// Clean before writing ums_log.txt (which cleaning spares) so the
+	// log can say what was kept.
+	kept, err := s.cleanDrive()
+	if err != nil {
 		log.Printf("Error cleaning USB drive: %v", err)
+		s.noteError("drive", fmt.Sprintf("cleaning failed: %v", err))
+	}
+	if len(kept) > 0 {
+		logger.Logf("drive", "kept unknown directories: %s", strings.Join(kept, ", "))
+	}
+
+	// After cleaning, so they stay on the drive for the next session.
+	if needDBC && s.dbcInterface.IsEnabled() {
+		if err := s.diagnostics.FetchDBCLogs(c.ctx, c.mountPoint); err != nil {
+			log.Printf("Warning: fetching DBC logs: %v", err)
+		} else {
+			logger.Logf("diagnostics", "DBC logs saved to diagnostics/dbc/")
+		}
+	}
+
+	if err := logger.WriteToFile(filepath.Join(c.mountPoint, "ums_log.txt")); err != nil {
+		log.Printf("Error writing log file: %v", err)
+		s.noteError("drive", fmt.Sprintf("writing ums_log.txt failed: %v", err))
+	}
+	// Last, so it also covers errors from cleaning and writing the log.
+	summary.Errors = append([]cycleError{}, c.Errors...)
+	if err := writeReceipt(c.mountPoint, summary); err != nil {
+		log.Printf("Error writing %s: %v", receiptFile, err)
+		s.noteError("drive", fmt.Sprintf("writing %s failed: %v", receiptFile, err))
 	}
 
 	if err := s.diskMgr.Unmount(); err != nil {
 		log.Printf("Error unmounting USB drive: %v", err)
+		if errors.Is(err, disk.ErrDriveBusy) {
+			logger.Error("drive", "%s", driveBusyHint)
+		} else {
+			s.noteError("drive", fmt.Sprintf("unmount failed: %v", err))
+		}
+	}
+
+	if c.journal != "" {
+		clearBatch(c.journal)
 	}
 
 	if needDBC {
 		if err := s.dbcInterface.Disable(); err != nil {
 			log.Printf("Warning: failed to disable DBC: %v", err)
+			s.noteError("dbc", fmt.Sprintf("disable failed: %v", err))
 		}
 	}
 
 	s.umsModeType = ""
 	s.setStep("")
 
-	if err == nil && (queued.MDB || queued.DBC) {
+	if result.RebootPending {
 		// Hand off to the awaiter goroutine. It owns setStatus
 		// transitions from "awaiting-reboot" back to "idle".
 		// On ProcessUpdates error we skip the watcher even if some
 		// pushes were staged — the partial state would confuse a
 		// user who only sees the error in usb:log.
 		s.startRebootWatcher(queued)
-	} else {
+	} else if !s.resumeDeferredReboot() {
 		s.setStatus("idle")
 	}
-	log.Println("Switched to normal mode and processed files")
+	s.publishCycleResult(result)
+}
 
-	return nil
+// abandonCycle stops processing when the service is shutting down. What
+// is left of the batch stays journalled and is finished on the next
+// start; Shutdown unmounts the drive. Must be called with s.mu held.
+func (s *Service) abandonCycle(c *normalCycle, needDBC bool) {
+	log.Printf("Shutting down mid-batch (done: %s); the rest runs on the next start", listOrNone(c.Done))
+	c.logger.Logf("batch", "interrupted by shutdown")
+	if needDBC {
+		if err := s.dbcInterface.Disable(); err != nil {
+			log.Printf("Warning: failed to disable DBC: %v", err)
+		}
+	}
 }
 
 // startRebootWatcher launches a goroutine that subscribes to the ota
 // hash, performs the queued install LPushes, waits for completion, and
-// triggers a reboot. Must be called with s.mu held (so writes to
-// s.rebootWatcher / s.rebootGen are race-free with switchToUMS).
+// hands the reboot to the RebootController. Must be called with s.mu held.
 func (s *Service) startRebootWatcher(queued update.Queued) {
-	ctx, cancel := context.WithCancel(s.serviceCtx)
-	s.rebootWatcher = cancel
-	s.rebootGen++
-	myGen := s.rebootGen
+	// The target is only known once the installs report back.
+	ctx, gen := s.reboots.Begin(s.serviceCtx, false)
 	s.setStatus("awaiting-reboot")
-	go s.awaitInstallsAndReboot(ctx, queued, myGen)
+	go s.awaitInstallsAndReboot(ctx, queued, gen)
 }
 
-func (s *Service) awaitInstallsAndReboot(ctx context.Context, queued update.Queued, myGen int) {
-	defer func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		// If a newer goroutine has been started, leave its state
-		// alone — we're a zombie from a cancelled cycle.
-		if s.rebootGen != myGen {
-			return
-		}
-		s.rebootWatcher = nil
-		// If we were cancelled externally, whoever cancelled us
-		// (switchToUMS) already owns the status field; don't clobber.
-		if ctx.Err() == nil {
-			s.setStatus("idle")
-		}
-	}()
+func (s *Service) awaitInstallsAndReboot(ctx context.Context, queued update.Queued, gen int) {
+	defer s.finishRebootWatcher(ctx, gen)
 
-	logger := umslog.New(s.client)
+	logger := s.newLogger()
 
 	source, err := update.NewIPCOTASource(s.client)
 	if err != nil {
@@ -525,7 +1102,7 @@ func (s *Service) awaitInstallsAndReboot(ctx context.Context, queued update.Queu
 	defer source.Stop()
 
 	for _, p := range queued.PendingPushes {
-		if _, perr := s.client.LPush(p.Channel, p.Value); perr != nil {
+		if _, perr := s.redis.LPush(p.Channel, p.Value); perr != nil {
 			logger.Error("reboot", "LPush %s failed: %v", p.Channel, perr)
 			log.Printf("awaiter: LPush %s failed: %v", p.Channel, perr)
 			return
@@ -533,50 +1110,149 @@ func (s *Service) awaitInstallsAndReboot(ctx context.Context, queued update.Queu
 		logger.Logf("reboot", "queued %s", p.Channel)
 	}
 
-	if err := update.WaitForCompletion(ctx, source, queued, installAwaitTimeout); err != nil {
-		logger.Error("reboot", "skip: %v", err)
-		log.Printf("awaiter: skip reboot: %v", err)
+	outcomes, err := update.AwaitOutcomes(ctx, source, queued, installAwaitTimeout)
+	if ctx.Err() != nil {
 		return
 	}
-
-	state, err := s.client.HGet("vehicle", "state")
 	if err != nil {
-		logger.Error("reboot", "skip: failed to read vehicle state: %v", err)
-		log.Printf("awaiter: failed to read vehicle state: %v", err)
-		return
+		logger.Error("reboot", "%v", err)
+		log.Printf("awaiter: %v", err)
 	}
-	if !rebootAllowedVehicleStates[state] {
-		logger.Logf("reboot", "skip: vehicle state %q not in allowed set", state)
-		log.Printf("awaiter: skip reboot, vehicle state is %q", state)
+	s.reportInstallOutcomes(logger, outcomes)
+
+	reboot, mdb := rebootPlan(outcomes)
+	s.recordLastUpdate(queued, outcomes, reboot)
+	if !reboot {
+		logger.Logf("reboot", "skip: no install completed")
+		log.Println("awaiter: skip reboot, no install completed")
 		return
 	}
+	s.reboots.Fire(ctx, logger, mdb)
+}
 
-	if queued.MDB {
-		if _, err := s.client.LPush("scooter:power", "reboot"); err != nil {
-			logger.Error("reboot", "LPush scooter:power reboot failed: %v", err)
-			log.Printf("awaiter: failed to trigger MDB reboot: %v", err)
-			return
+// rebootPlan decides from the install outcomes whether a reboot is
+// needed and whether it must be the MDB (which takes the DBC with it) or
+// just a DBC power cycle. A failed MDB install no longer holds back the
+// power cycle a successful DBC install needs.
+func rebootPlan(outcomes map[string]update.Outcome) (reboot, mdb bool) {
+	if outcomes["mdb"] == update.OutcomeInstalled {
+		return true, true
+	}
+	return outcomes["dbc"] == update.OutcomeInstalled, false
+}
+
+// reportInstallOutcomes logs each component's install outcome and adds
+// it to usb:result as install-<component>.
+func (s *Service) reportInstallOutcomes(logger *umslog.Logger, outcomes map[string]update.Outcome) {
+	fields := make(map[string]any, len(outcomes))
+	for _, component := range []string{"mdb", "dbc"} {
+		outcome, ok := outcomes[component]
+		if !ok {
+			continue
 		}
-		logger.Logf("reboot", "MDB reboot triggered")
-		log.Println("awaiter: MDB reboot triggered")
+		if outcome == update.OutcomeInstalled {
+			s.metrics.UpdatesApplied.Inc()
+			logger.Logf("updates", "%s install %s", component, outcome)
+		} else {
+			logger.Error("updates", "%s install %s", component, outcome)
+		}
+		fields["install-"+component] = string(outcome)
+	}
+	if len(fields) == 0 {
 		return
 	}
+	if err := s.resultPub.SetMany(fields, ipc.Sync()); err != nil {
+		log.Printf("Error publishing install outcomes: %v", err)
+	}
+}
 
-	// DBC-only: power-cycle the dashboard.
-	for _, cmd := range []string{"dashboard:off", "dashboard:on"} {
-		if _, err := s.client.LPush("scooter:hardware", cmd); err != nil {
-			logger.Error("reboot", "LPush scooter:hardware %s failed: %v", cmd, err)
-			log.Printf("awaiter: failed to send %s: %v", cmd, err)
-			return
-		}
+// recordLastUpdate replaces the usb:last-update hash with the artifacts
+// whose install succeeded, for fleet tooling to tell what a session put
+// on the scooter. Nothing is recorded if no install succeeded.
+func (s *Service) recordLastUpdate(queued update.Queued, outcomes map[string]update.Outcome, rebootPending bool) {
+	fields := map[string]any{
+		"mdb-artifact":   "",
+		"dbc-artifact":   "",
+		"installed-at":   time.Now().Unix(),
+		"reboot-pending": strconv.FormatBool(rebootPending),
+	}
+	installed := false
+	if outcomes["mdb"] == update.OutcomeInstalled {
+		fields["mdb-artifact"] = queued.MDBArtifact
+		installed = true
+	}
+	if outcomes["dbc"] == update.OutcomeInstalled {
+		fields["dbc-artifact"] = queued.DBCArtifact
+		installed = true
+	}
+	if !installed {
+		return
+	}
+	if err := s.lastUpdatePub.ReplaceAll(fields, ipc.Sync()); err != nil {
+		log.Printf("Error publishing usb:last-update: %v", err)
+	}
+}
+
+// resumeDeferredReboot picks up a reboot whose updates are installed but
+// which hasn't fired yet: deferred by a previous run of the service, or
+// suspended by a UMS session. Intents from before the last system boot
+// are dropped: that boot already applied the update. It reports whether
+// a reboot was resumed.
+func (s *Service) resumeDeferredReboot() bool {
+	intent, ok := s.reboots.Pending()
+	if !ok {
+		return false
+	}
+
+	log.Printf("Resuming deferred reboot (mdb=%v)", intent.MDB)
+
+	ctx, gen := s.reboots.Begin(s.serviceCtx, intent.MDB)
+	go func() {
+		defer s.finishRebootWatcher(ctx, gen)
+		s.reboots.Fire(ctx, s.newLogger(), false)
+	}()
+	return true
+}
+
+// handleRebootField handles writes to the usb hash's reboot field. The
+// service publishes its own intent there; a client writing "cancel"
+// drops the pending reboot.
+func (s *Service) handleRebootField(value string) error {
+	if value != "cancel" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.reboots.Cancel() {
+		return nil
 	}
-	logger.Logf("reboot", "DBC power cycle triggered")
-	log.Println("awaiter: DBC power cycle triggered")
+	log.Println("Pending reboot cancelled")
+	s.newLogger().Logf("reboot", "cancelled")
+	// A reboot suspended by a UMS session is cancelled too, but the
+	// status then belongs to the session.
+	switch s.statusBoard.Snapshot().Status {
+	case "awaiting-reboot", "reboot-deferred":
+		s.setStatus("idle")
+	}
+	return nil
 }
 
-func (s *Service) checkIfDBCNeeded(mountPoint string) bool {
+// finishRebootWatcher is deferred by reboot goroutines to return the
+// status to idle.
+func (s *Service) finishRebootWatcher(ctx context.Context, gen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// If a newer attempt has been started, or this one was suspended or
+	// cancelled, whoever did that owns the status field; don't clobber.
+	if !s.reboots.End(gen) || ctx.Err() != nil {
+		return
+	}
+	s.setStatus("idle")
+}
+
+func (s *Service) checkIfDBCNeeded(mountPoint string, plan *umsmanifest.Manifest) bool {
 	updateDir := filepath.Join(mountPoint, "system-update")
-	if entries, err := os.ReadDir(updateDir); err == nil {
+	if entries, err := os.ReadDir(updateDir); err == nil && plan.Enabled("updates") {
 		for _, entry := range entries {
 			if !entry.IsDir() && strings.HasPrefix(entry.Name(), "librescoot-") && strings.Contains(entry.Name(), "-dbc") && strings.HasSuffix(entry.Name(), ".mender") {
 				log.Println("Found DBC update files, DBC needed")
@@ -586,9 +1262,9 @@ func (s *Service) checkIfDBCNeeded(mountPoint string) bool {
 	}
 
 	mapsDir := filepath.Join(mountPoint, "maps")
-	if entries, err := os.ReadDir(mapsDir); err == nil {
+	if entries, err := os.ReadDir(mapsDir); err == nil && plan.Enabled("maps") {
 		for _, entry := range entries {
-			if !entry.IsDir() {
+			if !entry.IsDir() && listed(plan.MapFiles(), entry.Name()) {
 				filename := entry.Name()
 				if strings.HasSuffix(filename, ".mbtiles") ||
 					strings.HasSuffix(filename, "tiles.tar") ||
@@ -601,7 +1277,7 @@ func (s *Service) checkIfDBCNeeded(mountPoint string) bool {
 	}
 
 	dbcRPMDir := filepath.Join(mountPoint, "rpms", "dbc")
-	if entries, err := os.ReadDir(dbcRPMDir); err == nil {
+	if entries, err := os.ReadDir(dbcRPMDir); err == nil && plan.Enabled("rpms") {
 		for _, entry := range entries {
 			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".rpm") {
 				log.Println("Found DBC RPM files, DBC needed")
@@ -611,7 +1287,7 @@ func (s *Service) checkIfDBCNeeded(mountPoint string) bool {
 	}
 
 	dbcScript := filepath.Join(mountPoint, "scripts", "dbc.sh")
-	if _, err := os.Stat(dbcScript); err == nil {
+	if _, err := os.Stat(dbcScript); err == nil && plan.Enabled("scripts") {
 		log.Println("Found DBC script, DBC needed")
 		return true
 	}
@@ -620,9 +1296,24 @@ func (s *Service) checkIfDBCNeeded(mountPoint string) bool {
 	return false
 }
 
+// listed reports whether name is in names; a nil list holds everything.
+func listed(names []string, name string) bool {
+	if names == nil {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 // onDeviceDetached is called from detachLoop when the USB monitor detects
 // that the host has disconnected. It tracks the detach count to support
 // the ums-by-dbc mode which requires two disconnects before switching back.
+// The switch itself is queued for the mode worker like any other request,
+// so it can't run alongside or out of order with one from Redis.
 func (s *Service) onDeviceDetached() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -636,10 +1327,10 @@ func (s *Service) onDeviceDetached() {
 	log.Printf("USB detach #%d detected (mode type: %s)", s.detachCount, s.umsModeType)
 
 	switch s.umsModeType {
-	case "ums":
+	case "ums", "ums-ro":
 		if s.detachCount >= 1 {
-			log.Println("ums mode: switching to normal after disconnect")
-			s.doSwitchToNormal()
+			log.Printf("%s mode: switching to normal after disconnect", s.umsModeType)
+			s.modeQueue.push("normal")
 		}
 	case "ums-by-dbc":
 		if s.detachCount == 1 {
@@ -649,26 +1340,43 @@ func (s *Service) onDeviceDetached() {
 		}
 		if s.detachCount >= 2 {
 			log.Println("ums-by-dbc mode: second disconnect, switching to normal")
-			s.doSwitchToNormal()
+			s.modeQueue.push("normal")
 		}
 	default:
 		log.Printf("Unknown UMS mode type %q, switching to normal", s.umsModeType)
-		s.doSwitchToNormal()
+		s.modeQueue.push("normal")
 	}
 }
 
-// doSwitchToNormal performs the switch without re-acquiring the mutex.
-// Must be called with s.mu held.
-func (s *Service) doSwitchToNormal() {
-	prevMode := s.usbCtrl.GetCurrentMode()
-	if err := s.switchToNormal(prevMode); err != nil {
-		log.Printf("Error switching to normal mode: %v", err)
+// activeMode returns the mode the gadget is actually in, with the UMS
+// flavor the session was entered with.
+func (s *Service) activeMode() string {
+	mode := s.usbCtrl.GetCurrentMode()
+	if mode == "ums" && s.umsModeType != "" {
+		return s.umsModeType
+	}
+	return mode
+}
+
+// publishActiveMode writes activeMode to the usb hash's active-mode
+// field and announces it. Must be called with s.mu held.
+func (s *Service) publishActiveMode() {
+	if err := s.publisher.Set("active-mode", s.activeMode(), ipc.Sync()); err != nil {
+		log.Printf("Error publishing usb active-mode: %v", err)
 	}
+}
+
+// doSwitchToNormal switches back to normal mode and makes the usb hash
+// say so, since the request may have come from a detach or brake exit
+// rather than Redis. Must be called with s.mu held.
+func (s *Service) doSwitchToNormal(prevMode string) error {
+	err := s.switchToNormal(prevMode)
 	s.detachCount = 0
 
-	if err := s.publisher.Set("mode", "normal", ipc.Sync()); err != nil {
-		log.Printf("Error updating Redis usb mode: %v", err)
+	if perr := s.publisher.Set("mode", "normal", ipc.Sync()); perr != nil {
+		log.Printf("Error updating Redis usb mode: %v", perr)
 	}
+	return err
 }
 
 // LED fade indices (from /usr/share/led-curves/fades/)
@@ -703,24 +1411,120 @@ func (s *Service) setLEDs(p ledPattern) {
 		if !onSet[ch] {
 			fade = fadeSmoothOff
 		}
-		if _, err := s.client.LPush("scooter:led:fade", fmt.Sprintf("%d:%d", ch, fade)); err != nil {
+		if _, err := s.redis.LPush("scooter:led:fade", fmt.Sprintf("%d:%d", ch, fade)); err != nil {
 			log.Printf("Error setting LED channel %d: %v", ch, err)
 		}
 	}
 }
 
+// newLogger returns a usb:log logger whose entries are also streamed to
+// /logs/stream clients.
+func (s *Service) newLogger() *umslog.Logger {
+	logger := umslog.New(s.redis)
+	logger.SetSink(s.logHub.Publish)
+	logger.OnError(func(category, _ string) { s.metrics.Fail(category) })
+	return logger
+}
+
+// showStatus records status for the status server and, if it changed,
+// streams it to /logs/stream clients alongside the usb:log entries.
+func (s *Service) showStatus(status string) {
+	if s.statusBoard.SetStatus(status) {
+		s.logHub.Publish(umslog.Entry("status", status))
+	}
+}
+
 func (s *Service) setStatus(status string) {
+	s.showStatus(status)
 	if err := s.publisher.Set("status", status, ipc.Sync()); err != nil {
 		log.Printf("Error publishing usb status %q: %v", status, err)
 	}
 }
 
 func (s *Service) setStep(step string) {
+	if s.statusBoard.SetStep(step) && step != "" {
+		s.logHub.Publish(umslog.Entry("step", step))
+	}
 	if err := s.publisher.Set("step", step, ipc.Sync()); err != nil {
 		log.Printf("Error publishing usb step %q: %v", step, err)
 	}
 }
 
+// errDriveBusy is returned by listDrive while a mode switch or
+// processing has the drive.
+var errDriveBusy = errors.New("drive busy with a mode switch or processing")
+
+// listDrive lists the drive for the status server. It fails rather than
+// wait for a mode switch or processing in progress, which may have the
+// drive mounted read-write or be about to hand it to the host.
+func (s *Service) listDrive() (any, error) {
+	if !s.mu.TryLock() {
+		return nil, errDriveBusy
+	}
+	defer s.mu.Unlock()
+	return s.diskMgr.List()
+}
+
+// warnIfDriveNearlyFull logs a warning before the drive is filled with
+// settings, configs and exports if it has little room left for them.
+func (s *Service) warnIfDriveNearlyFull() {
+	usage, err := s.diskMgr.Usage()
+	if err != nil {
+		log.Printf("Warning: failed to check drive usage: %v", err)
+		return
+	}
+	if usage.FreeBytes < usage.TotalBytes/driveNearlyFullDivisor {
+		log.Printf("Warning: USB drive nearly full (%d of %d bytes free); copying to it may fail",
+			usage.FreeBytes, usage.TotalBytes)
+	}
+}
+
+// publishSessionUsage records how full the drive was when the host handed
+// it back, and what it was filled with, in the usb:usage hash. Must be
+// called with the drive mounted and before any processing removes files.
+func (s *Service) publishSessionUsage() {
+	usage, err := s.diskMgr.SessionUsage(driveCategories)
+	if err != nil {
+		log.Printf("Warning: failed to compute drive usage: %v", err)
+		return
+	}
+
+	fields := map[string]any{
+		"total":      usage.TotalBytes,
+		"used":       usage.UsedBytes,
+		"updated-at": time.Now().Unix(),
+	}
+	for category, size := range usage.Categories {
+		fields["category:"+category] = size
+	}
+	if err := s.usagePub.ReplaceAll(fields); err != nil {
+		log.Printf("Error publishing usb:usage: %v", err)
+		return
+	}
+	log.Printf("Drive usage: %d/%d bytes used, other=%d", usage.UsedBytes, usage.TotalBytes, usage.Categories["other"])
+}
+
+// validateDataPaths reports /data paths that exist with the wrong type. A
+// bad one only disables its own step (which then fails with the same clear
+// error), so we log rather than refuse to start.
+func (s *Service) validateDataPaths() {
+	checks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"settings", s.settingsLdr.Validate},
+		{"wireguard", s.wgManager.Validate},
+		{"radio-gaga", s.radioGagaMgr.Validate},
+		{"uplink-service", s.uplinkMgr.Validate},
+		{"onboot", s.onbootMgr.Validate},
+	}
+	for _, c := range checks {
+		if err := c.fn(); err != nil {
+			log.Printf("Warning: %s: %v", c.name, err)
+		}
+	}
+}
+
 func (s *Service) runStartupCleanup() {
 	if err := s.logBundlesMgr.PruneOldBundles(logBundleKeepCount); err != nil {
 		log.Printf("Warning: failed to prune old log bundles: %v", err)
@@ -740,15 +1544,82 @@ func (s *Service) runPostCycleCleanup() {
 	}
 }
 
-func restartUnit(logger *umslog.Logger, unit string) {
+// cleanDrive empties the drive after a session, by deleting its files or,
+// with UMS_CLEAN_MODE=reformat, by recreating the image. It returns the
+// unknown directories that were kept.
+func (s *Service) cleanDrive() ([]string, error) {
+	if s.secureClean {
+		return nil, s.diskMgr.SecureClean()
+	}
+	return s.diskMgr.CleanDrive()
+}
+
+// reportDBCDiskFull adds a plain-language entry to usb:log when a DBC
+// transfer failed for lack of space, so the user knows to free space
+// rather than retry.
+func reportDBCDiskFull(logger *umslog.Logger, err error) {
+	if !errors.Is(err, dbc.ErrDBCDiskFull) {
+		return
+	}
+	logger.Error("dbc", "DBC storage full: remove old maps or updates from the dashboard and try again")
+	log.Printf("DBC storage full: %v", err)
+}
+
+// commitSettings promotes staged settings, restarting settings-service and
+// rolling back if it doesn't stay up. Also covers pending wireguard
+// changes, which settings-service reads on the same restart. Reports
+// whether the new settings are live.
+func (s *Service) commitSettings(logger *umslog.Logger) bool {
+	restart := func() error {
+		log.Printf("Restarting %s", settingsUnit)
+		return s.systemctlRestart(settingsUnit)
+	}
+	healthy := func() error {
+		if s.dryRun {
+			return nil
+		}
+		return unitHealthy(settingsUnit, settingsHealthSettle)
+	}
+	if err := s.settingsLdr.Commit(restart, healthy); err != nil {
+		logger.Error("settings", "%v", err)
+		log.Printf("Error applying settings: %v", err)
+		return false
+	}
+	logger.Logf("settings", "applied, %s healthy", settingsUnit)
+	return true
+}
+
+func (s *Service) restartUnit(logger *umslog.Logger, unit string) {
 	log.Printf("Restarting %s", unit)
-	cmd := exec.Command("systemctl", "restart", unit)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
+	if err := s.systemctlRestart(unit); err != nil {
 		logger.Error(unit, "restart failed: %v", err)
-		log.Printf("Failed to restart %s: %v, output: %s", unit, err, string(output))
+		log.Printf("Failed to restart %s: %v", unit, err)
 		return
 	}
 	logger.Logf(unit, "restarted")
 	log.Printf("Successfully restarted %s", unit)
 }
+
+func (s *Service) systemctlRestart(unit string) error {
+	if s.dryRun {
+		log.Printf("would run: systemctl restart %s", unit)
+		return nil
+	}
+	output, err := exec.Command("systemctl", "restart", unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// unitHealthy waits settle for the unit to either crash or stay up, then
+// reports anything other than "active". A unit that failed on bad input
+// shows up as "failed" or, with Restart= set, "activating".
+func unitHealthy(unit string, settle time.Duration) error {
+	time.Sleep(settle)
+	output, _ := exec.Command("systemctl", "is-active", unit).Output()
+	if state := strings.TrimSpace(string(output)); state != "active" {
+		return fmt.Errorf("%s is %q after restart", unit, state)
+	}
+	return nil
+}
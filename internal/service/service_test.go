@@ -0,0 +1,449 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/disk"
+	"github.com/librescoot/ums-service/pkg/hooks"
+	"github.com/librescoot/ums-service/pkg/metrics"
+	"github.com/librescoot/ums-service/pkg/statusapi"
+	"github.com/librescoot/ums-service/pkg/umslog"
+	"github.com/librescoot/ums-service/pkg/update"
+	"github.com/librescoot/ums-service/pkg/usb"
+)
+
+func newTestService() (*Service, *fakeRedis, *fakePublisher) {
+	rdb := newFakeRedis()
+	pub := newFakePublisher()
+	s := &Service{
+		redis:         rdb,
+		publisher:     pub,
+		usagePub:      newFakePublisher(),
+		resultPub:     newFakePublisher(),
+		lastUpdatePub: newFakePublisher(),
+		usbCtrl:       usb.NewController("/nonexistent/usb.drive", usb.Identity{}, &commandtest.Recorder{}),
+		statusBoard:   statusapi.NewBoard(),
+		logHub:        statusapi.NewLogHub(),
+		metrics:       metrics.New(),
+	}
+	s.reboots = newRebootController(rdb, pub, nil, s.setStatus)
+	s.ops = newOpStatus(newFakePublisher())
+	s.reboots.intentPath = "/nonexistent/reboot-pending"
+	return s, rdb, pub
+}
+
+func TestApplyModeChange(t *testing.T) {
+	s, rdb, pub := newTestService()
+
+	// Already in normal mode: nothing to do, nothing published.
+	if err := s.applyModeChange("normal"); err != nil {
+		t.Fatalf("applyModeChange(normal): %v", err)
+	}
+	if got := pub.history(); len(got) != 0 {
+		t.Errorf("unexpected publishes for no-op mode change: %v", got)
+	}
+
+	if err := s.applyModeChange("bogus"); err == nil || !strings.Contains(err.Error(), "unknown mode") {
+		t.Errorf("applyModeChange(bogus) = %v, want unknown mode error", err)
+	}
+	if len(rdb.lists) != 0 {
+		t.Errorf("unexpected pushes for rejected mode: %v", rdb.lists)
+	}
+}
+
+func TestSetLEDs(t *testing.T) {
+	cases := []struct {
+		name    string
+		pattern ledPattern
+		want    []string
+	}{
+		{"active", ledsUMSActive, []string{"3:0", "4:0", "6:0", "7:0"}},
+		{"waiting", ledsWaitingPC, []string{"3:0", "4:0", "6:1", "7:1"}},
+		{"off", ledsOff, []string{"3:1", "4:1", "6:1", "7:1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, rdb, _ := newTestService()
+			s.setLEDs(c.pattern)
+			got := rdb.pushed("scooter:led:fade")
+			if strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Errorf("pushed %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSetStatusAndStep(t *testing.T) {
+	s, _, pub := newTestService()
+	s.setStatus("preparing")
+	s.setStep("settings")
+	s.setStatus("active")
+
+	want := []string{"status=preparing", "step=settings", "status=active"}
+	if got := pub.history(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("writes = %v, want %v", got, want)
+	}
+}
+
+func TestParseFileMode(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0644", 0644, false},
+		{"640", 0640, false},
+		{"0999", 0, true},
+		{"4755", 0, true},
+		{"rw-r--r--", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseFileMode(c.in)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("parseFileMode(%q) = %o, %v; want %o, err=%v", c.in, got, err, c.want, c.wantErr)
+		}
+	}
+}
+
+func TestRebootPlan(t *testing.T) {
+	cases := []struct {
+		name       string
+		outcomes   map[string]update.Outcome
+		wantReboot bool
+		wantMDB    bool
+	}{
+		{"mdb installed", map[string]update.Outcome{"mdb": update.OutcomeInstalled}, true, true},
+		{"dbc installed", map[string]update.Outcome{"dbc": update.OutcomeInstalled}, true, false},
+		{"both installed", map[string]update.Outcome{"mdb": update.OutcomeInstalled, "dbc": update.OutcomeInstalled}, true, true},
+		{"mdb failed, dbc installed", map[string]update.Outcome{"mdb": update.OutcomeFailed, "dbc": update.OutcomeInstalled}, true, false},
+		{"dbc failed", map[string]update.Outcome{"dbc": update.OutcomeFailed}, false, false},
+		{"dbc timed out", map[string]update.Outcome{"dbc": update.OutcomeUnknown}, false, false},
+	}
+	for _, c := range cases {
+		reboot, mdb := rebootPlan(c.outcomes)
+		if reboot != c.wantReboot || mdb != c.wantMDB {
+			t.Errorf("%s: rebootPlan = (%v, %v), want (%v, %v)", c.name, reboot, mdb, c.wantReboot, c.wantMDB)
+		}
+	}
+}
+
+func TestReportInstallOutcomes(t *testing.T) {
+	s, rdb, _ := newTestService()
+	result := s.resultPub.(*fakePublisher)
+
+	s.reportInstallOutcomes(umslog.New(rdb), map[string]update.Outcome{
+		"mdb": update.OutcomeFailed,
+		"dbc": update.OutcomeInstalled,
+	})
+
+	if result.fields["install-mdb"] != "failed" || result.fields["install-dbc"] != "installed" {
+		t.Errorf("usb:result = %v, want install-mdb=failed install-dbc=installed", result.fields)
+	}
+	logged := strings.Join(rdb.pushed("usb:log"), "\n")
+	if !strings.Contains(logged, "ERROR: mdb install failed") || !strings.Contains(logged, "dbc install installed") {
+		t.Errorf("usb:log = %q, want both outcomes", logged)
+	}
+}
+
+func TestRecordLastUpdate(t *testing.T) {
+	s, _, _ := newTestService()
+	last := s.lastUpdatePub.(*fakePublisher)
+	queued := update.Queued{MDB: true, DBC: true, MDBArtifact: "librescoot-mdb-v1.2.0.mender", DBCArtifact: "librescoot-dbc-v1.2.0.mender"}
+
+	s.recordLastUpdate(queued, map[string]update.Outcome{"mdb": update.OutcomeFailed, "dbc": update.OutcomeUnknown}, false)
+	if len(last.fields) != 0 {
+		t.Errorf("usb:last-update = %v without a successful install, want nothing", last.fields)
+	}
+
+	s.recordLastUpdate(queued, map[string]update.Outcome{"mdb": update.OutcomeFailed, "dbc": update.OutcomeInstalled}, true)
+	if last.fields["dbc-artifact"] != "librescoot-dbc-v1.2.0.mender" || last.fields["mdb-artifact"] != "" ||
+		last.fields["reboot-pending"] != "true" || last.fields["installed-at"] == "" {
+		t.Errorf("usb:last-update = %v, want the DBC artifact only, pending a reboot", last.fields)
+	}
+}
+
+func TestOnLinkRecoveredPublishesEvent(t *testing.T) {
+	s, _, pub := newTestService()
+	s.onLinkRecovered("medium lost")
+	if got, want := pub.history(), []string{"event=ums-link-recovered"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+}
+
+// TestSwitchToNormalSkipsUntouchedDrive checks that a UMS session in
+// which the host wrote nothing ends without mounting the drive.
+func TestSwitchToNormalSkipsUntouchedDrive(t *testing.T) {
+	s, _, pub := newTestService()
+	drive := filepath.Join(t.TempDir(), "usb.drive")
+	if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{}
+	s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+	s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+	if err := s.usbCtrl.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.switchToNormal("ums"); err != nil {
+		t.Fatalf("switchToNormal: %v", err)
+	}
+	if got := pub.fields["status"]; got != "idle" {
+		t.Errorf("status = %q, want idle", got)
+	}
+	for _, call := range r.Calls() {
+		if strings.HasPrefix(call, "mount") || strings.HasPrefix(call, "fsck") {
+			t.Errorf("ran %q", call)
+		}
+	}
+}
+
+// TestSwitchToNormalSkipsReadOnlySession checks that a read-only session
+// isn't processed, even if the image looks changed.
+func TestSwitchToNormalSkipsReadOnlySession(t *testing.T) {
+	s, _, pub := newTestService()
+	drive := filepath.Join(t.TempDir(), "usb.drive")
+	if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{}
+	s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+	s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+	s.usbCtrl.SetReadOnly(true)
+	if err := s.usbCtrl.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	s.umsModeType = "ums-ro"
+	if err := os.WriteFile(drive, []byte("image, touched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.switchToNormal("ums"); err != nil {
+		t.Fatalf("switchToNormal: %v", err)
+	}
+	if got := pub.fields["status"]; got != "idle" {
+		t.Errorf("status = %q, want idle", got)
+	}
+	for _, call := range r.Calls() {
+		if strings.HasPrefix(call, "mount") || strings.HasPrefix(call, "fsck") {
+			t.Errorf("ran %q", call)
+		}
+	}
+	if calls := r.Calls(); len(calls) < 2 || !strings.Contains(calls[1], " ro=1 ") {
+		t.Errorf("drive not exported read-only: %q", calls)
+	}
+}
+
+// TestActiveMode checks that active-mode follows the gadget, not the
+// request, after both a failed and a successful switch.
+func TestActiveMode(t *testing.T) {
+	s, _, pub := newTestService()
+	drive := filepath.Join(t.TempDir(), "usb.drive")
+	if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		"mount": {Err: errors.New("exit status 32")},
+	}}
+	s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+	s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+
+	if err := s.applyModeChange("ums-ro"); err == nil {
+		t.Fatal("switch to ums-ro succeeded with mount failing")
+	}
+	if got := pub.fields["active-mode"]; got != "normal" {
+		t.Errorf("active-mode after failed switch = %q, want normal", got)
+	}
+
+	if err := s.usbCtrl.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	s.umsModeType = "ums-ro"
+	if got := s.activeMode(); got != "ums-ro" {
+		t.Errorf("activeMode() = %q, want ums-ro", got)
+	}
+	if err := s.applyModeChange("normal"); err != nil {
+		t.Fatalf("switch to normal: %v", err)
+	}
+	if got := pub.fields["active-mode"]; got != "normal" {
+		t.Errorf("active-mode after switch to normal = %q, want normal", got)
+	}
+}
+
+// TestPreHookFailure checks that a failing pre-ums hook only refuses the
+// switch in strict mode.
+func TestPreHookFailure(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%v", strict), func(t *testing.T) {
+			s, _, pub := newTestService()
+			dir := t.TempDir()
+			hook := filepath.Join(dir, "pre-ums", "check")
+			if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(hook, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			s.hooks = hooks.New(dir, &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+				hook + " ums": {Err: errors.New("exit status 1")},
+			}})
+			s.strictHooks = strict
+
+			drive := filepath.Join(t.TempDir(), "usb.drive")
+			if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+				"mount": {Err: errors.New("exit status 32")},
+			}}
+			s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+			s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+
+			err := s.applyModeChange("ums")
+			if refused := err != nil && strings.Contains(err.Error(), "refused by hook"); refused != strict {
+				t.Errorf("applyModeChange = %v, want refused %v", err, strict)
+			}
+			if strict && pub.fields["mode"] != "normal" {
+				t.Errorf("usb mode = %q, want normal", pub.fields["mode"])
+			}
+			tried := false
+			for _, call := range r.Calls() {
+				tried = tried || strings.HasPrefix(call, "mount ")
+			}
+			if tried == strict {
+				t.Errorf("drive mount attempted = %v, want %v", tried, !strict)
+			}
+		})
+	}
+}
+
+// TestSwitchToUMSStatuses checks the statuses a successful switch to UMS
+// publishes, in order: the drive is prepared, the switch committed, and
+// then the host may connect.
+func TestSwitchToUMSStatuses(t *testing.T) {
+	s, _, pub := newTestService()
+	drive := filepath.Join(t.TempDir(), "usb.drive")
+	if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{}
+	s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+	s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+	populated := ""
+	s.populate = func(mountPoint string) { populated = mountPoint }
+	stream, unsubscribe := s.logHub.Subscribe()
+	defer unsubscribe()
+
+	if err := s.applyModeChange("ums"); err != nil {
+		t.Fatalf("switch to ums: %v", err)
+	}
+	if populated != s.diskMgr.GetMountPoint() {
+		t.Errorf("populated %q, want the mount point", populated)
+	}
+	var statuses []string
+	for _, w := range pub.history() {
+		if status, ok := strings.CutPrefix(w, "status="); ok {
+			statuses = append(statuses, status)
+		}
+	}
+	want := []string{"preparing", "active", "ums-ready"}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("published statuses %q, want %q", statuses, want)
+	}
+
+	// A client on /logs/stream follows the same transition.
+	var streamed []string
+	for len(stream) > 0 {
+		entry := <-stream
+		if _, status, ok := strings.Cut(entry, " [status] "); ok {
+			streamed = append(streamed, status)
+		}
+	}
+	if !reflect.DeepEqual(streamed, want) {
+		t.Errorf("streamed statuses %q, want %q", streamed, want)
+	}
+}
+
+// TestSwitchBetweenUMSFlavours checks a request for another UMS flavour
+// during a session is refused without touching the exported drive.
+func TestSwitchBetweenUMSFlavours(t *testing.T) {
+	for _, c := range []struct{ from, to string }{{"ums", "ums-ro"}, {"ums-ro", "ums"}} {
+		t.Run(c.from+" to "+c.to, func(t *testing.T) {
+			s, _, pub := newTestService()
+			drive := filepath.Join(t.TempDir(), "usb.drive")
+			if err := os.WriteFile(drive, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := &commandtest.Recorder{}
+			s.usbCtrl = usb.NewController(drive, usb.Identity{}, r)
+			s.diskMgr = disk.NewManager(drive, filepath.Join(filepath.Dir(drive), "mnt"), 0, r)
+			populated := 0
+			s.populate = func(string) { populated++ }
+
+			if err := s.applyModeChange(c.from); err != nil {
+				t.Fatalf("switch to %s: %v", c.from, err)
+			}
+			calls := len(r.Calls())
+			if err := s.applyModeChange(c.to); err == nil {
+				t.Errorf("switch to %s during %s succeeded", c.to, c.from)
+			}
+			if extra := r.Calls()[calls:]; len(extra) != 0 {
+				t.Errorf("ran %q", extra)
+			}
+			if populated != 1 {
+				t.Errorf("populated the drive %d times, want once", populated)
+			}
+			if got := s.activeMode(); got != c.from {
+				t.Errorf("active mode = %q, want %q", got, c.from)
+			}
+			if got := pub.fields["mode"]; got != c.from {
+				t.Errorf("mode field = %q, want %q", got, c.from)
+			}
+			if got := pub.fields["status"]; got != "ums-ready" {
+				t.Errorf("status = %q, want ums-ready", got)
+			}
+		})
+	}
+}
+
+// TestCancelRebootStatus checks cancelling a reboot returns the status to
+// idle only when the reboot was what it showed.
+func TestCancelRebootStatus(t *testing.T) {
+	cases := []struct {
+		status string
+		want   string
+	}{
+		{"awaiting-reboot", "idle"},
+		{"reboot-deferred", "idle"},
+		{"ums-ready", "ums-ready"},
+		{"processing", "processing"},
+	}
+	for _, c := range cases {
+		t.Run(c.status, func(t *testing.T) {
+			s, _, pub := newTestService()
+			s.reboots.intentPath = filepath.Join(t.TempDir(), "reboot-pending")
+			if err := saveRebootIntent(s.reboots.intentPath, rebootIntent{MDB: true, BootID: currentBootID()}); err != nil {
+				t.Fatal(err)
+			}
+			s.setStatus(c.status)
+
+			if err := s.handleRebootField("cancel"); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.reboots.Pending(); ok {
+				t.Error("reboot still pending after cancel")
+			}
+			if got := pub.fields["status"]; got != c.want {
+				t.Errorf("status = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
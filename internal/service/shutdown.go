@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// shutdownTimeout bounds how long Run waits for a mode switch in
+// progress when its context is cancelled, leaving room within systemd's
+// default 90s stop timeout.
+const shutdownTimeout = 60 * time.Second
+
+// Shutdown waits, until ctx is done, for a mode switch or batch in
+// progress to finish, then unmounts the drive if one left it mounted.
+// New mode requests must already have stopped, as they do once Run's
+// context is cancelled. A UMS session is left as it is: the host keeps
+// the drive, and the next start resumes the session. If ctx ends first,
+// the switch is abandoned mid-way; a batch is then finished from its
+// journal on the next start.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if !s.lockWithin(ctx) {
+		return fmt.Errorf("mode switch still in progress: %w", ctx.Err())
+	}
+	defer s.mu.Unlock()
+
+	if s.diskMgr.IsMounted() {
+		log.Println("Unmounting USB drive left mounted")
+		if err := s.diskMgr.Unmount(); err != nil {
+			return fmt.Errorf("failed to unmount drive: %w", err)
+		}
+	}
+	return nil
+}
+
+// lockWithin takes s.mu unless ctx ends first, and reports whether it
+// did. A lock obtained after giving up is released straight away.
+func (s *Service) lockWithin(ctx context.Context) bool {
+	acquired := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		select {
+		case acquired <- struct{}{}:
+		case <-ctx.Done():
+			s.mu.Unlock()
+		}
+	}()
+	select {
+	case <-acquired:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/disk"
+)
+
+func TestShutdownWaitsForModeSwitch(t *testing.T) {
+	s, _, _ := newTestService()
+	s.diskMgr = disk.NewManager(filepath.Join(t.TempDir(), "usb.drive"), t.TempDir(), 0, &commandtest.Recorder{})
+
+	s.mu.Lock() // a mode switch in progress
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned %v during the switch", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.mu.Unlock()
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestShutdownGivesUp(t *testing.T) {
+	s, _, _ := newTestService()
+
+	s.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want a timeout", err)
+	}
+	s.mu.Unlock()
+
+	// The abandoned attempt must not keep the lock once it gets it.
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock still held after Shutdown gave up")
+	}
+}
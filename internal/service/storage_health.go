@@ -0,0 +1,48 @@
+package service
+
+import (
+	"log"
+
+	ipc "github.com/librescoot/redis-ipc"
+
+	"github.com/librescoot/ums-service/pkg/disk"
+)
+
+// slowStorageMinSample is the least data a measurement needs before its
+// rate means anything; a few KB of settings are dominated by sync and
+// metadata overhead.
+const slowStorageMinSample = 1 << 20
+
+// isSlowStorage reports whether t is a meaningful sample below the
+// threshold. thresholdKBps <= 0 disables the check.
+func isSlowStorage(t disk.Throughput, thresholdKBps int) bool {
+	if thresholdKBps <= 0 || t.Bytes < slowStorageMinSample {
+		return false
+	}
+	return t.BytesPerSecond() < float64(thresholdKBps)*1024
+}
+
+// publishWriteThroughput records the drive write rate measured while
+// preparing the drive in the usb hash, setting warning=slow-storage when
+// it falls below the configured threshold (and clearing it otherwise).
+// Samples too small to judge leave the previous values in place.
+func (s *Service) publishWriteThroughput(t disk.Throughput) {
+	if t.Bytes < slowStorageMinSample {
+		return
+	}
+	kbps := int(t.BytesPerSecond() / 1024)
+	warning := ""
+	if isSlowStorage(t, s.config.SlowStorageKBps) {
+		warning = "slow-storage"
+		log.Printf("Warning: slow storage: wrote %d bytes to the drive at %d KiB/s (threshold %d KiB/s)",
+			t.Bytes, kbps, s.config.SlowStorageKBps)
+	} else {
+		log.Printf("Drive write throughput: %d KiB/s (%d bytes)", kbps, t.Bytes)
+	}
+	if err := s.publisher.SetMany(map[string]any{
+		"write-throughput": kbps,
+		"warning":          warning,
+	}, ipc.Sync()); err != nil {
+		log.Printf("Error publishing write throughput: %v", err)
+	}
+}
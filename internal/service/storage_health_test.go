@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/config"
+	"github.com/librescoot/ums-service/pkg/disk"
+)
+
+func TestIsSlowStorage(t *testing.T) {
+	const mib = 1 << 20
+	cases := []struct {
+		name      string
+		sample    disk.Throughput
+		threshold int
+		want      bool
+	}{
+		{"fast", disk.Throughput{Bytes: 8 * mib, Elapsed: time.Second}, 1024, false},
+		{"exactly at threshold", disk.Throughput{Bytes: 2 * mib, Elapsed: 2 * time.Second}, 1024, false},
+		{"slow", disk.Throughput{Bytes: 2 * mib, Elapsed: 4 * time.Second}, 1024, true},
+		{"sample too small", disk.Throughput{Bytes: 64 << 10, Elapsed: 10 * time.Second}, 1024, false},
+		{"disabled", disk.Throughput{Bytes: 2 * mib, Elapsed: time.Minute}, 0, false},
+	}
+	for _, c := range cases {
+		if got := isSlowStorage(c.sample, c.threshold); got != c.want {
+			t.Errorf("%s: isSlowStorage = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPublishWriteThroughput(t *testing.T) {
+	s, _, pub := newTestService()
+	s.config = &config.Config{SlowStorageKBps: 1024}
+
+	s.publishWriteThroughput(disk.Throughput{Bytes: 2 << 20, Elapsed: 4 * time.Second})
+	if pub.fields["warning"] != "slow-storage" || pub.fields["write-throughput"] != "512" {
+		t.Errorf("slow sample published %v", pub.fields)
+	}
+
+	s.publishWriteThroughput(disk.Throughput{Bytes: 16 << 20, Elapsed: time.Second})
+	if pub.fields["warning"] != "" || pub.fields["write-throughput"] != "16384" {
+		t.Errorf("fast sample published %v", pub.fields)
+	}
+
+	// Too little data to judge: previous values stay.
+	s.publishWriteThroughput(disk.Throughput{Bytes: 4096, Elapsed: time.Second})
+	if pub.fields["write-throughput"] != "16384" {
+		t.Errorf("small sample overwrote throughput: %v", pub.fields)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/wireguard"
+)
+
+// templateVarSource is a Redis hash field a WireGuard template variable
+// is read from.
+type templateVarSource struct {
+	hash, field string
+}
+
+// parseTemplateVars parses "name=hash.field,..." into variable sources,
+// e.g. "serial=system.serial-number,ip=wireguard.address".
+func parseTemplateVars(spec string) (map[string]templateVarSource, error) {
+	sources := make(map[string]templateVarSource)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(part, "=")
+		hash, field, fok := splitHashField(key)
+		if !ok || name == "" || !fok {
+			return nil, fmt.Errorf("%q: expected name=hash.field", part)
+		}
+		sources[name] = templateVarSource{hash: hash, field: field}
+	}
+	return sources, nil
+}
+
+// templateVars returns a wireguard.VarsFunc reading sources from Redis at
+// render time. Empty values are left out so templates using them fail
+// instead of rendering a blank.
+func templateVars(rdb redisClient, sources map[string]templateVarSource) wireguard.VarsFunc {
+	return func() (map[string]string, error) {
+		vars := make(map[string]string, len(sources))
+		for name, src := range sources {
+			v, err := rdb.HGet(src.hash, src.field)
+			if err != nil {
+				return nil, fmt.Errorf("%s (%s.%s): %w", name, src.hash, src.field, err)
+			}
+			if v != "" {
+				vars[name] = v
+			}
+		}
+		return vars, nil
+	}
+}
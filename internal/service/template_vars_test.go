@@ -0,0 +1,29 @@
+package service
+
+import "testing"
+
+func TestTemplateVars(t *testing.T) {
+	sources, err := parseTemplateVars("serial=system.serial-number, ip=scooter:wireguard.address")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := newFakeRedis()
+	rdb.HSet("system", "serial-number", "LS123")
+
+	vars, err := templateVars(rdb, sources)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["serial"] != "LS123" {
+		t.Errorf("serial = %q", vars["serial"])
+	}
+	if _, ok := vars["ip"]; ok {
+		t.Errorf("empty ip should be omitted, got %q", vars["ip"])
+	}
+
+	for _, bad := range []string{"serial", "serial=system", "=system.serial"} {
+		if _, err := parseTemplateVars(bad); err == nil {
+			t.Errorf("parseTemplateVars(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package checksum hashes files with the algorithm the operator chose, so
+// every verification in the service agrees on one.
+package checksum
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Algorithm names a supported hash function.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	SHA512 Algorithm = "sha512"
+	BLAKE3 Algorithm = "blake3"
+)
+
+// Default is used when no algorithm is configured.
+const Default = SHA256
+
+// Parse validates an algorithm name; empty means Default.
+func Parse(name string) (Algorithm, error) {
+	switch a := Algorithm(strings.ToLower(strings.TrimSpace(name))); a {
+	case "":
+		return Default, nil
+	case SHA256, SHA512, BLAKE3:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q (want sha256, sha512 or blake3)", name)
+	}
+}
+
+// New returns a fresh hash for a.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case SHA512:
+		return sha512.New()
+	case BLAKE3:
+		return blake3.New(32, nil)
+	default:
+		return sha256.New()
+	}
+}
+
+// Command returns the coreutils-style tool that prints a's digest of
+// the files it is given, for checking a copy on another host.
+func (a Algorithm) Command() string {
+	switch a {
+	case SHA512:
+		return "sha512sum"
+	case BLAKE3:
+		return "b3sum"
+	default:
+		return "sha256sum"
+	}
+}
+
+// Reader returns the hex digest of everything read from r.
+func (a Algorithm) Reader(r io.Reader) (string, error) {
+	h := a.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// File returns the hex digest of the file at path.
+func (a Algorithm) File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return a.Reader(f)
+}
+
+// Verify reports an error unless the file at path has digest want.
+func (a Algorithm) Verify(path, want string) error {
+	got, err := a.File(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s mismatch for %s: got %s, want %s", a, path, got, want)
+	}
+	return nil
+}
+
+// ParseManifest reads "<digest>  <name>" lines, as printed by Command,
+// into a name→digest map. A leading '*' on the name, as written by
+// binary-mode tools, is dropped; blank lines and '#' comments are
+// skipped.
+func ParseManifest(r io.Reader) (map[string]string, error) {
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected \"<digest>  <name>\"", n)
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("line %d: digest is not hex", n)
+		}
+		sums[name] = strings.ToLower(digest)
+	}
+	return sums, sc.Err()
+}
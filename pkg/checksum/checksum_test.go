@@ -0,0 +1,92 @@
+package checksum
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]Algorithm{"": SHA256, "sha256": SHA256, "SHA512": SHA512, " blake3 ": BLAKE3} {
+		if got, err := Parse(in); err != nil || got != want {
+			t.Errorf("Parse(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"md5", "sha1", "crc32"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q): expected error", in)
+		}
+	}
+}
+
+func TestKnownDigests(t *testing.T) {
+	cases := map[Algorithm]string{
+		SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		SHA512: "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043",
+		BLAKE3: "ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f",
+	}
+	for a, want := range cases {
+		if got, err := a.Reader(strings.NewReader("hello")); err != nil || got != want {
+			t.Errorf("%s(hello) = %s, %v; want %s", a, got, err, want)
+		}
+	}
+}
+
+// TestManifestRoundTrip writes a manifest the way an export does and
+// verifies the files against it the way ingest does, for each algorithm.
+func TestManifestRoundTrip(t *testing.T) {
+	for _, a := range []Algorithm{SHA256, SHA512, BLAKE3} {
+		t.Run(string(a), func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"a.mbtiles": "tiles", "b.mender": "artifact"}
+
+			var manifest []string
+			for name, content := range files {
+				path := filepath.Join(dir, name)
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				sum, err := a.File(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				manifest = append(manifest, sum+"  "+name)
+			}
+
+			sums, err := ParseManifest(strings.NewReader(strings.Join(manifest, "\n") + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range files {
+				if err := a.Verify(filepath.Join(dir, name), sums[name]); err != nil {
+					t.Errorf("Verify(%s): %v", name, err)
+				}
+			}
+
+			// A modified file no longer verifies.
+			if err := os.WriteFile(filepath.Join(dir, "a.mbtiles"), []byte("tampered"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Verify(filepath.Join(dir, "a.mbtiles"), sums["a.mbtiles"]); err == nil {
+				t.Error("Verify accepted a modified file")
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	in := "# comment\n\nABCDEF  *upper.bin\n0123  plain name.txt\n"
+	sums, err := ParseManifest(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sums["upper.bin"] != "abcdef" || sums["plain name.txt"] != "0123" {
+		t.Errorf("sums = %v", sums)
+	}
+	for _, bad := range []string{"nothex  file\n", "abcdef\n"} {
+		if _, err := ParseManifest(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseManifest(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Package command runs external programs behind an interface, so a
+// component's tests can check what it would run without running it.
+package command
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// Runner runs a program and returns its combined stdout and stderr. A
+// program still running when ctx ends is killed. RunInput is Run with
+// stdin fed from the given reader.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+	RunInput(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error)
+}
+
+// killGrace is how long a program killed because its context ended gets
+// to release its output pipes before they're closed under it.
+const killGrace = 2 * time.Second
+
+// Exec runs programs with os/exec.
+type Exec struct{}
+
+func (e Exec) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return e.RunInput(ctx, nil, name, args...)
+}
+
+func (Exec) RunInput(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	cmd.WaitDelay = killGrace
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,78 @@
+// Package commandtest provides a command.Runner for tests.
+package commandtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Reply is what a recorded command returns.
+type Reply struct {
+	Output string
+	Err    error
+}
+
+// Recorder records the command lines it is asked to run ("name arg1
+// arg2 ...") and answers from Replies, looked up by the whole line and
+// then by the program name. Anything else succeeds with no output.
+type Recorder struct {
+	Replies map[string]Reply
+
+	mu     sync.Mutex
+	calls  []string
+	inputs map[string]string
+}
+
+func (r *Recorder) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return r.RunInput(ctx, nil, name, args...)
+}
+
+// RunInput is Run, also keeping what was fed to stdin; see Input.
+func (r *Recorder) RunInput(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	var input []byte
+	if stdin != nil {
+		input, _ = io.ReadAll(stdin)
+	}
+	r.mu.Lock()
+	r.calls = append(r.calls, line)
+	if stdin != nil {
+		if r.inputs == nil {
+			r.inputs = make(map[string]string)
+		}
+		r.inputs[line] = string(input)
+	}
+	r.mu.Unlock()
+
+	reply, ok := r.Replies[line]
+	if !ok {
+		reply = r.Replies[name]
+	}
+	return []byte(reply.Output), reply.Err
+}
+
+// Input returns what was last fed to stdin of the command line.
+func (r *Recorder) Input(line string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inputs[line]
+}
+
+// Calls returns the command lines run so far.
+func (r *Recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+// ExitStatus is a Reply error for a program that exited with the given
+// status, like the *exec.ExitError Exec returns.
+type ExitStatus int
+
+func (e ExitStatus) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// ExitCode returns the status, as exec.ExitError does.
+func (e ExitStatus) ExitCode() int { return int(e) }
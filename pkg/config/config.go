@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -10,8 +11,57 @@ type Config struct {
 	RedisAddr     string
 	RedisPassword string
 	RedisDB       int
-	USBDriveFile  string
-	USBDriveSize  int64
+
+	// USBDriveFile is the drive image presented to the host.
+	USBDriveFile string
+
+	// USBDriveSize is the size of a new drive image, e.g. "1G" or "512M".
+	// An existing image keeps its size.
+	USBDriveSize string
+
+	// MountPoint is where the service mounts the drive image while it
+	// prepares or processes it. It is created on mount and removed on
+	// unmount.
+	MountPoint string
+
+	// ExtraDrives lists images presented to the host after the drive,
+	// each "path", "path:ro" or "path:rw"; see usb.ParseLUNs.
+	ExtraDrives string
+
+	// Where the service keeps and looks for files on the MDB: the live
+	// settings.toml, the WireGuard configs, the OTA staging root (with
+	// mdb/, dbc/, mdb-boot/ and dbc-boot/ below it) and the directory
+	// served to the DBC over HTTP.
+	SettingsFile string
+	WireGuardDir string
+	OTADir       string
+	DBCDataDir   string
+
+	// StateDir holds what the service persists across restarts: the
+	// journal of an interrupted batch, a reboot deferred to the
+	// maintenance window and a pending data backup request.
+	StateDir string
+
+	// DBCMapsDir and DBCValhallaDir are where maps are installed on the
+	// DBC.
+	DBCMapsDir     string
+	DBCValhallaDir string
+
+	// USBVendorID and USBProductID (4 hex digits) and USBSerial are what
+	// the mass-storage gadget presents to the host. Empty IDs keep the
+	// kernel defaults; an empty serial keeps the built-in one.
+	USBVendorID  string
+	USBProductID string
+	USBSerial    string
+
+	// NormalGadget is the USB gadget module (plus parameters) loaded in
+	// normal mode, e.g. "g_serial use_acm=1", or "none".
+	NormalGadget string
+
+	// LinkCheckInterval is how often the UMS link is checked for a
+	// degraded gadget (e.g. after the host slept) while a host is
+	// connected. 0 disables the periodic check.
+	LinkCheckInterval time.Duration
 
 	// Per-operation timeouts for DBC transfers. These wrap the entire
 	// upload (HTTP PUT + SCP fallback) for one file, so they need to
@@ -20,6 +70,167 @@ type Config struct {
 	RPMTransferTimeout    time.Duration
 	ScriptTransferTimeout time.Duration
 	MenderTransferTimeout time.Duration
+
+	// RebootWindow restricts update-triggered reboots to daily local-time
+	// ranges, e.g. "02:00-05:00,13:00-13:30". Empty allows any time.
+	RebootWindow string
+
+	// AutoReboot lets the service reboot after installed updates. When
+	// false it only flags reboot-required in usb:status, leaving the
+	// reboot to another component.
+	AutoReboot bool
+
+	// DBCHost is the DBC's address on the USB network link, used for
+	// ssh, scp and its upload server. DBCHTTPHost and DBCPort are the
+	// MDB's side of the link, where it serves files for the DBC to fetch.
+	DBCHost     string
+	DBCHTTPHost string
+	DBCPort     int
+
+	// DBCFileOwner ("user" or "user:group") and DBCFileMode (octal, e.g.
+	// "0644") are applied to maps and updates after they land on the DBC.
+	// Empty leaves them as transferred (root-owned).
+	DBCFileOwner string
+	DBCFileMode  string
+
+	// DBCVerifyCommand is run on the DBC after each map or update lands
+	// there; a non-zero exit fails the step and restores what it
+	// replaced. Empty skips verification.
+	DBCVerifyCommand string
+
+	// DBCVerifyCopies compares the checksum of every file sent to the
+	// DBC with the one that arrived.
+	DBCVerifyCopies bool
+
+	// DBCRsync updates map files already on the DBC with rsync, sending
+	// only what changed.
+	DBCRsync bool
+
+	// DBCRetryAttempts and DBCRetryDelay retry ssh/scp operations whose
+	// connection to the DBC failed: up to DBCRetryAttempts tries, waiting
+	// DBCRetryDelay before the first retry and doubling it each time.
+	DBCRetryAttempts int
+	DBCRetryDelay    time.Duration
+
+	// DBCCopyTimeout and DBCCommandTimeout bound a single scp/ssh call to
+	// the DBC whose caller didn't set a deadline of its own, so a hung
+	// session can't stall a mode switch.
+	DBCCopyTimeout    time.Duration
+	DBCCommandTimeout time.Duration
+
+	// DBCKnownHosts pins the DBC's ssh host key while the file exists.
+	// DBCLearnHostKey creates it from the key the DBC presents the first
+	// time it is reachable.
+	DBCKnownHosts   string
+	DBCLearnHostKey bool
+
+	// DBCReadyCheck is run on the DBC once its ssh port answers, until it
+	// exits zero, before the DBC counts as up. "off" waits for the port
+	// alone.
+	DBCReadyCheck string
+
+	// AllowedModes is a comma-separated allowlist of modes Redis may
+	// request (empty allows all). ModePrecondition ("hash.field=value")
+	// must hold before a UMS mode is entered, e.g. "keycard.present=true".
+	AllowedModes     string
+	ModePrecondition string
+
+	// WireGuardTemplateVars maps .conf.tmpl variables to Redis hash
+	// fields: "name=hash.field,...".
+	WireGuardTemplateVars string
+
+	// WireGuardMaxBytes is the largest WireGuard config accepted from
+	// the drive; SettingsMaxBytes the same for settings.toml. Bigger
+	// files are skipped before they are read.
+	WireGuardMaxBytes int
+	SettingsMaxBytes  int
+
+	// SlowStorageKBps is the drive write rate (KiB/s) below which a
+	// slow-storage warning is raised while preparing the drive. 0
+	// disables the check.
+	SlowStorageKBps int
+
+	// SettingsBackups is how many previous settings.toml versions are
+	// kept as settings.toml.1..N.
+	SettingsBackups int
+
+	// SettingsMode is how settings.toml from the drive is applied:
+	// "replace" the live file, or "merge" its keys into it. A drive's
+	// ums-manifest.toml can override it.
+	SettingsMode string
+
+	// LogExportUnits is a comma-separated list of systemd units whose
+	// journal is exported to logs/ on the drive alongside the system
+	// journal; LogExportBytes caps each exported file.
+	LogExportUnits string
+	LogExportBytes int
+
+	// BackupPaths is a comma-separated list of paths below /data written
+	// to backup/ on the drive when a backup is requested; BackupExclude
+	// lists paths below /data left out of it.
+	BackupPaths   string
+	BackupExclude string
+
+	// ModeQueueSize bounds how many mode requests may wait for the
+	// worker; beyond that the oldest are dropped. 1 means only the
+	// latest request is kept.
+	ModeQueueSize int
+
+	// ProcessingOrder is a comma-separated list of every processing
+	// category (settings, wireguard, updates, maps, ...) in the order
+	// they're applied after a UMS session. Empty keeps the default.
+	ProcessingOrder string
+
+	// DriveFilesystem is what a new drive image is formatted with: vfat,
+	// exfat or ext4. An existing image keeps its filesystem.
+	DriveFilesystem string
+
+	// DriveCheck controls whether an existing drive image is checked,
+	// and repaired or recreated if need be, on startup.
+	DriveCheck bool
+
+	// UnknownDirs is what cleaning the drive does with top-level
+	// directories the service doesn't manage: "clean" or "preserve".
+	UnknownDirs string
+
+	// CleanMode is how the drive is emptied after a session: "delete"
+	// removes the files, "reformat" recreates the image from scratch.
+	CleanMode string
+
+	// KeepPatterns is a comma-separated list of drive paths cleaning
+	// spares, e.g. "notes/**,backup/*.tar".
+	KeepPatterns string
+
+	// ChecksumAlgorithm is the hash used wherever files are verified:
+	// sha256, sha512 or blake3.
+	ChecksumAlgorithm string
+
+	// DriveManifest controls whether manifest.json, describing each area
+	// of the drive and its contents, is written on UMS entry.
+	DriveManifest bool
+
+	// HooksDir holds the integrator hook scripts, one directory per hook
+	// point (pre-ums, post-ums, pre-normal, post-normal); Hooks false
+	// disables them. HookTimeout bounds each script. With HooksStrict a failing
+	// pre hook refuses the mode change; otherwise hook failures are only
+	// logged.
+	Hooks       bool
+	HooksDir    string
+	HookTimeout time.Duration
+	HooksStrict bool
+
+	// StatusAddr is the listen address of the HTTP status endpoints,
+	// including /healthz, /readyz and /metrics, e.g. "127.0.0.1:8090".
+	// Empty disables them.
+	StatusAddr string
+
+	// LogLevel is the lowest level logged: debug, info, warn or error.
+	LogLevel string
+
+	// DryRun logs the external commands the service would run (modprobe,
+	// mkfs, mount, scp, systemctl, reboot) instead of running them. Files
+	// are still copied to and from the drive image.
+	DryRun bool
 }
 
 func New() *Config {
@@ -27,12 +238,71 @@ func New() *Config {
 		RedisAddr:             getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword:         getEnv("REDIS_PASSWORD", ""),
 		RedisDB:               0,
-		USBDriveFile:          "/data/usb.drive",
-		USBDriveSize:          1024 * 1024 * 1024, // 1GB
+		USBDriveFile:          getEnv("UMS_DRIVE_FILE", "/data/usb.drive"),
+		USBDriveSize:          getEnv("UMS_DRIVE_SIZE", "1G"),
+		MountPoint:            getEnv("UMS_MOUNT_POINT", "/mnt/usb-drive-temp"),
+		ExtraDrives:           getEnv("UMS_EXTRA_DRIVES", ""),
+		SettingsFile:          getEnv("UMS_SETTINGS_FILE", "/data/settings.toml"),
+		WireGuardDir:          getEnv("UMS_WIREGUARD_DIR", "/data/wireguard"),
+		OTADir:                getEnv("UMS_OTA_DIR", "/data/ota"),
+		DBCDataDir:            getEnv("UMS_DBC_DATA_DIR", "/data/dbc"),
+		StateDir:              getEnv("UMS_STATE_DIR", "/data/ums-service"),
+		DBCMapsDir:            getEnv("UMS_DBC_MAPS_DIR", "/data/maps"),
+		DBCValhallaDir:        getEnv("UMS_DBC_VALHALLA_DIR", "/data/valhalla"),
+		USBVendorID:           getEnv("UMS_USB_VENDOR_ID", ""),
+		USBProductID:          getEnv("UMS_USB_PRODUCT_ID", ""),
+		USBSerial:             getEnv("UMS_USB_SERIAL", ""),
+		NormalGadget:          getEnv("UMS_NORMAL_GADGET", "g_ether"),
+		LinkCheckInterval:     getDuration("UMS_LINK_CHECK_INTERVAL", 10*time.Second),
 		MapTransferTimeout:    getDuration("UMS_MAP_TIMEOUT", 10*time.Minute),
 		RPMTransferTimeout:    getDuration("UMS_RPM_TIMEOUT", 5*time.Minute),
 		ScriptTransferTimeout: getDuration("UMS_SCRIPT_TIMEOUT", 2*time.Minute),
 		MenderTransferTimeout: getDuration("UMS_MENDER_TIMEOUT", 15*time.Minute),
+		RebootWindow:          getEnv("UMS_REBOOT_WINDOW", ""),
+		AutoReboot:            getBool("UMS_AUTO_REBOOT", true),
+		DBCHost:               getEnv("UMS_DBC_HOST", "192.168.7.2"),
+		DBCHTTPHost:           getEnv("UMS_DBC_HTTP_HOST", "192.168.7.1"),
+		DBCPort:               getInt("UMS_DBC_PORT", 31337),
+		DBCFileOwner:          getEnv("UMS_DBC_FILE_OWNER", ""),
+		DBCFileMode:           getEnv("UMS_DBC_FILE_MODE", ""),
+		DBCVerifyCommand:      getEnv("UMS_DBC_VERIFY_COMMAND", ""),
+		DBCVerifyCopies:       getBool("UMS_DBC_VERIFY_COPIES", false),
+		DBCRsync:              getBool("UMS_DBC_RSYNC", false),
+		DBCRetryAttempts:      getInt("UMS_DBC_RETRY_ATTEMPTS", 3),
+		DBCRetryDelay:         getDuration("UMS_DBC_RETRY_DELAY", 2*time.Second),
+		DBCCopyTimeout:        getDuration("UMS_DBC_COPY_TIMEOUT", 120*time.Second),
+		DBCCommandTimeout:     getDuration("UMS_DBC_COMMAND_TIMEOUT", 30*time.Second),
+		DBCKnownHosts:         getEnv("UMS_DBC_KNOWN_HOSTS", "/data/dbc/known_hosts"),
+		DBCLearnHostKey:       getBool("UMS_DBC_LEARN_HOST_KEY", false),
+		DBCReadyCheck:         getEnv("UMS_DBC_READY_CHECK", "test -w /data"),
+		AllowedModes:          getEnv("UMS_ALLOWED_MODES", ""),
+		ModePrecondition:      getEnv("UMS_MODE_PRECONDITION", ""),
+		WireGuardTemplateVars: getEnv("UMS_WG_TEMPLATE_VARS", ""),
+		WireGuardMaxBytes:     getInt("UMS_WG_MAX_BYTES", 64*1024),
+		SettingsMaxBytes:      getInt("UMS_SETTINGS_MAX_BYTES", 1024*1024),
+		SlowStorageKBps:       getInt("UMS_SLOW_STORAGE_KBPS", 1024),
+		SettingsBackups:       getInt("UMS_SETTINGS_BACKUPS", 3),
+		SettingsMode:          getEnv("UMS_SETTINGS_MODE", "replace"),
+		LogExportUnits:        getEnv("UMS_LOG_EXPORT_UNITS", "librescoot-settings,radio-gaga,librescoot-uplink"),
+		LogExportBytes:        getInt("UMS_LOG_EXPORT_BYTES", 1024*1024),
+		BackupPaths:           getEnv("UMS_BACKUP_PATHS", "settings.toml,wireguard,dbc"),
+		BackupExclude:         getEnv("UMS_BACKUP_EXCLUDE", "maps,ota,usb.drive"),
+		ModeQueueSize:         getInt("UMS_MODE_QUEUE_SIZE", 1),
+		ProcessingOrder:       getEnv("UMS_PROCESSING_ORDER", ""),
+		DriveFilesystem:       getEnv("UMS_DRIVE_FILESYSTEM", "vfat"),
+		DriveCheck:            getBool("UMS_DRIVE_CHECK", true),
+		UnknownDirs:           getEnv("UMS_UNKNOWN_DIRS", "clean"),
+		CleanMode:             getEnv("UMS_CLEAN_MODE", "delete"),
+		KeepPatterns:          getEnv("UMS_KEEP_PATTERNS", ""),
+		ChecksumAlgorithm:     getEnv("UMS_CHECKSUM_ALGORITHM", "sha256"),
+		DriveManifest:         getBool("UMS_DRIVE_MANIFEST", true),
+		Hooks:                 getBool("UMS_HOOKS", true),
+		HooksDir:              getEnv("UMS_HOOKS_DIR", "/data/ums-hooks"),
+		HookTimeout:           getDuration("UMS_HOOK_TIMEOUT", 30*time.Second),
+		HooksStrict:           getBool("UMS_HOOKS_STRICT", false),
+		StatusAddr:            getEnv("UMS_STATUS_ADDR", ""),
+		LogLevel:              getEnv("LOG_LEVEL", "info"),
+		DryRun:                getBool("UMS_DRY_RUN", false),
 	}
 }
 
@@ -43,6 +313,32 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getInt(key string, defaultValue int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("config: bad %s=%q: %v, using default %d", key, raw, err, defaultValue)
+		return defaultValue
+	}
+	return v
+}
+
+func getBool(key string, defaultValue bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("config: bad %s=%q: %v, using default %t", key, raw, err, defaultValue)
+		return defaultValue
+	}
+	return v
+}
+
 func getDuration(key string, defaultValue time.Duration) time.Duration {
 	raw := os.Getenv(key)
 	if raw == "" {
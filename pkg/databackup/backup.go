@@ -0,0 +1,237 @@
+// Package databackup writes a restorable archive of selected /data
+// subtrees to the drive, for support engineers handed a scooter. Unlike
+// the diagnostics bundle it holds the files as they are, with their
+// paths relative to /data, so it can be unpacked back in place.
+package databackup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+// DataRoot is the tree a backup is taken from.
+const DataRoot = "/data"
+
+// ManifestName is the archive's last entry, listing what it holds.
+const ManifestName = "ums-backup.json"
+
+// manifestVersion is bumped on incompatible changes to the archive.
+const manifestVersion = 1
+
+// manifest describes a backup, so a restore can tell a complete archive
+// written by the service from anything else.
+type manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created-at"`
+	Paths     []string  `json:"paths"`
+	// Algorithm is the hash Files are in; archives written before it
+	// was recorded use checksum.Default.
+	Algorithm checksum.Algorithm `json:"algorithm,omitempty"`
+	// Files maps each regular file in the archive to its hash.
+	Files map[string]string `json:"files"`
+}
+
+type Exporter struct {
+	root    string
+	paths   []string
+	exclude []string
+	algo    checksum.Algorithm
+	now     func() time.Time
+}
+
+// New backs up the settings, the WireGuard configs and the DBC's files
+// under root, leaving out maps, staged updates and the drive image.
+func New(root string) *Exporter {
+	return &Exporter{
+		root:    root,
+		paths:   []string{"settings.toml", "wireguard", "dbc"},
+		exclude: []string{"maps", "ota", "usb.drive"},
+		algo:    checksum.Default,
+		now:     time.Now,
+	}
+}
+
+// SetPaths sets the files and directories, relative to the root, that
+// are backed up. An empty list keeps the default.
+func (e *Exporter) SetPaths(paths []string) error {
+	if err := checkPaths(paths); err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		e.paths = paths
+	}
+	return nil
+}
+
+// SetExclude sets the paths, relative to the root, left out even when
+// they lie below a backed-up directory. An empty list excludes nothing.
+func (e *Exporter) SetExclude(paths []string) error {
+	if err := checkPaths(paths); err != nil {
+		return err
+	}
+	e.exclude = paths
+	return nil
+}
+
+// SetChecksumAlgorithm sets the hash the manifest lists files with. A
+// restore uses whichever the archive's manifest names.
+func (e *Exporter) SetChecksumAlgorithm(algo checksum.Algorithm) {
+	e.algo = algo
+}
+
+func checkPaths(paths []string) error {
+	for _, p := range paths {
+		if !filepath.IsLocal(p) || filepath.Clean(p) == "." {
+			return fmt.Errorf("%q is not a path below %s", p, DataRoot)
+		}
+	}
+	return nil
+}
+
+// ExportToUSB writes backup/data-<timestamp>.tar.gz to the drive and
+// returns its path on the drive. A configured path that doesn't exist is
+// skipped; an archive that can't be written whole is removed again.
+func (e *Exporter) ExportToUSB(usbMountPath string) (string, error) {
+	dir := filepath.Join(usbMountPath, "backup")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	at := e.now()
+	name := "data-" + at.Format("20060102-150405") + ".tar.gz"
+	path := filepath.Join(dir, name)
+	if err := e.writeArchive(path, at); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	return filepath.Join("backup", name), nil
+}
+
+func (e *Exporter) writeArchive(path string, at time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	m := manifest{Version: manifestVersion, CreatedAt: at.UTC(), Paths: e.paths, Algorithm: e.algo, Files: make(map[string]string)}
+	for _, p := range e.paths {
+		if err := e.addTree(tw, filepath.Join(e.root, p), m.Files); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(data)), ModTime: at}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addTree adds start and, for a directory, everything below it that
+// isn't excluded, recording each regular file's hash in sums. Symlinks
+// are stored as links, not followed.
+func (e *Exporter) addTree(tw *tar.Writer, start string, sums map[string]string) error {
+	return filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == start && os.IsNotExist(err) {
+				log.Printf("backup: %s doesn't exist, skipping", p)
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(e.root, p)
+		if err != nil {
+			return err
+		}
+		if e.excluded(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return addEntry(tw, p, filepath.ToSlash(rel), d, e.algo, sums)
+	})
+}
+
+func (e *Exporter) excluded(rel string) bool {
+	for _, ex := range e.exclude {
+		ex = filepath.Clean(ex)
+		if rel == ex || strings.HasPrefix(rel, ex+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func addEntry(tw *tar.Writer, path, name string, d fs.DirEntry, algo checksum.Algorithm, sums map[string]string) error {
+	if name == "." {
+		return nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	var link string
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case !info.Mode().IsRegular() && !info.IsDir():
+		// Sockets, FIFOs and devices have nothing to restore.
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// CopyN: a file growing as it is read mustn't overrun its header.
+	h := algo.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), src, hdr.Size); err != nil {
+		return err
+	}
+	sums[name] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
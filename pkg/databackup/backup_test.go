@@ -0,0 +1,134 @@
+package databackup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readArchive returns the archive's entries by name, with the contents
+// of regular files.
+func readArchive(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	entries := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(data)
+		if hdr.Typeflag == tar.TypeSymlink {
+			entries[hdr.Name] = "-> " + hdr.Linkname
+		}
+	}
+}
+
+func TestExportToUSB(t *testing.T) {
+	root := t.TempDir()
+	usb := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"settings.toml":              "[scooter]\n",
+		"wireguard/wg0.conf":         "[Interface]\n",
+		"wireguard/profile/wg1.conf": "[Interface]\n",
+		"dbc/maps/tiles.mbtiles":     "tiles",
+		"dbc/onboot.sh":              "#!/bin/sh\n",
+		"ota/mdb/update.mender":      "artifact",
+	})
+	if err := os.Symlink("wg0.conf", filepath.Join(root, "wireguard", "active.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(root)
+	e.now = func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) }
+	if err := e.SetPaths([]string{"settings.toml", "wireguard", "dbc", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetExclude([]string{"dbc/maps"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := e.ExportToUSB(usb)
+	if err != nil {
+		t.Fatalf("ExportToUSB: %v", err)
+	}
+	if path != "backup/data-20240501-123000.tar.gz" {
+		t.Errorf("path = %q", path)
+	}
+
+	got := readArchive(t, filepath.Join(usb, path))
+	var m manifest
+	if err := json.Unmarshal([]byte(got[ManifestName]), &m); err != nil {
+		t.Fatalf("%s: %v", ManifestName, err)
+	}
+	if m.Version != manifestVersion || len(m.Files) != 4 || m.Files["settings.toml"] == "" {
+		t.Errorf("manifest = %+v, want the 4 regular files", m)
+	}
+	delete(got, ManifestName)
+	want := map[string]string{
+		"settings.toml":              "[scooter]\n",
+		"wireguard/":                 "",
+		"wireguard/active.conf":      "-> wg0.conf",
+		"wireguard/wg0.conf":         "[Interface]\n",
+		"wireguard/profile/":         "",
+		"wireguard/profile/wg1.conf": "[Interface]\n",
+		"dbc/":                       "",
+		"dbc/onboot.sh":              "#!/bin/sh\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		var names []string
+		for name := range got {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		t.Errorf("archive holds %q, want %v", names, want)
+	}
+}
+
+func TestSetPaths(t *testing.T) {
+	for _, p := range []string{"/etc", "../etc", "wireguard/../../etc", ""} {
+		if err := New(t.TempDir()).SetPaths([]string{p}); err == nil {
+			t.Errorf("SetPaths(%q) accepted", p)
+		}
+	}
+	e := New(t.TempDir())
+	if err := e.SetPaths(nil); err != nil || len(e.paths) == 0 {
+		t.Errorf("SetPaths(nil) = %v, paths %v; want the default kept", err, e.paths)
+	}
+}
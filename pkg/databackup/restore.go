@@ -0,0 +1,339 @@
+package databackup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+// maxRestoreBytes bounds what a restore unpacks, so a crafted archive
+// can't fill /data.
+const maxRestoreBytes = 64 << 20
+
+// ErrInvalidBackup means an archive isn't a complete backup written by
+// the service, or holds something a restore mustn't write. Nothing in
+// /data is touched.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Restored is what a restore put back.
+type Restored struct {
+	// Paths are the configured backup paths that were replaced.
+	Paths []string
+	// Files are the regular files written, relative to the root.
+	Files []string
+}
+
+// Restore unpacks the backup archive at archivePath into the root. Only
+// entries below the configured paths, and not excluded, are accepted,
+// and the archive's manifest has to account for every file in it; if
+// anything is off, ErrInvalidBackup is returned and nothing is changed.
+// The archive is unpacked next to the live files first, then each path
+// it holds replaces the live one by rename. Excluded paths below a
+// replaced directory are carried over.
+func (e *Exporter) Restore(archivePath string) (Restored, error) {
+	staging, err := os.MkdirTemp(e.root, ".ums-restore-*")
+	if err != nil {
+		return Restored{}, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	names, err := e.unpack(archivePath, staging)
+	if err != nil {
+		return Restored{}, err
+	}
+
+	// A failure part way leaves the paths already replaced restored;
+	// r says which.
+	var r Restored
+	for _, p := range e.paths {
+		staged := filepath.Join(staging, p)
+		if _, err := os.Lstat(staged); err != nil {
+			continue
+		}
+		if err := e.replace(p, staged); err != nil {
+			return r, fmt.Errorf("failed to restore %s: %w", p, err)
+		}
+		r.Paths = append(r.Paths, p)
+		p = filepath.ToSlash(filepath.Clean(p))
+		for _, name := range names {
+			if name == p || strings.HasPrefix(name, p+"/") {
+				r.Files = append(r.Files, name)
+			}
+		}
+	}
+	return r, nil
+}
+
+// unpack extracts the archive into dir and checks it against its
+// manifest, returning the regular files in it, sorted.
+func (e *Exporter) unpack(archivePath, dir string) ([]string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer gz.Close()
+
+	var names []string
+	var m *manifest
+	var total int64
+	// Symlinks are created once everything else is written, so no entry
+	// is ever written through one.
+	var links []*tar.Header
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if hdr.Name == ManifestName {
+			m = &manifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(m); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, ManifestName, err)
+			}
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if !e.restorable(name) {
+			return nil, fmt.Errorf("%w: %s is outside the restorable paths", ErrInvalidBackup, hdr.Name)
+		}
+		if total += hdr.Size; total > maxRestoreBytes {
+			return nil, fmt.Errorf("%w: more than %d MiB", ErrInvalidBackup, maxRestoreBytes>>20)
+		}
+		if belowLink(name, links) {
+			return nil, fmt.Errorf("%w: %s is below a symlink", ErrInvalidBackup, hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			hdr.Name = name
+			links = append(links, hdr)
+			continue
+		}
+		if err := e.extract(tr, hdr, name, dir); err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			names = append(names, name)
+		}
+	}
+	if err := e.link(links, dir); err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("%w: no %s", ErrInvalidBackup, ManifestName)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, m.Version)
+	}
+	algo, err := checksum.Parse(string(m.Algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if len(m.Files) != len(names) {
+		return nil, fmt.Errorf("%w: %d files, manifest lists %d", ErrInvalidBackup, len(names), len(m.Files))
+	}
+	// The manifest comes last, so the files are hashed once it says how.
+	for _, name := range names {
+		want, ok := m.Files[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s doesn't match the manifest", ErrInvalidBackup, name)
+		}
+		if err := algo.Verify(filepath.Join(dir, filepath.FromSlash(name)), want); err != nil {
+			return nil, fmt.Errorf("%w: %s doesn't match the manifest", ErrInvalidBackup, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// restorable reports whether name, relative to the root, is at or below
+// a configured path and not excluded.
+func (e *Exporter) restorable(name string) bool {
+	if !filepath.IsLocal(name) || e.excluded(filepath.FromSlash(name)) {
+		return false
+	}
+	for _, p := range e.paths {
+		p = filepath.ToSlash(filepath.Clean(p))
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Exporter) extract(tr *tar.Reader, hdr *tar.Header, name, dir string) error {
+	dest := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode().Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// Kept writable, so what follows can be unpacked into it.
+		return os.MkdirAll(dest, mode|0700)
+	case tar.TypeReg:
+		out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(dest, hdr.ModTime, hdr.ModTime)
+	default:
+		return fmt.Errorf("%w: %s is not a file, directory or symlink", ErrInvalidBackup, name)
+	}
+}
+
+// belowLink reports whether name lies below one of links.
+func belowLink(name string, links []*tar.Header) bool {
+	for _, l := range links {
+		if strings.HasPrefix(name, l.Name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// link creates links in dir once the files and directories are in place,
+// then checks each resolves, through the others, to somewhere a restore
+// could write.
+func (e *Exporter) link(links []*tar.Header, dir string) error {
+	for _, hdr := range links {
+		if path.IsAbs(hdr.Linkname) {
+			return fmt.Errorf("%w: %s links outside the restorable paths", ErrInvalidBackup, hdr.Name)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, dest); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+	}
+	for _, hdr := range links {
+		target, err := resolveLink(dir, hdr.Name)
+		if err != nil || !e.restorable(target) {
+			return fmt.Errorf("%w: %s links outside the restorable paths", ErrInvalidBackup, hdr.Name)
+		}
+	}
+	return nil
+}
+
+// maxLinkHops bounds how many links resolveLink follows, as the kernel
+// does for a loop.
+const maxLinkHops = 40
+
+// resolveLink returns where the link name, relative to dir, leads the
+// way the kernel would follow it through the links in dir, relative to
+// dir. It fails if the path climbs out of dir or loops.
+func resolveLink(dir, name string) (string, error) {
+	var cur []string
+	rest := strings.Split(name, "/")
+	for hops := 0; len(rest) > 0; {
+		c := rest[0]
+		rest = rest[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(cur) == 0 {
+				return "", errors.New("leaves the root")
+			}
+			cur = cur[:len(cur)-1]
+			continue
+		}
+		cur = append(cur, c)
+		p := filepath.Join(dir, filepath.Join(cur...))
+		info, err := os.Lstat(p)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if hops++; hops > maxLinkHops {
+			return "", errors.New("too many links")
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			return "", errors.New("absolute link")
+		}
+		cur = cur[:len(cur)-1]
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return strings.Join(cur, "/"), nil
+}
+
+// replace puts staged in place of the live path p. The live copy is
+// moved aside first and put back if the swap fails; the excluded paths
+// below it are then moved across, and only once they are is it removed.
+func (e *Exporter) replace(p, staged string) error {
+	live := filepath.Join(e.root, p)
+	if err := os.MkdirAll(filepath.Dir(live), 0755); err != nil {
+		return err
+	}
+	old := live + ".ums-restore-old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(live, old); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return os.Rename(staged, live)
+	}
+	if err := os.Rename(staged, live); err != nil {
+		os.Rename(old, live)
+		return err
+	}
+	if err := e.carryExcluded(p, old, live); err != nil {
+		return fmt.Errorf("previous copy kept in %s: %w", old, err)
+	}
+	return os.RemoveAll(old)
+}
+
+// carryExcluded moves the excluded paths below p from the tree at from
+// to the one at to.
+func (e *Exporter) carryExcluded(p, from, to string) error {
+	prefix := filepath.Clean(p) + string(filepath.Separator)
+	for _, ex := range e.exclude {
+		rel, ok := strings.CutPrefix(filepath.Clean(ex), prefix)
+		if !ok {
+			continue
+		}
+		src := filepath.Join(from, rel)
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(to, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package databackup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+func TestRestoreRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"settings.toml":      "[scooter]\nspeed_limit = 20\n",
+		"wireguard/wg0.conf": "[Interface]\n",
+	})
+	usb := t.TempDir()
+	archive, err := New(src).ExportToUSB(usb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	writeTree(t, dst, map[string]string{
+		"settings.toml":           "[scooter]\nspeed_limit = 25\n",
+		"wireguard/stale.conf":    "[Interface]\n",
+		"wireguard/keys/priv.key": "secret",
+		"dbc/onboot.sh":           "#!/bin/sh\n",
+	})
+	e := New(dst)
+	if err := e.SetExclude([]string{"wireguard/keys"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := e.Restore(filepath.Join(usb, archive))
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if want := []string{"settings.toml", "wireguard"}; !reflect.DeepEqual(r.Paths, want) {
+		t.Errorf("Paths = %v, want %v", r.Paths, want)
+	}
+	if want := []string{"settings.toml", "wireguard/wg0.conf"}; !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("Files = %v, want %v", r.Files, want)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(data)
+	}
+	for name, want := range map[string]string{
+		"settings.toml":           "[scooter]\nspeed_limit = 20\n",
+		"wireguard/wg0.conf":      "[Interface]\n",
+		"wireguard/keys/priv.key": "secret",
+		"dbc/onboot.sh":           "#!/bin/sh\n",
+	} {
+		if got := read(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "wireguard", "stale.conf")); !os.IsNotExist(err) {
+		t.Error("stale.conf survived the restore of wireguard/")
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dst, "*.ums-restore*")); len(leftovers) > 0 {
+		t.Errorf("left behind %v", leftovers)
+	}
+}
+
+type entry struct {
+	name, body, link string
+}
+
+// writeBackup writes a backup archive holding entries and, unless
+// manifestFiles is nil, a manifest listing them.
+func writeBackup(t *testing.T, entries []entry, manifestFiles map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data-20240501-120000.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	add := func(hdr *tar.Header, body string) {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range entries {
+		if e.link != "" {
+			add(&tar.Header{Name: e.name, Typeflag: tar.TypeSymlink, Linkname: e.link, Mode: 0777}, "")
+			continue
+		}
+		add(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body))}, e.body)
+	}
+	if manifestFiles != nil {
+		data, err := json.Marshal(manifest{Version: manifestVersion, Files: manifestFiles})
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(&tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(data))}, string(data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// TestRestoreUsesManifestAlgorithm checks a restore verifies files with
+// the hash the archive was written with, not its own setting.
+func TestRestoreUsesManifestAlgorithm(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"settings.toml": "[scooter]\nspeed_limit = 20\n"})
+	e := New(src)
+	e.SetChecksumAlgorithm(checksum.BLAKE3)
+	usb := t.TempDir()
+	archive, err := e.ExportToUSB(usb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(t.TempDir()).Restore(filepath.Join(usb, archive))
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if want := []string{"settings.toml"}; !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("Files = %v, want %v", r.Files, want)
+	}
+}
+
+func TestRestoreRefuses(t *testing.T) {
+	const settings = "[scooter]\n"
+	cases := []struct {
+		name     string
+		entries  []entry
+		manifest map[string]string
+	}{
+		{
+			name:    "no manifest",
+			entries: []entry{{name: "settings.toml", body: settings}},
+		},
+		{
+			name:     "tampered file",
+			entries:  []entry{{name: "settings.toml", body: "[scooter]\nspeed_limit = 99\n"}},
+			manifest: map[string]string{"settings.toml": sum(settings)},
+		},
+		{
+			name:     "unlisted file",
+			entries:  []entry{{name: "settings.toml", body: settings}, {name: "wireguard/wg0.conf", body: "x"}},
+			manifest: map[string]string{"settings.toml": sum(settings)},
+		},
+		{
+			name:     "outside the paths",
+			entries:  []entry{{name: "settings.toml", body: settings}, {name: "ums-service/batch", body: "{}"}},
+			manifest: map[string]string{"settings.toml": sum(settings), "ums-service/batch": sum("{}")},
+		},
+		{
+			name:     "escaping path",
+			entries:  []entry{{name: "wireguard/../../etc/passwd", body: "root"}},
+			manifest: map[string]string{"wireguard/../../etc/passwd": sum("root")},
+		},
+		{
+			name:     "escaping symlink",
+			entries:  []entry{{name: "wireguard/wg0.conf", link: "../../etc/shadow"}},
+			manifest: map[string]string{},
+		},
+		{
+			name: "file through a symlink",
+			entries: []entry{
+				{name: "wireguard/b", link: "."},
+				{name: "wireguard/a", link: "b/b/../../../wireguard"},
+				{name: "wireguard/a/ESCAPED", body: "x"},
+			},
+			manifest: map[string]string{"wireguard/a/ESCAPED": sum("x")},
+		},
+		{
+			name: "file through a symlink into the root",
+			entries: []entry{
+				{name: "wireguard/b", link: "."},
+				{name: "wireguard/a", link: "b/../../wireguard"},
+				{name: "wireguard/a/ESCAPED", body: "x"},
+			},
+			manifest: map[string]string{"wireguard/a/ESCAPED": sum("x")},
+		},
+		{
+			name: "symlink escaping through a symlink",
+			entries: []entry{
+				{name: "wireguard/b", link: "."},
+				{name: "wireguard/a", link: "b/b/../../../wireguard"},
+			},
+			manifest: map[string]string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "data")
+			writeTree(t, root, map[string]string{"settings.toml": "[scooter]\nspeed_limit = 25\n"})
+
+			r, err := New(root).Restore(writeBackup(t, c.entries, c.manifest))
+			if !errors.Is(err, ErrInvalidBackup) {
+				t.Errorf("Restore = %v, want ErrInvalidBackup", err)
+			}
+			if len(r.Paths) != 0 {
+				t.Errorf("restored %v", r.Paths)
+			}
+			data, _ := os.ReadFile(filepath.Join(root, "settings.toml"))
+			if string(data) != "[scooter]\nspeed_limit = 25\n" {
+				t.Errorf("settings.toml = %q, want it untouched", data)
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(root, ".ums-restore-*")); len(leftovers) > 0 {
+				t.Errorf("left behind %v", leftovers)
+			}
+			for _, dir := range []string{root, filepath.Dir(root)} {
+				if _, err := os.Lstat(filepath.Join(dir, "wireguard")); !os.IsNotExist(err) {
+					t.Errorf("wrote %s", filepath.Join(dir, "wireguard"))
+				}
+			}
+		})
+	}
+}
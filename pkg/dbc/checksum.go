@@ -0,0 +1,69 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+// ErrChecksumMismatch is wrapped into transfer errors where the file on
+// the DBC doesn't hash the same as the one sent.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// copyAttempts is how often copyFile sends a file whose copy on the DBC
+// fails SetVerifyCopies' check.
+const copyAttempts = 2
+
+// SetVerifyCopies makes transfers to the DBC compare the checksum of the
+// file that arrived with the local one, since scp and the upload servers
+// can report success for a truncated file. It costs a read of the file on
+// both ends.
+func (i *Interface) SetVerifyCopies(verify bool) {
+	i.verifyCopies = verify
+}
+
+// SetChecksumAlgorithm sets the hash SetVerifyCopies compares with. The
+// DBC needs its Command (sha256sum, sha512sum or b3sum). The default is
+// checksum.Default.
+func (i *Interface) SetChecksumAlgorithm(algo checksum.Algorithm) {
+	i.checksumAlgo = algo
+}
+
+// checkCopy compares the checksum of localPath with remotePath's on the
+// DBC, if SetVerifyCopies is on.
+func (i *Interface) checkCopy(ctx context.Context, localPath, remotePath string) error {
+	if !i.verifyCopies || i.dryRun {
+		return nil
+	}
+	return i.compareChecksum(ctx, localPath, remotePath)
+}
+
+// compareChecksum fails, wrapping ErrChecksumMismatch, unless remotePath
+// on the DBC has the same checksum as localPath.
+func (i *Interface) compareChecksum(ctx context.Context, localPath, remotePath string) error {
+	algo := i.checksumAlgo
+	want, err := algo.File(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", localPath, err)
+	}
+	out, err := i.RunCommand(ctx, algo.Command()+" "+ShellQuote(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to hash %s on DBC: %w", remotePath, err)
+	}
+	sums, err := checksum.ParseManifest(strings.NewReader(out))
+	if err != nil || len(sums) != 1 {
+		return fmt.Errorf("failed to hash %s on DBC: unexpected %s output %q", remotePath, algo.Command(), out)
+	}
+	var got string
+	for _, sum := range sums {
+		got = sum
+	}
+	if !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s on DBC: %w (%s %s, sent %s)", remotePath, ErrChecksumMismatch, algo, got, want)
+	}
+	i.log.Debugf("Checksum of %s on DBC matches", remotePath)
+	return nil
+}
@@ -0,0 +1,112 @@
+package dbc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+// hashingDBC answers command (sha256sum by default) with sums in turn,
+// repeating the last.
+type hashingDBC struct {
+	command string
+	sums    []string
+	copies  int
+	hashes  int
+}
+
+func (d *hashingDBC) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == "scp" {
+		d.copies++
+		return nil, nil
+	}
+	command := d.command
+	if command == "" {
+		command = "sha256sum"
+	}
+	if strings.HasPrefix(args[len(args)-1], command+" ") {
+		sum := d.sums[min(d.hashes, len(d.sums)-1)]
+		d.hashes++
+		return []byte(sum + "  /data/maps/map.mbtiles\n"), nil
+	}
+	return nil, nil
+}
+
+func (d *hashingDBC) RunInput(ctx context.Context, _ io.Reader, name string, args ...string) ([]byte, error) {
+	return d.Run(ctx, name, args...)
+}
+
+func TestCopyFileVerify(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "map.mbtiles")
+	if err := os.WriteFile(local, []byte("tiles"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("tiles"))
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name       string
+		verify     bool
+		sums       []string
+		wantCopies int
+		wantHashes int
+		wantErr    bool
+	}{
+		{name: "off", sums: []string{bad}, wantCopies: 1},
+		{name: "match", verify: true, sums: []string{good}, wantCopies: 1, wantHashes: 1},
+		{name: "mismatch, then match", verify: true, sums: []string{bad, good}, wantCopies: 2, wantHashes: 2},
+		{name: "mismatch twice", verify: true, sums: []string{bad}, wantCopies: 2, wantHashes: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &hashingDBC{sums: tt.sums}
+			i := &Interface{ip: "192.168.7.2", runner: d, retryAttempts: 1, log: logging.For("dbc")}
+			i.enabled.Store(true)
+			i.SetVerifyCopies(tt.verify)
+
+			err := i.CopyFile(context.Background(), local, "/data/maps/map.mbtiles")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CopyFile = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("CopyFile = %v, want ErrChecksumMismatch", err)
+			}
+			if d.copies != tt.wantCopies || d.hashes != tt.wantHashes {
+				t.Errorf("copied %d times and hashed %d, want %d and %d", d.copies, d.hashes, tt.wantCopies, tt.wantHashes)
+			}
+		})
+	}
+}
+
+func TestCopyFileVerifyAlgorithm(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "map.mbtiles")
+	if err := os.WriteFile(local, []byte("tiles"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := checksum.BLAKE3.File(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &hashingDBC{command: "b3sum", sums: []string{sum}}
+	i := &Interface{ip: "192.168.7.2", runner: d, retryAttempts: 1, log: logging.For("dbc")}
+	i.enabled.Store(true)
+	i.SetVerifyCopies(true)
+	i.SetChecksumAlgorithm(checksum.BLAKE3)
+
+	if err := i.CopyFile(context.Background(), local, "/data/maps/map.mbtiles"); err != nil {
+		t.Errorf("CopyFile = %v", err)
+	}
+	if d.hashes != 1 {
+		t.Errorf("hashed %d times with b3sum, want 1", d.hashes)
+	}
+}
@@ -0,0 +1,49 @@
+package dbc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDBCDiskFull is wrapped into transfer errors caused by the DBC running
+// out of space. Retrying or falling back to another transport won't help
+// until space is freed, so TransferFile returns it straight away.
+var ErrDBCDiskFull = errors.New("DBC storage full")
+
+// diskFullMarkers are lower-cased fragments that scp, sftp, the python
+// upload server and librescoot-data-server print for ENOSPC/EDQUOT.
+var diskFullMarkers = []string{
+	"no space left on device",
+	"disk quota exceeded",
+	"[errno 28]",
+	"enospc",
+}
+
+func isDiskFull(output string) bool {
+	lower := strings.ToLower(output)
+	for _, m := range diskFullMarkers {
+		if strings.Contains(lower, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandError formats a failed ssh/scp invocation, wrapping
+// ErrDBCDiskFull when the output says the remote side ran out of space,
+// ErrHostKeyRejected when the pinned host key didn't match and
+// errTransport when the connection failed.
+func commandError(op string, err error, output []byte) error {
+	out := string(output)
+	if isDiskFull(out) {
+		return fmt.Errorf("%s: %w: %v, output: %s", op, ErrDBCDiskFull, err, out)
+	}
+	if isHostKeyRejected(out) {
+		return fmt.Errorf("%s: %w: %v, output: %s", op, ErrHostKeyRejected, err, out)
+	}
+	if isTransportFailure(err, out) {
+		return fmt.Errorf("%s: %w: %v, output: %s", op, errTransport, err, out)
+	}
+	return fmt.Errorf("%s: %v, output: %s", op, err, out)
+}
@@ -0,0 +1,44 @@
+package dbc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsDiskFull(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{"dropbear scp", "scp: /data/maps/tiles.mbtiles: No space left on device\n", true},
+		{"openssh scp", `scp: write remote "/data/ota/dbc/librescoot-dbc.mender": No space left on device`, true},
+		{"sftp", `remote write "/data/maps/tiles.mbtiles": Disk quota exceeded`, true},
+		{"python upload server", "[Errno 28] No space left on device: '/data/maps/tiles.mbtiles'", true},
+		{"data-server", "write /data/maps/tiles.mbtiles: no space left on device", true},
+		{"connection refused", "ssh: connect to host 192.168.7.2 port 22: Connection refused", false},
+		{"permission denied", "scp: /data/maps/tiles.mbtiles: Permission denied", false},
+		{"empty", "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isDiskFull(c.output); got != c.want {
+				t.Errorf("isDiskFull(%q) = %v, want %v", c.output, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommandErrorWrapsDiskFull(t *testing.T) {
+	exit := errors.New("exit status 1")
+
+	err := commandError("failed to copy file", exit, []byte("scp: /data/x: No space left on device"))
+	if !errors.Is(err, ErrDBCDiskFull) {
+		t.Errorf("expected ErrDBCDiskFull, got %v", err)
+	}
+
+	err = commandError("failed to copy file", exit, []byte("Connection reset by peer"))
+	if errors.Is(err, ErrDBCDiskFull) {
+		t.Errorf("unexpected ErrDBCDiskFull in %v", err)
+	}
+}
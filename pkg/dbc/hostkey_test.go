@@ -0,0 +1,101 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestHostKeyArgs(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	i := &Interface{ip: "192.168.7.2"}
+	i.SetHostKeyPinning(knownHosts, false)
+
+	if got, want := i.sshArgs("true"), []string{"-y", "root@192.168.7.2", "true"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unpinned ssh args = %q, want %q", got, want)
+	}
+	wantSCP := []string{"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "a", "root@192.168.7.2:/b"}
+	if got := i.scpArgs("a", "/b"); !reflect.DeepEqual(got, wantSCP) {
+		t.Errorf("unpinned scp args = %q, want %q", got, wantSCP)
+	}
+
+	if err := os.WriteFile(knownHosts, []byte("192.168.7.2 ssh-ed25519 AAAA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pinned := []string{"-o", "UserKnownHostsFile=" + knownHosts, "-o", "StrictHostKeyChecking=yes"}
+	if got, want := i.sshArgs("true"), append(pinned, "root@192.168.7.2", "true"); !reflect.DeepEqual(got, want) {
+		t.Errorf("pinned ssh args = %q, want %q", got, want)
+	}
+	if got, want := i.scpArgs("a", "/b"), append(pinned, "a", "root@192.168.7.2:/b"); !reflect.DeepEqual(got, want) {
+		t.Errorf("pinned scp args = %q, want %q", got, want)
+	}
+	if got, want := i.scpFetchArgs("/b", "a"), append(pinned, "root@192.168.7.2:/b", "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("pinned scp fetch args = %q, want %q", got, want)
+	}
+}
+
+func TestCheckHostKeyPinning(t *testing.T) {
+	dropbear := "Dropbear SSH client v2022.83 https://matt.ucc.asn.au/dropbear/dropbear.html\nUsage: ssh [options] [user@]host[/port] [command]\n"
+	openssh := "OpenSSH_9.6p1, OpenSSL 3.2.1 30 Jan 2024\n"
+	cases := []struct {
+		name    string
+		pinned  bool
+		learn   bool
+		version string
+		wantErr bool
+	}{
+		{name: "off on dropbear", version: dropbear},
+		{name: "pinned on openssh", pinned: true, version: openssh},
+		{name: "learning on openssh", learn: true, version: openssh},
+		{name: "pinned on dropbear", pinned: true, version: dropbear, wantErr: true},
+		{name: "learning on dropbear", learn: true, version: dropbear, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+			if c.pinned {
+				if err := os.WriteFile(knownHosts, []byte("192.168.7.2 ssh-ed25519 AAAA\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+				"ssh -V": {Output: c.version},
+			}}
+			i := New(t.TempDir(), nil, r)
+			i.SetHostKeyPinning(knownHosts, c.learn)
+			if err := i.CheckHostKeyPinning(context.Background()); (err != nil) != c.wantErr {
+				t.Errorf("CheckHostKeyPinning = %v, want error %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeyscan(t *testing.T) {
+	out := "# 192.168.7.2:22 SSH-2.0-dropbear_2022.83\n" +
+		"192.168.7.2 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKey\n" +
+		"# 192.168.7.2:22 SSH-2.0-dropbear_2022.83\n" +
+		"192.168.7.2 ecdsa-sha2-nistp256 AAAAE2VjZHNh\n\n"
+	want := "192.168.7.2 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKey\n192.168.7.2 ecdsa-sha2-nistp256 AAAAE2VjZHNh\n"
+	if got := parseKeyscan([]byte(out)); got != want {
+		t.Errorf("parseKeyscan = %q, want %q", got, want)
+	}
+	if got := parseKeyscan([]byte("# 192.168.7.2:22 SSH-2.0-dropbear\n")); got != "" {
+		t.Errorf("parseKeyscan of comments only = %q", got)
+	}
+}
+
+func TestCommandErrorWrapsHostKeyRejected(t *testing.T) {
+	out := "@@@@@@@@@@@\n@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n...\nHost key verification failed.\r\nlost connection\n"
+	err := commandError("failed to copy file", commandtest.ExitStatus(1), []byte(out))
+	if !errors.Is(err, ErrHostKeyRejected) {
+		t.Errorf("err = %v, want ErrHostKeyRejected", err)
+	}
+	if errors.Is(err, errTransport) {
+		t.Error("host key rejection treated as a link failure")
+	}
+}
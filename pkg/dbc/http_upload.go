@@ -2,12 +2,13 @@ package dbc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -22,10 +23,11 @@ const uploadServerPort = 8080
 const uploadServerScript = `import http.server
 import os
 import sys
+import urllib.parse
 
 class H(http.server.BaseHTTPRequestHandler):
     def do_PUT(self):
-        p = self.path
+        p = urllib.parse.unquote(self.path)
         try:
             d = os.path.dirname(p)
             if d:
@@ -85,7 +87,7 @@ const dataServerHeaderPrefix = "librescoot-data-server/"
 func (i *Interface) startUploadServer(ctx context.Context) error {
 	if kind, ok := i.probeUploadServer(ctx); ok {
 		i.uploadServerKind = kind
-		log.Printf("DBC upload server: using existing %s on %s:%d", kindName(kind), i.ip, uploadServerPort)
+		i.log.Infof("DBC upload server: using existing %s on %s:%d", kindName(kind), i.ip, uploadServerPort)
 		return nil
 	}
 
@@ -102,13 +104,7 @@ func (i *Interface) startUploadServer(ctx context.Context) error {
 	sshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(sshCtx, "ssh",
-		"-y",
-		fmt.Sprintf("root@%s", i.ip),
-		remoteCmd)
-	cmd.Stdin = strings.NewReader(script)
-
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := i.runner.RunInput(sshCtx, strings.NewReader(script), "ssh", i.sshArgs(remoteCmd)...); err != nil {
 		return fmt.Errorf("failed to start DBC upload server: %w (output: %s)", err, string(out))
 	}
 
@@ -120,7 +116,7 @@ func (i *Interface) startUploadServer(ctx context.Context) error {
 		}
 		if kind, ok := i.probeUploadServer(ctx); ok {
 			i.uploadServerKind = kind
-			log.Printf("DBC upload server ready on %s:%d (%s)", i.ip, uploadServerPort, kindName(kind))
+			i.log.Infof("DBC upload server ready on %s:%d (%s)", i.ip, uploadServerPort, kindName(kind))
 			return nil
 		}
 		time.Sleep(200 * time.Millisecond)
@@ -197,12 +193,8 @@ func (i *Interface) stopUploadServer() {
 			"rm -f /tmp/upload_srv.pid /tmp/upload_srv.py /tmp/upload_srv.log"
 	}
 
-	cmd := exec.CommandContext(ctx, "ssh",
-		"-y",
-		fmt.Sprintf("root@%s", i.ip),
-		remoteCmd)
-	if err := cmd.Run(); err != nil {
-		log.Printf("stopUploadServer: %v (non-fatal)", err)
+	if _, err := i.runner.Run(ctx, "ssh", i.sshArgs(remoteCmd)...); err != nil {
+		i.log.Warnf("stopUploadServer: %v (non-fatal)", err)
 	}
 	i.uploadServerKind = uploadServerNone
 }
@@ -234,12 +226,13 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 
 // UploadFile streams localPath to the DBC via HTTP PUT against whatever
 // upload server startUploadServer settled on. remotePath must start with
-// "/". progressCb may be nil.
+// "/". progressCb may be nil. With SetVerifyCopies, an upload whose
+// checksum doesn't match fails, wrapping ErrChecksumMismatch.
 //
 // Much faster than SCP for large files because there's no per-block crypto;
 // the installer trampoline uses the same trick for tile uploads.
 func (i *Interface) UploadFile(ctx context.Context, localPath, remotePath string, progressCb ProgressFunc) error {
-	if !i.enabled {
+	if !i.enabled.Load() {
 		return fmt.Errorf("DBC interface not enabled")
 	}
 	if i.uploadServerKind == uploadServerNone {
@@ -275,9 +268,11 @@ func (i *Interface) UploadFile(ctx context.Context, localPath, remotePath string
 			return fmt.Errorf("data-server mode requires remotePath under /data, got %q", remotePath)
 		}
 	}
-	url := fmt.Sprintf("http://%s:%d%s", i.ip, uploadServerPort, urlPath)
+	// Escaped, as a file name may hold spaces or other characters a URL
+	// can't; both servers decode it again.
+	url := fmt.Sprintf("http://%s:%d%s", i.ip, uploadServerPort, (&neturl.URL{Path: urlPath}).EscapedPath())
 
-	body := &progressReader{r: f, total: size, progress: progressCb}
+	body := &progressReader{r: f, total: size, progress: i.progressFor(filepath.Base(localPath), progressCb)}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
 	if err != nil {
 		return fmt.Errorf("build request: %w", err)
@@ -302,14 +297,17 @@ func (i *Interface) UploadFile(ctx context.Context, localPath, remotePath string
 
 	if resp.StatusCode != http.StatusOK {
 		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if isDiskFull(string(msg)) {
+			return fmt.Errorf("PUT %s: %w: %s", url, ErrDBCDiskFull, strings.TrimSpace(string(msg)))
+		}
 		return fmt.Errorf("PUT %s: status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(msg)))
 	}
 
 	elapsed := time.Since(start)
 	mbps := float64(size) / elapsed.Seconds() / (1024 * 1024)
-	log.Printf("Uploaded %s → DBC:%s (%d bytes in %s, %.1f MB/s)",
+	i.log.Infof("Uploaded %s → DBC:%s (%d bytes in %s, %.1f MB/s)",
 		localPath, remotePath, size, elapsed.Truncate(time.Millisecond), mbps)
-	return nil
+	return i.checkCopy(ctx, localPath, remotePath)
 }
 
 // TransferFile sends localPath to remotePath on the DBC. Attempts, in
@@ -321,16 +319,27 @@ func (i *Interface) UploadFile(ctx context.Context, localPath, remotePath string
 //  3. SCP fallback
 //
 // After any failed attempt the (possibly partial) remote file is
-// removed via ssh rm -f so the next retry starts clean. progressCb is
-// only invoked on the HTTP path. The context bounds the whole
-// operation.
+// removed via ssh rm -f so the next retry starts clean. Errors wrapping
+// ErrDBCDiskFull end the sequence early. progressCb may be nil; on the
+// scp path it is fed by polling the remote file's size, so it advances
+// in steps. The context bounds the whole operation.
 func (i *Interface) TransferFile(ctx context.Context, localPath, remotePath string, progressCb ProgressFunc) error {
+	// A dry run has no upload server to PUT to.
+	if i.dryRun {
+		return i.copyFile(ctx, localPath, remotePath, progressCb)
+	}
+
 	// Attempt 1: primary HTTP PUT.
 	if err := i.UploadFile(ctx, localPath, remotePath, progressCb); err == nil {
 		return nil
 	} else {
-		log.Printf("HTTP upload of %s failed: %v", localPath, err)
+		i.log.Warnf("HTTP upload of %s failed: %v", localPath, err)
 		i.removePartialRemote(remotePath)
+		// A full disk is full for every transport; don't burn the
+		// operation budget re-sending the file.
+		if errors.Is(err, ErrDBCDiskFull) {
+			return err
+		}
 	}
 
 	if err := ctx.Err(); err != nil {
@@ -342,16 +351,19 @@ func (i *Interface) TransferFile(ctx context.Context, localPath, remotePath stri
 	// something is back on the port.
 	if kind, ok := i.probeUploadServer(ctx); ok {
 		if kind != i.uploadServerKind {
-			log.Printf("DBC upload server changed %s -> %s, retrying", kindName(i.uploadServerKind), kindName(kind))
+			i.log.Infof("DBC upload server changed %s -> %s, retrying", kindName(i.uploadServerKind), kindName(kind))
 			i.uploadServerKind = kind
 		} else {
-			log.Printf("DBC upload server still reachable, retrying once")
+			i.log.Infof("DBC upload server still reachable, retrying once")
 		}
 		if err := i.UploadFile(ctx, localPath, remotePath, progressCb); err == nil {
 			return nil
 		} else {
-			log.Printf("HTTP upload retry of %s failed: %v", localPath, err)
+			i.log.Warnf("HTTP upload retry of %s failed: %v", localPath, err)
 			i.removePartialRemote(remotePath)
+			if errors.Is(err, ErrDBCDiskFull) {
+				return err
+			}
 		}
 	}
 
@@ -360,9 +372,9 @@ func (i *Interface) TransferFile(ctx context.Context, localPath, remotePath stri
 	}
 
 	// Attempt 3: SCP fallback.
-	log.Printf("falling back to SCP for %s", localPath)
-	if err := i.CopyFile(ctx, localPath, remotePath); err != nil {
-		log.Printf("DBC transfer failed for %s -> %s (all paths exhausted)", localPath, remotePath)
+	i.log.Infof("falling back to SCP for %s", localPath)
+	if err := i.copyFile(ctx, localPath, remotePath, progressCb); err != nil {
+		i.log.Warnf("DBC transfer failed for %s -> %s (all paths exhausted)", localPath, remotePath)
 		i.removePartialRemote(remotePath)
 		return err
 	}
@@ -380,11 +392,7 @@ func (i *Interface) removePartialRemote(remotePath string) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "ssh",
-		"-y",
-		fmt.Sprintf("root@%s", i.ip),
-		fmt.Sprintf("rm -f %q", remotePath))
-	if err := cmd.Run(); err != nil {
-		log.Printf("cleanup of partial %s failed (non-fatal): %v", remotePath, err)
+	if _, err := i.runner.Run(ctx, "ssh", i.sshArgs("rm -f "+ShellQuote(remotePath))...); err != nil {
+		i.log.Warnf("cleanup of partial %s failed (non-fatal): %v", remotePath, err)
 	}
 }
@@ -2,16 +2,21 @@ package dbc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
-	"os/exec"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ipc "github.com/librescoot/redis-ipc"
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/logging"
 )
 
 // heartbeatInterval controls how often we re-publish start-dbc to keep
@@ -42,11 +47,18 @@ const (
 )
 
 type Interface struct {
-	ip               string
-	port             int
-	dataDir          string
+	// ip is the DBC's address; httpHost and port are where the MDB
+	// serves dataDir to it. See SetAddresses.
+	ip       string
+	httpHost string
+	port     int
+	dataDir  string
+	// powerMu serializes Enable and Disable, so concurrent calls claim
+	// and release the DBC once, and guards httpServer and httpListener.
+	powerMu          sync.Mutex
 	httpServer       *http.Server
-	enabled          bool
+	httpListener     net.Listener
+	enabled          atomic.Bool
 	client           *ipc.Client
 	uploadServerKind uploadServerKind
 	heartbeatCancel  context.CancelFunc
@@ -59,18 +71,97 @@ type Interface struct {
 	// Sending complete-dbc prematurely would drop the lock during
 	// the handoff window and let the FSM cut DBC power mid-install.
 	dbcUpdateQueued bool
+	// fileOwner and fileMode are applied to transferred files by
+	// ApplyOwnership; zero values leave them untouched.
+	fileOwner string
+	fileMode  os.FileMode
+	// verifyCommand is run on the DBC by Verify after a map or update
+	// lands there; empty skips verification.
+	verifyCommand string
+	// verifyCopies has transfers check the file that arrived; see
+	// SetVerifyCopies.
+	verifyCopies bool
+	// checksumAlgo is the hash verified copies are compared with; see
+	// SetChecksumAlgorithm.
+	checksumAlgo checksum.Algorithm
+	// rsync has SyncFile update existing files with rsync; see SetRsync.
+	rsync bool
+	// retryAttempts and retryDelay are the retry policy for link
+	// failures; see SetRetryPolicy.
+	retryAttempts int
+	retryDelay    time.Duration
+	// copyTimeout and commandTimeout bound a single ssh/scp invocation
+	// when the caller didn't set a deadline; see SetTimeouts.
+	copyTimeout    time.Duration
+	commandTimeout time.Duration
+	// knownHosts pins the DBC's host key while it exists; learnHostKey
+	// has Enable create it. See SetHostKeyPinning.
+	knownHosts   string
+	learnHostKey bool
+	// readyCheck is run on the DBC once its ssh port answers, until it
+	// succeeds; see SetReadyCheck.
+	readyCheck string
+	// progressFunc is called as transfers advance; progressInterval is
+	// how often their progress is logged and an scp's polled. See
+	// SetProgressFunc.
+	progressFunc     ProgressFunc
+	progressInterval time.Duration
+	// runner runs every ssh and scp call to the DBC.
+	runner command.Runner
+	// dryRun logs ssh/scp calls instead of making them and pretends the
+	// DBC came up without claiming its power; see SetDryRun.
+	dryRun bool
+
+	log *logging.Logger
 }
 
-func New(dataDir string, client *ipc.Client) *Interface {
+// SetLogger sets the logger the interface writes to.
+func (i *Interface) SetLogger(logger *logging.Logger) {
+	i.log = logger
+}
+
+func New(dataDir string, client *ipc.Client, runner command.Runner) *Interface {
 	return &Interface{
-		ip:      "192.168.7.2",
-		port:    31337,
-		dataDir: dataDir,
-		client:  client,
-		enabled: false,
+		ip:       "192.168.7.2",
+		httpHost: "192.168.7.1",
+		port:     31337,
+		dataDir:  dataDir,
+		client:   client,
+		runner:   runner,
+
+		retryAttempts: defaultRetryAttempts,
+		retryDelay:    defaultRetryDelay,
+
+		copyTimeout:    defaultCopyTimeout,
+		commandTimeout: defaultCommandTimeout,
+
+		progressInterval: defaultProgressInterval,
+
+		readyCheck: DefaultReadyCheck,
+
+		checksumAlgo: checksum.Default,
+
+		log: logging.For("dbc"),
 	}
 }
 
+// SetAddresses replaces the default addresses of the USB network link:
+// host is the DBC's, httpHost:httpPort where the MDB serves files to it.
+// Call before Enable.
+func (i *Interface) SetAddresses(host, httpHost string, httpPort int) {
+	i.ip = host
+	i.httpHost = httpHost
+	i.port = httpPort
+}
+
+// SetDryRun makes the interface log the ssh and scp calls it would make
+// instead of running them. Enable and Disable then neither ask
+// vehicle-service to power the DBC nor wait for it, and start no upload
+// servers, so maps and updates go down the scp path.
+func (i *Interface) SetDryRun(dryRun bool) {
+	i.dryRun = dryRun
+}
+
 // MarkDBCUpdateQueued records that a DBC update has been handed off
 // to update-service via scooter:update:dbc. Disable() will then leave
 // the vehicle-service update lock held so update-service's own
@@ -80,13 +171,24 @@ func (i *Interface) MarkDBCUpdateQueued() {
 	i.dbcUpdateQueued = true
 }
 
+// Enable powers the DBC for transfers and waits for it to come up. It
+// does nothing if the DBC is already enabled, including by a concurrent
+// call that was first. If it fails, the update lock is released and
+// nothing it started is left running.
 func (i *Interface) Enable(ctx context.Context) error {
-	if i.enabled {
+	i.powerMu.Lock()
+	defer i.powerMu.Unlock()
+	if i.enabled.Load() {
 		return nil
 	}
 
-	log.Println("Enabling DBC interface...")
+	i.log.Infof("Enabling DBC interface...")
 	i.dbcUpdateQueued = false
+	if i.dryRun {
+		i.log.Infof("would claim the DBC update lock (start-dbc) and wait for the DBC")
+		i.enabled.Store(true)
+		return nil
+	}
 
 	// `start-dbc` tells vehicle-service to claim the DBC update lock:
 	// set dbcUpdating=true, arm a safety watchdog, install the
@@ -103,6 +205,10 @@ func (i *Interface) Enable(ctx context.Context) error {
 
 	timeout := time.After(60 * time.Second)
 
+	// The TCP dial is the cheap first gate; the ready check only runs
+	// once sshd answers, so a DBC still booting isn't hammered with ssh
+	// sessions that can't connect.
+	var notReady error
 	for {
 		select {
 		case <-ctx.Done():
@@ -112,22 +218,38 @@ func (i *Interface) Enable(ctx context.Context) error {
 			return ctx.Err()
 		case <-timeout:
 			i.releaseUpdateLock()
+			if notReady != nil {
+				return fmt.Errorf("timeout waiting for DBC to become ready: %w", notReady)
+			}
 			return fmt.Errorf("timeout waiting for DBC to become reachable")
 		case <-ticker.C:
-			if i.isReachable() {
-				i.enabled = true
-				log.Println("DBC is now reachable")
-				if err := i.startHTTPServer(); err != nil {
-					i.releaseUpdateLock()
-					i.enabled = false
-					return err
+			if !i.isReachable() {
+				continue
+			}
+			if err := i.isReady(ctx); err != nil {
+				if notReady == nil {
+					i.log.Infof("DBC answers on ssh, waiting for it to be ready: %v", err)
 				}
-				if err := i.startUploadServer(ctx); err != nil {
-					log.Printf("DBC upload server failed to start, uploads will fall back to SCP: %v", err)
+				notReady = err
+				continue
+			}
+			i.enabled.Store(true)
+			i.log.Infof("DBC is now ready")
+			if i.learnHostKey && !i.hostKeyPinned() {
+				if err := i.LearnHostKey(ctx); err != nil {
+					i.log.Warnf("failed to pin DBC host key: %v", err)
 				}
-				i.startHeartbeat()
-				return nil
 			}
+			if err := i.startHTTPServer(); err != nil {
+				i.releaseUpdateLock()
+				i.enabled.Store(false)
+				return err
+			}
+			if err := i.startUploadServer(ctx); err != nil {
+				i.log.Warnf("DBC upload server failed to start, uploads will fall back to SCP: %v", err)
+			}
+			i.startHeartbeat()
+			return nil
 		}
 	}
 }
@@ -150,9 +272,9 @@ func (i *Interface) startHeartbeat() {
 				return
 			case <-ticker.C:
 				if _, err := i.client.LPush("scooter:update", "start-dbc"); err != nil {
-					log.Printf("heartbeat: failed to refresh DBC update lock: %v", err)
+					i.log.Warnf("heartbeat: failed to refresh DBC update lock: %v", err)
 				} else {
-					log.Printf("heartbeat: refreshed DBC update lock")
+					i.log.Debugf("heartbeat: refreshed DBC update lock")
 				}
 			}
 		}
@@ -177,16 +299,25 @@ func (i *Interface) stopHeartbeat() {
 // deferred dashboard power changes. Safe to call multiple times.
 func (i *Interface) releaseUpdateLock() {
 	if _, err := i.client.LPush("scooter:update", "complete-dbc"); err != nil {
-		log.Printf("Failed to release DBC update lock: %v", err)
+		i.log.Errorf("Failed to release DBC update lock: %v", err)
 	}
 }
 
+// Disable stops what Enable started and releases the DBC. It does
+// nothing if the DBC isn't enabled.
 func (i *Interface) Disable() error {
-	if !i.enabled {
+	i.powerMu.Lock()
+	defer i.powerMu.Unlock()
+	if !i.enabled.Load() {
 		return nil
 	}
 
-	log.Println("Disabling DBC interface...")
+	i.log.Infof("Disabling DBC interface...")
+	if i.dryRun {
+		i.log.Infof("would release the DBC update lock (complete-dbc)")
+		i.enabled.Store(false)
+		return nil
+	}
 
 	// If a DBC mender update was queued to update-service during
 	// this cycle, DO NOT release the update lock here. update-service
@@ -199,7 +330,7 @@ func (i *Interface) Disable() error {
 	// over from there.
 	releaseLock := !i.dbcUpdateQueued
 	if !releaseLock {
-		log.Println("DBC update queued to update-service; leaving update lock held for handoff")
+		i.log.Infof("DBC update queued to update-service; leaving update lock held for handoff")
 	}
 
 	// Stop the heartbeat FIRST, then release the lock. Reversing the
@@ -218,17 +349,9 @@ func (i *Interface) Disable() error {
 	}
 
 	i.stopUploadServer()
+	i.stopHTTPServer()
 
-	if i.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := i.httpServer.Shutdown(ctx); err != nil {
-			log.Printf("Error shutting down HTTP server: %v", err)
-		}
-		i.httpServer = nil
-	}
-
-	i.enabled = false
+	i.enabled.Store(false)
 	return nil
 }
 
@@ -241,88 +364,198 @@ func (i *Interface) isReachable() bool {
 	return true
 }
 
+// startHTTPServer serves the data directory to the DBC. The port is
+// bound before it returns, so a port in use fails Enable rather than
+// leaving it without a server. A server left over from an earlier cycle
+// is stopped first. Call with powerMu held.
 func (i *Interface) startHTTPServer() error {
+	i.stopHTTPServer()
+
 	mux := http.NewServeMux()
 	mux.Handle("/", http.FileServer(http.Dir(i.dataDir)))
 
-	i.httpServer = &http.Server{
-		Addr:    fmt.Sprintf("192.168.7.1:%d", i.port),
-		Handler: mux,
+	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", i.httpHost, i.port))
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
 	}
+	srv := &http.Server{Handler: mux}
+	i.httpServer = srv
+	i.httpListener = ln
 
 	go func() {
-		log.Printf("Starting HTTP server on port %d serving %s", i.port, i.dataDir)
-		if err := i.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server error: %v", err)
+		i.log.Infof("Starting HTTP server on port %d serving %s", i.port, i.dataDir)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			i.log.Errorf("HTTP server error: %v", err)
 		}
 	}()
 
 	return nil
 }
 
+// stopHTTPServer shuts the server from startHTTPServer down, if one is
+// running. Downloads still going after 5s are cut off, so the port is
+// always free for the next Enable. Call with powerMu held.
+func (i *Interface) stopHTTPServer() {
+	if i.httpServer == nil {
+		return
+	}
+	srv, ln := i.httpServer, i.httpListener
+	i.httpServer, i.httpListener = nil, nil
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		i.log.Errorf("Error shutting down HTTP server, closing it: %v", err)
+		srv.Close()
+	}
+	// Shutdown only closes the listener once Serve has picked it up,
+	// which a quick Disable can beat.
+	ln.Close()
+}
+
 func (i *Interface) DownloadFile(ctx context.Context, localPath, remotePath string) error {
-	if !i.enabled {
+	if !i.enabled.Load() {
 		return fmt.Errorf("DBC interface not enabled")
 	}
 
 	filename := filepath.Base(localPath)
-	url := fmt.Sprintf("http://192.168.7.1:%d/%s", i.port, filename)
-
-	// `-y` on dbclient auto-accepts unknown host keys. The scooter's
-	// ssh is dropbear, which doesn't understand OpenSSH's `-o Strict...`
-	// options and prints a warning for each one in journald.
-	cmd := exec.CommandContext(ctx, "ssh",
-		"-y",
-		fmt.Sprintf("root@%s", i.ip),
-		fmt.Sprintf("wget -O %s %s", remotePath, url))
+	url := fmt.Sprintf("http://%s:%d/%s", i.httpHost, i.port, filename)
 
-	output, err := cmd.CombinedOutput()
+	err := i.withRetry(ctx, "download of "+filename, func() error {
+		_, err := i.runBounded(ctx, i.copyTimeout, "failed to download file via SSH", "ssh",
+			i.sshArgs(fmt.Sprintf("wget -O %s %s", ShellQuote(remotePath), ShellQuote(url)))...)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to download file via SSH: %v, output: %s", err, string(output))
+		return err
 	}
 
-	log.Printf("Downloaded %s to DBC at %s", filename, remotePath)
+	i.log.Infof("Downloaded %s to DBC at %s", filename, remotePath)
 	return nil
 }
 
+// CopyFile copies localPath to remotePath on the DBC with scp. Progress
+// goes to the SetProgressFunc hook and the log. With SetVerifyCopies, a
+// copy whose checksum doesn't match is sent once more.
 func (i *Interface) CopyFile(ctx context.Context, localPath, remotePath string) error {
-	if !i.enabled {
+	return i.copyFile(ctx, localPath, remotePath, nil)
+}
+
+// copyFile is CopyFile, reporting progress to progressCb as well. scp
+// itself shows none without a terminal, so the size of the remote file is
+// polled while it runs.
+func (i *Interface) copyFile(ctx context.Context, localPath, remotePath string, progressCb ProgressFunc) error {
+	if !i.enabled.Load() {
 		return fmt.Errorf("DBC interface not enabled")
 	}
 
-	cmd := exec.CommandContext(ctx, "scp",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		localPath,
-		fmt.Sprintf("root@%s:%s", i.ip, remotePath))
+	var total int64
+	progress := i.progressFor(filepath.Base(localPath), progressCb)
+	stopWatching := func() {}
+	if st, err := os.Stat(localPath); err == nil && !i.dryRun && i.progressInterval > 0 {
+		total = st.Size()
+		stopWatching = i.watchRemoteSize(ctx, remotePath, total, progress)
+	}
+	var err error
+	for attempt := 1; attempt <= copyAttempts; attempt++ {
+		err = i.withRetry(ctx, "copy of "+filepath.Base(localPath), func() error {
+			_, err := i.runBounded(ctx, i.copyTimeout, "failed to copy file", "scp",
+				i.scpArgs(localPath, remotePath)...)
+			return err
+		})
+		if err == nil {
+			err = i.checkCopy(ctx, localPath, remotePath)
+		}
+		if !errors.Is(err, ErrChecksumMismatch) || attempt == copyAttempts {
+			break
+		}
+		i.log.Warnf("%v; copying it again", err)
+	}
+	stopWatching()
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		progress(total, total)
+	}
+
+	i.log.Infof("Copied %s to DBC at %s", localPath, remotePath)
+	return nil
+}
+
+// FetchFile copies remotePath on the DBC to localPath, with the same
+// timeout and retries as CopyFile. The file is fetched next to localPath
+// and renamed into place, so a failed fetch leaves whatever was at
+// localPath alone.
+func (i *Interface) FetchFile(ctx context.Context, remotePath, localPath string) error {
+	if !i.enabled.Load() {
+		return fmt.Errorf("DBC interface not enabled")
+	}
 
-	output, err := cmd.CombinedOutput()
+	partPath := localPath + ".part"
+	err := i.withRetry(ctx, "fetch of "+filepath.Base(remotePath), func() error {
+		_, err := i.runBounded(ctx, i.copyTimeout, "failed to fetch file", "scp",
+			i.scpFetchArgs(remotePath, partPath)...)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to copy file: %v, output: %s", err, string(output))
+		os.Remove(partPath)
+		return err
+	}
+	if i.dryRun {
+		return nil
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("failed to move fetched %s into place: %w", filepath.Base(remotePath), err)
 	}
 
-	log.Printf("Copied %s to DBC at %s", localPath, remotePath)
+	i.log.Infof("Fetched %s from DBC to %s", remotePath, localPath)
 	return nil
 }
 
+// RunCommand runs command on the DBC over ssh and returns its trimmed
+// output. It is run again if the connection fails, so command must be
+// safe to repeat.
 func (i *Interface) RunCommand(ctx context.Context, command string) (string, error) {
-	if !i.enabled {
+	if !i.enabled.Load() {
 		return "", fmt.Errorf("DBC interface not enabled")
 	}
 
-	cmd := exec.CommandContext(ctx, "ssh",
-		"-y",
-		fmt.Sprintf("root@%s", i.ip),
-		command)
+	var output []byte
+	err := i.withRetry(ctx, "command", func() error {
+		var err error
+		output, err = i.runCommand(ctx, command)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
 
-	output, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RunCommandOnce is RunCommand for commands that mustn't run twice,
+// like an install: if the connection drops, the command may already
+// have run, so the failure is returned rather than retried.
+func (i *Interface) RunCommandOnce(ctx context.Context, command string) (string, error) {
+	if !i.enabled.Load() {
+		return "", fmt.Errorf("DBC interface not enabled")
+	}
+
+	output, err := i.runCommand(ctx, command)
 	if err != nil {
-		return "", fmt.Errorf("failed to run command: %v, output: %s", err, string(output))
+		return "", err
 	}
 
 	return strings.TrimSpace(string(output)), nil
 }
 
+func (i *Interface) runCommand(ctx context.Context, command string) ([]byte, error) {
+	return i.runBounded(ctx, i.commandTimeout, "failed to run command", "ssh", i.sshArgs(command)...)
+}
+
+// IsEnabled reports whether the DBC is up for transfers. It may be
+// called from any goroutine.
 func (i *Interface) IsEnabled() bool {
-	return i.enabled
+	return i.enabled.Load()
 }
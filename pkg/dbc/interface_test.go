@@ -0,0 +1,120 @@
+package dbc
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+// TestFetchFileKeepsLocalOnFailure checks that a fetch that fails leaves
+// an existing local file as it was and no partial copy behind.
+func TestFetchFileKeepsLocalOnFailure(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "settings.toml")
+	if err := os.WriteFile(local, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		"scp": {Output: "ssh: connect to host 192.168.7.2 port 22: Connection refused\r\nlost connection\n", Err: commandtest.ExitStatus(1)},
+	}}
+	i := &Interface{ip: "192.168.7.2", runner: r, log: logging.For("dbc")}
+	i.enabled.Store(true)
+	i.SetRetryPolicy(2, time.Millisecond)
+
+	if err := i.FetchFile(context.Background(), "/data/settings.toml", local); err == nil {
+		t.Fatal("FetchFile succeeded, want an error")
+	}
+	if data, _ := os.ReadFile(local); string(data) != "old" {
+		t.Errorf("local file = %q, want it untouched", data)
+	}
+	if _, err := os.Stat(local + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+	if calls := r.Calls(); len(calls) != 2 || !strings.HasSuffix(calls[0], " root@192.168.7.2:/data/settings.toml "+local+".part") {
+		t.Errorf("commands = %q, want two scp attempts into the .part file", calls)
+	}
+}
+
+// TestEnableDisableConcurrent checks that concurrent Enable calls claim
+// the DBC once, and concurrent Disable calls release it once.
+func TestEnableDisableConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	i := New(t.TempDir(), nil, &commandtest.Recorder{})
+	i.SetLogger(logging.New(slog.New(logging.NewHandler(&buf, slog.LevelInfo, false)), "dbc"))
+	i.SetDryRun(true)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := i.Enable(context.Background()); err != nil {
+				t.Errorf("Enable: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if !i.IsEnabled() {
+		t.Fatal("not enabled")
+	}
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := i.Disable(); err != nil {
+				t.Errorf("Disable: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if i.IsEnabled() {
+		t.Fatal("still enabled")
+	}
+
+	out := buf.String()
+	if n := strings.Count(out, "start-dbc"); n != 1 {
+		t.Errorf("claimed the DBC %d times, want once:\n%s", n, out)
+	}
+	if n := strings.Count(out, "complete-dbc"); n != 1 {
+		t.Errorf("released the DBC %d times, want once:\n%s", n, out)
+	}
+}
+
+// TestHTTPServerPort checks that a port in use fails startHTTPServer
+// straight away, and that stopping the server frees its port.
+func TestHTTPServerPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	i := New(t.TempDir(), nil, &commandtest.Recorder{})
+	i.SetAddresses("127.0.0.1", "127.0.0.1", port)
+
+	if err := i.startHTTPServer(); err == nil {
+		t.Fatal("startHTTPServer succeeded on a port in use")
+	}
+	if i.httpServer != nil {
+		t.Error("server kept after a failed start")
+	}
+
+	ln.Close()
+	for n := 0; n < 2; n++ {
+		if err := i.startHTTPServer(); err != nil {
+			t.Fatalf("startHTTPServer #%d: %v", n+1, err)
+		}
+		i.stopHTTPServer()
+	}
+	if i.httpServer != nil {
+		t.Error("server kept after stopHTTPServer")
+	}
+}
@@ -0,0 +1,55 @@
+package dbc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// ownerPattern accepts "user" or "user:group" with the characters
+// busybox/shadow allow in names. Anything else is refused so the value
+// can't smuggle shell syntax into the remote command.
+var ownerPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(:[A-Za-z0-9._-]+)?$`)
+
+// SetFileOwnership configures what ApplyOwnership does to transferred
+// files. An empty owner and a zero mode leave files as the transfer
+// created them (root-owned).
+func (i *Interface) SetFileOwnership(owner string, mode os.FileMode) error {
+	if owner != "" && !ownerPattern.MatchString(owner) {
+		return fmt.Errorf("invalid owner %q: expected user or user:group", owner)
+	}
+	if mode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid mode %o: only permission bits are allowed", mode)
+	}
+	i.fileOwner = owner
+	i.fileMode = mode
+	return nil
+}
+
+// ApplyOwnership runs the configured chown/chmod on remotePath. A no-op
+// unless SetFileOwnership was given an owner or mode.
+func (i *Interface) ApplyOwnership(ctx context.Context, remotePath string) error {
+	cmd := ownershipCommand(remotePath, i.fileOwner, i.fileMode)
+	if cmd == "" {
+		return nil
+	}
+	if _, err := i.RunCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to set ownership of %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func ownershipCommand(remotePath, owner string, mode os.FileMode) string {
+	var cmd string
+	if owner != "" {
+		cmd = fmt.Sprintf("chown %s %s", owner, ShellQuote(remotePath))
+	}
+	if mode != 0 {
+		if cmd != "" {
+			cmd += " && "
+		}
+		cmd += fmt.Sprintf("chmod %04o %s", mode.Perm(), ShellQuote(remotePath))
+	}
+	return cmd
+}
@@ -0,0 +1,47 @@
+package dbc
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOwnershipCommand(t *testing.T) {
+	const path = "/data/maps/map.mbtiles"
+	cases := []struct {
+		name  string
+		owner string
+		mode  os.FileMode
+		want  string
+	}{
+		{"unchanged", "", 0, ""},
+		{"owner only", "navigator", 0, `chown navigator /data/maps/map.mbtiles`},
+		{"mode only", "", 0640, `chmod 0640 /data/maps/map.mbtiles`},
+		{"both", "navigator:maps", 0644, `chown navigator:maps /data/maps/map.mbtiles && chmod 0644 /data/maps/map.mbtiles`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ownershipCommand(path, c.owner, c.mode); got != c.want {
+				t.Errorf("ownershipCommand = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSetFileOwnership(t *testing.T) {
+	i := &Interface{}
+	if err := i.SetFileOwnership("navigator:maps", 0644); err != nil {
+		t.Fatalf("SetFileOwnership: %v", err)
+	}
+	if i.fileOwner != "navigator:maps" || i.fileMode != 0644 {
+		t.Errorf("got owner=%q mode=%o", i.fileOwner, i.fileMode)
+	}
+
+	for _, owner := range []string{"root; reboot", "a:b:c", "$(id)", "nav igator"} {
+		if err := i.SetFileOwnership(owner, 0); err == nil {
+			t.Errorf("SetFileOwnership(%q) accepted", owner)
+		}
+	}
+	if err := i.SetFileOwnership("", os.ModeSetuid|0755); err == nil {
+		t.Error("SetFileOwnership accepted setuid mode")
+	}
+}
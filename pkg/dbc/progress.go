@@ -0,0 +1,89 @@
+package dbc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is how often transfer progress is logged, and
+// how often the size of a file arriving over scp is polled.
+const defaultProgressInterval = 5 * time.Second
+
+// SetProgressFunc sets a hook called as any transfer to the DBC
+// advances, over HTTP or scp, in addition to the callback passed to
+// TransferFile. It runs on the transferring goroutine, so keep it cheap.
+func (i *Interface) SetProgressFunc(fn ProgressFunc) {
+	i.progressFunc = fn
+}
+
+// progressFor returns the progress callback for one transfer of name: it
+// calls cb and the SetProgressFunc hook, and logs how far along the
+// transfer is every progress interval. It is never nil.
+func (i *Interface) progressFor(name string, cb ProgressFunc) ProgressFunc {
+	hook := i.progressFunc
+	interval := i.progressInterval
+	var mu sync.Mutex
+	lastLog := time.Now()
+	return func(sent, total int64) {
+		if cb != nil {
+			cb(sent, total)
+		}
+		if hook != nil {
+			hook(sent, total)
+		}
+		mu.Lock()
+		due := interval > 0 && time.Since(lastLog) >= interval
+		if due {
+			lastLog = time.Now()
+		}
+		mu.Unlock()
+		if due && total > 0 && sent < total {
+			i.log.Infof("Sending %s to DBC: %d/%d MB (%d%%)",
+				name, sent/(1024*1024), total/(1024*1024), sent*100/total)
+		}
+	}
+}
+
+// watchRemoteSize reports the size of remotePath on the DBC to progress
+// every progress interval while an scp writes it, since scp shows no
+// progress without a terminal. Call the returned function once the copy
+// is done.
+func (i *Interface) watchRemoteSize(ctx context.Context, remotePath string, total int64, progress ProgressFunc) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(i.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if size, ok := i.remoteSize(ctx, remotePath); ok && size < total {
+				progress(size, total)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// remoteSize returns the size of path on the DBC, or false if it can't
+// be had (yet).
+func (i *Interface) remoteSize(ctx context.Context, path string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, i.progressInterval)
+	defer cancel()
+	out, err := i.runner.Run(ctx, "ssh", i.sshArgs("stat -c %s "+ShellQuote(path))...)
+	if err != nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return size, err == nil
+}
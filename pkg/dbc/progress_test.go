@@ -0,0 +1,70 @@
+package dbc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+// slowSCP answers a remote stat with a partial size and takes a while to
+// finish an scp, as a large copy would.
+type slowSCP struct{}
+
+func (slowSCP) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == "scp" {
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	}
+	if strings.HasPrefix(args[len(args)-1], "stat ") {
+		return []byte("1024\n"), nil
+	}
+	return nil, nil
+}
+
+func (r slowSCP) RunInput(ctx context.Context, _ io.Reader, name string, args ...string) ([]byte, error) {
+	return r.Run(ctx, name, args...)
+}
+
+// TestCopyFileProgress checks that an scp copy reports the remote file's
+// size while it runs, and completion once it's done, to both the
+// per-transfer callback and the SetProgressFunc hook.
+func TestCopyFileProgress(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "tiles.tar")
+	if err := os.WriteFile(local, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := &Interface{ip: "192.168.7.2", runner: slowSCP{}, progressInterval: 5 * time.Millisecond, log: logging.For("dbc")}
+	i.enabled.Store(true)
+
+	var mu sync.Mutex
+	var calls, hooked [][2]int64
+	i.SetProgressFunc(func(sent, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		hooked = append(hooked, [2]int64{sent, total})
+	})
+	cb := func(sent, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, [2]int64{sent, total})
+	}
+
+	if err := i.copyFile(context.Background(), local, "/data/maps/tiles.tar", cb); err != nil {
+		t.Fatalf("copyFile: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) < 2 || calls[0] != [2]int64{1024, 4096} || calls[len(calls)-1] != [2]int64{4096, 4096} {
+		t.Errorf("progress = %v, want 1024/4096 while copying and 4096/4096 at the end", calls)
+	}
+	if len(hooked) != len(calls) {
+		t.Errorf("hook called %d times, callback %d", len(hooked), len(calls))
+	}
+}
@@ -0,0 +1,22 @@
+package dbc
+
+import "strings"
+
+// ShellQuote quotes s as a single word for the DBC's shell, so a path
+// with spaces or shell metacharacters reaches the command as is. Words
+// made only of characters the shell treats literally are left bare, to
+// keep commands in the log readable.
+func ShellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, needsQuoting) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("_-./:,+=@%", r)
+}
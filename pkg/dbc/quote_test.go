@@ -0,0 +1,82 @@
+package dbc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/data/ota/dbc", "/data/ota/dbc"},
+		{"", "''"},
+		{"/data/ota/librescoot-mdb v2 (rc1).mender", "'/data/ota/librescoot-mdb v2 (rc1).mender'"},
+		{"it's; rm -rf /", `'it'\''s; rm -rf /'`},
+		{"$(reboot)`id`", "'$(reboot)`id`'"},
+	}
+	for _, tt := range tests {
+		if got := ShellQuote(tt.in); got != tt.want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestRemotePathQuoting checks the commands that reach the DBC keep an
+// awkward file name in one piece.
+func TestRemotePathQuoting(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "librescoot-mdb v2 (rc1).mender")
+	if err := os.WriteFile(local, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := "/data/ota/librescoot-mdb v2 (rc1).mender"
+	quoted := "'" + remote + "'"
+
+	sum, err := checksum.SHA256.File(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		"ssh": {Output: sum + "  " + remote},
+	}}
+	i := &Interface{ip: "192.168.7.2", runner: r, retryAttempts: 1, log: logging.For("dbc")}
+	i.enabled.Store(true)
+	if err := i.SetFileOwnership("root", 0644); err != nil {
+		t.Fatal(err)
+	}
+	i.SetVerifyCopies(true)
+	i.SetVerifyCommand("true")
+
+	if err := i.CopyFile(context.Background(), local, remote); err != nil {
+		t.Fatalf("CopyFile: %v", err)
+	}
+	if err := i.ApplyOwnership(context.Background(), remote); err != nil {
+		t.Fatalf("ApplyOwnership: %v", err)
+	}
+	if err := i.Verify(context.Background(), "update", remote); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	calls := r.Calls()
+	wantSuffixes := []string{
+		" root@192.168.7.2:" + quoted,
+		" sha256sum " + quoted,
+		" chown root " + quoted + " && chmod 0644 " + quoted,
+		" export UMS_VERIFY_KIND=update UMS_VERIFY_PATH=" + quoted + "; true",
+	}
+	if len(calls) != len(wantSuffixes) {
+		t.Fatalf("commands = %q", calls)
+	}
+	for n, want := range wantSuffixes {
+		if !strings.HasSuffix(calls[n], want) {
+			t.Errorf("command %d = %q, want it to end in %q", n, calls[n], want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package dbc
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultReadyCheck is the command Enable runs on the DBC once its ssh
+// port answers. sshd comes up before /data is mounted read-write, so a
+// DBC taking connections can't necessarily take files yet.
+const DefaultReadyCheck = "test -w /data"
+
+// readyCheckTimeout bounds a single readiness check, so one hung ssh
+// session doesn't eat the rest of Enable's wait.
+const readyCheckTimeout = 5 * time.Second
+
+// SetReadyCheck sets the shell command Enable runs on the DBC, once it
+// accepts TCP connections on port 22, to tell whether it is ready for
+// transfers; it polls until the command exits zero. Empty skips the
+// check, so the DBC counts as up as soon as the port answers.
+func (i *Interface) SetReadyCheck(cmd string) {
+	i.readyCheck = cmd
+}
+
+// isReady runs the readiness check on the DBC. Failures aren't retried
+// here: Enable polls again on its next tick.
+func (i *Interface) isReady(ctx context.Context) error {
+	if i.readyCheck == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+	_, err := i.runBounded(ctx, readyCheckTimeout, "DBC readiness check failed", "ssh", i.sshArgs(i.readyCheck)...)
+	return err
+}
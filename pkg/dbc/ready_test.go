@@ -0,0 +1,38 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestIsReady(t *testing.T) {
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		"ssh": {Err: errors.New("exit status 1")},
+	}}
+	i := New(t.TempDir(), nil, r)
+	if err := i.isReady(context.Background()); err == nil {
+		t.Error("isReady succeeded with a failing check")
+	}
+	calls := r.Calls()
+	if len(calls) != 1 || !strings.HasSuffix(calls[0], "root@192.168.7.2 "+DefaultReadyCheck) {
+		t.Errorf("calls = %q, want one ssh running %q", calls, DefaultReadyCheck)
+	}
+
+	r.Replies = nil
+	if err := i.isReady(context.Background()); err != nil {
+		t.Errorf("isReady with a passing check = %v", err)
+	}
+
+	// Without a check, only the port counts: nothing is run.
+	i.SetReadyCheck("")
+	if err := i.isReady(context.Background()); err != nil {
+		t.Errorf("isReady without a check = %v", err)
+	}
+	if n := len(r.Calls()); n != 2 {
+		t.Errorf("%d calls, want 2", n)
+	}
+}
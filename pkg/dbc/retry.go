@@ -0,0 +1,101 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 2 * time.Second
+)
+
+// errTransport is wrapped into ssh/scp errors where the link to the DBC
+// failed or timed out rather than the remote command. The USB network to
+// the DBC takes a moment to settle after it's powered up, so these are
+// retried.
+var errTransport = errors.New("DBC link failure")
+
+// transportExitStatus is what OpenSSH's ssh and rsync exit with when the
+// connection itself failed; a remote command's own status is passed
+// through instead.
+const transportExitStatus = 255
+
+// linkFailureLines start the lines ssh, scp and Dropbear's dbclient print
+// when the connection to the DBC fails or breaks. scp and dbclient exit
+// with status 1 then, which a remote command may exit with too, so their
+// message tells the two apart.
+var linkFailureLines = []string{
+	"ssh: connect to host ",
+	"ssh: Could not resolve hostname ",
+	"kex_exchange_identification: ",
+	"Connection closed by ",
+	"Connection reset by ",
+	"Connection timed out during banner exchange",
+	"client_loop: send disconnect: ",
+	"lost connection",
+	"dbclient: Connection to ",
+	"dbclient: Exited: ",
+	"ssh: Connection to ",
+	"ssh: Exited: ",
+}
+
+// isTransportFailure reports whether err and output, as returned by the
+// command runner, mean the client lost or never got a session to the
+// DBC: it exited with transportExitStatus, or failed and printed one of
+// linkFailureLines at the start of a line. A remote command that merely
+// mentions a refused connection somewhere in its output doesn't count.
+func isTransportFailure(err error, output string) bool {
+	var exit interface{ ExitCode() int }
+	if !errors.As(err, &exit) {
+		return false
+	}
+	if exit.ExitCode() == transportExitStatus {
+		return true
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range linkFailureLines {
+			if strings.HasPrefix(line, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetRetryPolicy sets how often copies and RunCommand try an operation
+// that failed on the link to the DBC or timed out, and the delay before
+// the first retry, doubled for each one after. Failures of the remote
+// command itself are never retried.
+func (i *Interface) SetRetryPolicy(attempts int, baseDelay time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	i.retryAttempts = attempts
+	i.retryDelay = baseDelay
+}
+
+// withRetry runs op until it succeeds, fails with something other than
+// a link failure, or runs out of attempts. A command whose connection
+// broke mid-way is run again, so commands that aren't safe to repeat go
+// through RunCommandOnce.
+func (i *Interface) withRetry(ctx context.Context, what string, op func() error) error {
+	delay := i.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !errors.Is(err, errTransport) || attempt >= i.retryAttempts {
+			return err
+		}
+		i.log.Warnf("DBC %s: attempt %d/%d failed: %v; retrying in %s", what, attempt, i.retryAttempts, err, delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up retrying: %v)", err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,115 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+func TestCommandErrorWrapsTransport(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		output string
+		want   bool
+	}{
+		{"dbclient refused", commandtest.ExitStatus(1), "dbclient: Connection to root@192.168.7.2:22 exited: Connection to 192.168.7.2 port 22 failed: Connection refused\n", true},
+		{"dbclient as ssh, link dropped", commandtest.ExitStatus(1), "ssh: Connection to root@192.168.7.2:22 exited: Remote closed the connection\n", true},
+		{"openssh ssh refused", commandtest.ExitStatus(255), "ssh: connect to host 192.168.7.2 port 22: Connection refused\r\n", true},
+		{"openssh scp refused", commandtest.ExitStatus(1), "ssh: connect to host 192.168.7.2 port 22: Connection refused\r\nlost connection\n", true},
+		{"openssh scp reset", commandtest.ExitStatus(1), "Connection reset by 192.168.7.2 port 22\r\nlost connection\n", true},
+		{"remote command failed", commandtest.ExitStatus(127), "sh: mender: not found", false},
+		{"remote command exits 1", commandtest.ExitStatus(1), "grep: /data/ota/dbc: No such file or directory", false},
+		{"remote output mentions the link", commandtest.ExitStatus(1), "curl: (7) Failed to connect to 192.168.7.1 port 31337: Connection refused", false},
+		{"disk full", commandtest.ExitStatus(1), "scp: /data/x: No space left on device", false},
+		{"no exit status", errors.New("signal: killed"), "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := commandError("failed to run command", c.err, []byte(c.output))
+			if got := errors.Is(err, errTransport); got != c.want {
+				t.Errorf("errors.Is(%v, errTransport) = %v, want %v", err, got, c.want)
+			}
+		})
+	}
+}
+
+// TestRunCommandOnce checks that a command that isn't safe to repeat is
+// run once even when the connection fails, while RunCommand retries it.
+func TestRunCommandOnce(t *testing.T) {
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		"ssh": {Output: "dbclient: Connection to root@192.168.7.2:22 exited: Connection to 192.168.7.2 port 22 failed: Connection refused", Err: commandtest.ExitStatus(1)},
+	}}
+	i := &Interface{ip: "192.168.7.2", runner: r, log: logging.For("dbc")}
+	i.enabled.Store(true)
+	i.SetRetryPolicy(3, time.Millisecond)
+
+	if _, err := i.RunCommandOnce(context.Background(), "rpm -Uvh x.rpm"); !errors.Is(err, errTransport) {
+		t.Errorf("RunCommandOnce = %v, want a link failure", err)
+	}
+	if n := len(r.Calls()); n != 1 {
+		t.Errorf("RunCommandOnce ran %d times, want 1", n)
+	}
+	if _, err := i.RunCommand(context.Background(), "cat /etc/os-release"); err == nil {
+		t.Error("RunCommand succeeded, want an error")
+	}
+	if n := len(r.Calls()); n != 4 {
+		t.Errorf("%d calls in all, want 1 + 3", n)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	linkErr := commandError("failed to copy file", commandtest.ExitStatus(1), []byte("ssh: connect to host 192.168.7.2 port 22: Connection refused\r\nlost connection\n"))
+	remoteErr := commandError("failed to run command", commandtest.ExitStatus(2), []byte("false"))
+	cases := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"first try", []error{nil}, 1, nil},
+		{"recovers", []error{linkErr, linkErr, nil}, 3, nil},
+		{"gives up", []error{linkErr, linkErr, linkErr, nil}, 3, errTransport},
+		{"remote failure not retried", []error{remoteErr, nil}, 1, remoteErr},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			i := &Interface{}
+			i.SetRetryPolicy(3, time.Millisecond)
+			calls := 0
+			err := i.withRetry(context.Background(), "test", func() error {
+				err := c.errs[calls]
+				calls++
+				return err
+			})
+			if calls != c.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, c.wantCalls)
+			}
+			if !errors.Is(err, c.wantErr) && err != c.wantErr {
+				t.Errorf("err = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testing.T) {
+	i := &Interface{}
+	i.SetRetryPolicy(5, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := i.withRetry(ctx, "test", func() error {
+		calls++
+		cancel()
+		return commandError("failed to copy file", commandtest.ExitStatus(1), []byte("Connection reset by 192.168.7.2 port 22\r\nlost connection\n"))
+	})
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if !errors.Is(err, errTransport) {
+		t.Errorf("err = %v, want a link failure", err)
+	}
+}
@@ -0,0 +1,90 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SetRsync makes SyncFile update a file that already exists on the DBC
+// with rsync, which only sends the parts that changed. It needs rsync on
+// both ends; without it on the DBC, SyncFile sends the file whole.
+func (i *Interface) SetRsync(enabled bool) {
+	i.rsync = enabled
+}
+
+// RsyncEnabled reports whether SetRsync is on, so callers know SyncFile
+// may leave the file it replaces in place until the new one is complete.
+func (i *Interface) RsyncEnabled() bool {
+	return i.rsync
+}
+
+// SyncFile sends localPath to remotePath on the DBC. With SetRsync on and
+// the file already on the DBC it runs rsync and checks the result with
+// the SetChecksumAlgorithm hash; otherwise, or if that fails, the file is
+// removed from the DBC and sent whole with TransferFile. Removing it first means a hard link
+// to the old file, e.g. a backup, keeps the old content, as it does with
+// rsync, which builds the new file beside the old one.
+func (i *Interface) SyncFile(ctx context.Context, localPath, remotePath string, progressCb ProgressFunc) error {
+	if !i.rsync || i.dryRun {
+		return i.TransferFile(ctx, localPath, remotePath, progressCb)
+	}
+	if !i.enabled.Load() {
+		return fmt.Errorf("DBC interface not enabled")
+	}
+
+	name := filepath.Base(localPath)
+	if i.canRsync(ctx, remotePath) {
+		err := i.rsyncFile(ctx, localPath, remotePath)
+		if err == nil {
+			if st, err := os.Stat(localPath); err == nil {
+				i.progressFor(name, progressCb)(st.Size(), st.Size())
+			}
+			i.log.Infof("Synced %s to DBC at %s", localPath, remotePath)
+			return nil
+		}
+		if errors.Is(err, ErrDBCDiskFull) {
+			return err
+		}
+		i.log.Warnf("rsync of %s failed, sending it whole: %v", name, err)
+	}
+
+	if _, err := i.RunCommand(ctx, "rm -f "+ShellQuote(remotePath)); err != nil {
+		return fmt.Errorf("failed to remove %s before sending it: %w", remotePath, err)
+	}
+	return i.TransferFile(ctx, localPath, remotePath, progressCb)
+}
+
+// canRsync reports whether the DBC has rsync and a file at remotePath
+// for it to work from; for a new file a plain transfer is faster.
+func (i *Interface) canRsync(ctx context.Context, remotePath string) bool {
+	out, err := i.RunCommand(ctx, "command -v rsync >/dev/null && [ -f "+ShellQuote(remotePath)+" ] && echo rsync")
+	return err == nil && out == "rsync"
+}
+
+func (i *Interface) rsyncFile(ctx context.Context, localPath, remotePath string) error {
+	err := i.withRetry(ctx, "sync of "+filepath.Base(localPath), func() error {
+		_, err := i.runBounded(ctx, i.copyTimeout, "failed to rsync file", "rsync",
+			i.rsyncArgs(localPath, remotePath)...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return i.compareChecksum(ctx, localPath, remotePath)
+}
+
+// rsyncArgs returns the rsync arguments that update remotePath on the
+// DBC from localPath. -s hands the remote path over as is rather than
+// through the DBC's shell, and the ssh options are those of sshArgs.
+func (i *Interface) rsyncArgs(localPath, remotePath string) []string {
+	rsh := []string{"ssh"}
+	for _, opt := range i.sshOptions() {
+		rsh = append(rsh, ShellQuote(opt))
+	}
+	return []string{"-s", "--times", "-e", strings.Join(rsh, " "),
+		localPath, fmt.Sprintf("root@%s:%s", i.ip, remotePath)}
+}
@@ -0,0 +1,58 @@
+package dbc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+func TestRsyncArgs(t *testing.T) {
+	i := &Interface{ip: "192.168.7.2"}
+	got := i.rsyncArgs("/mnt/usb/maps/tiles.tar", "/data/valhalla/my tiles.tar")
+	want := []string{"-s", "--times", "-e", "ssh -y", "/mnt/usb/maps/tiles.tar", "root@192.168.7.2:/data/valhalla/my tiles.tar"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rsyncArgs = %q, want %q", got, want)
+	}
+}
+
+// TestSyncFileRsync checks that a file already on the DBC is updated with
+// rsync and its checksum compared afterwards.
+func TestSyncFileRsync(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "tiles.tar")
+	if err := os.WriteFile(local, []byte("tiles"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := checksum.SHA256.File(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := "/data/valhalla/tiles.tar"
+	probe := "ssh -y root@192.168.7.2 command -v rsync >/dev/null && [ -f " + remote + " ] && echo rsync"
+	hash := "ssh -y root@192.168.7.2 sha256sum " + remote
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		probe: {Output: "rsync\n"},
+		hash:  {Output: sum + "  " + remote + "\n"},
+	}}
+	i := &Interface{ip: "192.168.7.2", runner: r, retryAttempts: 1, log: logging.For("dbc")}
+	i.enabled.Store(true)
+	i.SetRsync(true)
+
+	var done [2]int64
+	if err := i.SyncFile(context.Background(), local, remote, func(sent, total int64) { done = [2]int64{sent, total} }); err != nil {
+		t.Fatalf("SyncFile: %v", err)
+	}
+	calls := r.Calls()
+	if len(calls) != 3 || !strings.HasPrefix(calls[1], "rsync ") || !strings.HasSuffix(calls[2], "sha256sum "+remote) {
+		t.Errorf("commands = %q, want the rsync check, rsync, then sha256sum", calls)
+	}
+	if done != [2]int64{5, 5} {
+		t.Errorf("progress = %v, want 5/5", done)
+	}
+}
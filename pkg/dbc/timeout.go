@@ -0,0 +1,58 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultCopyTimeout    = 120 * time.Second
+	defaultCommandTimeout = 30 * time.Second
+)
+
+// SetTimeouts sets how long a single copy (CopyFile, FetchFile,
+// DownloadFile) or command (RunCommand) may take when the caller's
+// context has no deadline of its own. Callers that set one, like the
+// per-file transfer timeouts, keep it: a map upload can legitimately
+// outlast these defaults.
+func (i *Interface) SetTimeouts(copyTimeout, commandTimeout time.Duration) {
+	i.copyTimeout = copyTimeout
+	i.commandTimeout = commandTimeout
+}
+
+// withDefaultTimeout bounds ctx by timeout unless it already has a
+// deadline.
+func withDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runBounded runs name with args under ctx, bounded by timeout as
+// withDefaultTimeout does. A process still running when the context ends
+// is killed, and the error says it timed out rather than showing the
+// kill signal; a timeout counts as a link failure. In a dry run it is
+// only logged.
+func (i *Interface) runBounded(ctx context.Context, timeout time.Duration, op, name string, args ...string) ([]byte, error) {
+	if i.dryRun {
+		i.log.WouldRun(name, args...)
+		return nil, nil
+	}
+	ctx, cancel := withDefaultTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := i.runner.Run(ctx, name, args...)
+	if err == nil {
+		return output, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return output, fmt.Errorf("%s: timed out: %w: %w", op, errTransport, ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+	return output, commandError(op, err, output)
+}
@@ -0,0 +1,46 @@
+package dbc
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command"
+)
+
+func TestWithDefaultTimeout(t *testing.T) {
+	ctx, cancel := withDefaultTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("no-deadline context: deadline = %v, %v; want about a minute", deadline, ok)
+	}
+
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	ctx, cancel = withDefaultTimeout(parent, time.Minute)
+	defer cancel()
+	want, _ := parent.Deadline()
+	if got, _ := ctx.Deadline(); !got.Equal(want) {
+		t.Errorf("caller's deadline replaced: %v, want %v", got, want)
+	}
+}
+
+func TestRunBoundedKillsOnTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	start := time.Now()
+	_, err := (&Interface{runner: command.Exec{}}).runBounded(context.Background(), 50*time.Millisecond, "failed to run command", "sleep", "10")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if !errors.Is(err, errTransport) {
+		t.Errorf("err = %v, want a timeout to count as a link failure", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s, process not killed", elapsed)
+	}
+}
@@ -0,0 +1,39 @@
+package dbc
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetVerifyCommand configures the shell command Verify runs on the DBC,
+// e.g. a script that checks the routing engine loaded new tiles. Empty
+// disables verification.
+func (i *Interface) SetVerifyCommand(cmd string) {
+	i.verifyCommand = cmd
+}
+
+// VerifyConfigured reports whether Verify runs anything, so callers know
+// whether to keep what they need for a rollback.
+func (i *Interface) VerifyConfigured() bool {
+	return i.verifyCommand != ""
+}
+
+// Verify runs the configured verification command on the DBC after kind
+// ("mbtiles", "tiles" or "update") was installed at remotePath. The
+// command sees both as UMS_VERIFY_KIND and UMS_VERIFY_PATH; a non-zero
+// exit is an error. A no-op without a command.
+func (i *Interface) Verify(ctx context.Context, kind, remotePath string) error {
+	if i.verifyCommand == "" {
+		return nil
+	}
+	out, err := i.RunCommand(ctx, verifyCommand(i.verifyCommand, kind, remotePath))
+	if err != nil {
+		return fmt.Errorf("verification of %s failed: %w", remotePath, err)
+	}
+	i.log.Infof("Verified %s on DBC: %s", remotePath, out)
+	return nil
+}
+
+func verifyCommand(cmd, kind, remotePath string) string {
+	return fmt.Sprintf("export UMS_VERIFY_KIND=%s UMS_VERIFY_PATH=%s; %s", ShellQuote(kind), ShellQuote(remotePath), cmd)
+}
@@ -0,0 +1,33 @@
+package dbc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	got := verifyCommand("/usr/bin/check-tiles && echo ok", "tiles", "/data/valhalla/tiles.tar")
+	want := `export UMS_VERIFY_KIND=tiles UMS_VERIFY_PATH=/data/valhalla/tiles.tar; /usr/bin/check-tiles && echo ok`
+	if got != want {
+		t.Errorf("verifyCommand = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyWithoutCommand(t *testing.T) {
+	// Not enabled: any attempt to reach the DBC would fail.
+	i := &Interface{}
+	if i.VerifyConfigured() {
+		t.Error("VerifyConfigured with no command")
+	}
+	if err := i.Verify(context.Background(), "update", "/data/ota/dbc/a.mender"); err != nil {
+		t.Errorf("Verify without a command = %v", err)
+	}
+
+	i.SetVerifyCommand("true")
+	if !i.VerifyConfigured() {
+		t.Error("VerifyConfigured false after SetVerifyCommand")
+	}
+	if err := i.Verify(context.Background(), "update", "/data/ota/dbc/a.mender"); err == nil {
+		t.Error("Verify succeeded without reaching the DBC")
+	}
+}
@@ -1,6 +1,12 @@
+// Package diagnostics packs a snapshot of the scooter's state into a
+// bundle on the drive when UMS mode is entered, so a user handing the
+// scooter (or just the drive's contents) to support doesn't have to
+// gather anything by hand.
 package diagnostics
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"log"
@@ -10,46 +16,115 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/librescoot/ums-service/pkg/dbc"
 )
 
 const (
-	dbcIP             = "192.168.7.2"
-	dbcAddr           = dbcIP + ":22"
-	journalMaxAge     = "8 hours ago"
+	journalMaxAge     = "-1h"
 	dbcCommandTimeout = 30 * time.Second
 )
 
-type Collector struct{}
+type Collector struct {
+	dbcHost       string
+	settingsPath  string
+	osReleasePath string
+	now           func() time.Time
+	run           func(name string, args ...string) ([]byte, error)
+	dbcReachable  func() bool
+	runDBC        func(command string) (string, error)
+	dbc           dbcTarget
+}
+
+func New(dbcInterface *dbc.Interface, settingsPath string) *Collector {
+	c := &Collector{
+		dbc:           dbcInterface,
+		dbcHost:       "192.168.7.2",
+		settingsPath:  settingsPath,
+		osReleasePath: "/etc/os-release",
+		now:           time.Now,
+		run:           runCommand,
+	}
+	c.dbcReachable = c.reachDBC
+	c.runDBC = c.runDBCCommand
+	return c
+}
+
+// SetDBCHost sets the DBC's address, if not the default 192.168.7.2.
+func (c *Collector) SetDBCHost(host string) {
+	c.dbcHost = host
+}
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// bundle is a diagnostics archive being written.
+type bundle struct {
+	tw *tar.Writer
+	at time.Time
+}
 
-func New() *Collector {
-	return &Collector{}
+func (b *bundle) add(name string, data []byte) {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: b.at}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		log.Printf("Failed to add %s to diagnostics bundle: %v", name, err)
+		return
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		log.Printf("Failed to add %s to diagnostics bundle: %v", name, err)
+	}
 }
 
+// CollectToUSB writes diagnostics/<timestamp>.tar.gz to the drive with
+// the MDB's recent journal, dmesg, system info, settings.toml and
+// installed versions, plus the DBC's journal, dmesg and system info if
+// it answers. It is best effort: whatever can't be collected is noted in
+// the bundle or logged, and a bundle that can't be written at all is
+// removed again.
 func (c *Collector) CollectToUSB(mountPoint string) {
-	mdbDir := filepath.Join(mountPoint, "diagnostics", "mdb")
-	if err := os.MkdirAll(mdbDir, 0755); err != nil {
-		log.Printf("Failed to create MDB diagnostics directory: %v", err)
+	dir := filepath.Join(mountPoint, "diagnostics")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create diagnostics directory: %v", err)
 		return
 	}
+	at := c.now()
+	path := filepath.Join(dir, at.Format("20060102-150405")+".tar.gz")
+	if err := c.writeBundle(path, at); err != nil {
+		log.Printf("Failed to write diagnostics bundle: %v", err)
+		os.Remove(path)
+		return
+	}
+	log.Printf("Diagnostics written to %s", filepath.Join("diagnostics", filepath.Base(path)))
+}
 
-	c.collectMDB(mdbDir)
+func (c *Collector) writeBundle(path string, at time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	b := &bundle{tw: tar.NewWriter(gz), at: at}
 
+	c.collectMDB(b)
 	if c.dbcReachable() {
-		dbcDir := filepath.Join(mountPoint, "diagnostics", "dbc")
-		if err := os.MkdirAll(dbcDir, 0755); err != nil {
-			log.Printf("Failed to create DBC diagnostics directory: %v", err)
-			return
-		}
-		c.collectDBC(dbcDir)
+		c.collectDBC(b)
 	} else {
 		log.Println("DBC not reachable, skipping DBC diagnostics")
 	}
 
-	log.Println("Diagnostics collection complete")
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
 }
 
-func (c *Collector) dbcReachable() bool {
-	conn, err := net.DialTimeout("tcp", dbcAddr, 2*time.Second)
+func (c *Collector) reachDBC() bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:22", c.dbcHost), 2*time.Second)
 	if err != nil {
 		return false
 	}
@@ -57,16 +132,22 @@ func (c *Collector) dbcReachable() bool {
 	return true
 }
 
-func (c *Collector) collectMDB(dir string) {
-	writeCommandOutput(dir, "journal.log", "journalctl", "--no-pager", "--since", journalMaxAge)
-	writeCommandOutput(dir, "dmesg.log", "dmesg")
-	c.writeMDBSystemInfo(dir)
+func (c *Collector) collectMDB(b *bundle) {
+	b.add("mdb/journal.log", c.commandOutput("journal.log", "journalctl", "--no-pager", "--since", journalMaxAge))
+	b.add("mdb/dmesg.log", c.commandOutput("dmesg.log", "dmesg"))
+	b.add("mdb/system-info.txt", c.mdbSystemInfo())
+	b.add("mdb/versions.txt", c.versions())
+	if data, err := os.ReadFile(c.settingsPath); err == nil {
+		b.add("mdb/settings.toml", data)
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to read %s for diagnostics: %v", c.settingsPath, err)
+	}
 }
 
-func (c *Collector) collectDBC(dir string) {
-	c.writeDBCCommand(dir, "journal.log", fmt.Sprintf("journalctl --no-pager --since '%s'", journalMaxAge))
-	c.writeDBCCommand(dir, "dmesg.log", "dmesg")
-	c.writeDBCSystemInfo(dir)
+func (c *Collector) collectDBC(b *bundle) {
+	c.addDBCCommand(b, "journal.log", fmt.Sprintf("journalctl --no-pager --since '%s'", journalMaxAge))
+	c.addDBCCommand(b, "dmesg.log", "dmesg")
+	c.addDBCCommand(b, "system-info.txt", `printf '=== uptime ===\n'; uptime; printf '\n=== disk usage ===\n'; df -h; printf '\n=== memory ===\n'; free -m; printf '\n=== installed packages ===\n'; rpm -qa --last 2>/dev/null | head -50`)
 }
 
 func (c *Collector) runDBCCommand(command string) (string, error) {
@@ -75,7 +156,7 @@ func (c *Collector) runDBCCommand(command string) (string, error) {
 
 	cmd := exec.CommandContext(ctx,
 		"ssh", "-y",
-		fmt.Sprintf("root@%s", dbcIP),
+		fmt.Sprintf("root@%s", c.dbcHost),
 		command)
 	output, err := cmd.CombinedOutput()
 	if ctx.Err() == context.DeadlineExceeded {
@@ -87,30 +168,16 @@ func (c *Collector) runDBCCommand(command string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func (c *Collector) writeDBCCommand(dir, filename, command string) {
-	output, err := c.runDBCCommand(command)
+func (c *Collector) addDBCCommand(b *bundle, filename, command string) {
+	output, err := c.runDBC(command)
 	if err != nil {
 		log.Printf("Failed to collect DBC %s: %v", filename, err)
 		return
 	}
-	if err := os.WriteFile(filepath.Join(dir, filename), []byte(output), 0644); err != nil {
-		log.Printf("Failed to write DBC %s: %v", filename, err)
-	}
+	b.add("dbc/"+filename, []byte(output))
 }
 
-func (c *Collector) writeDBCSystemInfo(dir string) {
-	cmd := `printf '=== uptime ===\n'; uptime; printf '\n=== disk usage ===\n'; df -h; printf '\n=== memory ===\n'; free -m; printf '\n=== installed packages ===\n'; rpm -qa --last 2>/dev/null | head -50`
-	output, err := c.runDBCCommand(cmd)
-	if err != nil {
-		log.Printf("Failed to collect DBC system info: %v", err)
-		return
-	}
-	if err := os.WriteFile(filepath.Join(dir, "system-info.txt"), []byte(output), 0644); err != nil {
-		log.Printf("Failed to write DBC system-info.txt: %v", err)
-	}
-}
-
-func (c *Collector) writeMDBSystemInfo(dir string) {
+func (c *Collector) mdbSystemInfo() []byte {
 	sections := []struct {
 		header string
 		name   string
@@ -124,8 +191,7 @@ func (c *Collector) writeMDBSystemInfo(dir string) {
 
 	var content string
 	for _, s := range sections {
-		cmd := exec.Command(s.name, s.args...)
-		output, err := cmd.CombinedOutput()
+		output, err := c.run(s.name, s.args...)
 		if err != nil {
 			content += fmt.Sprintf("=== %s ===\nERROR: %v\n\n", s.header, err)
 			continue
@@ -136,22 +202,31 @@ func (c *Collector) writeMDBSystemInfo(dir string) {
 		}
 		content += fmt.Sprintf("=== %s ===\n%s\n", s.header, out)
 	}
+	return []byte(content)
+}
 
-	if err := os.WriteFile(filepath.Join(dir, "system-info.txt"), []byte(content), 0644); err != nil {
-		log.Printf("Failed to write system-info.txt: %v", err)
+// versions summarizes what is installed: the OS release (image name and
+// version) and the running kernel. Packages are in system-info.txt.
+func (c *Collector) versions() []byte {
+	var content string
+	if data, err := os.ReadFile(c.osReleasePath); err == nil {
+		content += fmt.Sprintf("=== os-release ===\n%s\n", strings.TrimRight(string(data), "\n"))
+	}
+	if out, err := c.run("uname", "-a"); err == nil {
+		content += fmt.Sprintf("=== kernel ===\n%s", out)
 	}
+	return []byte(content)
 }
 
-func writeCommandOutput(dir, filename string, name string, args ...string) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
+// commandOutput returns what the command printed, or the error and
+// whatever output there was if it failed.
+func (c *Collector) commandOutput(filename string, name string, args ...string) []byte {
+	output, err := c.run(name, args...)
 	if err != nil {
 		log.Printf("Failed to collect %s: %v", filename, err)
 		output = []byte(fmt.Sprintf("ERROR: %v\n%s", err, string(output)))
 	}
-	if err := os.WriteFile(filepath.Join(dir, filename), output, 0644); err != nil {
-		log.Printf("Failed to write %s: %v", filename, err)
-	}
+	return output
 }
 
 func truncateLines(s string, max int) string {
@@ -0,0 +1,113 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestCollectToUSB(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings bool
+		dbc      bool
+		want     []string
+	}{
+		{
+			name:     "mdb and dbc",
+			settings: true,
+			dbc:      true,
+			want: []string{
+				"dbc/dmesg.log", "dbc/journal.log", "dbc/system-info.txt",
+				"mdb/dmesg.log", "mdb/journal.log", "mdb/settings.toml", "mdb/system-info.txt", "mdb/versions.txt",
+			},
+		},
+		{
+			name: "no settings, dbc unreachable",
+			want: []string{"mdb/dmesg.log", "mdb/journal.log", "mdb/system-info.txt", "mdb/versions.txt"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			mountPoint := t.TempDir()
+			col := New(nil, filepath.Join(dataDir, "settings.toml"))
+			col.osReleasePath = filepath.Join(dataDir, "os-release")
+			col.now = func() time.Time { return time.Date(2026, 5, 1, 12, 30, 0, 0, time.UTC) }
+			col.run = func(name string, args ...string) ([]byte, error) {
+				if name == "dmesg" {
+					return []byte("permission denied"), errors.New("exit status 1")
+				}
+				return []byte(name + " output\n"), nil
+			}
+			col.dbcReachable = func() bool { return c.dbc }
+			col.runDBC = func(command string) (string, error) { return "dbc " + command, nil }
+			if err := os.WriteFile(col.osReleasePath, []byte("VERSION_ID=1.2.3\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if c.settings {
+				if err := os.WriteFile(col.settingsPath, []byte("[scooter]\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			col.CollectToUSB(mountPoint)
+
+			files := readBundle(t, filepath.Join(mountPoint, "diagnostics", "20260501-123000.tar.gz"))
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, c.want) {
+				t.Errorf("bundle holds %q, want %q", names, c.want)
+			}
+			if got := files["mdb/journal.log"]; got != "journalctl output\n" {
+				t.Errorf("journal.log = %q", got)
+			}
+			if got := files["mdb/dmesg.log"]; !strings.HasPrefix(got, "ERROR: exit status 1") {
+				t.Errorf("dmesg.log = %q, want the failure recorded", got)
+			}
+			if got := files["mdb/versions.txt"]; !strings.Contains(got, "VERSION_ID=1.2.3") || !strings.Contains(got, "uname output") {
+				t.Errorf("versions.txt = %q, want os-release and kernel", got)
+			}
+		})
+	}
+}
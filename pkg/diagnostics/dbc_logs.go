@@ -0,0 +1,70 @@
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// dbcTarget is the part of dbc.Interface FetchDBCLogs uses.
+type dbcTarget interface {
+	IsEnabled() bool
+	RunCommand(ctx context.Context, command string) (string, error)
+	FetchFile(ctx context.Context, remotePath, localPath string) error
+}
+
+// dbcLogDir is where the DBC writes the logs before they are fetched.
+const dbcLogDir = "/tmp/ums-diagnostics"
+
+// dbcLogs are the files FetchDBCLogs writes on the DBC and copies back,
+// with the command that produces each.
+var dbcLogs = []struct {
+	name    string
+	command string
+}{
+	{"journal.log", fmt.Sprintf("journalctl --no-pager --since '%s'", journalMaxAge)},
+	{"dmesg.log", "dmesg"},
+}
+
+// FetchDBCLogs copies the DBC's recent journal and dmesg into
+// diagnostics/dbc/ on the drive, replacing what an earlier session left
+// there. The DBC is only powered while the drive is being processed, so
+// this is the one chance to get at them; the UMS-entry bundle usually
+// finds it off. It needs the DBC interface to be enabled and returns
+// what failed, having fetched whatever it could.
+func (c *Collector) FetchDBCLogs(ctx context.Context, mountPoint string) error {
+	if !c.dbc.IsEnabled() {
+		return fmt.Errorf("DBC interface not enabled")
+	}
+	dir := filepath.Join(mountPoint, "diagnostics", "dbc")
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	var errs []error
+	for _, l := range dbcLogs {
+		remote := dbcLogDir + "/" + l.name
+		cmd := fmt.Sprintf("mkdir -p %s && %s > %s 2>&1", dbcLogDir, l.command, remote)
+		if _, err := c.dbc.RunCommand(ctx, cmd); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", l.name, err))
+			continue
+		}
+		if err := c.dbc.FetchFile(ctx, remote, filepath.Join(dir, l.name)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", l.name, err))
+		}
+	}
+	if _, err := c.dbc.RunCommand(ctx, "rm -rf "+dbcLogDir); err != nil {
+		log.Printf("Warning: failed to remove %s on DBC: %v", dbcLogDir, err)
+	}
+
+	if len(errs) == len(dbcLogs) {
+		os.Remove(dir)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package diagnostics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDBC runs commands by recording them and fetches a remote file by
+// writing its name into the local one.
+type fakeDBC struct {
+	commands []string
+	failOn   string // fail commands containing this
+}
+
+func (f *fakeDBC) IsEnabled() bool { return true }
+
+func (f *fakeDBC) RunCommand(ctx context.Context, command string) (string, error) {
+	f.commands = append(f.commands, command)
+	if f.failOn != "" && strings.Contains(command, f.failOn) {
+		return "", errors.New("exit status 1")
+	}
+	return "", nil
+}
+
+func (f *fakeDBC) FetchFile(ctx context.Context, remotePath, localPath string) error {
+	return os.WriteFile(localPath, []byte(remotePath), 0644)
+}
+
+func TestFetchDBCLogs(t *testing.T) {
+	mountPoint := t.TempDir()
+	dir := filepath.Join(mountPoint, "diagnostics", "dbc")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stale.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeDBC{failOn: "dmesg"}
+	c := &Collector{dbc: fake}
+	err := c.FetchDBCLogs(context.Background(), mountPoint)
+	if err == nil || !strings.Contains(err.Error(), "dmesg.log") {
+		t.Errorf("err = %v, want the dmesg failure reported", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "journal.log")); err != nil || string(data) != dbcLogDir+"/journal.log" {
+		t.Errorf("journal.log = %q, %v; want it fetched", data, err)
+	}
+	for _, name := range []string{"dmesg.log", "stale.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s present, want it absent", name)
+		}
+	}
+	if last := fake.commands[len(fake.commands)-1]; last != "rm -rf "+dbcLogDir {
+		t.Errorf("last command = %q, want the DBC's copies removed", last)
+	}
+}
@@ -0,0 +1,64 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDriveBusy means the drive couldn't be unmounted cleanly because
+// something still has files on it open. It was detached lazily if it
+// could be, so the mount point is free again, but the filesystem itself
+// stays live until it is let go.
+var ErrDriveBusy = errors.New("drive is still in use")
+
+const (
+	// unmountAttempts is how often a busy drive is tried before it is
+	// detached lazily; the wait between tries starts at
+	// defaultUnmountBackoff and doubles.
+	unmountAttempts       = 3
+	defaultUnmountBackoff = 500 * time.Millisecond
+)
+
+// unmountRetrying unmounts mountPoint, retrying while it is busy and
+// detaching it lazily if it stays busy. lazy reports a lazy detach, in
+// which case err wraps ErrDriveBusy.
+func (m *Manager) unmountRetrying(mountPoint string) (lazy bool, err error) {
+	delay := m.unmountBackoff
+	for attempt := 1; ; attempt++ {
+		output, err := m.run("umount", mountPoint)
+		if err == nil {
+			return false, nil
+		}
+		if !isBusy(output) {
+			return false, fmt.Errorf("umount failed: %v, output: %s", err, string(output))
+		}
+		if attempt == unmountAttempts {
+			break
+		}
+		m.log.Infof("USB drive busy, retrying unmount in %s", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	output, err := m.run("umount", "-l", mountPoint)
+	if err != nil {
+		return false, fmt.Errorf("%w: umount -l failed: %v, output: %s", ErrDriveBusy, err, string(output))
+	}
+	m.log.Warnf("USB drive still busy, detached it lazily from %s", mountPoint)
+	return true, fmt.Errorf("%w: detached lazily", ErrDriveBusy)
+}
+
+// busyMessages are how umount and the kernel word EBUSY.
+var busyMessages = []string{"target is busy", "device is busy", "resource busy"}
+
+func isBusy(output []byte) bool {
+	out := strings.ToLower(string(output))
+	for _, msg := range busyMessages {
+		if strings.Contains(out, msg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package disk
+
+import "os"
+
+// CheckResult is the outcome of the drive check Initialize runs.
+type CheckResult string
+
+const (
+	// CheckSkipped: the check is disabled, the image was just created,
+	// or it may be in use (exported to the host, or a stale mount that
+	// couldn't be cleared).
+	CheckSkipped CheckResult = "skipped"
+	// CheckClean: the filesystem had no errors.
+	CheckClean CheckResult = "clean"
+	// CheckRepaired: errors were found and repaired.
+	CheckRepaired CheckResult = "repaired"
+	// CheckRecreated: the filesystem was beyond repair, and the image
+	// was replaced with an empty one.
+	CheckRecreated CheckResult = "recreated"
+)
+
+// Recovered reports whether the check had to repair or recreate the
+// image.
+func (r CheckResult) Recovered() bool {
+	return r == CheckRepaired || r == CheckRecreated
+}
+
+// SetStartupCheck sets whether Initialize checks and repairs an existing
+// image. On by default; off saves the time a check takes on boot.
+func (m *Manager) SetStartupCheck(enabled bool) {
+	m.startupCheck = enabled
+}
+
+// StartupCheck returns the outcome of Initialize's drive check.
+func (m *Manager) StartupCheck() CheckResult {
+	return m.checkResult
+}
+
+// repairDrive runs the filesystem's repair on the image. Whether anything
+// was wrong is up to a read-only check afterwards, as the tools' exit
+// codes differ; an image still inconsistent then is recreated empty.
+func (m *Manager) repairDrive() (CheckResult, error) {
+	args := m.driveFS.repairCommand(m.driveFile)
+	output, err := m.run(args[0], args[1:]...)
+	if err == nil {
+		return CheckClean, nil
+	}
+	m.log.Warnf("%s found problems on %s: %v, output: %s", args[0], m.driveFile, err, string(output))
+	if err := m.checkFilesystem(); err != nil {
+		m.log.Warnf("Drive image is beyond repair (%v), recreating it", err)
+		os.Remove(m.driveFile)
+		if err := m.createAndFormatDrive(); err != nil {
+			return CheckRecreated, err
+		}
+		return CheckRecreated, nil
+	}
+	m.log.Warnf("Drive image repaired")
+	return CheckRepaired, nil
+}
@@ -0,0 +1,114 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+// allocating records commands like Recorder, but creates the file a
+// fallocate would, so a new image can be created.
+type allocating struct{ *commandtest.Recorder }
+
+func (r allocating) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := r.Recorder.Run(ctx, name, args...)
+	if name == "fallocate" && err == nil {
+		size, _ := strconv.ParseInt(args[1], 10, 64)
+		f, err := os.Create(args[2])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return out, f.Truncate(size)
+	}
+	return out, err
+}
+
+func TestStartupCheck(t *testing.T) {
+	failed := commandtest.Reply{Output: "errors found", Err: errors.New("exit status 1")}
+	cases := []struct {
+		name      string
+		noImage   bool
+		disabled  bool
+		exported  bool
+		replies   map[string]commandtest.Reply
+		want      CheckResult
+		wantCalls []string
+	}{
+		{name: "clean", want: CheckClean, wantCalls: []string{"fsck.fat -a"}},
+		{
+			name:      "repaired",
+			replies:   map[string]commandtest.Reply{"fsck.fat -a": failed},
+			want:      CheckRepaired,
+			wantCalls: []string{"fsck.fat -a", "fsck.fat -n"},
+		},
+		{
+			name:      "beyond repair",
+			replies:   map[string]commandtest.Reply{"fsck.fat -a": failed, "fsck.fat -n": failed},
+			want:      CheckRecreated,
+			wantCalls: []string{"fsck.fat -a", "fsck.fat -n", "fallocate", "mkfs.fat"},
+		},
+		{name: "new image", noImage: true, want: CheckSkipped, wantCalls: []string{"fallocate", "mkfs.fat"}},
+		{name: "disabled", disabled: true, want: CheckSkipped},
+		{name: "exported", exported: true, want: CheckSkipped},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "usb.drive")
+			if !c.noImage {
+				if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mounts := filepath.Join(dir, "mounts")
+			if err := os.WriteFile(mounts, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Replies are keyed by the command without the image path.
+			replies := map[string]commandtest.Reply{}
+			for line, reply := range c.replies {
+				replies[line+" "+path] = reply
+			}
+			r := &commandtest.Recorder{Replies: replies}
+			m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, allocating{r})
+			m.mountsPath = mounts
+			m.SetStartupCheck(!c.disabled)
+			m.SetExported(c.exported)
+
+			if err := m.Initialize(); err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+			if got := m.StartupCheck(); got != c.want {
+				t.Errorf("StartupCheck() = %q, want %q", got, c.want)
+			}
+			var progs []string
+			for _, call := range r.Calls() {
+				progs = append(progs, trimArgs(call))
+			}
+			if !reflect.DeepEqual(progs, c.wantCalls) {
+				t.Errorf("commands = %q, want %q", r.Calls(), c.wantCalls)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("image missing after Initialize: %v", err)
+			}
+		})
+	}
+}
+
+// trimArgs shortens a recorded command line to the program and, for
+// fsck, its mode flag.
+func trimArgs(call string) string {
+	f := strings.Fields(call)
+	if strings.HasPrefix(f[0], "fsck") {
+		return f[0] + " " + f[1]
+	}
+	return f[0]
+}
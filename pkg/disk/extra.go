@@ -0,0 +1,34 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/librescoot/ums-service/pkg/fsutil"
+)
+
+// SetExtraImages sets images presented to the host beside the drive.
+// Initialize creates any that are missing; their contents are otherwise
+// left alone: the drive is the only image mounted, prepared and cleaned.
+func (m *Manager) SetExtraImages(paths []string) {
+	m.extraImages = paths
+}
+
+// ensureExtraImages creates each missing extra image empty, the same
+// size and filesystem as a new drive, for the user to fill.
+func (m *Manager) ensureExtraImages() error {
+	for _, path := range m.extraImages {
+		if err := fsutil.CheckFile(path); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		os.Remove(path + tmpSuffix)
+		m.log.Infof("Creating extra drive image at %s (%s)", path, m.filesystem)
+		if err := m.createImage(path); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package disk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+// touchingRunner records commands like commandtest.Recorder, and creates
+// the file fallocate is asked for.
+type touchingRunner struct{ commandtest.Recorder }
+
+func (r *touchingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == "fallocate" {
+		if err := os.WriteFile(args[len(args)-1], nil, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return r.Recorder.Run(ctx, name, args...)
+}
+
+func TestEnsureExtraImages(t *testing.T) {
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs.img")
+	uploads := filepath.Join(dir, "uploads.img")
+	if err := os.WriteFile(docs, []byte("firmware and manuals"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &touchingRunner{}
+	m := NewManager(filepath.Join(dir, "usb.drive"), filepath.Join(dir, "mnt"), minDriveSize, r)
+	m.SetExtraImages([]string{docs, uploads})
+
+	if err := m.ensureExtraImages(); err != nil {
+		t.Fatalf("ensureExtraImages: %v", err)
+	}
+	if data, err := os.ReadFile(docs); err != nil || string(data) != "firmware and manuals" {
+		t.Errorf("existing image = %q, %v; want it untouched", data, err)
+	}
+	if _, err := os.Stat(uploads); err != nil {
+		t.Errorf("missing image not created: %v", err)
+	}
+	for _, call := range r.Calls() {
+		if strings.Contains(call, docs) {
+			t.Errorf("ran %q on the existing image", call)
+		}
+	}
+	if fs, err := readFSMarker(uploads); err != nil || fs != FAT32 {
+		t.Errorf("new image filesystem = %v, %v", fs, err)
+	}
+
+	// A directory where an image should be is refused.
+	m.SetExtraImages([]string{dir})
+	if err := m.ensureExtraImages(); err == nil {
+		t.Error("ensureExtraImages accepted a directory")
+	}
+}
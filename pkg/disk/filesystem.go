@@ -0,0 +1,102 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Filesystem is what the drive image is formatted with. The gadget
+// exposes the raw image as a block device, so the host has to be able to
+// read the filesystem itself:
+//
+//   - FAT32 ("vfat"): every host, including Windows, macOS, Linux,
+//     Android and iOS. Files are capped at 4 GiB, which large tiles.tar
+//     bundles exceed.
+//   - ExFAT ("exfat"): Windows 7 and later (Vista SP1 with an update),
+//     macOS 10.6.5 and later, Linux 5.4 and later (older kernels need
+//     exfat-fuse), most Android devices. No practical file size limit.
+//     The MDB kernel needs exfat support to mount it.
+//   - Ext4 ("ext4"): Linux hosts only; Windows and macOS need third-party
+//     drivers. No practical file size limit.
+type Filesystem string
+
+const (
+	FAT32 Filesystem = "vfat"
+	ExFAT Filesystem = "exfat"
+	Ext4  Filesystem = "ext4"
+)
+
+// fsMarkerSuffix names the file next to the image that records which
+// filesystem it was formatted with.
+const fsMarkerSuffix = ".fs"
+
+// ParseFilesystem validates a filesystem name; "fat32" is accepted for
+// vfat and empty means FAT32.
+func ParseFilesystem(name string) (Filesystem, error) {
+	switch fs := Filesystem(strings.ToLower(strings.TrimSpace(name))); fs {
+	case "", "fat32":
+		return FAT32, nil
+	case FAT32, ExFAT, Ext4:
+		return fs, nil
+	default:
+		return "", fmt.Errorf("unsupported filesystem %q (want vfat, exfat or ext4)", name)
+	}
+}
+
+// mkfsCommand returns the command that formats the image at path.
+func (fs Filesystem) mkfsCommand(path string) []string {
+	switch fs {
+	case ExFAT:
+		return []string{"mkfs.exfat", path}
+	case Ext4:
+		// -F: the image is a regular file, not a block device.
+		return []string{"mkfs.ext4", "-F", "-q", path}
+	default:
+		return []string{"mkfs.fat", "-F", "32", path}
+	}
+}
+
+// fsckCommand returns a read-only check of the image at path.
+func (fs Filesystem) fsckCommand(path string) []string {
+	switch fs {
+	case ExFAT:
+		return []string{"fsck.exfat", "-n", path}
+	case Ext4:
+		return []string{"e2fsck", "-n", path}
+	default:
+		return []string{"fsck.fat", "-n", path}
+	}
+}
+
+// repairCommand returns a check of the image at path that repairs
+// whatever it can without asking.
+func (fs Filesystem) repairCommand(path string) []string {
+	switch fs {
+	case ExFAT:
+		return []string{"fsck.exfat", "-p", path}
+	case Ext4:
+		return []string{"e2fsck", "-p", path}
+	default:
+		return []string{"fsck.fat", "-a", path}
+	}
+}
+
+// readFSMarker returns the filesystem recorded for the image at
+// driveFile. Images from before the marker existed are FAT32, as are
+// those with an unreadable marker, which is also reported.
+func readFSMarker(driveFile string) (Filesystem, error) {
+	data, err := os.ReadFile(driveFile + fsMarkerSuffix)
+	if err != nil {
+		return FAT32, nil
+	}
+	fs, err := ParseFilesystem(string(data))
+	if err != nil {
+		return FAT32, fmt.Errorf("%s%s: %w", driveFile, fsMarkerSuffix, err)
+	}
+	return fs, nil
+}
+
+func writeFSMarker(driveFile string, fs Filesystem) error {
+	return os.WriteFile(driveFile+fsMarkerSuffix, []byte(string(fs)+"\n"), 0644)
+}
@@ -0,0 +1,92 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestParseFilesystem(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Filesystem
+		wantErr bool
+	}{
+		{in: "", want: FAT32},
+		{in: "vfat", want: FAT32},
+		{in: "FAT32", want: FAT32},
+		{in: "exfat", want: ExFAT},
+		{in: " ext4\n", want: Ext4},
+		{in: "ntfs", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilesystem(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFilesystem(%q) = %q, %v; want %q, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFilesystemCommands(t *testing.T) {
+	tests := []struct {
+		fs       Filesystem
+		wantMkfs []string
+		wantFsck []string
+	}{
+		{FAT32, []string{"mkfs.fat", "-F", "32", "img"}, []string{"fsck.fat", "-n", "img"}},
+		{ExFAT, []string{"mkfs.exfat", "img"}, []string{"fsck.exfat", "-n", "img"}},
+		{Ext4, []string{"mkfs.ext4", "-F", "-q", "img"}, []string{"e2fsck", "-n", "img"}},
+	}
+	for _, tt := range tests {
+		if got := tt.fs.mkfsCommand("img"); !reflect.DeepEqual(got, tt.wantMkfs) {
+			t.Errorf("%s mkfs = %q, want %q", tt.fs, got, tt.wantMkfs)
+		}
+		if got := tt.fs.fsckCommand("img"); !reflect.DeepEqual(got, tt.wantFsck) {
+			t.Errorf("%s fsck = %q, want %q", tt.fs, got, tt.wantFsck)
+		}
+	}
+}
+
+// TestEnsureDriveExistsKeepsFilesystem checks that an existing image is
+// used with the filesystem it was created with rather than the configured
+// one, so a changed setting doesn't get it fsck'd as the wrong type and
+// recreated.
+func TestEnsureDriveExistsKeepsFilesystem(t *testing.T) {
+	tests := []struct {
+		name   string
+		marker string
+		want   Filesystem
+	}{
+		{name: "recorded exfat", marker: "exfat\n", want: ExFAT},
+		{name: "no marker", want: FAT32},
+		{name: "bad marker", marker: "ntfs\n", want: FAT32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "usb.drive")
+			if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.marker != "" {
+				if err := os.WriteFile(path+fsMarkerSuffix, []byte(tt.marker), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := NewManager(path, filepath.Join(filepath.Dir(path), "mnt"), minDriveSize, &commandtest.Recorder{})
+			m.SetFilesystem(Ext4)
+
+			if err := m.Initialize(); err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+			if got := m.Filesystem(); got != tt.want {
+				t.Errorf("Filesystem() = %q, want %q", got, tt.want)
+			}
+			if data, _ := os.ReadFile(path); string(data) != "image" {
+				t.Error("existing image was rewritten")
+			}
+		})
+	}
+}
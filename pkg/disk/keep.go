@@ -0,0 +1,129 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SetKeepPatterns makes CleanDrive leave paths matching any of patterns
+// in place. Patterns are relative to the drive root, with / between
+// directories; each segment is a path.Match glob and ** matches any
+// number of directories, e.g. "README.txt", "backup/**" or
+// "wireguard/*.tmpl". A directory that matches is kept whole.
+func (m *Manager) SetKeepPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if err := checkKeepPattern(p); err != nil {
+			return err
+		}
+	}
+	m.keep = patterns
+	return nil
+}
+
+func checkKeepPattern(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("bad keep pattern %q: must be relative to the drive root", pattern)
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("bad keep pattern %q", pattern)
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("bad keep pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// keepMatch reports whether the drive path rel (slash-separated) matches
+// one of patterns.
+func keepMatch(patterns []string, rel string) bool {
+	name := strings.Split(rel, "/")
+	for _, p := range patterns {
+		if matchSegments(strings.Split(p, "/"), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// keepBelow reports whether one of patterns may match something inside
+// the directory dir, so it has to be looked into rather than removed.
+func keepBelow(patterns []string, dir string) bool {
+	name := strings.Split(dir, "/")
+	for _, p := range patterns {
+		pat := strings.Split(p, "/")
+		for i := 0; ; i++ {
+			if i == len(name) {
+				if i < len(pat) {
+					return true
+				}
+				break
+			}
+			if i == len(pat) {
+				break
+			}
+			if pat[i] == "**" {
+				return true
+			}
+			if ok, _ := path.Match(pat[i], name[i]); !ok {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// removeUnkept removes what is under the directory rel (relative to
+// root) except paths matching patterns, and the directory itself if
+// nothing in it was kept.
+func removeUnkept(root, rel string, patterns []string) error {
+	if keepMatch(patterns, rel) {
+		return nil
+	}
+	full := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Lstat(full)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || !keepBelow(patterns, rel) {
+		return os.RemoveAll(full)
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := removeUnkept(root, rel+"/"+e.Name(), patterns); err != nil {
+			return err
+		}
+	}
+	if entries, err := os.ReadDir(full); err == nil && len(entries) == 0 {
+		return os.Remove(full)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package disk
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is a file or directory on the drive.
+type Entry struct {
+	// Path is relative to the drive root, with / between directories.
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+	Dir     bool      `json:"dir,omitempty"`
+}
+
+// List returns what is on the drive, in lexical order. If the drive isn't
+// mounted it is mounted read-only for the duration, so listing never
+// changes it. Fails with ErrDriveExported while the drive is in UMS mode.
+func (m *Manager) List() ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exported {
+		return nil, ErrDriveExported
+	}
+	if !m.mounted {
+		if err := os.MkdirAll(m.mountPoint, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mount point: %w", err)
+		}
+		output, err := m.run("mount", "-o", "ro", "-t", string(m.driveFS), m.driveFile, m.mountPoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mount drive read-only: %v, output: %s", err, string(output))
+		}
+		m.mounted = true
+		defer func() {
+			if err := m.unmountLocked(); err != nil {
+				m.log.Errorf("Error unmounting USB drive after listing it: %v", err)
+			}
+		}()
+	}
+	return listTree(m.mountPoint)
+}
+
+// listTree walks root and returns an Entry for everything below it.
+func listTree(root string) ([]Entry, error) {
+	entries := []Entry{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		e := Entry{Path: filepath.ToSlash(rel), ModTime: info.ModTime(), Dir: d.IsDir()}
+		if !e.Dir {
+			e.Size = info.Size()
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,53 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usb.drive")
+	r := &commandtest.Recorder{}
+	m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, r)
+	if err := os.MkdirAll(filepath.Join(m.mountPoint, "mdb"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(m.mountPoint, "mdb", "update.mender"), []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetExported(true)
+	if _, err := m.List(); !errors.Is(err, ErrDriveExported) {
+		t.Errorf("List while exported = %v, want ErrDriveExported", err)
+	}
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("ran %q while exported", calls)
+	}
+
+	m.SetExported(false)
+	entries, err := m.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []Entry
+	for _, e := range entries {
+		got = append(got, Entry{Path: e.Path, Size: e.Size, Dir: e.Dir})
+	}
+	want := []Entry{{Path: "mdb", Dir: true}, {Path: "mdb/update.mender", Size: 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List = %+v, want %+v", got, want)
+	}
+	wantCalls := []string{"mount -o ro -t vfat " + path + " " + m.mountPoint, "umount " + m.mountPoint}
+	if calls := r.Calls(); !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("commands = %q, want %q", calls, wantCalls)
+	}
+	if m.IsMounted() {
+		t.Error("still mounted after List")
+	}
+}
@@ -1,58 +1,240 @@
 package disk
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/fsutil"
+	"github.com/librescoot/ums-service/pkg/logging"
 )
 
 const tmpSuffix = ".tmp"
 
+const (
+	// driveAlignment keeps the image a whole number of 512-byte sectors,
+	// the unit mkfs and the mass-storage gadget work in.
+	driveAlignment = 512
+	// ddBlockSize is the block size dd writes the image with.
+	ddBlockSize = 1024 * 1024
+	// minDriveSize is comfortably above the ~33 MiB FAT32 needs for its
+	// minimum cluster count with 512-byte sectors.
+	minDriveSize = 64 * 1024 * 1024
+)
+
 type Manager struct {
 	driveFile  string
 	driveSize  int64
 	mountPoint string
+	// mountsPath and sysBlock are /proc/mounts and /sys/block, where
+	// stale mounts of the drive are looked for.
+	mountsPath string
+	sysBlock   string
+	// filesystem is what new images are formatted with; driveFS is what
+	// the image on disk actually has, which may differ if the setting
+	// changed after it was created.
+	filesystem Filesystem
+	driveFS    Filesystem
+	probe      func(dir string) error
+	remount    func(mountPoint string) error
+	// runner runs fallocate (or dd), mkfs, fsck, mount and umount. In a
+	// dry run they are only logged, and the mount point directory stands
+	// in for the drive.
+	runner command.Runner
+	dryRun bool
+	// unmountBackoff is the first wait before retrying a busy unmount.
+	unmountBackoff time.Duration
+	// startupCheck makes Initialize check and repair an existing image;
+	// checkResult is what that found.
+	startupCheck bool
+	checkResult  CheckResult
+	// known, when set, lists the top-level entries the service manages;
+	// CleanDrive then leaves any other directory alone.
+	known map[string]bool
+	// keep lists glob patterns of paths CleanDrive leaves in place; see
+	// SetKeepPatterns.
+	keep []string
+	// extraImages are presented to the host beside the drive; see
+	// SetExtraImages.
+	extraImages []string
+
+	// mu guards mounting; mounted and exported track where the image
+	// is, so Usage knows whether it may mount it.
+	mu       sync.Mutex
+	mounted  bool
+	exported bool
+
+	log *logging.Logger
 }
 
-func NewManager(driveFile string, driveSize int64) *Manager {
-	return &Manager{
+// NewManager manages the drive image at driveFile, creating it with
+// driveSize bytes if missing and mounting it at mountPoint, and runs the
+// tools that format, check and mount it through runner.
+func NewManager(driveFile, mountPoint string, driveSize int64, runner command.Runner) *Manager {
+	m := &Manager{
 		driveFile:  driveFile,
 		driveSize:  driveSize,
-		mountPoint: "/mnt/usb-drive-temp",
+		mountPoint: mountPoint,
+		mountsPath: "/proc/mounts",
+		sysBlock:   "/sys/block",
+		filesystem: FAT32,
+		driveFS:    FAT32,
+		probe:      probeWritable,
+		runner:     runner,
+
+		unmountBackoff: defaultUnmountBackoff,
+		startupCheck:   true,
+		checkResult:    CheckSkipped,
+
+		log: logging.For("disk"),
+	}
+	m.remount = m.remountRW
+	if aligned, adjusted := alignDriveSize(driveSize); adjusted {
+		m.log.Warnf("drive size %d is not a whole number of sectors, using %d", driveSize, aligned)
+		m.driveSize = aligned
+	}
+	return m
+}
+
+// SetDryRun makes the manager log the fallocate, mkfs, fsck, mount and
+// umount calls it would make instead of running them. The mount point is
+// then a plain directory that stays in place between "mounts", so files
+// prepared for the host can be inspected and edited before switching
+// back.
+func (m *Manager) SetDryRun(dryRun bool) {
+	m.dryRun = dryRun
+}
+
+func (m *Manager) run(name string, args ...string) ([]byte, error) {
+	if m.dryRun {
+		m.log.WouldRun(name, args...)
+		return nil, nil
 	}
+	return m.runner.Run(context.Background(), name, args...)
 }
 
+// SetLogger sets the logger the manager writes to.
+func (m *Manager) SetLogger(logger *logging.Logger) {
+	m.log = logger
+}
+
+// alignDriveSize rounds size up to driveAlignment, raising it to
+// minDriveSize if smaller, and reports whether it changed.
+func alignDriveSize(size int64) (int64, bool) {
+	aligned := size
+	if aligned < minDriveSize {
+		aligned = minDriveSize
+	}
+	if rem := aligned % driveAlignment; rem != 0 {
+		aligned += driveAlignment - rem
+	}
+	return aligned, aligned != size
+}
+
+// SetFilesystem sets the filesystem new drive images are formatted
+// with. Call before Initialize.
+func (m *Manager) SetFilesystem(fs Filesystem) {
+	m.filesystem = fs
+}
+
+// Filesystem returns the filesystem of the drive image in use.
+func (m *Manager) Filesystem() Filesystem {
+	return m.driveFS
+}
+
+// Initialize creates the drive image if it is missing. An existing one is
+// checked and repaired first, unless that is turned off or the image may
+// be in use; StartupCheck tells what the check found.
 func (m *Manager) Initialize() error {
 	m.cleanupTempFile()
+	staleErr := m.clearStaleMounts()
+	if staleErr != nil {
+		m.log.Warnf("%v", staleErr)
+	}
 
+	_, statErr := os.Stat(m.driveFile)
+	existed := statErr == nil
 	if err := m.ensureDriveExists(); err != nil {
 		return fmt.Errorf("failed to ensure drive exists: %w", err)
 	}
+	if err := m.ensureExtraImages(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	exported := m.exported
+	m.mu.Unlock()
+	if !m.startupCheck || !existed || staleErr != nil || exported {
+		return nil
+	}
+	result, err := m.repairDrive()
+	m.checkResult = result
+	if err != nil {
+		return fmt.Errorf("failed to recreate drive after corruption: %w", err)
+	}
 	return nil
 }
 
 func (m *Manager) cleanupTempFile() {
 	tmpFile := m.driveFile + tmpSuffix
 	if _, err := os.Stat(tmpFile); err == nil {
-		log.Printf("Removing leftover temp drive file %s", tmpFile)
+		m.log.Infof("Removing leftover temp drive file %s", tmpFile)
 		os.Remove(tmpFile)
 	}
 }
 
 func (m *Manager) ensureDriveExists() error {
+	if err := fsutil.CheckFile(m.driveFile); err != nil {
+		return err
+	}
 	if _, err := os.Stat(m.driveFile); os.IsNotExist(err) {
 		return m.createAndFormatDrive()
 	}
+	// An existing image keeps the filesystem it was made with; checking
+	// or mounting it as anything else would fail and get it recreated.
+	fs, err := readFSMarker(m.driveFile)
+	if err != nil {
+		m.log.Warnf("%v, assuming vfat", err)
+	}
+	m.driveFS = fs
+	if m.driveFS != m.filesystem {
+		m.log.Warnf("%s is formatted as %s, not %s; keeping it (delete it to reformat)",
+			m.driveFile, m.driveFS, m.filesystem)
+	}
 	return nil
 }
 
 func (m *Manager) createAndFormatDrive() error {
-	log.Printf("Creating virtual USB drive at %s", m.driveFile)
-	tmpFile := m.driveFile + tmpSuffix
+	m.log.Infof("Creating virtual USB drive at %s (%s)", m.driveFile, m.filesystem)
+	if err := m.createImage(m.driveFile); err != nil {
+		return err
+	}
+	m.driveFS = m.filesystem
+	if !m.dryRun {
+		m.log.Infof("Virtual USB drive created successfully")
+	}
+	return nil
+}
+
+// createImage creates an empty image at path, formatted with the
+// configured filesystem. It is built next to path and renamed into
+// place, so an interrupted run leaves nothing half made at path.
+func (m *Manager) createImage(path string) error {
+	if m.dryRun {
+		m.run("fallocate", m.fallocateArgs(path)...)
+		mkfs := m.filesystem.mkfsCommand(path)
+		m.run(mkfs[0], mkfs[1:]...)
+		return nil
+	}
+	tmpFile := path + tmpSuffix
 
-	if err := os.MkdirAll(filepath.Dir(m.driveFile), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -66,52 +248,121 @@ func (m *Manager) createAndFormatDrive() error {
 		return fmt.Errorf("failed to format drive: %w", err)
 	}
 
-	if err := os.Rename(tmpFile, m.driveFile); err != nil {
+	// The marker goes first: an image without one is taken for FAT32.
+	if err := writeFSMarker(path, m.filesystem); err != nil {
 		os.Remove(tmpFile)
-		return fmt.Errorf("failed to move drive file into place: %w", err)
+		return fmt.Errorf("failed to record drive filesystem: %w", err)
 	}
 
-	log.Printf("Virtual USB drive created successfully")
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to move drive file into place: %w", err)
+	}
 	return nil
 }
 
+// createDriveFile creates an image of exactly m.driveSize bytes that
+// reads as zeros. fallocate reserves the blocks without writing them, so
+// it is instant and spares the eMMC a gigabyte of zeros, yet a full /data
+// still shows up here rather than mid-session. Where fallocate isn't
+// available (no binary, or a filesystem without support) the image is
+// zero-filled with dd instead.
 func (m *Manager) createDriveFile(path string) error {
-	cmd := exec.Command("dd", "if=/dev/zero", fmt.Sprintf("of=%s", path),
-		"bs=1M", fmt.Sprintf("count=%d", m.driveSize/(1024*1024)))
-	output, err := cmd.CombinedOutput()
+	output, err := m.run("fallocate", m.fallocateArgs(path)...)
+	if err == nil {
+		return nil
+	}
+	m.log.Warnf("fallocate failed (%v: %s), zero-filling with dd", err, strings.TrimSpace(string(output)))
+	os.Remove(path)
+	return m.zeroFill(path)
+}
+
+func (m *Manager) fallocateArgs(path string) []string {
+	return []string{"-l", strconv.FormatInt(m.driveSize, 10), path}
+}
+
+// zeroFill writes the image with dd, whole MiBs first; any remainder is
+// then written past them and the file truncated to the exact size.
+func (m *Manager) zeroFill(path string) error {
+	output, err := m.run("dd", m.ddArgs(path)...)
 	if err != nil {
 		return fmt.Errorf("dd failed: %v, output: %s", err, string(output))
 	}
+	whole := m.driveSize / ddBlockSize * ddBlockSize
+	if tail := m.driveSize - whole; tail > 0 {
+		if err := writeZeros(path, whole, tail); err != nil {
+			return fmt.Errorf("failed to size drive file: %w", err)
+		}
+	}
+	if err := os.Truncate(path, m.driveSize); err != nil {
+		return fmt.Errorf("failed to size drive file: %w", err)
+	}
 	return nil
 }
 
+func (m *Manager) ddArgs(path string) []string {
+	return []string{"if=/dev/zero", fmt.Sprintf("of=%s", path),
+		"bs=1M", fmt.Sprintf("count=%d", m.driveSize/ddBlockSize)}
+}
+
+// writeZeros writes n zero bytes to path at offset.
+func writeZeros(path string, offset, n int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(make([]byte, n), offset); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func (m *Manager) formatDrive(path string) error {
-	cmd := exec.Command("mkfs.fat", "-F", "32", path)
-	output, err := cmd.CombinedOutput()
+	args := m.filesystem.mkfsCommand(path)
+	output, err := m.run(args[0], args[1:]...)
 	if err != nil {
-		return fmt.Errorf("mkfs.fat failed: %v, output: %s", err, string(output))
+		return fmt.Errorf("%s failed: %v, output: %s", args[0], err, string(output))
 	}
 	return nil
 }
 
 func (m *Manager) checkFilesystem() error {
-	cmd := exec.Command("fsck.fat", "-n", m.driveFile)
-	output, err := cmd.CombinedOutput()
+	args := m.driveFS.fsckCommand(m.driveFile)
+	output, err := m.run(args[0], args[1:]...)
 	if err != nil {
-		return fmt.Errorf("fsck.fat failed: %v, output: %s", err, string(output))
+		return fmt.Errorf("%s failed: %v, output: %s", args[0], err, string(output))
 	}
 	return nil
 }
 
+// Mount checks the image, recreating it if it is beyond repair, and
+// mounts it at the mount point. Fails with ErrDriveExported while the
+// drive is in UMS mode.
 func (m *Manager) Mount() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exported {
+		return ErrDriveExported
+	}
+	// A mount left behind by a crash would make the mount below fail,
+	// and the check must not run on a mounted image.
+	if !m.mounted {
+		if err := m.clearStaleMounts(); err != nil {
+			return err
+		}
+	}
 	if err := m.checkFilesystem(); err != nil {
-		log.Printf("Filesystem check failed: %v — recreating drive", err)
+		m.log.Warnf("Filesystem check failed: %v — recreating drive", err)
 		os.Remove(m.driveFile)
 		if err := m.createAndFormatDrive(); err != nil {
 			return fmt.Errorf("failed to recreate drive after corruption: %w", err)
 		}
 	}
+	return m.mountLocked()
+}
 
+func (m *Manager) mountLocked() error {
 	if err := os.MkdirAll(m.mountPoint, 0755); err != nil {
 		return fmt.Errorf("failed to create mount point: %w", err)
 	}
@@ -119,39 +370,135 @@ func (m *Manager) Mount() error {
 	if err := m.mountDrive(m.mountPoint); err != nil {
 		return fmt.Errorf("failed to mount drive: %w", err)
 	}
+	m.mounted = true
 
-	log.Printf("Mounted USB drive at %s", m.mountPoint)
+	m.log.Infof("Mounted USB drive at %s", m.mountPoint)
 	return nil
 }
 
 func (m *Manager) Unmount() error {
-	if err := m.unmountDrive(m.mountPoint); err != nil {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return m.unmountLocked()
+}
+
+// flush writes buffered data back to storage, so a crash between here
+// and the drive being handed to the host can't leave its filesystem half
+// written. umount flushes too, but not if the drive ends up detached
+// lazily. A failure is only logged.
+func (m *Manager) flush() {
+	if output, err := m.run("sync"); err != nil {
+		m.log.Warnf("sync failed: %v, output: %s", err, string(output))
+	}
+}
+
+// unmountLocked unmounts the drive. A drive that stays busy is detached
+// lazily and counts as unmounted, but the error, wrapping ErrDriveBusy,
+// is still returned: its filesystem may not be written back yet.
+func (m *Manager) unmountLocked() error {
+	lazy, err := m.unmountRetrying(m.mountPoint)
+	if err != nil && !lazy {
 		return fmt.Errorf("failed to unmount drive: %w", err)
 	}
+	m.mounted = false
 
-	os.RemoveAll(m.mountPoint)
-	log.Println("Unmounted USB drive")
+	// Remove, not RemoveAll: the mount point is configurable, and an
+	// unmounted one should be empty anyway.
+	if !m.dryRun {
+		os.Remove(m.mountPoint)
+	}
+	if lazy {
+		return fmt.Errorf("failed to unmount drive: %w", err)
+	}
+	m.log.Infof("Unmounted USB drive")
 	return nil
 }
 
+// SetExported records whether the image is handed to the USB host as the
+// mass-storage LUN.
+func (m *Manager) SetExported(exported bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exported = exported
+}
+
+// IsMounted reports whether the drive is mounted at the mount point.
+func (m *Manager) IsMounted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mounted
+}
+
 func (m *Manager) GetMountPoint() string {
 	return m.mountPoint
 }
 
-func (m *Manager) CleanDrive() error {
-	log.Println("Cleaning USB drive")
+// PreserveUnknownDirs makes CleanDrive keep top-level directories not in
+// known, e.g. folders the user created for their own files.
+func (m *Manager) PreserveUnknownDirs(known []string) {
+	m.known = make(map[string]bool, len(known))
+	for _, name := range known {
+		m.known[name] = true
+	}
+}
+
+// CleanDrive empties the drive except for ums_log.txt, ums-result.json,
+// paths matching SetKeepPatterns and, with PreserveUnknownDirs, unknown
+// top-level directories, whose names it returns.
+func (m *Manager) CleanDrive() ([]string, error) {
+	m.log.Infof("Cleaning USB drive")
+
+	kept, err := cleanDrive(m.mountPoint, m.known, m.keep)
+	if err != nil {
+		return kept, fmt.Errorf("failed to clean drive: %w", err)
+	}
+	for _, name := range kept {
+		m.log.Infof("Preserved unknown directory %s on USB drive", name)
+	}
+
+	m.log.Infof("Successfully cleaned USB drive")
+	return kept, nil
+}
+
+// SecureClean empties the drive by recreating the image: a fresh
+// zero-filled file, formatted anew. Unlike CleanDrive it leaves nothing
+// of the old contents for the host to recover and no fragmentation, but
+// it keeps nothing either, not even ums_log.txt, and is much slower. The
+// new image has the configured filesystem and is mounted again if the
+// old one was. In a dry run the mount point is only cleaned.
+func (m *Manager) SecureClean() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.log.Infof("Wiping USB drive")
 
-	if err := m.cleanDrive(m.mountPoint); err != nil {
-		return fmt.Errorf("failed to clean drive: %w", err)
+	if m.dryRun {
+		if _, err := cleanDrive(m.mountPoint, nil, nil); err != nil {
+			return fmt.Errorf("failed to clean drive: %w", err)
+		}
+		return nil
 	}
 
-	log.Println("Successfully cleaned USB drive")
+	wasMounted := m.mounted
+	if wasMounted {
+		if err := m.unmountLocked(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(m.driveFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove drive: %w", err)
+	}
+	if err := m.createAndFormatDrive(); err != nil {
+		return fmt.Errorf("failed to recreate drive: %w", err)
+	}
+	if wasMounted {
+		return m.mountLocked()
+	}
 	return nil
 }
 
 func (m *Manager) mountDrive(mountPoint string) error {
-	cmd := exec.Command("mount", "-t", "vfat", m.driveFile, mountPoint)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run("mount", "-t", string(m.driveFS), m.driveFile, mountPoint)
 	if err != nil {
 		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
 	}
@@ -159,24 +506,42 @@ func (m *Manager) mountDrive(mountPoint string) error {
 }
 
 func (m *Manager) unmountDrive(mountPoint string) error {
-	cmd := exec.Command("umount", mountPoint)
-	output, err := cmd.CombinedOutput()
+	output, err := m.run("umount", mountPoint)
 	if err != nil {
 		return fmt.Errorf("umount failed: %v, output: %s", err, string(output))
 	}
 	return nil
 }
 
-func (m *Manager) cleanDrive(mountPoint string) error {
-	cmds := [][]string{
-		{"find", mountPoint, "-mindepth", "1", "-type", "f", "-not", "-name", "ums_log.txt", "-delete"},
-		{"find", mountPoint, "-mindepth", "1", "-type", "d", "-empty", "-delete"},
+// keptEntries survive cleaning: the log and receipt of the last session,
+// and lost+found, which belongs to ext4 and e2fsck expects there.
+var keptEntries = map[string]bool{
+	"ums_log.txt":     true,
+	"ums-result.json": true,
+	"lost+found":      true,
+}
+
+// cleanDrive removes everything under mountPoint except keptEntries and
+// paths matching keep. With known set, top-level directories not in it
+// are kept and returned.
+func cleanDrive(mountPoint string, known map[string]bool, keep []string) ([]string, error) {
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return nil, err
 	}
-	for _, args := range cmds {
-		cmd := exec.Command(args[0], args[1:]...)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("clean failed: %v, output: %s", err, string(output))
+	var kept []string
+	for _, e := range entries {
+		name := e.Name()
+		if keptEntries[name] {
+			continue
+		}
+		if known != nil && e.IsDir() && !known[name] {
+			kept = append(kept, name)
+			continue
+		}
+		if err := removeUnkept(mountPoint, name, keep); err != nil {
+			return kept, err
 		}
 	}
-	return nil
+	return kept, nil
 }
@@ -0,0 +1,430 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestAlignDriveSize(t *testing.T) {
+	const mib = 1024 * 1024
+	cases := []struct {
+		in           int64
+		want         int64
+		wantAdjusted bool
+	}{
+		{1024 * mib, 1024 * mib, false},
+		{1024*mib + 1, 1024*mib + 512, true},
+		{1000*mib + 512*1024, 1000*mib + 512*1024, false},
+		{1000*mib + 700, 1000*mib + 1024, true},
+		{minDriveSize, minDriveSize, false},
+		{10 * mib, minDriveSize, true},
+		{0, minDriveSize, true},
+	}
+	for _, c := range cases {
+		got, adjusted := alignDriveSize(c.in)
+		if got != c.want || adjusted != c.wantAdjusted {
+			t.Errorf("alignDriveSize(%d) = %d, %v; want %d, %v", c.in, got, adjusted, c.want, c.wantAdjusted)
+		}
+	}
+}
+
+func TestNewManagerAlignsSize(t *testing.T) {
+	m := NewManager("/data/usb.drive", "/mnt/usb-drive-temp", 1024*1024*1024+100, command.Exec{})
+	if m.driveSize%driveAlignment != 0 {
+		t.Errorf("driveSize %d not aligned", m.driveSize)
+	}
+}
+
+// noFallocate runs commands for real, except that fallocate fails as it
+// does on a filesystem without support for it.
+type noFallocate struct{ command.Exec }
+
+func (r noFallocate) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == "fallocate" {
+		return []byte("fallocate failed: Operation not supported"), errors.New("exit status 1")
+	}
+	return r.Exec.Run(ctx, name, args...)
+}
+
+func TestCreateDriveFileSize(t *testing.T) {
+	cases := []struct {
+		name   string
+		tool   string
+		runner command.Runner
+	}{
+		{"fallocate", "fallocate", command.Exec{}},
+		{"dd fallback", "dd", noFallocate{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := exec.LookPath(c.tool); err != nil {
+				t.Skipf("%s not available", c.tool)
+			}
+			path := filepath.Join(t.TempDir(), "usb.drive")
+			// Bypass NewManager to cover a size that isn't a whole number of MiB.
+			m := &Manager{driveFile: path, driveSize: 3*ddBlockSize + 4096, runner: c.runner}
+
+			if err := m.createDriveFile(path); err != nil {
+				t.Fatalf("createDriveFile: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != m.driveSize {
+				t.Errorf("image size = %d, want %d", info.Size(), m.driveSize)
+			}
+		})
+	}
+}
+
+func TestCleanDrive(t *testing.T) {
+	populate := func(t *testing.T) string {
+		t.Helper()
+		root := t.TempDir()
+		for _, p := range []string{"maps/a.mbtiles", "system-update/x.mender", "holiday-photos/1.jpg", "stray.txt", "ums_log.txt", "ums-result.json"} {
+			path := filepath.Join(root, p)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root
+	}
+	remaining := func(t *testing.T, root string) []string {
+		t.Helper()
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	t.Run("clean all", func(t *testing.T) {
+		root := populate(t)
+		m := &Manager{mountPoint: root}
+		kept, err := m.CleanDrive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kept) != 0 {
+			t.Errorf("kept = %v, want none", kept)
+		}
+		if got := remaining(t, root); !reflect.DeepEqual(got, []string{"ums-result.json", "ums_log.txt"}) {
+			t.Errorf("remaining = %v, want only ums-result.json and ums_log.txt", got)
+		}
+	})
+
+	t.Run("preserve unknown", func(t *testing.T) {
+		root := populate(t)
+		m := &Manager{mountPoint: root}
+		m.PreserveUnknownDirs([]string{"maps", "system-update", "ums_log.txt"})
+		kept, err := m.CleanDrive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(kept, []string{"holiday-photos"}) {
+			t.Errorf("kept = %v, want [holiday-photos]", kept)
+		}
+		// Unknown files are still cleaned; only directories are kept.
+		want := []string{"holiday-photos", "ums-result.json", "ums_log.txt"}
+		if got := remaining(t, root); !reflect.DeepEqual(got, want) {
+			t.Errorf("remaining = %v, want %v", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(root, "holiday-photos", "1.jpg")); err != nil {
+			t.Errorf("preserved directory lost its contents: %v", err)
+		}
+	})
+
+	t.Run("keep patterns", func(t *testing.T) {
+		root := populate(t)
+		for _, p := range []string{"maps/notes.txt", "maps/old/b.mbtiles"} {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(root, p)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, p), []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := &Manager{mountPoint: root}
+		if err := m.SetKeepPatterns([]string{"stray.txt", "maps/*.txt", "holiday-*/**"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.CleanDrive(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"holiday-photos", "maps", "stray.txt", "ums-result.json", "ums_log.txt"}
+		if got := remaining(t, root); !reflect.DeepEqual(got, want) {
+			t.Errorf("remaining = %v, want %v", got, want)
+		}
+		if got := remaining(t, filepath.Join(root, "maps")); !reflect.DeepEqual(got, []string{"notes.txt"}) {
+			t.Errorf("maps/ = %v, want only notes.txt", got)
+		}
+	})
+}
+
+func TestKeepPatterns(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"README.txt", "README.txt", true},
+		{"README.txt", "docs/README.txt", false},
+		{"backup/**", "backup", true},
+		{"backup/**", "backup/a/b.txt", true},
+		{"backup/**", "backups/a", false},
+		{"wireguard/*.tmpl", "wireguard/home.tmpl", true},
+		{"wireguard/*.tmpl", "wireguard/home.conf", false},
+		{"**/notes.txt", "notes.txt", true},
+		{"**/notes.txt", "maps/old/notes.txt", true},
+	}
+	for _, c := range cases {
+		if got := keepMatch([]string{c.pattern}, c.path); got != c.want {
+			t.Errorf("keepMatch(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+
+	m := &Manager{}
+	for _, bad := range []string{"", "/etc/passwd", "../x", "maps//a", "[x"} {
+		if err := m.SetKeepPatterns([]string{bad}); err == nil {
+			t.Errorf("SetKeepPatterns(%q) accepted", bad)
+		}
+	}
+}
+
+// TestDryRun checks that a dry run creates no image and leaves the mount
+// point directory, and what was written to it, in place across a
+// mount/unmount cycle.
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	r := &commandtest.Recorder{}
+	m := NewManager(filepath.Join(dir, "usb.drive"), filepath.Join(dir, "mnt"), minDriveSize, r)
+	m.SetDryRun(true)
+
+	if err := m.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	if _, err := os.Stat(m.driveFile); !os.IsNotExist(err) {
+		t.Errorf("drive image created in dry run: %v", err)
+	}
+	if err := m.Mount(); err != nil {
+		t.Fatalf("Mount: %v", err)
+	}
+	note := filepath.Join(m.mountPoint, "note.txt")
+	if err := os.WriteFile(note, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Unmount(); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	if _, err := os.Stat(note); err != nil {
+		t.Errorf("mount point contents removed in dry run: %v", err)
+	}
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("ran %q in dry run", calls)
+	}
+}
+
+// TestMountUnmount checks the commands a mount/unmount cycle of an
+// existing image runs.
+func TestMountUnmount(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usb.drive")
+	if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mounts := filepath.Join(dir, "mounts")
+	if err := os.WriteFile(mounts, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{}
+	m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, r)
+	m.mountsPath = mounts
+
+	if err := m.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	if err := m.Mount(); err != nil {
+		t.Fatalf("Mount: %v", err)
+	}
+	if err := m.Unmount(); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	want := []string{
+		"fsck.fat -a " + path,
+		"fsck.fat -n " + path,
+		"mount -t vfat " + path + " " + m.mountPoint,
+		"sync",
+		"umount " + m.mountPoint,
+	}
+	if got := r.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+// TestMountWhileExported checks the image isn't checked or mounted while
+// the host has it.
+func TestMountWhileExported(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usb.drive")
+	if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &commandtest.Recorder{}
+	m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, r)
+	m.SetExported(true)
+
+	if err := m.Mount(); !errors.Is(err, ErrDriveExported) {
+		t.Errorf("Mount = %v, want ErrDriveExported", err)
+	}
+	if calls := r.Calls(); len(calls) != 0 {
+		t.Errorf("ran %q while exported", calls)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "image" {
+		t.Errorf("image = %q, want it untouched", data)
+	}
+}
+
+// TestSecureClean checks that a failed recreation is reported, and that
+// a dry run only empties the mount point.
+func TestSecureClean(t *testing.T) {
+	t.Run("dd fails", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "usb.drive")
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+			"fallocate": {Output: "fallocate: Operation not supported", Err: errors.New("exit status 1")},
+			"dd":        {Output: "No space left on device", Err: errors.New("exit status 1")},
+		}}
+		m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, r)
+		m.mounted = true
+
+		if err := m.SecureClean(); err == nil {
+			t.Fatal("SecureClean succeeded, want an error")
+		}
+		calls := r.Calls()
+		if len(calls) != 3 || calls[0] != "umount "+m.mountPoint ||
+			!strings.HasPrefix(calls[1], "fallocate ") || !strings.HasPrefix(calls[2], "dd ") {
+			t.Errorf("commands = %q, want umount, fallocate, then dd", calls)
+		}
+		if m.mounted {
+			t.Error("still marked mounted")
+		}
+		if _, err := os.Stat(path + tmpSuffix); !os.IsNotExist(err) {
+			t.Errorf("partial image left behind: %v", err)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "usb.drive")
+		if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r := &commandtest.Recorder{}
+		m := NewManager(path, filepath.Join(dir, "mnt"), minDriveSize, r)
+		m.mounted = true
+		m.SetDryRun(true)
+		if err := os.MkdirAll(filepath.Join(m.mountPoint, "maps"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.SecureClean(); err != nil {
+			t.Fatalf("SecureClean: %v", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "image" {
+			t.Error("image touched in dry run")
+		}
+		if _, err := os.Stat(filepath.Join(m.mountPoint, "maps")); !os.IsNotExist(err) {
+			t.Errorf("mount point not cleaned: %v", err)
+		}
+		if calls := r.Calls(); len(calls) != 0 {
+			t.Errorf("ran %q in dry run", calls)
+		}
+	})
+}
+
+func TestUnmountBusy(t *testing.T) {
+	mp := filepath.Join(t.TempDir(), "mnt")
+	busy := commandtest.Reply{Output: "umount: " + mp + ": target is busy.", Err: errors.New("exit status 32")}
+	tests := []struct {
+		name        string
+		replies     map[string]commandtest.Reply
+		wantCalls   []string
+		wantBusy    bool
+		wantErr     bool
+		wantMounted bool
+	}{
+		{
+			name:      "clean",
+			wantCalls: []string{"sync", "umount " + mp},
+		},
+		{
+			name:      "sync fails",
+			replies:   map[string]commandtest.Reply{"sync": {Err: errors.New("exit status 1")}},
+			wantCalls: []string{"sync", "umount " + mp},
+		},
+		{
+			name:      "busy, detached lazily",
+			replies:   map[string]commandtest.Reply{"umount " + mp: busy},
+			wantCalls: []string{"sync", "umount " + mp, "umount " + mp, "umount " + mp, "umount -l " + mp},
+			wantBusy:  true,
+			wantErr:   true,
+		},
+		{
+			name:        "busy, lazy detach fails",
+			replies:     map[string]commandtest.Reply{"umount": busy},
+			wantCalls:   []string{"sync", "umount " + mp, "umount " + mp, "umount " + mp, "umount -l " + mp},
+			wantBusy:    true,
+			wantErr:     true,
+			wantMounted: true,
+		},
+		{
+			name:        "other failure",
+			replies:     map[string]commandtest.Reply{"umount": {Output: "umount: " + mp + ": not mounted.", Err: errors.New("exit status 32")}},
+			wantCalls:   []string{"sync", "umount " + mp},
+			wantErr:     true,
+			wantMounted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &commandtest.Recorder{Replies: tt.replies}
+			m := NewManager(filepath.Join(t.TempDir(), "usb.drive"), mp, minDriveSize, r)
+			m.mounted = true
+			m.unmountBackoff = 0
+
+			err := m.Unmount()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unmount = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrDriveBusy) != tt.wantBusy {
+				t.Errorf("Unmount = %v, want ErrDriveBusy %v", err, tt.wantBusy)
+			}
+			if m.IsMounted() != tt.wantMounted {
+				t.Errorf("mounted = %v, want %v", m.IsMounted(), tt.wantMounted)
+			}
+			if got := r.Calls(); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("commands = %q, want %q", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package disk
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findMounts lists the mount points in mountsPath (/proc/mounts format)
+// that are mountPoint itself or a loop device backed by driveFile. Loop
+// backing files are looked up under sysBlock (/sys/block).
+func findMounts(mountsPath, sysBlock, mountPoint, driveFile string) ([]string, error) {
+	f, err := os.Open(mountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var found []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		dev, target := unescapeMountField(fields[0]), unescapeMountField(fields[1])
+		if target == mountPoint || loopBackingFile(sysBlock, dev) == driveFile {
+			found = append(found, target)
+		}
+	}
+	return found, sc.Err()
+}
+
+// unescapeMountField undoes the octal escapes (\040 for a space, ...)
+// /proc/mounts uses for whitespace and backslashes.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// loopBackingFile returns the file behind loop device dev, or "" if dev
+// isn't a loop device.
+func loopBackingFile(sysBlock, dev string) string {
+	if !strings.HasPrefix(dev, "/dev/loop") {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(sysBlock, filepath.Base(dev), "loop", "backing_file"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// clearStaleMounts unmounts the drive wherever a previous run left it
+// mounted, e.g. after crashing between Mount and Unmount. A mount that
+// won't go away cleanly is detached lazily, so the image is free to be
+// checked and mounted again.
+func (m *Manager) clearStaleMounts() error {
+	targets, err := findMounts(m.mountsPath, m.sysBlock, m.mountPoint, m.driveFile)
+	if err != nil {
+		return fmt.Errorf("failed to read mounts: %w", err)
+	}
+	// Unmount the most recent first, in case they are stacked.
+	for i := len(targets) - 1; i >= 0; i-- {
+		target := targets[i]
+		m.log.Infof("Unmounting stale mount of USB drive at %s", target)
+		if err := m.unmountDrive(target); err == nil {
+			continue
+		}
+		output, err := m.run("umount", "-l", target)
+		if err != nil {
+			return fmt.Errorf("failed to unmount stale mount at %s: %v, output: %s", target, err, string(output))
+		}
+	}
+	return nil
+}
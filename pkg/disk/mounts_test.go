@@ -0,0 +1,65 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindMounts(t *testing.T) {
+	dir := t.TempDir()
+	sysBlock := filepath.Join(dir, "block")
+	for loop, backing := range map[string]string{
+		"loop0": "/data/usb.drive\n",
+		"loop1": "/data/other.img\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(sysBlock, loop, "loop"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sysBlock, loop, "loop", "backing_file"), []byte(backing), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mounts := filepath.Join(dir, "mounts")
+	content := "/dev/root / ext4 rw,relatime 0 0\n" +
+		"/dev/mmcblk0p4 /data ext4 rw,relatime 0 0\n" +
+		"/dev/loop0 /mnt/usb-drive-temp vfat rw,relatime 0 0\n" +
+		"/dev/loop0 /mnt/old\\040mount vfat rw,relatime 0 0\n" +
+		"/dev/loop1 /mnt/other ext4 rw,relatime 0 0\n"
+	if err := os.WriteFile(mounts, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findMounts(mounts, sysBlock, "/mnt/usb-drive-temp", "/data/usb.drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/mnt/usb-drive-temp", "/mnt/old mount"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findMounts = %q, want %q", got, want)
+	}
+
+	got, err = findMounts(mounts, sysBlock, "/mnt/elsewhere", "/data/missing.drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("findMounts = %q, want none", got)
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	cases := map[string]string{
+		"/mnt/usb-drive-temp":   "/mnt/usb-drive-temp",
+		`/mnt/a\040b`:           "/mnt/a b",
+		`/mnt/tab\011x\134y`:    "/mnt/tab\tx\\y",
+		`/mnt/trailing\04`:      `/mnt/trailing\04`,
+		`/mnt/not\999an-escape`: `/mnt/not\999an-escape`,
+	}
+	for in, want := range cases {
+		if got := unescapeMountField(in); got != want {
+			t.Errorf("unescapeMountField(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package disk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes ParseSize accepts. Like dd's K, M and G they
+// are binary: "1500MB" is 1500 MiB, the same as "1500M" or "1500MiB".
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseSize parses a drive size such as "512M", "2G", "1500MB" or a plain
+// byte count, and checks it is one an image can be made with: at least
+// 64 MiB and a whole number of 512-byte sectors.
+func ParseSize(s string) (int64, error) {
+	raw := strings.TrimSpace(s)
+	i := strings.IndexFunc(raw, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(raw)
+	}
+	num, unit := raw[:i], strings.ToLower(strings.TrimSpace(raw[i:]))
+	if len(unit) == 3 && strings.HasSuffix(unit, "ib") {
+		unit = unit[:1]
+	} else if len(unit) == 2 && strings.HasSuffix(unit, "b") {
+		unit = unit[:1]
+	}
+	mult, ok := sizeUnits[unit]
+	if num == "" || !ok {
+		return 0, fmt.Errorf("bad size %q (want e.g. 512M, 2G or a byte count)", s)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n > (1<<63-1)/mult {
+		return 0, fmt.Errorf("bad size %q: out of range", s)
+	}
+	size := n * mult
+	if size < minDriveSize {
+		return 0, fmt.Errorf("size %s is below the %dM minimum", s, minDriveSize>>20)
+	}
+	if size%driveAlignment != 0 {
+		return 0, fmt.Errorf("size %s is not a multiple of %d bytes", s, driveAlignment)
+	}
+	return size, nil
+}
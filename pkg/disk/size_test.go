@@ -0,0 +1,35 @@
+package disk
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	const mib = 1024 * 1024
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512M", 512 * mib, false},
+		{"2G", 2048 * mib, false},
+		{"1500MB", 1500 * mib, false},
+		{"1GiB", 1024 * mib, false},
+		{" 64m ", 64 * mib, false},
+		{"65536K", 64 * mib, false},
+		{"67109376", 64*mib + 512, false},
+		{"67109376B", 64*mib + 512, false},
+		{"63M", 0, true},
+		{"67109000", 0, true},
+		{"", 0, true},
+		{"G", 0, true},
+		{"1.5G", 0, true},
+		{"2X", 0, true},
+		{"-1G", 0, true},
+		{"99999999999T", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseSize(c.in)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", c.in, got, err, c.want, c.wantErr)
+		}
+	}
+}
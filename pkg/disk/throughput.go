@@ -0,0 +1,54 @@
+package disk
+
+import (
+	"os"
+	"time"
+)
+
+// Throughput is how much data a write put on the drive and how long it
+// took to reach the backing file.
+type Throughput struct {
+	Bytes   uint64
+	Elapsed time.Duration
+}
+
+// BytesPerSecond returns the write rate, or 0 for an empty measurement.
+func (t Throughput) BytesPerSecond() float64 {
+	if t.Bytes == 0 || t.Elapsed <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Elapsed.Seconds()
+}
+
+// WriteProbeSize is how much ProbeWriteSpeed writes: enough that the
+// rate isn't dominated by sync and metadata overhead, little enough not
+// to hold up the switch.
+const WriteProbeSize = 4 << 20
+
+// ProbeWriteSpeed writes WriteProbeSize bytes to a scratch file on the
+// mounted drive, flushes them to the backing file and removes the file
+// again, timing only the write and flush. Must be called while mounted.
+func (m *Manager) ProbeWriteSpeed() (Throughput, error) {
+	f, err := os.CreateTemp(m.mountPoint, ".ums-speed-*")
+	if err != nil {
+		return Throughput{}, classifyWriteErr(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Not zeros, so nothing on the way can shortcut them.
+	block := make([]byte, 64<<10)
+	for n := range block {
+		block[n] = byte(n)
+	}
+	start := time.Now()
+	for written := 0; written < WriteProbeSize; written += len(block) {
+		if _, err := f.Write(block); err != nil {
+			return Throughput{}, classifyWriteErr(err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return Throughput{}, err
+	}
+	return Throughput{Bytes: WriteProbeSize, Elapsed: time.Since(start)}, nil
+}
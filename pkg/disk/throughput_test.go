@@ -0,0 +1,33 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestProbeWriteSpeed(t *testing.T) {
+	dir := t.TempDir()
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mnt, "settings.toml"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(filepath.Join(dir, "usb.drive"), mnt, minDriveSize, &commandtest.Recorder{})
+
+	got, err := m.ProbeWriteSpeed()
+	if err != nil {
+		t.Fatalf("ProbeWriteSpeed: %v", err)
+	}
+	if got.Bytes != WriteProbeSize || got.Elapsed <= 0 {
+		t.Errorf("ProbeWriteSpeed = %+v, want %d bytes in some time", got, WriteProbeSize)
+	}
+	entries, _ := os.ReadDir(mnt)
+	if len(entries) != 1 || entries[0].Name() != "settings.toml" {
+		t.Errorf("drive holds %v after the probe, want only settings.toml", entries)
+	}
+}
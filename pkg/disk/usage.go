@@ -0,0 +1,132 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// otherCategory collects everything on the drive that doesn't belong to a
+// known top-level entry.
+const otherCategory = "other"
+
+// ErrDriveExported is returned by Mount, Usage and List while the image is
+// handed to the USB host: mounting it here as well would corrupt it.
+var ErrDriveExported = errors.New("drive is exported to the USB host")
+
+// Usage is how full the drive's filesystem is.
+type Usage struct {
+	TotalBytes uint64
+	UsedBytes  uint64
+	FreeBytes  uint64
+}
+
+// Usage reports the drive's capacity and fill level, mounting it for
+// the duration if it isn't mounted already. Fails with ErrDriveExported
+// while the drive is in UMS mode.
+func (m *Manager) Usage() (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exported {
+		return Usage{}, ErrDriveExported
+	}
+	if !m.mounted {
+		if err := m.mountLocked(); err != nil {
+			return Usage{}, err
+		}
+		defer func() {
+			if err := m.unmountLocked(); err != nil {
+				m.log.Errorf("Error unmounting USB drive after usage check: %v", err)
+			}
+		}()
+	}
+	return m.statfs()
+}
+
+// SessionUsage describes how full the drive was when the host handed it
+// back, broken down by top-level entry.
+type SessionUsage struct {
+	TotalBytes uint64
+	UsedBytes  uint64
+	// Categories maps a known top-level file or directory name (or
+	// "other") to the apparent size of the files stored under it.
+	Categories map[string]int64
+}
+
+// SessionUsage reports filesystem capacity and per-category sizes of the
+// mounted drive. known lists the top-level names that get their own
+// category; everything else is summed under "other". Must be called while
+// the drive is mounted.
+func (m *Manager) SessionUsage(known []string) (SessionUsage, error) {
+	u, err := m.statfs()
+	if err != nil {
+		return SessionUsage{}, err
+	}
+
+	categories, err := categorySizes(m.mountPoint, known)
+	if err != nil {
+		return SessionUsage{}, err
+	}
+
+	return SessionUsage{
+		TotalBytes: u.TotalBytes,
+		UsedBytes:  u.UsedBytes,
+		Categories: categories,
+	}, nil
+}
+
+func (m *Manager) statfs() (Usage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(m.mountPoint, &st); err != nil {
+		return Usage{}, fmt.Errorf("statfs %s: %w", m.mountPoint, err)
+	}
+	total := st.Blocks * uint64(st.Bsize)
+	return Usage{
+		TotalBytes: total,
+		UsedBytes:  total - st.Bfree*uint64(st.Bsize),
+		FreeBytes:  st.Bavail * uint64(st.Bsize),
+	}, nil
+}
+
+// categorySizes walks root and sums regular file sizes per top-level entry.
+// Known entries are always present in the result (possibly as 0) so
+// consumers can tell "empty" from "missing".
+func categorySizes(root string, known []string) (map[string]int64, error) {
+	sizes := make(map[string]int64, len(known)+1)
+	isKnown := make(map[string]bool, len(known))
+	for _, name := range known {
+		isKnown[name] = true
+		sizes[name] = 0
+	}
+	sizes[otherCategory] = 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		top, _, _ := strings.Cut(rel, string(filepath.Separator))
+		if !isKnown[top] {
+			top = otherCategory
+		}
+		sizes[top] += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return sizes, nil
+}
@@ -0,0 +1,73 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestCategorySizes(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{
+		"settings.toml":                       10,
+		"system-update/librescoot-mdb.mender": 1000,
+		"maps/map.mbtiles":                    500,
+		"maps/nested/extra.bin":               20,
+		"holiday-photos/img001.jpg":           3000,
+		"notes.txt":                           7,
+	}
+	for rel, size := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "wireguard"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := categorySizes(root, []string{"settings.toml", "system-update", "maps", "wireguard"})
+	if err != nil {
+		t.Fatalf("categorySizes: %v", err)
+	}
+
+	want := map[string]int64{
+		"settings.toml": 10,
+		"system-update": 1000,
+		"maps":          520,
+		"wireguard":     0,
+		"other":         3007,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d categories, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("category %q = %d, want %d", k, got[k], v)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "usb.drive"), t.TempDir(), minDriveSize, &commandtest.Recorder{})
+	m.mounted = true
+
+	u, err := m.Usage()
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if u.TotalBytes == 0 || u.UsedBytes > u.TotalBytes || u.FreeBytes > u.TotalBytes {
+		t.Errorf("implausible usage %+v", u)
+	}
+
+	m.SetExported(true)
+	if _, err := m.Usage(); !errors.Is(err, ErrDriveExported) {
+		t.Errorf("Usage while exported: err = %v, want ErrDriveExported", err)
+	}
+}
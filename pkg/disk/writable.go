@@ -0,0 +1,65 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ErrReadOnly means the drive is mounted but can't be written, typically
+// because the kernel forced a read-only mount over a dirty FAT.
+var ErrReadOnly = errors.New("drive mounted read-only")
+
+// EnsureWritable checks the mounted drive can be written, remounting it
+// read-write once if it came up read-only. Call after Mount and before
+// copying anything onto the drive.
+func (m *Manager) EnsureWritable() error {
+	err := m.probe(m.mountPoint)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrReadOnly) {
+		return fmt.Errorf("drive not writable: %w", err)
+	}
+
+	m.log.Infof("%s is read-only, remounting read-write", m.mountPoint)
+	if rerr := m.remount(m.mountPoint); rerr != nil {
+		return fmt.Errorf("%w (remount rw failed: %v)", ErrReadOnly, rerr)
+	}
+	if err := m.probe(m.mountPoint); err != nil {
+		return fmt.Errorf("after remount: %w", err)
+	}
+	m.log.Infof("Remounted %s read-write", m.mountPoint)
+	return nil
+}
+
+// probeWritable creates and removes a scratch file in dir. A read-only
+// filesystem is reported as ErrReadOnly.
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ums-probe-*")
+	if err != nil {
+		return classifyWriteErr(err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return classifyWriteErr(err)
+	}
+	return nil
+}
+
+func classifyWriteErr(err error) error {
+	if errors.Is(err, syscall.EROFS) {
+		return fmt.Errorf("%w: %v", ErrReadOnly, err)
+	}
+	return err
+}
+
+func (m *Manager) remountRW(mountPoint string) error {
+	output, err := m.run("mount", "-o", "remount,rw", mountPoint)
+	if err != nil {
+		return fmt.Errorf("mount failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestProbeWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := probeWritable(dir); err != nil {
+		t.Fatalf("probeWritable: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("probe left %d file(s) behind", len(entries))
+	}
+
+	err = probeWritable(filepath.Join(dir, "missing"))
+	if err == nil || errors.Is(err, ErrReadOnly) {
+		t.Errorf("missing dir: got %v, want a non-read-only error", err)
+	}
+}
+
+func TestClassifyWriteErr(t *testing.T) {
+	rofs := &os.PathError{Op: "open", Path: "/mnt/usb-drive-temp/x", Err: syscall.EROFS}
+	if err := classifyWriteErr(rofs); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("EROFS classified as %v", err)
+	}
+	full := &os.PathError{Op: "open", Path: "/mnt/usb-drive-temp/x", Err: syscall.ENOSPC}
+	if err := classifyWriteErr(full); errors.Is(err, ErrReadOnly) {
+		t.Errorf("ENOSPC classified as read-only")
+	}
+}
+
+func TestEnsureWritable(t *testing.T) {
+	roErr := classifyWriteErr(&os.PathError{Op: "open", Path: "x", Err: syscall.EROFS})
+	cases := []struct {
+		name        string
+		probes      []error // successive probe results
+		remountErr  error
+		wantRemount bool
+		wantErr     error
+	}{
+		{name: "writable", probes: []error{nil}},
+		{name: "ro then remounted", probes: []error{roErr, nil}, wantRemount: true},
+		{name: "remount fails", probes: []error{roErr}, remountErr: errors.New("busy"), wantRemount: true, wantErr: ErrReadOnly},
+		{name: "still ro after remount", probes: []error{roErr, roErr}, wantRemount: true, wantErr: ErrReadOnly},
+		{name: "other error", probes: []error{syscall.EIO}, wantErr: syscall.EIO},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			remounted := false
+			calls := 0
+			m := &Manager{
+				mountPoint: "/mnt/usb-drive-temp",
+				probe: func(string) error {
+					err := c.probes[calls]
+					calls++
+					return err
+				},
+				remount: func(string) error {
+					remounted = true
+					return c.remountErr
+				},
+			}
+			err := m.EnsureWritable()
+			if remounted != c.wantRemount {
+				t.Errorf("remounted = %v, want %v", remounted, c.wantRemount)
+			}
+			if c.wantErr == nil && err != nil {
+				t.Errorf("EnsureWritable: %v", err)
+			}
+			if c.wantErr != nil && !errors.Is(err, c.wantErr) {
+				t.Errorf("EnsureWritable = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
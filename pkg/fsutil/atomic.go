@@ -0,0 +1,56 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeData is the write WriteFileAtomic makes; tests swap it to fail
+// midway.
+var writeData = func(f *os.File, data []byte) (int, error) {
+	return f.Write(data)
+}
+
+// WriteFileAtomic writes data to path through a temp file in the same
+// directory that is synced and then renamed over path, so a power loss
+// leaves either the old contents or the new ones, never a truncated mix.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := writeTemp(f, data, perm); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Persist the rename itself. Not every filesystem (vfat) lets a
+	// directory be synced, and the data is safe either way.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func writeTemp(f *os.File, data []byte, perm os.FileMode) error {
+	if _, err := writeData(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.toml")
+	if err := os.WriteFile(path, []byte("old = 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, []byte("new = 2\n"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new = 2\n" {
+		t.Errorf("contents = %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	assertOnlyEntry(t, dir, "settings.toml")
+}
+
+// TestWriteFileAtomicFailure cuts the write off halfway, as a full disk
+// or a power loss would, and checks the original file is untouched.
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.toml")
+	if err := os.WriteFile(path, []byte("old = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := writeData
+	defer func() { writeData = orig }()
+	writeData = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errors.New("no space left on device")
+	}
+
+	if err := WriteFileAtomic(path, []byte("new = 2\nmore = 3\n"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic succeeded")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old = 1\n" {
+		t.Errorf("original clobbered: %q", data)
+	}
+	assertOnlyEntry(t, dir, "settings.toml")
+}
+
+func assertOnlyEntry(t *testing.T, dir, name string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != name {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("directory holds %v, want only %s", names, name)
+	}
+}
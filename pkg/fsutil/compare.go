@@ -0,0 +1,43 @@
+package fsutil
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// compareChunk is how much of a file SameContent reads at a time.
+const compareChunk = 32 << 10
+
+// SameContent reports whether the file at path holds exactly data. The
+// sizes are compared first, and the file is then read a chunk at a time,
+// so a large file is never held in memory whole. A file that can't be
+// read counts as different.
+func SameContent(path string, data []byte) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() != int64(len(data)) {
+		return false
+	}
+	buf := make([]byte, min(compareChunk, len(data)+1))
+	for {
+		// Asking for a byte past data catches a file that grew since
+		// the Stat.
+		n, err := io.ReadFull(f, buf[:min(len(buf), len(data)+1)])
+		if n > len(data) || !bytes.Equal(buf[:n], data[:n]) {
+			return false
+		}
+		data = data[n:]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return len(data) == 0
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
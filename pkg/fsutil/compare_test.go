@@ -0,0 +1,45 @@
+package fsutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSameContent(t *testing.T) {
+	dir := t.TempDir()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 5000) // spans several chunks
+	changed := append([]byte{}, big...)
+	changed[len(changed)-1] = 'x'
+
+	cases := []struct {
+		name string
+		file []byte
+		data []byte
+		want bool
+	}{
+		{"equal", []byte("a = 1\n"), []byte("a = 1\n"), true},
+		{"empty", []byte{}, []byte{}, true},
+		{"different size", []byte("a = 1\n"), []byte("a = 10\n"), false},
+		{"same size", []byte("a = 1\n"), []byte("a = 2\n"), false},
+		{"large equal", big, big, true},
+		{"large, last byte differs", big, changed, false},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, "f")
+		if err := os.WriteFile(path, c.file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := SameContent(path, c.data); got != c.want {
+			t.Errorf("%s: SameContent = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if SameContent(filepath.Join(dir, "missing"), nil) {
+		t.Error("a missing file matched")
+	}
+	if SameContent(dir, nil) {
+		t.Error("a directory matched")
+	}
+}
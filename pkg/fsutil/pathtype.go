@@ -0,0 +1,63 @@
+// Package fsutil holds small filesystem helpers shared by the components
+// that move files between /data and the USB drive.
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrWrongType is returned when a path exists but is not the kind of
+// filesystem object the configuration expects (e.g. settings.toml is a
+// directory after a bad bind mount).
+var ErrWrongType = errors.New("path has wrong type")
+
+// CheckFile returns nil if path is a regular file or doesn't exist yet,
+// and an error wrapping ErrWrongType if it is anything else.
+func CheckFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("misconfiguration: %s is a %s, expected a regular file: %w", path, describe(info.Mode()), ErrWrongType)
+	}
+	return nil
+}
+
+// CheckDir returns nil if path is a directory or doesn't exist yet, and an
+// error wrapping ErrWrongType if it is anything else.
+func CheckDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("misconfiguration: %s is a %s, expected a directory: %w", path, describe(info.Mode()), ErrWrongType)
+	}
+	return nil
+}
+
+func describe(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "regular file"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	default:
+		return "special file"
+	}
+}
@@ -0,0 +1,47 @@
+package fsutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFileAndDir(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "settings.toml")
+	dir := filepath.Join(root, "wireguard")
+	missing := filepath.Join(root, "missing")
+	if err := os.WriteFile(file, []byte("a = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		check     func(string) error
+		path      string
+		wrongType bool
+	}{
+		{"file is file", CheckFile, file, false},
+		{"file missing", CheckFile, missing, false},
+		{"file is dir", CheckFile, dir, true},
+		{"dir is dir", CheckDir, dir, false},
+		{"dir missing", CheckDir, missing, false},
+		{"dir is file", CheckDir, file, true},
+	}
+	for _, c := range cases {
+		err := c.check(c.path)
+		if c.wrongType {
+			if !errors.Is(err, ErrWrongType) {
+				t.Errorf("%s: expected ErrWrongType, got %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected nil, got %v", c.name, err)
+		}
+	}
+}
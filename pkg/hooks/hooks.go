@@ -0,0 +1,132 @@
+// Package hooks runs integrators' scripts around mode changes, so they
+// can add their own steps (flash an LED, notify a backend, snapshot a
+// database) without changing the service. Scripts live in one directory
+// per hook point below a root, e.g. /data/ums-hooks/pre-ums.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/logging"
+)
+
+// DefaultTimeout bounds a single hook script unless SetTimeout changes
+// it.
+const DefaultTimeout = 30 * time.Second
+
+// Runner runs the scripts of a hook point. A nil Runner runs nothing.
+type Runner struct {
+	dir     string
+	timeout time.Duration
+	runner  command.Runner
+	dryRun  bool
+
+	log *logging.Logger
+}
+
+// New runs hook scripts found below dir through runner.
+func New(dir string, runner command.Runner) *Runner {
+	return &Runner{
+		dir:     dir,
+		timeout: DefaultTimeout,
+		runner:  runner,
+		log:     logging.For("hooks"),
+	}
+}
+
+// SetTimeout sets how long each script may run before it is killed.
+func (r *Runner) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// SetDryRun makes the runner log the scripts it would run instead of
+// running them.
+func (r *Runner) SetDryRun(dryRun bool) {
+	r.dryRun = dryRun
+}
+
+// SetLogger sets the logger the runner writes to.
+func (r *Runner) SetLogger(logger *logging.Logger) {
+	r.log = logger
+}
+
+// Run runs the executable files in the point's directory, e.g.
+// "pre-ums", in name order, each with mode as its only argument. Hidden
+// files, directories and files without an execute bit are skipped; a
+// missing directory has no hooks. A failing or timed-out script doesn't
+// stop the ones after it; the failures are returned joined.
+func (r *Runner) Run(ctx context.Context, point, mode string) error {
+	if r == nil {
+		return nil
+	}
+	scripts, err := r.scripts(point)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range scripts {
+		if err := r.runScript(ctx, path, mode); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", point, filepath.Base(path), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scripts lists the point's scripts in the order they run.
+func (r *Runner) scripts(point string) ([]string, error) {
+	dir := filepath.Join(r.dir, point)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read hook directory: %w", err)
+	}
+	var scripts []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// Stat, not the entry's type, so a symlink to a script counts.
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			r.log.Infof("Skipping %s/%s, not executable", point, name)
+			continue
+		}
+		scripts = append(scripts, path)
+	}
+	sort.Strings(scripts)
+	return scripts, nil
+}
+
+func (r *Runner) runScript(ctx context.Context, path, mode string) error {
+	if r.dryRun {
+		r.log.WouldRun(path, mode)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	r.log.Infof("Running hook %s %s", path, mode)
+	output, err := r.runner.Run(ctx, path, mode)
+	if out := strings.TrimSpace(string(output)); out != "" {
+		r.log.Infof("%s: %s", filepath.Base(path), out)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", r.timeout)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+// writeHooks creates files under dir/point, mapping name to mode; a mode
+// of 0 makes a directory.
+func writeHooks(t *testing.T, dir, point string, files map[string]os.FileMode) {
+	t.Helper()
+	for name, mode := range files {
+		path := filepath.Join(dir, point, name)
+		if mode == 0 {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeHooks(t, dir, "pre-ums", map[string]os.FileMode{
+		"20-notify":  0755,
+		"10-led":     0755,
+		"README":     0644,
+		".disabled":  0755,
+		"lib":        0,
+		"30-backend": 0700,
+	})
+	pre := filepath.Join(dir, "pre-ums")
+	r := &commandtest.Recorder{Replies: map[string]commandtest.Reply{
+		filepath.Join(pre, "20-notify") + " ums-ro": {Err: errors.New("exit status 1")},
+	}}
+	h := New(dir, r)
+
+	err := h.Run(context.Background(), "pre-ums", "ums-ro")
+	if err == nil || !strings.Contains(err.Error(), "pre-ums/20-notify") {
+		t.Errorf("Run = %v, want 20-notify's failure", err)
+	}
+	want := []string{
+		filepath.Join(pre, "10-led") + " ums-ro",
+		filepath.Join(pre, "20-notify") + " ums-ro",
+		filepath.Join(pre, "30-backend") + " ums-ro",
+	}
+	if got := r.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran:\n got %q\nwant %q", got, want)
+	}
+
+	if err := h.Run(context.Background(), "post-normal", "normal"); err != nil {
+		t.Errorf("Run without hooks = %v", err)
+	}
+	if got := len(r.Calls()); got != len(want) {
+		t.Errorf("%d more commands run for a point without hooks", got-len(want))
+	}
+
+	var nilRunner *Runner
+	if err := nilRunner.Run(context.Background(), "pre-ums", "ums"); err != nil {
+		t.Errorf("nil Runner = %v", err)
+	}
+}
+
+// hangingRunner runs programs that don't exit until they are killed.
+type hangingRunner struct{}
+
+func (hangingRunner) Run(ctx context.Context, _ string, _ ...string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, errors.New("signal: killed")
+}
+
+func (r hangingRunner) RunInput(ctx context.Context, _ io.Reader, name string, args ...string) ([]byte, error) {
+	return r.Run(ctx, name, args...)
+}
+
+func TestRunTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeHooks(t, dir, "post-ums", map[string]os.FileMode{"hang": 0755})
+	h := New(dir, hangingRunner{})
+	h.SetTimeout(10 * time.Millisecond)
+
+	err := h.Run(context.Background(), "post-ums", "ums")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Run = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package logexport writes recent journal output into a logs/ directory
+// on the drive so users can copy it off and send it in.
+package logexport
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// journalLines bounds how much journalctl reads per unit before the
+// byte cap is applied.
+const journalLines = "20000"
+
+// JournalFunc returns recent journal output for unit, or for the whole
+// system when unit is empty.
+type JournalFunc func(unit string) ([]byte, error)
+
+type Exporter struct {
+	units    []string
+	maxBytes int
+	journal  JournalFunc
+}
+
+// New exports the system journal plus one file per unit, each capped at
+// the newest maxBytes.
+func New(units []string, maxBytes int) *Exporter {
+	return &Exporter{units: units, maxBytes: maxBytes, journal: readJournal}
+}
+
+func readJournal(unit string) ([]byte, error) {
+	args := []string{"--no-pager", "-n", journalLines}
+	if unit != "" {
+		args = append(args, "-u", unit)
+	}
+	return exec.Command("journalctl", args...).Output()
+}
+
+// ExportToUSB replaces logs/ on the drive with fresh exports. A unit
+// whose journal can't be read is skipped; the rest are still written.
+func (e *Exporter) ExportToUSB(usbMountPath string) error {
+	dir := filepath.Join(usbMountPath, "logs")
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear logs directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create logs directory: %w", err)
+	}
+
+	exported := 0
+	for _, unit := range append([]string{""}, e.units...) {
+		name := "system.log"
+		if unit != "" {
+			name = strings.TrimSuffix(filepath.Base(unit), ".service") + ".log"
+		}
+		data, err := e.journal(unit)
+		if err != nil {
+			log.Printf("Failed to read journal for %s: %v", name, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), tail(data, e.maxBytes), 0644); err != nil {
+			log.Printf("Failed to write %s: %v", name, err)
+			continue
+		}
+		exported++
+	}
+
+	log.Printf("Exported %d log file(s) to USB drive", exported)
+	return nil
+}
+
+// tail keeps the newest max bytes of data, starting at a line boundary.
+// max <= 0 means no limit.
+func tail(data []byte, max int) []byte {
+	if max <= 0 || len(data) <= max {
+		return data
+	}
+	start := len(data) - max
+	if data[start-1] == '\n' {
+		return data[start:]
+	}
+	if i := strings.IndexByte(string(data[start:]), '\n'); i >= 0 {
+		return data[start+i+1:]
+	}
+	return data[start:]
+}
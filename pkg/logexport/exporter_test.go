@@ -0,0 +1,80 @@
+package logexport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExportToUSB(t *testing.T) {
+	mount := t.TempDir()
+	// Left over from a previous session; must not survive.
+	if err := os.MkdirAll(filepath.Join(mount, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mount, "logs", "stale.log"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{
+		units:    []string{"librescoot-settings.service", "radio-gaga", "broken.service"},
+		maxBytes: 16,
+		journal: func(unit string) ([]byte, error) {
+			switch unit {
+			case "":
+				return []byte("line one\nline two\nline three\n"), nil
+			case "broken.service":
+				return nil, errors.New("exit status 1")
+			default:
+				return []byte(unit + " ok\n"), nil
+			}
+		},
+	}
+	if err := e.ExportToUSB(mount); err != nil {
+		t.Fatalf("ExportToUSB: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(mount, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	want := []string{"librescoot-settings.log", "radio-gaga.log", "system.log"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	system, err := os.ReadFile(filepath.Join(mount, "logs", "system.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(system) != "line three\n" {
+		t.Errorf("system.log = %q, want newest whole lines within 16 bytes", system)
+	}
+}
+
+func TestTail(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"a\nb\n", 0, "a\nb\n"},
+		{"a\nb\n", 10, "a\nb\n"},
+		{"aaaa\nbb\ncc\n", 7, "bb\ncc\n"},
+		{"aaaa\nbb\ncc\n", 6, "bb\ncc\n"},
+		{"aaaa\nbb\ncc\n", 4, "cc\n"},
+	}
+	for _, c := range cases {
+		if got := string(tail([]byte(c.in), c.max)); got != c.want {
+			t.Errorf("tail(%q, %d) = %q, want %q", c.in, c.max, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package logging gives each component a levelled logger tagged with
+// its name, on top of log/slog. Output goes to stderr: with a level
+// prefix journald turns into the entry's priority when running under
+// systemd, with a timestamp otherwise.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ParseLevel parses a LOG_LEVEL value: debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// Setup makes a stderr handler at level the slog default and routes the
+// standard log package through it, so code still calling log.Printf is
+// filtered too. Those lines get their level from the message: "Warning"
+// is a warning, "Error" or "Failed" an error, anything else info.
+func Setup(level slog.Level) *slog.Logger {
+	h := NewHandler(os.Stderr, level, os.Getenv("JOURNAL_STREAM") != "")
+	l := slog.New(h)
+	slog.SetDefault(l)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdBridge{l: l})
+	return l
+}
+
+// Logger is a component's logger. The zero value and nil log to the slog
+// default without a component, so structs built without a constructor
+// (as in tests) can still log.
+type Logger struct {
+	l *slog.Logger
+}
+
+// New returns a logger for component writing through l.
+func New(l *slog.Logger, component string) *Logger {
+	return &Logger{l: l.With("component", component)}
+}
+
+// For returns a logger for component writing through whatever the slog
+// default is when it logs; components start out with one of these.
+func For(component string) *Logger {
+	return &Logger{l: slog.New(deferredHandler{}).With("component", component)}
+}
+
+func (g *Logger) logf(level slog.Level, format string, args ...any) {
+	l := slog.Default()
+	if g != nil && g.l != nil {
+		l = g.l
+	}
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+func (g *Logger) Debugf(format string, args ...any) { g.logf(slog.LevelDebug, format, args...) }
+func (g *Logger) Infof(format string, args ...any)  { g.logf(slog.LevelInfo, format, args...) }
+func (g *Logger) Warnf(format string, args ...any)  { g.logf(slog.LevelWarn, format, args...) }
+func (g *Logger) Errorf(format string, args ...any) { g.logf(slog.LevelError, format, args...) }
+
+// WouldRun logs the command a dry run skips in place of running it.
+func (g *Logger) WouldRun(name string, args ...string) {
+	g.Infof("would run: %s", strings.Join(append([]string{name}, args...), " "))
+}
+
+// deferredHandler hands records to the slog default at the time they're
+// logged rather than when the logger was made.
+type deferredHandler struct {
+	attrs []slog.Attr
+}
+
+func (d deferredHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (d deferredHandler) Handle(ctx context.Context, r slog.Record) error {
+	return slog.Default().Handler().WithAttrs(d.attrs).Handle(ctx, r)
+}
+
+func (d deferredHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return deferredHandler{attrs: append(append([]slog.Attr(nil), d.attrs...), attrs...)}
+}
+
+func (d deferredHandler) WithGroup(string) slog.Handler { return d }
+
+// Handler writes one line per record: the level, the component in
+// brackets, the message and any other attributes as key=value. For
+// journald the level is a <N> syslog priority prefix and the timestamp
+// is left to the journal.
+type Handler struct {
+	w        io.Writer
+	mu       *sync.Mutex
+	level    slog.Leveler
+	journald bool
+	attrs    []slog.Attr
+}
+
+func NewHandler(w io.Writer, level slog.Leveler, journald bool) *Handler {
+	return &Handler{w: w, mu: &sync.Mutex{}, level: level, journald: journald}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.journald {
+		fmt.Fprintf(&b, "<%d>", syslogPriority(r.Level))
+	} else {
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05.000000 "))
+		b.WriteString(r.Level.String())
+		b.WriteByte(' ')
+	}
+	var rest []slog.Attr
+	addAttr := func(a slog.Attr) bool {
+		if a.Key == "component" {
+			fmt.Fprintf(&b, "[%s] ", a.Value)
+		} else {
+			rest = append(rest, a)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		addAttr(a)
+	}
+	r.Attrs(addAttr)
+	b.WriteString(r.Message)
+	for _, a := range rest {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup is a no-op: nothing here logs grouped attributes.
+func (h *Handler) WithGroup(string) slog.Handler { return h }
+
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// stdBridge carries standard log package output into slog.
+type stdBridge struct {
+	l *slog.Logger
+}
+
+func (s *stdBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := stdLevel(msg)
+	if s.l.Enabled(context.Background(), level) {
+		s.l.Log(context.Background(), level, msg)
+	}
+	return len(p), nil
+}
+
+func stdLevel(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "Warning"):
+		return slog.LevelWarn
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "Failed"):
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
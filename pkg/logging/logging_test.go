@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    slog.Level
+		wantErr bool
+	}{
+		{in: "", want: slog.LevelInfo},
+		{in: "debug", want: slog.LevelDebug},
+		{in: "INFO", want: slog.LevelInfo},
+		{in: "warn", want: slog.LevelWarn},
+		{in: "warning", want: slog.LevelWarn},
+		{in: " error\n", want: slog.LevelError},
+		{in: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		journald bool
+		log      func(*Logger)
+		want     string
+	}{
+		{
+			name:     "journald priority",
+			journald: true,
+			log:      func(l *Logger) { l.Warnf("disk %s", "full") },
+			want:     "<4>[disk] disk full\n",
+		},
+		{
+			name:     "error priority",
+			journald: true,
+			log:      func(l *Logger) { l.Errorf("boom") },
+			want:     "<3>[disk] boom\n",
+		},
+		{
+			name:     "below level",
+			journald: true,
+			log:      func(l *Logger) { l.Debugf("noise") },
+			want:     "",
+		},
+		{
+			name: "plain",
+			log:  func(l *Logger) { l.Infof("mounted") },
+			want: "INFO [disk] mounted\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(New(slog.New(NewHandler(&buf, slog.LevelInfo, tt.journald)), "disk"))
+
+			got := buf.String()
+			if !tt.journald && got != "" {
+				// Drop the timestamp.
+				if i := strings.Index(got, "INFO"); i >= 0 {
+					got = got[i:]
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestForFollowsDefault checks that a logger made before Setup writes
+// through the handler installed later.
+func TestForFollowsDefault(t *testing.T) {
+	l := For("usb")
+
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(NewHandler(&buf, slog.LevelWarn, true)))
+
+	l.Infof("filtered")
+	l.Warnf("link degraded")
+	if got, want := buf.String(), "<4>[usb] link degraded\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStdLevel(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want slog.Level
+	}{
+		{"Warning: drive nearly full", slog.LevelWarn},
+		{"Error switching mode: boom", slog.LevelError},
+		{"Failed to publish status", slog.LevelError},
+		{"Switching to ums mode", slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		if got := stdLevel(tt.msg); got != tt.want {
+			t.Errorf("stdLevel(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package manifest writes manifest.json to the drive root: what each area
+// of the drive is for, which files the service picks up from it, and what
+// it currently holds. The phone app builds its drive UI from it.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+// FileName is the manifest's name at the drive root.
+const FileName = "manifest.json"
+
+// Version is bumped whenever the format changes incompatibly.
+const Version = 1
+
+type Manifest struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"` // hash used for File.Hash
+	Areas     []Area `json:"areas"`
+}
+
+// Area is one top-level file or directory on the drive.
+type Area struct {
+	Path    string `json:"path"` // relative to the drive root
+	Dir     bool   `json:"dir"`
+	Purpose string `json:"purpose"`
+	// Accepts lists the name patterns the service processes from the
+	// area. Empty for areas written for the user to copy off.
+	Accepts  []string `json:"accepts"`
+	ReadOnly bool     `json:"read-only"`
+	Files    []File   `json:"files"`
+}
+
+// File is one file currently in an area. Name is relative to the area's
+// directory (or the file's own name for a file area).
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// Build fills in the current contents of areas under mountPoint. An area
+// that doesn't exist on the drive is listed with no files.
+func Build(mountPoint string, areas []Area, algo checksum.Algorithm) (Manifest, error) {
+	m := Manifest{Version: Version, Algorithm: string(algo), Areas: make([]Area, 0, len(areas))}
+	for _, a := range areas {
+		if a.Accepts == nil {
+			a.Accepts = []string{}
+		}
+		files, err := listFiles(filepath.Join(mountPoint, a.Path), a.Dir, algo)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("%s: %w", a.Path, err)
+		}
+		a.Files = files
+		m.Areas = append(m.Areas, a)
+	}
+	return m, nil
+}
+
+func listFiles(path string, dir bool, algo checksum.Algorithm) ([]File, error) {
+	files := []File{}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return files, nil
+	}
+
+	if !dir {
+		f, err := describe(path, filepath.Base(path), algo)
+		if err != nil {
+			return nil, err
+		}
+		return append(files, f), nil
+	}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		f, err := describe(p, filepath.ToSlash(rel), algo)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func describe(path, name string, algo checksum.Algorithm) (File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return File{}, err
+	}
+	hash, err := algo.File(path)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Size: info.Size(), Hash: hash}, nil
+}
+
+// Write stores m as FileName under mountPoint.
+func Write(mountPoint string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(mountPoint, FileName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", FileName, err)
+	}
+	return nil
+}
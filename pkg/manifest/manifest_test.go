@@ -0,0 +1,93 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+func TestBuildAndWrite(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "settings.toml"), "[scooter]\n")
+	writeFile(t, filepath.Join(root, "maps", "tiles.mbtiles"), "mbtiles")
+	writeFile(t, filepath.Join(root, "rpms", "mdb", "foo.rpm"), "rpm")
+	if err := os.MkdirAll(filepath.Join(root, "rpms", "dbc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	areas := []Area{
+		{Path: "settings.toml", Purpose: "settings", Accepts: []string{"settings.toml"}},
+		{Path: "maps", Dir: true, Purpose: "maps", Accepts: []string{"*.mbtiles"}},
+		{Path: "rpms", Dir: true, Purpose: "packages", Accepts: []string{"mdb/*.rpm", "dbc/*.rpm"}},
+		{Path: "scripts", Dir: true, Purpose: "scripts", Accepts: []string{"mdb.sh"}},
+		{Path: "logs", Dir: true, Purpose: "journal export", ReadOnly: true},
+	}
+	m, err := Build(root, areas, checksum.SHA256)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if m.Version != Version || m.Algorithm != "sha256" || len(m.Areas) != len(areas) {
+		t.Fatalf("Build = %+v", m)
+	}
+
+	hash := func(s string) string {
+		h, err := checksum.SHA256.Reader(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	wantFiles := [][]File{
+		{{Name: "settings.toml", Size: 10, Hash: hash("[scooter]\n")}},
+		{{Name: "tiles.mbtiles", Size: 7, Hash: hash("mbtiles")}},
+		{{Name: "mdb/foo.rpm", Size: 3, Hash: hash("rpm")}},
+		{},
+		{},
+	}
+	for i, a := range m.Areas {
+		if !reflect.DeepEqual(a.Files, wantFiles[i]) {
+			t.Errorf("%s: files = %+v, want %+v", a.Path, a.Files, wantFiles[i])
+		}
+	}
+
+	if err := Write(root, m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Manifest
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("manifest.json doesn't parse: %v", err)
+	}
+	if !reflect.DeepEqual(back, m) {
+		t.Errorf("round trip = %+v, want %+v", back, m)
+	}
+	// The app iterates these; they must be arrays, not null.
+	var raw struct {
+		Areas []map[string]json.RawMessage `json:"areas"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	logs := raw.Areas[4]
+	if string(logs["files"]) != "[]" || string(logs["accepts"]) != "[]" {
+		t.Errorf("empty lists encoded as files=%s accepts=%s", logs["files"], logs["accepts"])
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+package maps
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/dbc"
+)
+
+// freeSpaceCommand prints the space available to remoteDir's filesystem.
+// -P keeps each filesystem on one line and -k fixes the unit, which
+// BusyBox df supports as well as coreutils.
+func freeSpaceCommand(remoteDir string) string {
+	return "df -Pk " + dbc.ShellQuote(remoteDir)
+}
+
+// parseFreeSpace reads the available bytes from df -Pk output: the
+// fourth column of the last line, in KiB.
+func parseFreeSpace(out string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	kib, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output %q: %w", out, err)
+	}
+	return kib * 1024, nil
+}
+
+// checkFreeSpace fails with dbc.ErrDBCDiskFull if remoteDir can't hold
+// localPath, before anything on the DBC is touched. The file being
+// replaced isn't counted as free: it stays in place until the upload has
+// finished, and is kept aside while a verify command runs. A DBC whose
+// free space can't be read is given the benefit of the doubt.
+func (u *Updater) checkFreeSpace(ctx context.Context, localPath, remoteDir, name string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	out, err := u.dbcInterface.RunCommand(ctx, freeSpaceCommand(remoteDir))
+	if err != nil {
+		u.log.Warnf("could not check free space on DBC: %v", err)
+		return nil
+	}
+	avail, err := parseFreeSpace(out)
+	if err != nil {
+		u.log.Warnf("could not check free space on DBC: %v", err)
+		return nil
+	}
+	if avail < info.Size() {
+		return fmt.Errorf("insufficient space on DBC for %s: %d bytes needed, %d available: %w",
+			name, info.Size(), avail, dbc.ErrDBCDiskFull)
+	}
+	return nil
+}
@@ -3,32 +3,66 @@ package maps
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/librescoot/ums-service/pkg/dbc"
+	"github.com/librescoot/ums-service/pkg/logging"
+	"github.com/librescoot/ums-service/pkg/metrics"
 	"github.com/librescoot/ums-service/pkg/umslog"
 )
 
+// dbcTarget is the part of dbc.Interface the updater uses.
+type dbcTarget interface {
+	IsEnabled() bool
+	RunCommand(ctx context.Context, command string) (string, error)
+	SyncFile(ctx context.Context, localPath, remotePath string, progressCb dbc.ProgressFunc) error
+	RsyncEnabled() bool
+	ApplyOwnership(ctx context.Context, remotePath string) error
+	VerifyConfigured() bool
+	Verify(ctx context.Context, kind, remotePath string) error
+}
+
 type Updater struct {
 	dbcMapsDir     string
 	dbcValhallaDir string
-	dbcInterface   *dbc.Interface
+	dbcInterface   dbcTarget
+	// transfers counts map files installed; see SetTransferCounter.
+	transfers *metrics.Counter
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger the updater writes to.
+func (u *Updater) SetLogger(logger *logging.Logger) {
+	u.log = logger
+}
+
+// SetTransferCounter sets a counter ProcessMaps increments for each map
+// file installed on the DBC.
+func (u *Updater) SetTransferCounter(c *metrics.Counter) {
+	u.transfers = c
 }
 
+// rollbackTimeout bounds restoring the previous file after a failed
+// install; it's a rename on the DBC.
+const rollbackTimeout = 30 * time.Second
+
 func isValhallaTilesArchive(filename string) bool {
 	return strings.HasSuffix(filename, "tiles.tar") ||
 		(strings.HasPrefix(filename, "valhalla_tiles_") && strings.HasSuffix(filename, ".tar"))
 }
 
-func New(dbcInterface *dbc.Interface) *Updater {
+// New installs maps on the DBC: map files in mapsDir, Valhalla routing
+// tiles in valhallaDir (normally /data/maps and /data/valhalla).
+func New(dbcInterface *dbc.Interface, mapsDir, valhallaDir string) *Updater {
 	return &Updater{
-		dbcMapsDir:     "/data/maps",
-		dbcValhallaDir: "/data/valhalla",
+		dbcMapsDir:     mapsDir,
+		dbcValhallaDir: valhallaDir,
 		dbcInterface:   dbcInterface,
+		log:            logging.For("maps"),
 	}
 }
 
@@ -37,7 +71,7 @@ func (u *Updater) PrepareUSB(usbMountPath string) error {
 	if err := os.MkdirAll(mapsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create maps directory: %w", err)
 	}
-	log.Println("Created maps directory on USB drive")
+	u.log.Infof("Created maps directory on USB drive")
 	return nil
 }
 
@@ -45,24 +79,32 @@ func (u *Updater) PrepareUSB(usbMountPath string) error {
 // DBC. The supplied context bounds the **entire** map processing phase;
 // per-file transfers run under child contexts derived from perFileTimeout
 // so one slow file can't starve later ones. If logger is non-nil, upload
-// progress is published to the `usb` hash for the UI.
-func (u *Updater) ProcessMaps(ctx context.Context, perFileTimeout time.Duration, logger *umslog.Logger, usbMountPath string) error {
+// progress is published to the `usb` hash for the UI. The returned bool
+// reports whether any map file reached the DBC, even if a later one failed.
+// A non-nil only limits it to the map files named there.
+func (u *Updater) ProcessMaps(ctx context.Context, perFileTimeout time.Duration, logger *umslog.Logger, usbMountPath string, only []string) (bool, error) {
 	mapsDir := filepath.Join(usbMountPath, "maps")
 
 	entries, err := os.ReadDir(mapsDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Println("No maps directory found")
-			return nil
+			u.log.Infof("No maps directory found")
+			return false, nil
 		}
-		return fmt.Errorf("failed to read maps directory: %w", err)
+		return false, fmt.Errorf("failed to read maps directory: %w", err)
 	}
 
 	if !u.dbcInterface.IsEnabled() {
-		return fmt.Errorf("DBC interface not enabled for map updates")
+		return false, fmt.Errorf("DBC interface not enabled for map updates")
 	}
 
 	var mbtilesFile, tilesFile string
+	var transferred bool
+
+	wanted := make(map[string]bool, len(only))
+	for _, name := range only {
+		wanted[name] = true
+	}
 
 	// Find map files
 	for _, entry := range entries {
@@ -71,6 +113,13 @@ func (u *Updater) ProcessMaps(ctx context.Context, perFileTimeout time.Duration,
 		}
 
 		filename := entry.Name()
+		if only != nil {
+			if !wanted[filename] {
+				u.log.Infof("Skipping %s, not listed for installation", filename)
+				continue
+			}
+			delete(wanted, filename)
+		}
 		if strings.HasSuffix(filename, ".mbtiles") {
 			mbtilesFile = filepath.Join(mapsDir, filename)
 		} else if isValhallaTilesArchive(filename) {
@@ -78,67 +127,144 @@ func (u *Updater) ProcessMaps(ctx context.Context, perFileTimeout time.Duration,
 		}
 	}
 
+	for name := range wanted {
+		u.log.Warnf("Map file %s is listed for installation but not on the drive", name)
+	}
+
 	if mbtilesFile != "" {
 		if err := u.processMBTiles(ctx, perFileTimeout, logger, mbtilesFile); err != nil {
-			return fmt.Errorf("failed to process mbtiles: %w", err)
+			return false, fmt.Errorf("failed to process mbtiles: %w", err)
 		}
+		transferred = true
 	}
 
 	if tilesFile != "" {
 		if err := u.processTilesTar(ctx, perFileTimeout, logger, tilesFile); err != nil {
-			return fmt.Errorf("failed to process tiles.tar: %w", err)
+			return transferred, fmt.Errorf("failed to process tiles.tar: %w", err)
 		}
+		transferred = true
 	}
 
 	if mbtilesFile == "" && tilesFile == "" {
-		log.Println("No map files found to process")
+		u.log.Infof("No map files found to process")
 	}
 
-	return nil
+	return transferred, nil
 }
 
 func (u *Updater) processMBTiles(ctx context.Context, timeout time.Duration, logger *umslog.Logger, localPath string) error {
+	if err := checkMBTiles(localPath); err != nil {
+		u.log.Errorf("Rejecting map file: %v", err)
+		return err
+	}
+	return u.install(ctx, timeout, logger, localPath, u.dbcMapsDir, "map.mbtiles", "mbtiles")
+}
+
+func (u *Updater) processTilesTar(ctx context.Context, timeout time.Duration, logger *umslog.Logger, localPath string) error {
+	if err := checkTilesTar(localPath); err != nil {
+		u.log.Errorf("Rejecting map file: %v", err)
+		return err
+	}
+	return u.install(ctx, timeout, logger, localPath, u.dbcValhallaDir, "tiles.tar", "tiles")
+}
+
+// install transfers localPath to remoteDir/name on the DBC, provided the
+// DBC has room for it. With a verify command configured, the file it
+// replaces is kept aside until the command has accepted the new one, and
+// put back if it doesn't. With rsync, the file kept aside is a hard link,
+// so the old file stays in place for rsync to send only what changed.
+func (u *Updater) install(ctx context.Context, timeout time.Duration, logger *umslog.Logger, localPath, remoteDir, name, kind string) error {
 	opCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	if _, err := u.dbcInterface.RunCommand(opCtx, fmt.Sprintf("mkdir -p %s", u.dbcMapsDir)); err != nil {
-		return fmt.Errorf("failed to create remote maps directory: %w", err)
+	if _, err := u.dbcInterface.RunCommand(opCtx, "mkdir -p "+dbc.ShellQuote(remoteDir)); err != nil {
+		return fmt.Errorf("failed to create remote %s directory: %w", filepath.Base(remoteDir), err)
+	}
+	if err := u.checkFreeSpace(opCtx, localPath, remoteDir, name); err != nil {
+		return err
 	}
 
-	remotePath := filepath.Join(u.dbcMapsDir, "map.mbtiles")
+	remotePath := filepath.Join(remoteDir, name)
+	backupPath := ""
+	if u.dbcInterface.VerifyConfigured() {
+		backupPath = remotePath + ".prev"
+		cmd := backupCommand(remotePath, backupPath)
+		if u.dbcInterface.RsyncEnabled() {
+			cmd = linkBackupCommand(remotePath, backupPath)
+		}
+		if _, err := u.dbcInterface.RunCommand(opCtx, cmd); err != nil {
+			return fmt.Errorf("failed to set aside previous %s: %w", name, err)
+		}
+	}
 
 	var progress dbc.ProgressFunc
 	if logger != nil {
-		progress = logger.ProgressCallback("map.mbtiles")
+		progress = logger.ProgressCallback(name)
 		defer logger.ClearProgress()
 	}
-	if err := u.dbcInterface.TransferFile(opCtx, localPath, remotePath, progress); err != nil {
-		return fmt.Errorf("failed to transfer mbtiles to DBC: %w", err)
+	if err := u.dbcInterface.SyncFile(opCtx, localPath, remotePath, progress); err != nil {
+		u.rollback(opCtx, remotePath, backupPath)
+		return fmt.Errorf("failed to transfer %s to DBC: %w", kind, err)
+	}
+	if err := u.dbcInterface.ApplyOwnership(opCtx, remotePath); err != nil {
+		u.log.Warnf("%v", err)
 	}
 
-	log.Printf("Successfully copied mbtiles to DBC at %s", remotePath)
+	if err := u.dbcInterface.Verify(opCtx, kind, remotePath); err != nil {
+		u.rollback(opCtx, remotePath, backupPath)
+		return fmt.Errorf("%w; previous %s restored", err, name)
+	}
+	if backupPath != "" {
+		if _, err := u.dbcInterface.RunCommand(opCtx, "rm -f "+dbc.ShellQuote(backupPath)); err != nil {
+			u.log.Warnf("failed to remove %s: %v", backupPath, err)
+		}
+	}
+
+	u.transfers.Inc()
+	u.log.Infof("Successfully copied %s to DBC at %s", kind, remotePath)
 	return nil
 }
 
-func (u *Updater) processTilesTar(ctx context.Context, timeout time.Duration, logger *umslog.Logger, localPath string) error {
-	opCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// backupCommand moves remotePath aside to backupPath, or clears a stale
+// backupPath if there's nothing to keep.
+func backupCommand(remotePath, backupPath string) string {
+	return swapCommand(dbc.ShellQuote(remotePath), dbc.ShellQuote(backupPath))
+}
 
-	if _, err := u.dbcInterface.RunCommand(opCtx, fmt.Sprintf("mkdir -p %s", u.dbcValhallaDir)); err != nil {
-		return fmt.Errorf("failed to create remote valhalla directory: %w", err)
-	}
+// linkBackupCommand is backupCommand for rsync: it leaves remotePath in
+// place and hard-links it to backupPath. rsync, and SyncFile falling back
+// to a whole transfer, replace remotePath with a new file, so the link
+// keeps the old content.
+func linkBackupCommand(remotePath, backupPath string) string {
+	from, to := dbc.ShellQuote(remotePath), dbc.ShellQuote(backupPath)
+	return fmt.Sprintf("if [ -e %s ]; then ln -f %s %s; else rm -f %s; fi", from, from, to, to)
+}
 
-	remotePath := filepath.Join(u.dbcValhallaDir, "tiles.tar")
+// rollbackCommand puts backupPath back at remotePath, or removes
+// remotePath if there was nothing before it.
+func rollbackCommand(remotePath, backupPath string) string {
+	return swapCommand(dbc.ShellQuote(backupPath), dbc.ShellQuote(remotePath))
+}
 
-	var progress dbc.ProgressFunc
-	if logger != nil {
-		progress = logger.ProgressCallback("tiles.tar")
-		defer logger.ClearProgress()
+// swapCommand moves the quoted path from to to, or removes to if from
+// doesn't exist.
+func swapCommand(from, to string) string {
+	return fmt.Sprintf("if [ -e %s ]; then mv -f %s %s; else rm -f %s; fi", from, from, to, to)
+}
+
+// rollback undoes a failed install. Without a backup (no verify command)
+// there's nothing to restore, and the failed transfer cleans up after
+// itself.
+func (u *Updater) rollback(ctx context.Context, remotePath, backupPath string) {
+	if backupPath == "" {
+		return
 	}
-	if err := u.dbcInterface.TransferFile(opCtx, localPath, remotePath, progress); err != nil {
-		return fmt.Errorf("failed to transfer tiles.tar to DBC: %w", err)
+	// The operation's context may be what just expired.
+	rbCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	if _, err := u.dbcInterface.RunCommand(rbCtx, rollbackCommand(remotePath, backupPath)); err != nil {
+		u.log.Errorf("Error restoring previous %s on DBC: %v", filepath.Base(remotePath), err)
+		return
 	}
-
-	log.Printf("Successfully copied tiles.tar to DBC at %s", remotePath)
-	return nil
+	u.log.Infof("Restored previous %s on DBC", filepath.Base(remotePath))
 }
@@ -0,0 +1,251 @@
+package maps
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/dbc"
+	"github.com/librescoot/ums-service/pkg/metrics"
+)
+
+func TestProcessMapsWithoutMapsDir(t *testing.T) {
+	u := &Updater{}
+	transferred, err := u.ProcessMaps(context.Background(), time.Minute, nil, t.TempDir(), nil)
+	if err != nil || transferred {
+		t.Errorf("ProcessMaps = %v, %v; want false, nil", transferred, err)
+	}
+}
+
+// fakeDBC records what the updater asks of the DBC.
+type fakeDBC struct {
+	verify    bool
+	rsync     bool
+	verifyErr error
+	df        string
+	calls     []string
+}
+
+func (f *fakeDBC) IsEnabled() bool { return true }
+
+func (f *fakeDBC) RunCommand(_ context.Context, command string) (string, error) {
+	f.calls = append(f.calls, "run "+command)
+	if strings.HasPrefix(command, "df ") {
+		return f.df, nil
+	}
+	return "", nil
+}
+
+func (f *fakeDBC) SyncFile(_ context.Context, _, remotePath string, _ dbc.ProgressFunc) error {
+	f.calls = append(f.calls, "transfer "+remotePath)
+	return nil
+}
+
+func (f *fakeDBC) RsyncEnabled() bool { return f.rsync }
+
+func (f *fakeDBC) ApplyOwnership(context.Context, string) error { return nil }
+
+func (f *fakeDBC) VerifyConfigured() bool { return f.verify }
+
+func (f *fakeDBC) Verify(_ context.Context, kind, remotePath string) error {
+	if !f.verify {
+		return nil
+	}
+	f.calls = append(f.calls, "verify "+kind+" "+remotePath)
+	return f.verifyErr
+}
+
+func TestProcessMapsVerification(t *testing.T) {
+	const (
+		remote = "/data/maps/map.mbtiles"
+		backup = "/data/maps/map.mbtiles.prev"
+	)
+	tests := []struct {
+		name      string
+		verify    bool
+		rsync     bool
+		verifyErr error
+		wantErr   bool
+		wantCalls []string
+	}{
+		{
+			name: "no verify command",
+			wantCalls: []string{
+				"run mkdir -p /data/maps",
+				"run df -Pk /data/maps",
+				"transfer " + remote,
+			},
+		},
+		{
+			name:   "verified",
+			verify: true,
+			wantCalls: []string{
+				"run mkdir -p /data/maps",
+				"run df -Pk /data/maps",
+				"run " + backupCommand(remote, backup),
+				"transfer " + remote,
+				"verify mbtiles " + remote,
+				"run rm -f " + backup,
+			},
+		},
+		{
+			name:   "verified, with rsync",
+			verify: true,
+			rsync:  true,
+			wantCalls: []string{
+				"run mkdir -p /data/maps",
+				"run df -Pk /data/maps",
+				"run " + linkBackupCommand(remote, backup),
+				"transfer " + remote,
+				"verify mbtiles " + remote,
+				"run rm -f " + backup,
+			},
+		},
+		{
+			name:      "rejected and rolled back",
+			verify:    true,
+			verifyErr: errors.New("exit status 1"),
+			wantErr:   true,
+			wantCalls: []string{
+				"run mkdir -p /data/maps",
+				"run df -Pk /data/maps",
+				"run " + backupCommand(remote, backup),
+				"transfer " + remote,
+				"verify mbtiles " + remote,
+				"run " + rollbackCommand(remote, backup),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mount := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(mount, "maps"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(mount, "maps", "berlin.mbtiles"), mbtilesImage(4), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f := &fakeDBC{verify: tt.verify, rsync: tt.rsync, verifyErr: tt.verifyErr}
+			u := &Updater{dbcMapsDir: "/data/maps", dbcValhallaDir: "/data/valhalla", dbcInterface: f}
+
+			transferred, err := u.ProcessMaps(context.Background(), time.Minute, nil, mount, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ProcessMaps error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if transferred == tt.wantErr {
+				t.Errorf("transferred = %v", transferred)
+			}
+			if !reflect.DeepEqual(f.calls, tt.wantCalls) {
+				t.Errorf("calls:\n got %q\nwant %q", f.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBackupAndRollbackCommands(t *testing.T) {
+	got := backupCommand("/data/maps/map.mbtiles", "/data/maps/map.mbtiles.prev")
+	want := `if [ -e /data/maps/map.mbtiles ]; then mv -f /data/maps/map.mbtiles /data/maps/map.mbtiles.prev; else rm -f /data/maps/map.mbtiles.prev; fi`
+	if got != want {
+		t.Errorf("backupCommand = %q, want %q", got, want)
+	}
+	got = rollbackCommand("/data/maps/map.mbtiles", "/data/maps/map.mbtiles.prev")
+	want = `if [ -e /data/maps/map.mbtiles.prev ]; then mv -f /data/maps/map.mbtiles.prev /data/maps/map.mbtiles; else rm -f /data/maps/map.mbtiles; fi`
+	if got != want {
+		t.Errorf("rollbackCommand = %q, want %q", got, want)
+	}
+	got = backupCommand("/data/maps/berlin (old).mbtiles", "/data/maps/berlin (old).mbtiles.prev")
+	want = `if [ -e '/data/maps/berlin (old).mbtiles' ]; then mv -f '/data/maps/berlin (old).mbtiles' '/data/maps/berlin (old).mbtiles.prev'; else rm -f '/data/maps/berlin (old).mbtiles.prev'; fi`
+	if got != want {
+		t.Errorf("backupCommand = %q, want %q", got, want)
+	}
+}
+
+func TestParseFreeSpace(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    int64
+		wantErr bool
+	}{
+		{
+			name: "coreutils",
+			out:  "Filesystem     1024-blocks    Used Available Capacity Mounted on\n/dev/mmcblk0p4     3023760 1020004   1847676      36% /data\n",
+			want: 1847676 * 1024,
+		},
+		{
+			name: "long device name",
+			out:  "Filesystem           1024-blocks      Used Available Capacity Mounted on\n/dev/disk/by-partlabel/data 3023760 1020004 12 1% /data",
+			want: 12 * 1024,
+		},
+		{name: "empty", out: "", wantErr: true},
+		{name: "header only", out: "Filesystem 1024-blocks Used Available Capacity Mounted on", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFreeSpace(tt.out)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseFreeSpace = %d, %v; want %d, err %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessMapsInsufficientSpace(t *testing.T) {
+	mount := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(mount, "maps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mount, "maps", "berlin.mbtiles"), mbtilesImage(2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeDBC{verify: true, df: "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/root 4 3 1 75% /data\n"}
+	u := &Updater{dbcMapsDir: "/data/maps", dbcValhallaDir: "/data/valhalla", dbcInterface: f}
+
+	transferred, err := u.ProcessMaps(context.Background(), time.Minute, nil, mount, nil)
+	if !errors.Is(err, dbc.ErrDBCDiskFull) {
+		t.Errorf("ProcessMaps error = %v, want ErrDBCDiskFull", err)
+	}
+	if transferred {
+		t.Error("transferred = true")
+	}
+	// The existing map must not have been moved aside or overwritten.
+	want := []string{"run mkdir -p /data/maps", "run df -Pk /data/maps"}
+	if !reflect.DeepEqual(f.calls, want) {
+		t.Errorf("calls:\n got %q\nwant %q", f.calls, want)
+	}
+}
+
+func TestProcessMapsOnlyListed(t *testing.T) {
+	mount := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(mount, "maps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"berlin.mbtiles": mbtilesImage(4), "tiles.tar": tilesArchive(t)}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(mount, "maps", name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := &fakeDBC{}
+	u := &Updater{dbcMapsDir: "/data/maps", dbcValhallaDir: "/data/valhalla", dbcInterface: f}
+	var transfers metrics.Counter
+	u.SetTransferCounter(&transfers)
+
+	transferred, err := u.ProcessMaps(context.Background(), time.Minute, nil, mount, []string{"tiles.tar", "missing.mbtiles"})
+	if err != nil || !transferred {
+		t.Fatalf("ProcessMaps = %v, %v; want true, nil", transferred, err)
+	}
+	if n := transfers.Load(); n != 1 {
+		t.Errorf("counted %d transfers, want 1", n)
+	}
+	for _, call := range f.calls {
+		if strings.HasPrefix(call, "transfer ") && call != "transfer /data/valhalla/tiles.tar" {
+			t.Errorf("unlisted file transferred: %q", call)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package maps
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrIncompleteMap means a map file on the drive is cut short or isn't
+// what its name says, typically because the drive was pulled before the
+// copy finished. It is not transferred.
+var ErrIncompleteMap = errors.New("map file is incomplete or corrupt")
+
+// sqliteMagic starts every SQLite database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqliteHeaderSize is the size of the database header; the fields
+// checkMBTiles reads are all in it.
+const sqliteHeaderSize = 100
+
+// tarBlockSize is the size of a tar header and of the padding unit.
+const tarBlockSize = 512
+
+// checkMBTiles checks that the .mbtiles file at path is a whole SQLite
+// database: it has to start with the SQLite header, and be as long as
+// the header says the database is. A copy that was cut short fails the
+// second check even if its header made it.
+func checkMBTiles(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+
+	header := make([]byte, sqliteHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(sqliteMagic)]) != sqliteMagic {
+		return fmt.Errorf("%w: %s is not an SQLite database", ErrIncompleteMap, name)
+	}
+	pageSize := int64(binary.BigEndian.Uint16(header[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return fmt.Errorf("%w: %s has an invalid page size %d", ErrIncompleteMap, name, pageSize)
+	}
+	// The page count in the header is only current if whatever last
+	// wrote the database also updated it, which the matching change
+	// counters (offsets 24 and 92) attest.
+	size := info.Size()
+	pages := int64(binary.BigEndian.Uint32(header[28:32]))
+	if pages > 0 && bytes.Equal(header[24:28], header[92:96]) {
+		if want := pages * pageSize; size < want {
+			return fmt.Errorf("%w: %s has %d of %d bytes", ErrIncompleteMap, name, size, want)
+		}
+	} else if size%pageSize != 0 {
+		return fmt.Errorf("%w: %s ends part way through a page", ErrIncompleteMap, name)
+	}
+	return nil
+}
+
+// checkTilesTar checks that the tar archive at path is whole: it has to
+// end with the two zero blocks that mark the end of an archive, and its
+// headers have to read back up to them. Entry contents are skipped, not
+// read.
+func checkTilesTar(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+
+	size := info.Size()
+	if size < 2*tarBlockSize || size%tarBlockSize != 0 {
+		return fmt.Errorf("%w: %s is not a whole tar archive (%d bytes)", ErrIncompleteMap, name, size)
+	}
+	trailer := make([]byte, 2*tarBlockSize)
+	if _, err := f.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer, make([]byte, len(trailer))) {
+		return fmt.Errorf("%w: %s has no end-of-archive marker", ErrIncompleteMap, name)
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrIncompleteMap, name, err)
+		}
+	}
+}
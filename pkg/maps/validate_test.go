@@ -0,0 +1,131 @@
+package maps
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mbtilesImage returns a database of pages 1 KiB pages with a current
+// page count in its header.
+func mbtilesImage(pages int) []byte {
+	db := make([]byte, pages*1024)
+	copy(db, sqliteMagic)
+	binary.BigEndian.PutUint16(db[16:], 1024)
+	binary.BigEndian.PutUint32(db[24:], 7)
+	binary.BigEndian.PutUint32(db[28:], uint32(pages))
+	binary.BigEndian.PutUint32(db[92:], 7)
+	return db
+}
+
+// tilesArchive returns a tar archive holding one tile file.
+func tilesArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tile := bytes.Repeat([]byte("t"), 1500)
+	if err := tw.WriteHeader(&tar.Header{Name: "2/000/000/000.gph", Mode: 0644, Size: int64(len(tile))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(tile); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckMBTiles(t *testing.T) {
+	stale := mbtilesImage(4)
+	binary.BigEndian.PutUint32(stale[92:], 6) // page count not current
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"whole", mbtilesImage(4), false},
+		{"truncated", mbtilesImage(4)[:3000], true},
+		{"truncated at a page", mbtilesImage(4)[:2048], true},
+		{"stale page count, whole pages", stale[:3072], false},
+		{"stale page count, partial page", stale[:3000], true},
+		{"shorter than the header", mbtilesImage(4)[:50], true},
+		{"not SQLite", []byte("tiles"), true},
+		{"zeroed", make([]byte, 4096), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "berlin.mbtiles")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := checkMBTiles(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkMBTiles = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIncompleteMap) {
+				t.Errorf("error %v is not ErrIncompleteMap", err)
+			}
+		})
+	}
+}
+
+func TestCheckTilesTar(t *testing.T) {
+	whole := tilesArchive(t)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"whole", whole, false},
+		{"truncated", whole[:1000], true},
+		{"truncated at a block", whole[:1536], true},
+		{"no end marker", whole[:2048], true},
+		{"not tar", bytes.Repeat([]byte("x"), 2048), true},
+		{"empty", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tiles.tar")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := checkTilesTar(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTilesTar = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIncompleteMap) {
+				t.Errorf("error %v is not ErrIncompleteMap", err)
+			}
+		})
+	}
+}
+
+func TestProcessMapsRejectsIncomplete(t *testing.T) {
+	mount := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(mount, "maps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mount, "maps", "berlin.mbtiles"), mbtilesImage(4)[:3000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeDBC{}
+	u := &Updater{dbcMapsDir: "/data/maps", dbcValhallaDir: "/data/valhalla", dbcInterface: f}
+
+	transferred, err := u.ProcessMaps(context.Background(), time.Minute, nil, mount, nil)
+	if !errors.Is(err, ErrIncompleteMap) {
+		t.Errorf("ProcessMaps error = %v, want ErrIncompleteMap", err)
+	}
+	if transferred {
+		t.Error("transferred = true")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("DBC touched: %q", f.calls)
+	}
+}
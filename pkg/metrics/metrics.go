@@ -0,0 +1,122 @@
+// Package metrics counts what the service has done since it started, for
+// fleet analytics: mode switches, what processing changed and what
+// failed. The counts live as long as the process and are safe for
+// concurrent use.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a count that only goes up. A nil Counter ignores
+// increments, so components can be handed one optionally.
+type Counter struct {
+	n atomic.Int64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n.
+func (c *Counter) Add(n int64) {
+	if c == nil {
+		return
+	}
+	c.n.Add(n)
+}
+
+// Load returns the count.
+func (c *Counter) Load() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.n.Load()
+}
+
+// Metrics holds the service's counters.
+type Metrics struct {
+	// UMSEntries and NormalReturns count switches into UMS mode and
+	// back to normal mode from it.
+	UMSEntries    Counter
+	NormalReturns Counter
+	// UpdatesApplied counts installs update-service reported done, one
+	// per board.
+	UpdatesApplied Counter
+	// MapsTransferred counts map files installed on the DBC.
+	MapsTransferred Counter
+	// WireGuardChanges counts WireGuard configs written or removed.
+	WireGuardChanges Counter
+	// SettingsChanges counts settings.toml changes applied.
+	SettingsChanges Counter
+
+	started time.Time
+
+	mu       sync.Mutex
+	failures map[string]int64
+}
+
+func New() *Metrics {
+	return &Metrics{started: time.Now(), failures: make(map[string]int64)}
+}
+
+// Fail counts a failure in category, e.g. "maps" or "drive".
+func (m *Metrics) Fail(category string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[category]++
+}
+
+// Snapshot is the counters at one point, as served by the health
+// endpoint.
+type Snapshot struct {
+	Since            time.Time        `json:"since"`
+	UMSEntries       int64            `json:"ums-entries"`
+	NormalReturns    int64            `json:"normal-returns"`
+	UpdatesApplied   int64            `json:"updates-applied"`
+	MapsTransferred  int64            `json:"maps-transferred"`
+	WireGuardChanges int64            `json:"wireguard-changes"`
+	SettingsChanges  int64            `json:"settings-changes"`
+	Failures         map[string]int64 `json:"failures"`
+}
+
+// Snapshot returns the current counts.
+func (m *Metrics) Snapshot() Snapshot {
+	s := Snapshot{
+		Since:            m.started.UTC().Truncate(time.Second),
+		UMSEntries:       m.UMSEntries.Load(),
+		NormalReturns:    m.NormalReturns.Load(),
+		UpdatesApplied:   m.UpdatesApplied.Load(),
+		MapsTransferred:  m.MapsTransferred.Load(),
+		WireGuardChanges: m.WireGuardChanges.Load(),
+		SettingsChanges:  m.SettingsChanges.Load(),
+		Failures:         make(map[string]int64),
+	}
+	m.mu.Lock()
+	for category, n := range m.failures {
+		s.Failures[category] = n
+	}
+	m.mu.Unlock()
+	return s
+}
+
+// String formats the counts for a log line.
+func (s Snapshot) String() string {
+	categories := make([]string, 0, len(s.Failures))
+	for category := range s.Failures {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	failures := make([]string, len(categories))
+	for n, category := range categories {
+		failures[n] = fmt.Sprintf("%s:%d", category, s.Failures[category])
+	}
+	return fmt.Sprintf("ums-entries=%d normal-returns=%d updates-applied=%d maps-transferred=%d wireguard-changes=%d settings-changes=%d failures=[%s]",
+		s.UMSEntries, s.NormalReturns, s.UpdatesApplied, s.MapsTransferred, s.WireGuardChanges, s.SettingsChanges, strings.Join(failures, " "))
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.UMSEntries.Inc()
+			m.Fail("maps")
+		}()
+	}
+	wg.Wait()
+	m.WireGuardChanges.Add(3)
+	m.Fail("drive")
+
+	s := m.Snapshot()
+	if s.UMSEntries != 10 || s.WireGuardChanges != 3 || s.Failures["maps"] != 10 || s.Failures["drive"] != 1 {
+		t.Errorf("snapshot = %+v", s)
+	}
+	want := "ums-entries=10 normal-returns=0 updates-applied=0 maps-transferred=0 wireguard-changes=3 settings-changes=0 failures=[drive:1 maps:10]"
+	if got := s.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// The snapshot is a copy.
+	m.Fail("drive")
+	if s.Failures["drive"] != 1 {
+		t.Error("snapshot changed with the counters")
+	}
+}
+
+func TestNilCounter(t *testing.T) {
+	var c *Counter
+	c.Inc()
+	if c.Load() != 0 {
+		t.Error("nil counter counted")
+	}
+}
@@ -3,12 +3,15 @@ package onboot
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/fsutil"
 )
 
 const (
@@ -18,13 +21,23 @@ const (
 
 type Manager struct {
 	srcPath string
+	// runner runs the interpreter that syntax-checks a new script.
+	runner command.Runner
+}
+
+func New(runner command.Runner) *Manager {
+	return &Manager{srcPath: scriptPath, runner: runner}
 }
 
-func New() *Manager {
-	return &Manager{srcPath: scriptPath}
+// Validate reports a script path that exists but isn't a regular file.
+func (m *Manager) Validate() error {
+	return fsutil.CheckFile(m.srcPath)
 }
 
 func (m *Manager) CopyToUSB(usbMountPath string) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
 	if _, err := os.Stat(m.srcPath); os.IsNotExist(err) {
 		log.Printf("onboot: %s does not exist, skipping", m.srcPath)
 		return nil
@@ -50,6 +63,10 @@ func (m *Manager) CopyToUSB(usbMountPath string) error {
 // that interpreter isn't installed). On any validation failure, the existing
 // script is left untouched.
 func (m *Manager) CopyFromUSB(usbMountPath string) (bool, error) {
+	if err := m.Validate(); err != nil {
+		return false, err
+	}
+
 	src := filepath.Join(usbMountPath, usbName)
 	if _, err := os.Stat(src); os.IsNotExist(err) {
 		return false, nil
@@ -60,18 +77,16 @@ func (m *Manager) CopyFromUSB(usbMountPath string) (bool, error) {
 		return false, fmt.Errorf("failed to read onboot.sh from USB: %w", err)
 	}
 
-	if existing, err := os.ReadFile(m.srcPath); err == nil {
-		if bytes.Equal(existing, input) {
-			log.Printf("onboot: onboot.sh unchanged")
-			// Make sure permissions are still correct even if content is unchanged.
-			if err := os.Chmod(m.srcPath, 0755); err != nil {
-				log.Printf("onboot: failed to chmod existing script: %v", err)
-			}
-			return false, nil
+	if fsutil.SameContent(m.srcPath, input) {
+		log.Printf("onboot: onboot.sh unchanged")
+		// Make sure permissions are still correct even if content is unchanged.
+		if err := os.Chmod(m.srcPath, 0755); err != nil {
+			log.Printf("onboot: failed to chmod existing script: %v", err)
 		}
+		return false, nil
 	}
 
-	if err := validate(input); err != nil {
+	if err := m.validate(input); err != nil {
 		log.Printf("onboot: validation failed, leaving existing script untouched: %v", err)
 		return false, nil
 	}
@@ -86,7 +101,7 @@ func (m *Manager) CopyFromUSB(usbMountPath string) (bool, error) {
 	return true, nil
 }
 
-func validate(content []byte) error {
+func (m *Manager) validate(content []byte) error {
 	if len(content) < 2 || content[0] != '#' || content[1] != '!' {
 		return fmt.Errorf("missing shebang")
 	}
@@ -96,14 +111,14 @@ func validate(content []byte) error {
 		return fmt.Errorf("could not parse shebang interpreter")
 	}
 
-	if err := runSyntaxCheck(interp, content); err == nil {
+	if err := m.runSyntaxCheck(interp, content); err == nil {
 		return nil
 	} else {
 		log.Printf("onboot: shebang interpreter %q syntax check failed: %v", interp, err)
 	}
 
 	if interp != "/bin/sh" {
-		if err := runSyntaxCheck("/bin/sh", content); err == nil {
+		if err := m.runSyntaxCheck("/bin/sh", content); err == nil {
 			return nil
 		} else {
 			return fmt.Errorf("syntax check failed (interp=%s, fallback=/bin/sh): %w", interp, err)
@@ -135,10 +150,8 @@ func parseShebangInterp(content []byte) string {
 	return fields[0]
 }
 
-func runSyntaxCheck(interp string, content []byte) error {
-	cmd := exec.Command(interp, "-n", "/dev/stdin")
-	cmd.Stdin = bytes.NewReader(content)
-	output, err := cmd.CombinedOutput()
+func (m *Manager) runSyntaxCheck(interp string, content []byte) error {
+	output, err := m.runner.RunInput(context.Background(), bytes.NewReader(content), interp, "-n", "/dev/stdin")
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
 	}
@@ -0,0 +1,47 @@
+package onboot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestCopyFromUSBSyntaxCheck(t *testing.T) {
+	const script = "#!/bin/bash\necho hello\n"
+	cases := []struct {
+		name        string
+		replies     map[string]commandtest.Reply
+		wantChanged bool
+	}{
+		{name: "passes", wantChanged: true},
+		{name: "fails", replies: map[string]commandtest.Reply{
+			"/bin/bash -n /dev/stdin": {Output: "syntax error", Err: errors.New("exit status 2")},
+			"/bin/sh -n /dev/stdin":   {Output: "syntax error", Err: errors.New("exit status 2")},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, usbName), []byte(script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := &commandtest.Recorder{Replies: c.replies}
+			m := New(r)
+			m.srcPath = filepath.Join(dir, "installed.sh")
+
+			changed, err := m.CopyFromUSB(dir)
+			if err != nil || changed != c.wantChanged {
+				t.Fatalf("CopyFromUSB = %v, %v; want %v, nil", changed, err, c.wantChanged)
+			}
+			if got := r.Input("/bin/bash -n /dev/stdin"); got != script {
+				t.Errorf("syntax check read %q, want the script", got)
+			}
+			if _, err := os.Stat(m.srcPath); (err == nil) != c.wantChanged {
+				t.Errorf("installed script present = %v, want %v", err == nil, c.wantChanged)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/librescoot/ums-service/pkg/fsutil"
 )
 
 const (
@@ -33,7 +35,18 @@ func (m *Manager) PrepareUSB(usbMountPath string) error {
 	return nil
 }
 
+// Validate reports a config path that exists with the wrong type.
+func (m *Manager) Validate() error {
+	if err := fsutil.CheckDir(configDir); err != nil {
+		return err
+	}
+	return fsutil.CheckFile(m.srcPath)
+}
+
 func (m *Manager) CopyToUSB(usbMountPath string) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
 	if _, err := os.Stat(m.srcPath); os.IsNotExist(err) {
 		log.Printf("radio-gaga: %s does not exist, skipping", m.srcPath)
 		return nil
@@ -53,6 +66,10 @@ func (m *Manager) CopyToUSB(usbMountPath string) error {
 
 // CopyFromUSB returns true if the on-device config changed.
 func (m *Manager) CopyFromUSB(usbMountPath string) (bool, error) {
+	if err := m.Validate(); err != nil {
+		return false, err
+	}
+
 	src := filepath.Join(usbMountPath, m.dirName, configFile)
 	if _, err := os.Stat(src); os.IsNotExist(err) {
 		return false, nil
@@ -63,11 +80,9 @@ func (m *Manager) CopyFromUSB(usbMountPath string) (bool, error) {
 		return false, fmt.Errorf("failed to read radio-gaga config from USB: %w", err)
 	}
 
-	if existing, err := os.ReadFile(m.srcPath); err == nil {
-		if string(existing) == string(input) {
-			log.Printf("radio-gaga: config.yaml unchanged")
-			return false, nil
-		}
+	if fsutil.SameContent(m.srcPath, input) {
+		log.Printf("radio-gaga: config.yaml unchanged")
+		return false, nil
 	}
 
 	if err := os.MkdirAll(configDir, 0755); err != nil {
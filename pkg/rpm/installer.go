@@ -5,25 +5,35 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/librescoot/ums-service/pkg/command"
 	"github.com/librescoot/ums-service/pkg/dbc"
 	"github.com/librescoot/ums-service/pkg/umslog"
 )
 
 type Installer struct {
 	dbcInterface *dbc.Interface
+	// runner runs rpm for the MDB's packages.
+	runner command.Runner
+	dryRun bool
 }
 
-func New(dbcInterface *dbc.Interface) *Installer {
+func New(dbcInterface *dbc.Interface, runner command.Runner) *Installer {
 	return &Installer{
 		dbcInterface: dbcInterface,
+		runner:       runner,
 	}
 }
 
+// SetDryRun makes the installer log the MDB rpm install instead of
+// running it. DBC RPMs go through dbc.Interface.
+func (i *Installer) SetDryRun(dryRun bool) {
+	i.dryRun = dryRun
+}
+
 func (i *Installer) PrepareUSB(usbMountPath string) error {
 	mdbDir := filepath.Join(usbMountPath, "rpms", "mdb")
 	if err := os.MkdirAll(mdbDir, 0755); err != nil {
@@ -40,7 +50,7 @@ func (i *Installer) PrepareUSB(usbMountPath string) error {
 }
 
 func (i *Installer) ProcessRPMs(ctx context.Context, dbcTimeout time.Duration, logger *umslog.Logger, usbMountPath string) error {
-	if err := i.processMDBRPMs(usbMountPath); err != nil {
+	if err := i.processMDBRPMs(ctx, usbMountPath); err != nil {
 		return fmt.Errorf("failed to process MDB RPMs: %w", err)
 	}
 
@@ -65,7 +75,7 @@ func collectRPMs(dir string) []string {
 	return paths
 }
 
-func (i *Installer) processMDBRPMs(usbMountPath string) error {
+func (i *Installer) processMDBRPMs(ctx context.Context, usbMountPath string) error {
 	rpms := collectRPMs(filepath.Join(usbMountPath, "rpms", "mdb"))
 	if len(rpms) == 0 {
 		return nil
@@ -74,8 +84,11 @@ func (i *Installer) processMDBRPMs(usbMountPath string) error {
 	log.Printf("Installing %d MDB RPM(s)", len(rpms))
 
 	args := append([]string{"-Uvh", "--force"}, rpms...)
-	cmd := exec.Command("rpm", args...)
-	output, err := cmd.CombinedOutput()
+	if i.dryRun {
+		log.Printf("would run: rpm %s", strings.Join(args, " "))
+		return nil
+	}
+	output, err := i.runner.Run(ctx, "rpm", args...)
 	if err != nil {
 		return fmt.Errorf("rpm install failed: %v, output: %s", err, string(output))
 	}
@@ -101,7 +114,7 @@ func (i *Installer) processDBCRPMs(ctx context.Context, timeout time.Duration, l
 
 	log.Printf("Installing %d DBC RPM(s)", len(rpms))
 
-	if _, err := i.dbcInterface.RunCommand(opCtx, fmt.Sprintf("mkdir -p %s", dbcRPMDir)); err != nil {
+	if _, err := i.dbcInterface.RunCommand(opCtx, "mkdir -p "+dbc.ShellQuote(dbcRPMDir)); err != nil {
 		return fmt.Errorf("failed to create remote RPM directory: %w", err)
 	}
 
@@ -126,20 +139,23 @@ func (i *Installer) processDBCRPMs(ctx context.Context, timeout time.Duration, l
 		logger.ClearProgress()
 	}
 
-	installCmd := fmt.Sprintf("rpm -Uvh --force %s", strings.Join(remoteFiles, " "))
-	output, err := i.dbcInterface.RunCommand(opCtx, installCmd)
+	installCmd := "rpm -Uvh --force"
+	for _, f := range remoteFiles {
+		installCmd += " " + dbc.ShellQuote(f)
+	}
+	output, err := i.dbcInterface.RunCommandOnce(opCtx, installCmd)
 	if err != nil {
 		// Clean up even on failure — use a fresh short context in case
 		// the outer one is already done.
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 30*time.Second)
-		i.dbcInterface.RunCommand(cleanupCtx, fmt.Sprintf("rm -rf %s", dbcRPMDir))
+		i.dbcInterface.RunCommand(cleanupCtx, "rm -rf "+dbc.ShellQuote(dbcRPMDir))
 		cleanupCancel()
 		return fmt.Errorf("DBC rpm install failed: %v", err)
 	}
 
 	log.Printf("DBC RPM install output: %s", output)
 
-	if _, err := i.dbcInterface.RunCommand(opCtx, fmt.Sprintf("rm -rf %s", dbcRPMDir)); err != nil {
+	if _, err := i.dbcInterface.RunCommand(opCtx, "rm -rf "+dbc.ShellQuote(dbcRPMDir)); err != nil {
 		log.Printf("Failed to clean up remote RPMs: %v", err)
 	}
 
@@ -5,24 +5,34 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
+	"github.com/librescoot/ums-service/pkg/command"
 	"github.com/librescoot/ums-service/pkg/dbc"
 	"github.com/librescoot/ums-service/pkg/umslog"
 )
 
 type Runner struct {
 	dbcInterface *dbc.Interface
+	// runner runs mdb.sh.
+	runner command.Runner
+	dryRun bool
 }
 
-func New(dbcInterface *dbc.Interface) *Runner {
+func New(dbcInterface *dbc.Interface, runner command.Runner) *Runner {
 	return &Runner{
 		dbcInterface: dbcInterface,
+		runner:       runner,
 	}
 }
 
+// SetDryRun makes the runner log mdb.sh instead of running it. dbc.sh
+// goes through dbc.Interface.
+func (r *Runner) SetDryRun(dryRun bool) {
+	r.dryRun = dryRun
+}
+
 func (r *Runner) PrepareUSB(usbMountPath string) error {
 	scriptsDir := filepath.Join(usbMountPath, "scripts")
 	if err := os.MkdirAll(scriptsDir, 0755); err != nil {
@@ -39,18 +49,23 @@ func (r *Runner) ProcessScripts(ctx context.Context, dbcTimeout time.Duration, l
 		return nil
 	}
 
-	r.runMDBScript(scriptsDir)
+	r.runMDBScript(ctx, scriptsDir)
 	r.runDBCScript(ctx, dbcTimeout, logger, scriptsDir)
 
 	return nil
 }
 
-func (r *Runner) runMDBScript(scriptsDir string) {
+func (r *Runner) runMDBScript(ctx context.Context, scriptsDir string) {
 	srcPath := filepath.Join(scriptsDir, "mdb.sh")
 	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
 		return
 	}
 
+	if r.dryRun {
+		log.Printf("would run: bash %s", srcPath)
+		return
+	}
+
 	log.Println("Running MDB script")
 
 	tmpPath := "/tmp/ums-mdb.sh"
@@ -65,8 +80,7 @@ func (r *Runner) runMDBScript(scriptsDir string) {
 		return
 	}
 
-	cmd := exec.Command("bash", tmpPath)
-	output, err := cmd.CombinedOutput()
+	output, err := r.runner.Run(ctx, "bash", tmpPath)
 	if err != nil {
 		log.Printf("MDB script failed: %v, output: %s", err, string(output))
 		return
@@ -102,7 +116,7 @@ func (r *Runner) runDBCScript(ctx context.Context, timeout time.Duration, logger
 		return
 	}
 
-	output, err := r.dbcInterface.RunCommand(opCtx, fmt.Sprintf("bash %s", remotePath))
+	output, err := r.dbcInterface.RunCommandOnce(opCtx, "bash "+dbc.ShellQuote(remotePath))
 	if err != nil {
 		log.Printf("DBC script failed: %v", err)
 		return
@@ -1,27 +1,149 @@
 package settings
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/librescoot/ums-service/pkg/fsutil"
+	"github.com/librescoot/ums-service/pkg/logging"
 )
 
 type Loader struct {
 	settingsFile string
+	// stagedFile holds settings accepted from USB until Commit promotes
+	// them; backupFile keeps the previous live file for rollback.
+	stagedFile string
+	backupFile string
+	// rejectedFile keeps the last settings.toml from USB that didn't
+	// parse, for the user to inspect.
+	rejectedFile string
+	// backups is how many previous versions Commit keeps as
+	// settings.toml.1 (newest) .. settings.toml.N.
+	backups int
+	// mode is how CopyFromUSB treats the drive's file; see SetMode.
+	mode Mode
+	// maxSize is the largest settings.toml read from the drive; see
+	// SetMaxSize.
+	maxSize int64
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger the loader writes to.
+func (l *Loader) SetLogger(logger *logging.Logger) {
+	l.log = logger
 }
 
-func New() *Loader {
+// New manages the live settings file, normally /data/settings.toml, and
+// the staged, backup and rejected copies kept next to it.
+func New(settingsFile string) *Loader {
 	return &Loader{
-		settingsFile: "/data/settings.toml",
+		settingsFile: settingsFile,
+		stagedFile:   settingsFile + ".staged",
+		backupFile:   settingsFile + ".prev",
+		rejectedFile: settingsFile + ".rejected",
+		backups:      defaultBackups,
+		mode:         ModeReplace,
+		maxSize:      defaultMaxSize,
+		log:          logging.For("settings"),
+	}
+}
+
+const (
+	defaultBackups = 3
+	defaultMaxSize = 1 << 20
+)
+
+// ErrInvalidTOML is returned by CopyFromUSB for a settings.toml that
+// doesn't parse; the live settings are left alone.
+var ErrInvalidTOML = errors.New("invalid TOML")
+
+// ErrTooLarge is returned by CopyFromUSB for a settings.toml over the
+// SetMaxSize limit. It isn't read, and the live settings are left alone.
+var ErrTooLarge = errors.New("file too large")
+
+// SetMaxSize sets the largest settings.toml, in bytes, CopyFromUSB
+// reads. 0 means no limit.
+func (l *Loader) SetMaxSize(n int64) {
+	if n < 0 {
+		n = 0
+	}
+	l.maxSize = n
+}
+
+// SetBackupCount sets how many previous versions are retained. 0 keeps
+// none.
+func (l *Loader) SetBackupCount(n int) {
+	if n < 0 {
+		n = 0
+	}
+	l.backups = n
+}
+
+func (l *Loader) versionFile(n int) string {
+	return fmt.Sprintf("%s.%d", l.settingsFile, n)
+}
+
+// rotateIn shifts settings.toml.1..N-1 up by one, dropping N, and moves
+// src into the settings.toml.1 slot.
+func (l *Loader) rotateIn(src string) error {
+	if l.backups == 0 {
+		return os.Remove(src)
+	}
+	os.Remove(l.versionFile(l.backups))
+	for n := l.backups - 1; n >= 1; n-- {
+		if err := os.Rename(l.versionFile(n), l.versionFile(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
+	return os.Rename(src, l.versionFile(1))
+}
+
+// RestoreVersion makes backup n (1 = the version before the current one)
+// the live settings file. The current file is rotated into the history
+// like any other change, so a restore can itself be undone. Restarting
+// settings-service is up to the caller.
+func (l *Loader) RestoreVersion(n int) error {
+	if n < 1 || n > l.backups {
+		return fmt.Errorf("no settings version %d (keeping %d)", n, l.backups)
+	}
+	data, err := os.ReadFile(l.versionFile(n))
+	if err != nil {
+		return fmt.Errorf("failed to read settings version %d: %w", n, err)
+	}
+
+	hadPrevious, err := l.backUpLive()
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(l.settingsFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to install restored settings: %w", err)
+	}
+	if hadPrevious {
+		if err := l.rotateIn(l.backupFile); err != nil {
+			l.log.Warnf("failed to rotate settings backups: %v", err)
+		}
+	}
+	l.log.Infof("Restored settings.toml from version %d", n)
+	return nil
+}
+
+// Validate reports a settings path that exists but isn't a regular file.
+func (l *Loader) Validate() error {
+	return fsutil.CheckFile(l.settingsFile)
 }
 
 func (l *Loader) CopyToUSB(usbMountPath string) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
 	if _, err := os.Stat(l.settingsFile); os.IsNotExist(err) {
-		log.Printf("Settings file %s does not exist, skipping", l.settingsFile)
+		l.log.Infof("Settings file %s does not exist, skipping", l.settingsFile)
 		return nil
 	}
 
@@ -32,47 +154,188 @@ func (l *Loader) CopyToUSB(usbMountPath string) error {
 		return fmt.Errorf("failed to read settings file: %w", err)
 	}
 
-	if err := os.WriteFile(destPath, input, 0644); err != nil {
+	if err := fsutil.WriteFileAtomic(destPath, input, 0644); err != nil {
 		return fmt.Errorf("failed to write settings to USB: %w", err)
 	}
 
-	log.Printf("Copied settings.toml to USB drive")
+	l.log.Infof("Copied settings.toml to USB drive")
 	return nil
 }
 
+// CopyFromUSB stages settings.toml from the drive if it parses and
+// differs from the live file, combining the two as SetMode says. It
+// reports whether something was staged; the live file is only replaced by
+// Commit. A file that doesn't parse is rejected with ErrInvalidTOML and a
+// copy kept in settings.toml.rejected.
 func (l *Loader) CopyFromUSB(usbMountPath string) (bool, error) {
+	return l.StageFromUSB(usbMountPath, l.mode)
+}
+
+// StageFromUSB is CopyFromUSB with mode in place of the one set by
+// SetMode. In ModeMerge the staged file is the live settings with the
+// drive's keys laid over them, re-encoded, so comments in the live file
+// don't survive.
+func (l *Loader) StageFromUSB(usbMountPath string, mode Mode) (bool, error) {
+	if err := l.Validate(); err != nil {
+		return false, err
+	}
+
 	srcPath := filepath.Join(usbMountPath, "settings.toml")
 
-	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
-		log.Printf("No settings.toml found on USB drive")
+	info, err := os.Stat(srcPath)
+	if os.IsNotExist(err) {
+		l.log.Infof("No settings.toml found on USB drive")
 		return false, nil
 	}
+	if err == nil && l.maxSize > 0 && info.Size() > l.maxSize {
+		return false, fmt.Errorf("settings.toml on USB drive is %d bytes, over the %d byte limit, keeping current settings: %w",
+			info.Size(), l.maxSize, ErrTooLarge)
+	}
 
 	input, err := os.ReadFile(srcPath)
 	if err != nil {
 		return false, fmt.Errorf("failed to read settings from USB: %w", err)
 	}
 
-	var dummy map[string]interface{}
-	if err := toml.Unmarshal(input, &dummy); err != nil {
-		log.Printf("Invalid TOML in settings.toml on USB drive: %v — skipping", err)
-		return false, nil
+	var parsed map[string]interface{}
+	if err := toml.Unmarshal(input, &parsed); err != nil {
+		if werr := fsutil.WriteFileAtomic(l.rejectedFile, input, 0644); werr != nil {
+			l.log.Warnf("failed to save rejected settings: %v", werr)
+		}
+		return false, fmt.Errorf("settings.toml on USB drive rejected, keeping current settings (copy in %s): %w: %v",
+			l.rejectedFile, ErrInvalidTOML, err)
+	}
+	input = normalize(input, parsed)
+
+	if mode == ModeMerge {
+		if existing, err := os.ReadFile(l.settingsFile); err == nil {
+			var current map[string]interface{}
+			if err := toml.Unmarshal(existing, &current); err != nil {
+				l.log.Warnf("current settings don't parse, replacing rather than merging: %v", err)
+			} else {
+				merged := mergeTables(current, parsed)
+				if reflect.DeepEqual(merged, current) {
+					l.log.Infof("settings.toml unchanged after merge")
+					return false, nil
+				}
+				if input, err = encodeTOML(merged); err != nil {
+					return false, fmt.Errorf("failed to encode merged settings: %w", err)
+				}
+				parsed = merged
+				l.log.Infof("Merged settings.toml from USB drive into current settings")
+			}
+		}
 	}
 
-	// Check if content changed
-	changed := true
+	// Compare values, not bytes: an editor reformatting the file
+	// shouldn't cost a settings-service restart.
+	if fsutil.SameContent(l.settingsFile, input) {
+		l.log.Infof("settings.toml unchanged")
+		return false, nil
+	}
 	if existing, err := os.ReadFile(l.settingsFile); err == nil {
-		changed = string(existing) != string(input)
+		var current map[string]interface{}
+		if toml.Unmarshal(existing, &current) == nil && reflect.DeepEqual(current, parsed) {
+			if err := fsutil.WriteFileAtomic(l.settingsFile, input, 0644); err != nil {
+				return false, fmt.Errorf("failed to write settings file: %w", err)
+			}
+			l.log.Infof("settings.toml reformatted, values unchanged")
+			return false, nil
+		}
+	}
+
+	if err := fsutil.WriteFileAtomic(l.stagedFile, input, 0644); err != nil {
+		return false, fmt.Errorf("failed to stage settings file: %w", err)
+	}
+	l.log.Infof("Staged settings.toml from USB drive")
+	return true, nil
+}
+
+// Commit promotes the staged settings to the live path and calls restart
+// so settings-service picks them up. If healthy then reports a problem,
+// the previous file is put back and restart is called again, so a bad
+// file can't keep the service down until the next UMS session.
+func (l *Loader) Commit(restart, healthy func() error) error {
+	if _, err := os.Stat(l.stagedFile); err != nil {
+		return fmt.Errorf("no staged settings: %w", err)
+	}
+
+	hadPrevious, err := l.backUpLive()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(l.stagedFile, l.settingsFile); err != nil {
+		l.restoreBackup(hadPrevious)
+		return fmt.Errorf("failed to promote staged settings: %w", err)
+	}
+
+	err = restart()
+	if err == nil {
+		err = healthy()
+	}
+	if err == nil {
+		l.log.Infof("Updated settings.toml from USB drive")
+		if hadPrevious {
+			if rerr := l.rotateIn(l.backupFile); rerr != nil {
+				l.log.Warnf("failed to rotate settings backups: %v", rerr)
+			}
+		}
+		return nil
+	}
+
+	l.log.Warnf("settings-service unhealthy with new settings, rolling back: %v", err)
+	l.restoreBackup(hadPrevious)
+	if rerr := restart(); rerr != nil {
+		return fmt.Errorf("new settings rejected (%v); restart after rollback failed: %w", err, rerr)
 	}
+	return fmt.Errorf("new settings rejected, previous settings restored: %w", err)
+}
 
-	if changed {
-		if err := os.WriteFile(l.settingsFile, input, 0644); err != nil {
-			return false, fmt.Errorf("failed to write settings file: %w", err)
+// backUpLive copies the live file to backupFile, reporting whether there
+// was one. The live file stays in place, so the change that follows can
+// replace it with a single rename and a crash in between never leaves
+// the settings missing.
+func (l *Loader) backUpLive() (bool, error) {
+	data, err := os.ReadFile(l.settingsFile)
+	if os.IsNotExist(err) {
+		os.Remove(l.backupFile)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read settings file: %w", err)
+	}
+	if err := fsutil.WriteFileAtomic(l.backupFile, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to back up settings file: %w", err)
+	}
+	return true, nil
+}
+
+// restoreBackup puts the pre-Commit live file back, or removes the live
+// file if there wasn't one.
+func (l *Loader) restoreBackup(hadPrevious bool) {
+	if !hadPrevious {
+		if err := os.Remove(l.settingsFile); err != nil && !os.IsNotExist(err) {
+			l.log.Warnf("failed to remove rejected settings: %v", err)
 		}
-		log.Printf("Updated settings.toml from USB drive")
-	} else {
-		log.Printf("settings.toml unchanged")
+		return
+	}
+	if err := os.Rename(l.backupFile, l.settingsFile); err != nil {
+		l.log.Warnf("failed to restore previous settings: %v", err)
 	}
+}
+
+// normalize converts CRLF line endings to LF and strips trailing blanks,
+// unless that would change a value (e.g. inside a multi-line string).
+func normalize(data []byte, parsed map[string]interface{}) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	out := []byte(strings.Join(lines, "\n"))
 
-	return changed, nil
+	var check map[string]interface{}
+	if toml.Unmarshal(out, &check) != nil || !reflect.DeepEqual(check, parsed) {
+		return data
+	}
+	return out
 }
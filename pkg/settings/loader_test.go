@@ -0,0 +1,430 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// stage sets up a live file (unless old is empty) and stages newContent
+// from a fake USB mount.
+func stage(t *testing.T, old, newContent string) *Loader {
+	t.Helper()
+	dir := t.TempDir()
+	usb := filepath.Join(dir, "usb")
+	if err := os.Mkdir(usb, 0755); err != nil {
+		t.Fatal(err)
+	}
+	l := New(filepath.Join(dir, "settings.toml"))
+	if old != "" {
+		writeFile(t, l.settingsFile, old)
+	}
+	writeFile(t, filepath.Join(usb, "settings.toml"), newContent)
+
+	changed, err := l.CopyFromUSB(usb)
+	if err != nil || !changed {
+		t.Fatalf("CopyFromUSB = %v, %v; want true, nil", changed, err)
+	}
+	if old != "" && readFile(t, l.settingsFile) != old {
+		t.Fatal("CopyFromUSB touched the live file")
+	}
+	return l
+}
+
+func TestCommitPromotes(t *testing.T) {
+	l := stage(t, "a = 1\n", "a = 2\n")
+
+	restarts := 0
+	err := l.Commit(func() error { restarts++; return nil }, func() error { return nil })
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if restarts != 1 {
+		t.Errorf("restarts = %d, want 1", restarts)
+	}
+	if got := readFile(t, l.settingsFile); got != "a = 2\n" {
+		t.Errorf("live = %q, want new settings", got)
+	}
+	if _, err := os.Stat(l.stagedFile); !os.IsNotExist(err) {
+		t.Errorf("staged file still present: %v", err)
+	}
+}
+
+func TestCommitRollsBackWhenUnhealthy(t *testing.T) {
+	l := stage(t, "a = 1\n", "a = 2\n")
+
+	restarts := 0
+	var seen []string
+	restart := func() error {
+		restarts++
+		seen = append(seen, readFile(t, l.settingsFile))
+		return nil
+	}
+	unhealthy := errors.New("librescoot-settings.service is \"failed\"")
+	err := l.Commit(restart, func() error { return unhealthy })
+	if !errors.Is(err, unhealthy) {
+		t.Fatalf("Commit = %v, want wrapped health error", err)
+	}
+	if got := readFile(t, l.settingsFile); got != "a = 1\n" {
+		t.Errorf("live = %q, want previous settings restored", got)
+	}
+	want := []string{"a = 2\n", "a = 1\n"}
+	if restarts != 2 || seen[0] != want[0] || seen[1] != want[1] {
+		t.Errorf("restarted with %q, want %q", seen, want)
+	}
+}
+
+func TestCommitRollbackWithoutPrevious(t *testing.T) {
+	l := stage(t, "", "a = 2\n")
+
+	err := l.Commit(func() error { return nil }, func() error { return errors.New("failed") })
+	if err == nil {
+		t.Fatal("Commit succeeded despite unhealthy service")
+	}
+	if _, err := os.Stat(l.settingsFile); !os.IsNotExist(err) {
+		t.Errorf("rejected settings left in place: %v", err)
+	}
+}
+
+// TestCommitKeepsLiveUntilReplaced checks the live file is backed up by
+// copying, not moved aside: it is still there while the staged file
+// is promoted, and untouched if that fails.
+func TestCommitKeepsLiveUntilReplaced(t *testing.T) {
+	l := stage(t, "a = 1\n", "a = 2\n")
+	if err := os.Remove(l.stagedFile); err != nil {
+		t.Fatal(err)
+	}
+	// A directory can't be renamed over the live file.
+	if err := os.MkdirAll(filepath.Join(l.stagedFile, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	if err := l.Commit(func() error { called = true; return nil }, func() error { return nil }); err == nil {
+		t.Fatal("Commit succeeded with an unpromotable staged file")
+	}
+	if called {
+		t.Error("restart called although nothing was promoted")
+	}
+	if got := readFile(t, l.settingsFile); got != "a = 1\n" {
+		t.Errorf("live = %q, want it untouched", got)
+	}
+}
+
+func TestCommitWithoutStaged(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "settings.toml"))
+	called := false
+	if err := l.Commit(func() error { called = true; return nil }, func() error { return nil }); err == nil {
+		t.Error("Commit without staged settings succeeded")
+	}
+	if called {
+		t.Error("restart called without staged settings")
+	}
+}
+
+// commitVersion stages and commits content against l.
+func commitVersion(t *testing.T, l *Loader, content string) {
+	t.Helper()
+	usb := t.TempDir()
+	writeFile(t, filepath.Join(usb, "settings.toml"), content)
+	if _, err := l.CopyFromUSB(usb); err != nil {
+		t.Fatal(err)
+	}
+	ok := func() error { return nil }
+	if err := l.Commit(ok, ok); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitRotatesBackups(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "settings.toml"))
+	l.SetBackupCount(2)
+
+	for v := 1; v <= 4; v++ {
+		commitVersion(t, l, fmt.Sprintf("v = %d\n", v))
+	}
+
+	if got := readFile(t, l.settingsFile); got != "v = 4\n" {
+		t.Errorf("live = %q", got)
+	}
+	if got := readFile(t, l.versionFile(1)); got != "v = 3\n" {
+		t.Errorf(".1 = %q, want v = 3", got)
+	}
+	if got := readFile(t, l.versionFile(2)); got != "v = 2\n" {
+		t.Errorf(".2 = %q, want v = 2", got)
+	}
+	if _, err := os.Stat(l.versionFile(3)); !os.IsNotExist(err) {
+		t.Errorf(".3 exists beyond backup count: %v", err)
+	}
+	if _, err := os.Stat(l.backupFile); !os.IsNotExist(err) {
+		t.Errorf("rollback backup left behind: %v", err)
+	}
+}
+
+func TestCommitWithoutBackups(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "settings.toml"))
+	l.SetBackupCount(0)
+	commitVersion(t, l, "v = 1\n")
+	commitVersion(t, l, "v = 2\n")
+
+	if _, err := os.Stat(l.versionFile(1)); !os.IsNotExist(err) {
+		t.Errorf("backup kept with count 0: %v", err)
+	}
+	if _, err := os.Stat(l.backupFile); !os.IsNotExist(err) {
+		t.Errorf("rollback backup left behind: %v", err)
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "settings.toml"))
+	for v := 1; v <= 3; v++ {
+		commitVersion(t, l, fmt.Sprintf("v = %d\n", v))
+	}
+	// live v3, .1 v2, .2 v1
+
+	if err := l.RestoreVersion(2); err != nil {
+		t.Fatalf("RestoreVersion(2): %v", err)
+	}
+	if got := readFile(t, l.settingsFile); got != "v = 1\n" {
+		t.Errorf("live = %q, want v = 1", got)
+	}
+	// The replaced live file is now the newest backup.
+	if got := readFile(t, l.versionFile(1)); got != "v = 3\n" {
+		t.Errorf(".1 = %q, want v = 3", got)
+	}
+	if entries, _ := filepath.Glob(l.settingsFile + ".*"); len(entries) != 3 {
+		t.Errorf("files next to settings.toml = %q, want only .1 to .3", entries)
+	}
+	if entries, _ := filepath.Glob(l.settingsFile + ".*"); len(entries) != 3 {
+		t.Errorf("files next to settings.toml = %q, want only .1 to .3", entries)
+	}
+
+	for _, n := range []int{0, 4} {
+		if err := l.RestoreVersion(n); err == nil {
+			t.Errorf("RestoreVersion(%d) succeeded", n)
+		}
+	}
+}
+
+func TestCopyFromUSBSemanticCompare(t *testing.T) {
+	const live = "[scooter]\nspeed_limit = 25\nname = \"blue\"\n"
+	cases := []struct {
+		name        string
+		usb         string
+		wantChanged bool
+		wantLive    string
+	}{
+		{"identical", live, false, live},
+		{"crlf", "[scooter]\r\nspeed_limit = 25\r\nname = \"blue\"\r\n", false, live},
+		{"trailing whitespace", "[scooter]  \nspeed_limit = 25\t\nname = \"blue\"\n", false, live},
+		{"reordered and reindented", "[scooter]\n  name = \"blue\"\n  speed_limit = 25\n", false, "[scooter]\n  name = \"blue\"\n  speed_limit = 25\n"},
+		{"value changed", "[scooter]\nspeed_limit = 20\nname = \"blue\"\n", true, live},
+		{"key added", live + "color = \"red\"\n", true, live},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := New(filepath.Join(dir, "settings.toml"))
+			writeFile(t, l.settingsFile, live)
+			usb := filepath.Join(dir, "usb")
+			if err := os.Mkdir(usb, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, filepath.Join(usb, "settings.toml"), c.usb)
+
+			changed, err := l.CopyFromUSB(usb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != c.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, c.wantChanged)
+			}
+			if got := readFile(t, l.settingsFile); got != c.wantLive {
+				t.Errorf("live = %q, want %q", got, c.wantLive)
+			}
+		})
+	}
+}
+
+func TestCopyFromUSBRejectsInvalidTOML(t *testing.T) {
+	const (
+		live = "[scooter]\nspeed_limit = 25\n"
+		bad  = "[scooter]\nspeed_limit = = 20\n"
+	)
+	dir := t.TempDir()
+	l := New(filepath.Join(dir, "settings.toml"))
+	writeFile(t, l.settingsFile, live)
+	usb := filepath.Join(dir, "usb")
+	if err := os.Mkdir(usb, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(usb, "settings.toml"), bad)
+
+	changed, err := l.CopyFromUSB(usb)
+	if !errors.Is(err, ErrInvalidTOML) {
+		t.Errorf("err = %v, want ErrInvalidTOML", err)
+	}
+	if changed {
+		t.Error("changed = true")
+	}
+	if got := readFile(t, l.settingsFile); got != live {
+		t.Errorf("live = %q, want %q", got, live)
+	}
+	if _, err := os.Stat(l.stagedFile); !os.IsNotExist(err) {
+		t.Errorf("staged file exists: %v", err)
+	}
+	if got := readFile(t, l.rejectedFile); got != bad {
+		t.Errorf("rejected = %q, want %q", got, bad)
+	}
+}
+
+func TestCopyFromUSBRejectsOversized(t *testing.T) {
+	const live = "[scooter]\nspeed_limit = 25\n"
+	dir := t.TempDir()
+	l := New(filepath.Join(dir, "settings.toml"))
+	l.SetMaxSize(64)
+	writeFile(t, l.settingsFile, live)
+	usb := filepath.Join(dir, "usb")
+	if err := os.Mkdir(usb, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(usb, "settings.toml"), "[scooter]\nspeed_limit = 20\n# "+strings.Repeat("x", 64)+"\n")
+
+	changed, err := l.CopyFromUSB(usb)
+	if !errors.Is(err, ErrTooLarge) || changed {
+		t.Errorf("CopyFromUSB = %v, %v; want false, ErrTooLarge", changed, err)
+	}
+	if got := readFile(t, l.settingsFile); got != live {
+		t.Errorf("live = %q, want %q", got, live)
+	}
+	if _, err := os.Stat(l.stagedFile); !os.IsNotExist(err) {
+		t.Errorf("staged file exists: %v", err)
+	}
+}
+
+func TestNormalizeKeepsMultilineStrings(t *testing.T) {
+	in := "motd = \"\"\"\nhello  \nworld\"\"\"\r\n"
+	var parsed map[string]interface{}
+	if err := toml.Unmarshal([]byte(in), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(normalize([]byte(in), parsed)); got != in {
+		t.Errorf("normalize changed a multi-line string value: %q", got)
+	}
+}
+
+func TestCopyFromUSBMerge(t *testing.T) {
+	const live = "[scooter]\nspeed_limit = 25\nname = \"blue\"\n\n[cellular]\napn = \"internet\"\n"
+	cases := []struct {
+		name        string
+		live        string
+		usb         string
+		wantChanged bool
+		want        map[string]interface{}
+	}{
+		{
+			name:        "nested value overridden",
+			live:        live,
+			usb:         "[scooter]\nspeed_limit = 20\n",
+			wantChanged: true,
+			want: map[string]interface{}{
+				"scooter":  map[string]interface{}{"speed_limit": int64(20), "name": "blue"},
+				"cellular": map[string]interface{}{"apn": "internet"},
+			},
+		},
+		{
+			name:        "table and top-level key added",
+			live:        live,
+			usb:         "debug = true\n\n[alarm]\nenabled = true\n",
+			wantChanged: true,
+			want: map[string]interface{}{
+				"debug":    true,
+				"scooter":  map[string]interface{}{"speed_limit": int64(25), "name": "blue"},
+				"cellular": map[string]interface{}{"apn": "internet"},
+				"alarm":    map[string]interface{}{"enabled": true},
+			},
+		},
+		{
+			name: "subset of the live values",
+			live: live,
+			usb:  "[scooter]\nname = \"blue\"\n",
+		},
+		{
+			name:        "no live file",
+			usb:         "[scooter]\nspeed_limit = 20\n",
+			wantChanged: true,
+			want: map[string]interface{}{
+				"scooter": map[string]interface{}{"speed_limit": int64(20)},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l := New(filepath.Join(dir, "settings.toml"))
+			l.SetMode(ModeMerge)
+			if c.live != "" {
+				writeFile(t, l.settingsFile, c.live)
+			}
+			usb := filepath.Join(dir, "usb")
+			if err := os.Mkdir(usb, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, filepath.Join(usb, "settings.toml"), c.usb)
+
+			changed, err := l.CopyFromUSB(usb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != c.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, c.wantChanged)
+			}
+			if !changed {
+				if _, err := os.Stat(l.stagedFile); !os.IsNotExist(err) {
+					t.Errorf("staged file exists: %v", err)
+				}
+				return
+			}
+			var got map[string]interface{}
+			if _, err := toml.Decode(readFile(t, l.stagedFile), &got); err != nil {
+				t.Fatalf("staged file doesn't parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("staged = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"replace", "merge"} {
+		if m, err := ParseMode(s); err != nil || string(m) != s {
+			t.Errorf("ParseMode(%q) = %q, %v", s, m, err)
+		}
+	}
+	if _, err := ParseMode("patch"); err == nil {
+		t.Error("ParseMode(\"patch\") succeeded")
+	}
+}
@@ -0,0 +1,62 @@
+package settings
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Mode is how CopyFromUSB combines settings.toml from the drive with the
+// live file.
+type Mode string
+
+const (
+	// ModeReplace makes the drive's file the new settings, wholesale.
+	ModeReplace Mode = "replace"
+	// ModeMerge lays the drive's keys over the live settings, so the
+	// file need only hold what should change.
+	ModeMerge Mode = "merge"
+)
+
+// ParseMode parses "replace" or "merge".
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeReplace, ModeMerge:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown settings mode %q: expected replace or merge", s)
+}
+
+// SetMode sets how CopyFromUSB treats the drive's settings.toml. The
+// default is ModeReplace.
+func (l *Loader) SetMode(mode Mode) {
+	l.mode = mode
+}
+
+// mergeTables returns base with over laid on top: tables present in both
+// are merged key by key, anything else in over wins. Arrays, including
+// arrays of tables, are replaced whole. Neither argument is modified.
+func mergeTables(base, over map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base)+len(over))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range over {
+		sub, ok := v.(map[string]interface{})
+		if prev, isTable := out[k].(map[string]interface{}); ok && isTable {
+			out[k] = mergeTables(prev, sub)
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func encodeTOML(table map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(table); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+// Package statusapi serves the service's progress and live log over HTTP
+// for UIs and browsers that can't subscribe to Redis.
+package statusapi
+
+import (
+	"context"
+	"sync"
+)
+
+// Snapshot is the state reported by /status. Version increases on every
+// change so clients can long-poll for "anything newer than what I have".
+type Snapshot struct {
+	Status  string `json:"status"`
+	Step    string `json:"step"`
+	Version uint64 `json:"version"`
+}
+
+// Board holds the current Snapshot and wakes waiters when it changes.
+type Board struct {
+	mu      sync.Mutex
+	snap    Snapshot
+	changed chan struct{}
+}
+
+func NewBoard() *Board {
+	return &Board{changed: make(chan struct{})}
+}
+
+// SetStatus records a new status and reports whether it changed;
+// unchanged values don't wake waiters.
+func (b *Board) SetStatus(status string) bool {
+	return b.update(func(s *Snapshot) bool {
+		if s.Status == status {
+			return false
+		}
+		s.Status = status
+		return true
+	})
+}
+
+// SetStep records the current processing step and reports whether it
+// changed.
+func (b *Board) SetStep(step string) bool {
+	return b.update(func(s *Snapshot) bool {
+		if s.Step == step {
+			return false
+		}
+		s.Step = step
+		return true
+	})
+}
+
+func (b *Board) update(fn func(*Snapshot) bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !fn(&b.snap) {
+		return false
+	}
+	b.snap.Version++
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return true
+}
+
+// Snapshot returns the current state.
+func (b *Board) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.snap
+}
+
+// Wait blocks until the version differs from since or ctx is done, then
+// returns the current state. Callers without a version pass the one from
+// Snapshot to wait for the next change.
+func (b *Board) Wait(ctx context.Context, since uint64) Snapshot {
+	for {
+		b.mu.Lock()
+		snap, changed := b.snap, b.changed
+		b.mu.Unlock()
+		if snap.Version != since {
+			return snap
+		}
+		select {
+		case <-ctx.Done():
+			return snap
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package statusapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// FileLister returns what is on the drive, ready to be encoded as JSON.
+type FileLister func() (any, error)
+
+// SetFileLister serves list's result at /drive/files. Without one the
+// endpoint answers 404. Call before Start.
+func (s *Server) SetFileLister(list FileLister) {
+	s.listFiles = list
+}
+
+// handleDriveFiles returns the drive's files. A listing that fails, e.g.
+// because the drive is with the USB host or a mode switch is using it,
+// answers 503 with the reason.
+func (s *Server) handleDriveFiles(w http.ResponseWriter, r *http.Request) {
+	if s.listFiles == nil {
+		http.NotFound(w, r)
+		return
+	}
+	files, err := s.listFiles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		log.Printf("Status server: write response: %v", err)
+	}
+}
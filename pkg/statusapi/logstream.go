@@ -0,0 +1,96 @@
+package statusapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logSubscriberBuffer is how many entries a slow stream client may lag
+// behind before further entries are dropped for it.
+const logSubscriberBuffer = 64
+
+// sseKeepAlive is how often an idle stream gets a comment line, so
+// proxies and browsers don't time it out.
+const sseKeepAlive = 15 * time.Second
+
+// LogHub fans log entries out to live stream clients.
+type LogHub struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+func NewLogHub() *LogHub {
+	return &LogHub{subs: make(map[chan string]struct{})}
+}
+
+// Publish hands entry to every subscriber without blocking; a subscriber
+// whose buffer is full misses it.
+func (h *LogHub) Publish(entry string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of entries published from now on and a
+// function that unsubscribes it.
+func (h *LogHub) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, logSubscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// handleLogStream streams log entries as server-sent events until the
+// client goes away.
+func (s *Server) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	entries, unsubscribe := s.logs.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	// Announce the subscription so clients know nothing after this point
+	// is missed.
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case entry := <-entries:
+			for _, line := range strings.Split(entry, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
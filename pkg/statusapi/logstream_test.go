@@ -0,0 +1,98 @@
+package statusapi
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+)
+
+func TestLogStreamDeliversTransitionEvents(t *testing.T) {
+	hub := NewLogHub()
+	srv := httptest.NewServer(NewServer("", NewBoard(), hub).http.Handler)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/logs/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != ": connected" {
+		t.Fatalf("first line = %q, want the connected comment", lines.Text())
+	}
+
+	// Simulate a transition logging through the service's logger.
+	logger := umslog.New(nil)
+	logger.SetSink(hub.Publish)
+	logger.Logf("settings", "done (changed=%v)", true)
+	logger.Error("maps", "transfer failed")
+	logger.Logf("reboot", "deferred until 02:00")
+
+	want := []string{"[settings] done (changed=true)", "[maps] ERROR: transfer failed", "[reboot] deferred until 02:00"}
+	var got []string
+	for len(got) < len(want) && lines.Scan() {
+		if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+			got = append(got, data)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("received %d events %q, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if !strings.HasSuffix(got[i], want[i]) {
+			t.Errorf("event %d = %q, want suffix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLogHubUnsubscribe(t *testing.T) {
+	hub := NewLogHub()
+	ch, unsubscribe := hub.Subscribe()
+	hub.Publish("one")
+	unsubscribe()
+	hub.Publish("two")
+
+	if got := <-ch; got != "one" {
+		t.Errorf("got %q, want one", got)
+	}
+	select {
+	case got := <-ch:
+		t.Errorf("received %q after unsubscribing", got)
+	default:
+	}
+}
+
+func TestLogHubDropsForSlowSubscriber(t *testing.T) {
+	hub := NewLogHub()
+	ch, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < logSubscriberBuffer*2; i++ {
+			hub.Publish("entry")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if len(ch) != logSubscriberBuffer {
+		t.Errorf("buffered %d entries, want %d", len(ch), logSubscriberBuffer)
+	}
+}
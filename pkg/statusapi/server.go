@@ -0,0 +1,94 @@
+package statusapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxWait caps ?wait= so a client can't pin a connection indefinitely.
+const maxWait = 60 * time.Second
+
+// Server serves the Board, the live log via /logs/stream, with
+// SetFileLister the drive's files via /drive/files, and whatever else is
+// mounted with Handle, over HTTP.
+type Server struct {
+	board     *Board
+	logs      *LogHub
+	listFiles FileLister
+	mux       *http.ServeMux
+	http      *http.Server
+	// done is closed by Shutdown to end open log streams, which would
+	// otherwise hold it up until its deadline.
+	done chan struct{}
+}
+
+func NewServer(addr string, board *Board, logs *LogHub) *Server {
+	s := &Server{board: board, logs: logs, done: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/logs/stream", s.handleLogStream)
+	mux.HandleFunc("/drive/files", s.handleDriveFiles)
+	s.mux = mux
+	s.http = &http.Server{Addr: addr, Handler: mux}
+	return s
+}
+
+// Handle serves handler at pattern alongside the status endpoints. Call
+// before Start.
+func (s *Server) Handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start listens in the background until Shutdown.
+func (s *Server) Start() {
+	go func() {
+		log.Printf("Starting status server on %s", s.http.Addr)
+		if err := s.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Status server error: %v", err)
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.done)
+	return s.http.Shutdown(ctx)
+}
+
+// handleStatus returns the current Snapshot. With ?wait=<duration> it
+// first blocks until the state changes (or the wait elapses); ?since=
+// <version> makes that "changes from the version I last saw", so no
+// update between two polls is missed.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	snap := s.board.Snapshot()
+
+	if raw := r.URL.Query().Get("wait"); raw != "" {
+		wait, err := time.ParseDuration(raw)
+		if err != nil || wait < 0 {
+			http.Error(w, "invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		since := snap.Version
+		if rawSince := r.URL.Query().Get("since"); rawSince != "" {
+			if since, err = strconv.ParseUint(rawSince, 10, 64); err != nil {
+				http.Error(w, "invalid since version", http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		snap = s.board.Wait(ctx, since)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		log.Printf("Status server: write response: %v", err)
+	}
+}
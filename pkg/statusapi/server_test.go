@@ -0,0 +1,150 @@
+package statusapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, s *Server, url string) (int, Snapshot) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleStatus(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	var snap Snapshot
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, snap
+}
+
+func TestStatusImmediate(t *testing.T) {
+	b := NewBoard()
+	b.SetStatus("processing")
+	b.SetStep("maps")
+	s := NewServer("", b, NewLogHub())
+
+	code, snap := get(t, s, "/status")
+	if code != http.StatusOK || snap.Status != "processing" || snap.Step != "maps" || snap.Version != 2 {
+		t.Errorf("got %d %+v", code, snap)
+	}
+}
+
+func TestStatusLongPollWakesOnChange(t *testing.T) {
+	b := NewBoard()
+	b.SetStatus("processing")
+	s := NewServer("", b, NewLogHub())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.SetStatus("awaiting-reboot")
+	}()
+
+	start := time.Now()
+	_, snap := get(t, s, "/status?wait=5s")
+	if snap.Status != "awaiting-reboot" {
+		t.Errorf("status = %q, want awaiting-reboot", snap.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("long poll took %s, should return on change", elapsed)
+	}
+}
+
+func TestStatusLongPollTimeout(t *testing.T) {
+	b := NewBoard()
+	b.SetStatus("processing")
+	s := NewServer("", b, NewLogHub())
+
+	start := time.Now()
+	_, snap := get(t, s, "/status?wait=50ms")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("returned after %s, before the wait elapsed", elapsed)
+	}
+	if snap.Status != "processing" || snap.Version != 1 {
+		t.Errorf("timeout returned %+v, want unchanged state", snap)
+	}
+}
+
+func TestStatusLongPollSince(t *testing.T) {
+	b := NewBoard()
+	b.SetStatus("processing")
+	b.SetStatus("idle")
+	s := NewServer("", b, NewLogHub())
+
+	// The client last saw version 1; version 2 is already newer.
+	start := time.Now()
+	_, snap := get(t, s, "/status?wait=5s&since=1")
+	if snap.Status != "idle" || time.Since(start) > time.Second {
+		t.Errorf("since=1 returned %+v after %s", snap, time.Since(start))
+	}
+}
+
+func TestStatusBadParams(t *testing.T) {
+	s := NewServer("", NewBoard(), NewLogHub())
+	for _, url := range []string{"/status?wait=soon", "/status?wait=-1s", "/status?wait=1s&since=x"} {
+		if code, _ := get(t, s, url); code != http.StatusBadRequest {
+			t.Errorf("%s: code %d, want 400", url, code)
+		}
+	}
+}
+
+func TestBoardIgnoresNoOpUpdates(t *testing.T) {
+	b := NewBoard()
+	if !b.SetStatus("idle") {
+		t.Error("first status reported unchanged")
+	}
+	if b.SetStatus("idle") || b.SetStep("") {
+		t.Error("no-op update reported as a change")
+	}
+	if v := b.Snapshot().Version; v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+}
+
+func TestDriveFiles(t *testing.T) {
+	s := NewServer("", NewBoard(), NewLogHub())
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleDriveFiles(rec, httptest.NewRequest(http.MethodGet, "/drive/files", nil))
+		return rec
+	}
+
+	if rec := serve(); rec.Code != http.StatusNotFound {
+		t.Errorf("without a lister: %d, want 404", rec.Code)
+	}
+
+	s.SetFileLister(func() (any, error) { return nil, errors.New("drive is exported to the USB host") })
+	if rec := serve(); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "exported") {
+		t.Errorf("failed listing: %d %q, want 503 with the reason", rec.Code, rec.Body.String())
+	}
+
+	s.SetFileLister(func() (any, error) { return []string{"mdb/update.mender"}, nil })
+	rec := serve()
+	var got []string
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK || len(got) != 1 || got[0] != "mdb/update.mender" {
+		t.Errorf("listing: %d %v %v", rec.Code, got, err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer("", NewBoard(), NewLogHub())
+	s.Handle("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	rec := httptest.NewRecorder()
+	s.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("mounted handler: %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	s.http.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/status alongside it: %d, want 200", rec.Code)
+	}
+}
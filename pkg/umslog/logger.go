@@ -6,26 +6,45 @@ import (
 	"os"
 	"strings"
 	"time"
-
-	ipc "github.com/librescoot/redis-ipc"
 )
 
 const redisKey = "usb:log"
 const maxEntries = 100
 
+// Client is the subset of *ipc.Client the logger writes through.
+type Client interface {
+	LPush(key string, values ...interface{}) (int64, error)
+	Do(cmd string, args ...interface{}) (interface{}, error)
+	HSet(key, field string, value interface{}) error
+}
+
 // Logger collects timestamped entries during USB processing.
 // Entries are pushed to Redis in real-time and written to a file at the end.
 type Logger struct {
 	entries      []string
-	client       *ipc.Client
+	client       Client
 	lastProgress int
 	lastDetail   string
+	sink         func(entry string)
+	onError      func(category, msg string)
 }
 
-func New(client *ipc.Client) *Logger {
+func New(client Client) *Logger {
 	return &Logger{client: client}
 }
 
+// SetSink registers fn to receive every entry as it is logged, e.g. to
+// stream it to a live viewer. fn must not block.
+func (l *Logger) SetSink(fn func(entry string)) {
+	l.sink = fn
+}
+
+// OnError registers fn to be called with every Error entry's category
+// and message, e.g. to surface it outside usb:log.
+func (l *Logger) OnError(fn func(category, msg string)) {
+	l.onError = fn
+}
+
 func (l *Logger) timestamp() string {
 	return time.Now().Format("2006-01-02 15:04:05")
 }
@@ -33,6 +52,10 @@ func (l *Logger) timestamp() string {
 func (l *Logger) push(entry string) {
 	l.entries = append(l.entries, entry)
 
+	if l.sink != nil {
+		l.sink(entry)
+	}
+
 	if l.client == nil {
 		return
 	}
@@ -55,13 +78,21 @@ func (l *Logger) Log(format string, args ...interface{}) {
 }
 
 func (l *Logger) Logf(category, format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	l.push(fmt.Sprintf("%s [%s] %s", l.timestamp(), category, msg))
+	l.push(Entry(category, fmt.Sprintf(format, args...)))
+}
+
+// Entry formats msg the way Logf records it, for sinks fed from outside a
+// Logger.
+func Entry(category, msg string) string {
+	return fmt.Sprintf("%s [%s] %s", time.Now().Format("2006-01-02 15:04:05"), category, msg)
 }
 
 func (l *Logger) Error(category, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	l.push(fmt.Sprintf("%s [%s] ERROR: %s", l.timestamp(), category, msg))
+	if l.onError != nil {
+		l.onError(category, msg)
+	}
 }
 
 // SetProgress publishes the current per-file progress (0..100) on the
@@ -0,0 +1,145 @@
+// Package umsmanifest reads ums-manifest.toml, an optional file at the
+// drive root with which a user declares what the service should do with
+// the drive, rather than have it act on everything it finds there.
+package umsmanifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/librescoot/ums-service/pkg/settings"
+)
+
+// FileName is the manifest's name at the drive root.
+const FileName = "ums-manifest.toml"
+
+// Manifest switches processing categories on or off. A key left out
+// keeps the category's usual behavior: it is processed if the drive has
+// anything for it.
+type Manifest struct {
+	ApplySettings  *bool `toml:"apply_settings"`
+	SyncWireGuard  *bool `toml:"sync_wireguard"`
+	ApplyRadioGaga *bool `toml:"apply_radio_gaga"`
+	ApplyUplink    *bool `toml:"apply_uplink"`
+	ApplyOnboot    *bool `toml:"apply_onboot"`
+	ProcessUpdates *bool `toml:"process_updates"`
+	InstallMaps    *bool `toml:"install_maps"`
+	InstallRPMs    *bool `toml:"install_rpms"`
+	RunScripts     *bool `toml:"run_scripts"`
+	// Maps, if set, limits map installation to these files in maps/.
+	Maps []string `toml:"maps"`
+	// Settings, if set, is "replace" or "merge": how settings.toml is
+	// combined with the live settings this session.
+	Settings string `toml:"settings_mode"`
+	// Backup asks for a backup of /data to be written to the drive the
+	// next time UMS mode is entered.
+	Backup bool `toml:"backup_data"`
+}
+
+// Read parses root/ums-manifest.toml. It returns nil and no error when
+// the drive has none. Unknown keys are an error, so a misspelt switch
+// isn't silently ignored.
+func Read(root string) (*Manifest, error) {
+	path := filepath.Join(root, FileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	meta, err := toml.Decode(string(data), &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return nil, fmt.Errorf("%s: unknown keys: %s", FileName, strings.Join(keys, ", "))
+	}
+	for _, name := range m.Maps {
+		if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+			return nil, fmt.Errorf("%s: maps: %q is not a file name in maps/", FileName, name)
+		}
+	}
+	if m.Settings != "" {
+		if _, err := settings.ParseMode(m.Settings); err != nil {
+			return nil, fmt.Errorf("%s: settings_mode: %w", FileName, err)
+		}
+	}
+	return &m, nil
+}
+
+// switches maps each processing category to its key's value.
+func (m *Manifest) switches() map[string]*bool {
+	return map[string]*bool{
+		"settings":       m.ApplySettings,
+		"wireguard":      m.SyncWireGuard,
+		"radio-gaga":     m.ApplyRadioGaga,
+		"uplink-service": m.ApplyUplink,
+		"onboot":         m.ApplyOnboot,
+		"updates":        m.ProcessUpdates,
+		"maps":           m.InstallMaps,
+		"rpms":           m.InstallRPMs,
+		"scripts":        m.RunScripts,
+	}
+}
+
+// Enabled reports whether category may be processed. Everything is
+// enabled without a manifest.
+func (m *Manifest) Enabled(category string) bool {
+	if m == nil {
+		return true
+	}
+	v := m.switches()[category]
+	return v == nil || *v
+}
+
+// Summary lists the categories the manifest switched on and off; those it
+// doesn't mention are in neither.
+func (m *Manifest) Summary() (enabled, disabled []string) {
+	if m == nil {
+		return nil, nil
+	}
+	for category, v := range m.switches() {
+		switch {
+		case v == nil:
+		case *v:
+			enabled = append(enabled, category)
+		default:
+			disabled = append(disabled, category)
+		}
+	}
+	sort.Strings(enabled)
+	sort.Strings(disabled)
+	return enabled, disabled
+}
+
+// SettingsMode returns the settings mode the manifest asks for, or ""
+// to keep the configured one.
+func (m *Manifest) SettingsMode() settings.Mode {
+	if m == nil {
+		return ""
+	}
+	return settings.Mode(m.Settings)
+}
+
+// BackupRequested reports whether the manifest asks for a /data backup.
+func (m *Manifest) BackupRequested() bool {
+	return m != nil && m.Backup
+}
+
+// MapFiles returns the map files to install, or nil for all of them.
+func (m *Manifest) MapFiles() []string {
+	if m == nil {
+		return nil
+	}
+	return m.Maps
+}
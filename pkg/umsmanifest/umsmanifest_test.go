@@ -0,0 +1,87 @@
+package umsmanifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/settings"
+)
+
+func TestRead(t *testing.T) {
+	cases := []struct {
+		name         string
+		content      string // "" for no manifest
+		wantErr      bool
+		wantEnabled  []string
+		wantDisabled []string
+		wantMaps     []string
+		wantSettings settings.Mode
+		wantBackup   bool
+	}{
+		{name: "absent"},
+		{
+			name:         "switches",
+			content:      "apply_settings = false\nprocess_updates = true\nsync_wireguard = true\n",
+			wantEnabled:  []string{"updates", "wireguard"},
+			wantDisabled: []string{"settings"},
+		},
+		{
+			name:     "map list",
+			content:  `maps = ["germany.mbtiles", "valhalla_tiles_de.tar"]`,
+			wantMaps: []string{"germany.mbtiles", "valhalla_tiles_de.tar"},
+		},
+		{
+			name:         "settings mode",
+			content:      "settings_mode = \"merge\"\n",
+			wantSettings: settings.ModeMerge,
+		},
+		{name: "backup", content: "backup_data = true\n", wantBackup: true},
+		{name: "unknown settings mode", content: "settings_mode = \"patch\"\n", wantErr: true},
+		{name: "misspelt key", content: "apply_setings = false\n", wantErr: true},
+		{name: "wrong type", content: "apply_settings = \"no\"\n", wantErr: true},
+		{name: "map path", content: `maps = ["../settings.toml"]`, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			if c.content != "" {
+				if err := os.WriteFile(filepath.Join(root, FileName), []byte(c.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := Read(root)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Read: err = %v, want error %v", err, c.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.content == "" && m != nil {
+				t.Errorf("Read = %+v without a manifest, want nil", m)
+			}
+			enabled, disabled := m.Summary()
+			if !reflect.DeepEqual(enabled, c.wantEnabled) || !reflect.DeepEqual(disabled, c.wantDisabled) {
+				t.Errorf("Summary = %v, %v; want %v, %v", enabled, disabled, c.wantEnabled, c.wantDisabled)
+			}
+			for _, category := range c.wantDisabled {
+				if m.Enabled(category) {
+					t.Errorf("Enabled(%q) = true", category)
+				}
+			}
+			if !m.Enabled("maps") {
+				t.Error("Enabled(maps) = false, want the default")
+			}
+			if got := m.MapFiles(); !reflect.DeepEqual(got, c.wantMaps) {
+				t.Errorf("MapFiles = %q, want %q", got, c.wantMaps)
+			}
+			if got := m.SettingsMode(); got != c.wantSettings {
+				t.Errorf("SettingsMode = %q, want %q", got, c.wantSettings)
+			}
+			if got := m.BackupRequested(); got != c.wantBackup {
+				t.Errorf("BackupRequested = %v, want %v", got, c.wantBackup)
+			}
+		})
+	}
+}
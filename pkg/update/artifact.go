@@ -0,0 +1,136 @@
+package update
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// headerInfo is the part of a mender artifact's header-info the device
+// and version checks need. Format 3 lists the compatible device types
+// under artifact_depends and the name under artifact_provides; format 2
+// has both at the top level.
+type headerInfo struct {
+	ArtifactDepends struct {
+		DeviceType []string `json:"device_type"`
+	} `json:"artifact_depends"`
+	ArtifactProvides struct {
+		ArtifactName string `json:"artifact_name"`
+	} `json:"artifact_provides"`
+	DeviceTypesCompatible []string `json:"device_types_compatible"`
+	ArtifactName          string   `json:"artifact_name"`
+}
+
+// artifactDeviceTypes returns the device types the mender artifact at
+// path is built for.
+func artifactDeviceTypes(path string) ([]string, error) {
+	info, err := readHeaderInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	types := info.ArtifactDepends.DeviceType
+	if len(types) == 0 {
+		types = info.DeviceTypesCompatible
+	}
+	if len(types) == 0 {
+		return nil, errors.New("header-info lists no device types")
+	}
+	return types, nil
+}
+
+// artifactName returns the name, and with it the version, of the mender
+// artifact at path, as mender records it in artifact_info once installed.
+func artifactName(path string) (string, error) {
+	info, err := readHeaderInfo(path)
+	if err != nil {
+		return "", err
+	}
+	name := info.ArtifactProvides.ArtifactName
+	if name == "" {
+		name = info.ArtifactName
+	}
+	if name == "" {
+		return "", errors.New("header-info has no artifact name")
+	}
+	return name, nil
+}
+
+// readHeaderInfo reads the header-info of the mender artifact at path.
+// An artifact is an uncompressed tar whose header.tar.gz holds
+// header-info; only the header is read, not the payload.
+func readHeaderInfo(path string) (headerInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return headerInfo{}, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return headerInfo{}, errors.New("no header.tar.gz in artifact")
+		}
+		if err != nil {
+			return headerInfo{}, fmt.Errorf("not a mender artifact: %w", err)
+		}
+		switch {
+		case hdr.Name == "header.tar.gz":
+			return decodeHeaderInfo(tr)
+		case strings.HasPrefix(hdr.Name, "header.tar"):
+			return headerInfo{}, fmt.Errorf("unsupported header compression %s", hdr.Name)
+		case strings.HasPrefix(hdr.Name, "data"):
+			return headerInfo{}, errors.New("no header before the payload")
+		}
+	}
+}
+
+func decodeHeaderInfo(r io.Reader) (headerInfo, error) {
+	var info headerInfo
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return info, fmt.Errorf("header.tar.gz: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return info, errors.New("no header-info in header.tar.gz")
+		}
+		if err != nil {
+			return info, fmt.Errorf("header.tar.gz: %w", err)
+		}
+		if hdr.Name != "header-info" {
+			continue
+		}
+		if err := json.NewDecoder(tr).Decode(&info); err != nil {
+			return info, fmt.Errorf("header-info: %w", err)
+		}
+		return info, nil
+	}
+}
+
+// checkArtifactDeviceType confirms that deviceType, the board's, is one
+// the artifact at path is built for. As with the board check, only a
+// definite mismatch is refused; an artifact whose header can't be read
+// is let through with a warning.
+func (l *Loader) checkArtifactDeviceType(path, deviceType string) error {
+	types, err := artifactDeviceTypes(path)
+	if err != nil {
+		l.log.Warnf("cannot read device type of %s: %v", path, err)
+		return nil
+	}
+	for _, t := range types {
+		if t == deviceType {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: artifact is for %s, device is %s", ErrHardwareMismatch, strings.Join(types, ", "), deviceType)
+}
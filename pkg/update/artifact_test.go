@@ -0,0 +1,132 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tarEntries builds a tar archive holding the given files in order.
+func tarEntries(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0644, Size: int64(len(f[1]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeArtifact writes a mender artifact whose header-info is info.
+func writeArtifact(t *testing.T, path, info string) {
+	t.Helper()
+	var header bytes.Buffer
+	gz := gzip.NewWriter(&header)
+	if _, err := gz.Write(tarEntries(t, [2]string{"header-info", info})); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	artifact := tarEntries(t,
+		[2]string{"version", `{"format":"mender","version":3}`},
+		[2]string{"manifest", "0000 data/0000.tar.gz\n"},
+		[2]string{"header.tar.gz", header.String()},
+		[2]string{"data/0000.tar.gz", "payload"},
+	)
+	if err := os.WriteFile(path, artifact, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArtifactDeviceTypes(t *testing.T) {
+	cases := []struct {
+		name    string
+		info    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "format 3", info: `{"artifact_depends":{"device_type":["librescoot-mdb","librescoot-mdb-v2"]}}`, want: []string{"librescoot-mdb", "librescoot-mdb-v2"}},
+		{name: "format 2", info: `{"device_types_compatible":["librescoot-dbc"]}`, want: []string{"librescoot-dbc"}},
+		{name: "no device types", info: `{"artifact_provides":{"artifact_name":"x"}}`, wantErr: true},
+		{name: "bad json", info: `{`, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.mender")
+			writeArtifact(t, path, c.info)
+			got, err := artifactDeviceTypes(path)
+			if (err != nil) != c.wantErr || !reflect.DeepEqual(got, c.want) {
+				t.Errorf("artifactDeviceTypes = %q, %v; want %q, err %v", got, err, c.want, c.wantErr)
+			}
+		})
+	}
+
+	t.Run("not an artifact", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "a.mender")
+		if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := artifactDeviceTypes(path); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestArtifactName(t *testing.T) {
+	cases := []struct {
+		name    string
+		info    string
+		want    string
+		wantErr bool
+	}{
+		{name: "format 3", info: `{"artifact_provides":{"artifact_name":"librescoot-mdb-v1.2.0"}}`, want: "librescoot-mdb-v1.2.0"},
+		{name: "format 2", info: `{"artifact_name":"librescoot-dbc-v1.2.0"}`, want: "librescoot-dbc-v1.2.0"},
+		{name: "no name", info: `{"artifact_depends":{"device_type":["librescoot-mdb"]}}`, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.mender")
+			writeArtifact(t, path, c.info)
+			got, err := artifactName(path)
+			if (err != nil) != c.wantErr || got != c.want {
+				t.Errorf("artifactName = %q, %v; want %q, err %v", got, err, c.want, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckHardwareArtifact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "librescoot-foo-mdb-stable-v1.0.0.mender")
+	writeArtifact(t, path, `{"artifact_depends":{"device_type":["librescoot-mdb-v2"]}}`)
+
+	cases := []struct {
+		name       string
+		deviceType string
+		wantErr    bool
+	}{
+		{"matching revision", "device_type=librescoot-mdb-v2\n", false},
+		{"other revision", "device_type=librescoot-mdb\n", true},
+		{"unknown board type", "", false},
+	}
+	for _, c := range cases {
+		err := (&Loader{}).checkHardware(context.Background(), "mdb", path, deviceType(c.deviceType, nil))
+		if c.wantErr != errors.Is(err, ErrHardwareMismatch) {
+			t.Errorf("%s: err = %v, want mismatch %v", c.name, err, c.wantErr)
+		}
+	}
+}
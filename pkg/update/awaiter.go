@@ -41,11 +41,26 @@ type awaiterState struct {
 	// status leave pending-reboot (downloading/installing/error)
 	// before counting a subsequent pending-reboot as ours.
 	sawNonPendingReboot bool
-	// done becomes true when pending-reboot is observed after
-	// sawNonPendingReboot is true.
+	// done becomes true when pending-reboot (or, for AwaitOutcomes,
+	// error) is observed after sawNonPendingReboot is true.
 	done bool
 }
 
+// Outcome is how one component's install ended, as far as the awaiter
+// could tell.
+type Outcome string
+
+const (
+	// OutcomeInstalled: the component reached pending-reboot; the
+	// artifact is staged and takes effect once that board reboots.
+	OutcomeInstalled Outcome = "installed"
+	// OutcomeFailed: update-service reported error for the install.
+	OutcomeFailed Outcome = "failed"
+	// OutcomeUnknown: the wait ended (timeout, cancellation, closed
+	// source) before the component finished.
+	OutcomeUnknown Outcome = "unknown"
+)
+
 // WaitForCompletion blocks until every component in q with its bool set
 // has transitioned to pending-reboot since the function was entered.
 //
@@ -53,9 +68,28 @@ type awaiterState struct {
 // timeout, an error wrapping context.Canceled on ctx cancellation, or an
 // error naming the component that went to error status.
 func WaitForCompletion(ctx context.Context, source OTAStatusSource, q Queued, timeout time.Duration) error {
+	_, err := await(ctx, source, q, timeout, true)
+	return err
+}
+
+// AwaitOutcomes is WaitForCompletion for callers that can act on a
+// partial result: one component failing doesn't end the wait for the
+// others, and every queued component gets an Outcome. The error is only
+// set when the wait itself was cut short (timeout, cancellation, closed
+// source, unreadable initial status); components still running then are
+// OutcomeUnknown.
+func AwaitOutcomes(ctx context.Context, source OTAStatusSource, q Queued, timeout time.Duration) (map[string]Outcome, error) {
+	return await(ctx, source, q, timeout, false)
+}
+
+func await(ctx context.Context, source OTAStatusSource, q Queued, timeout time.Duration, stopOnError bool) (map[string]Outcome, error) {
 	required := requiredComponents(q)
+	outcomes := make(map[string]Outcome, len(required))
+	for _, c := range required {
+		outcomes[c] = OutcomeUnknown
+	}
 	if len(required) == 0 {
-		return nil
+		return outcomes, nil
 	}
 
 	states := make(map[string]*awaiterState, len(required))
@@ -63,7 +97,7 @@ func WaitForCompletion(ctx context.Context, source OTAStatusSource, q Queued, ti
 		st := &awaiterState{}
 		initial, err := source.Current(c)
 		if err != nil {
-			return fmt.Errorf("read initial status for %s: %w", c, err)
+			return outcomes, fmt.Errorf("read initial status for %s: %w", c, err)
 		}
 		if initial != "" && initial != statusPendingReboot {
 			st.sawNonPendingReboot = true
@@ -78,26 +112,29 @@ func WaitForCompletion(ctx context.Context, source OTAStatusSource, q Queued, ti
 	for {
 		select {
 		case <-waitCtx.Done():
-			return fmt.Errorf("waiting for install completion: %w", waitCtx.Err())
+			return outcomes, fmt.Errorf("waiting for install completion: %w", waitCtx.Err())
 		case u, ok := <-updates:
 			if !ok {
-				return fmt.Errorf("ota status source closed before completion")
+				return outcomes, fmt.Errorf("ota status source closed before completion")
 			}
 			st, watched := states[u.Component]
-			if !watched {
+			if !watched || st.done {
 				continue
 			}
 			switch u.Status {
 			case statusPendingReboot:
 				if st.sawNonPendingReboot {
 					st.done = true
-					if allDone(states) {
-						return nil
-					}
+					outcomes[u.Component] = OutcomeInstalled
 				}
 			case statusError:
 				if st.sawNonPendingReboot {
-					return fmt.Errorf("install for %s reported error", u.Component)
+					if stopOnError {
+						return outcomes, fmt.Errorf("install for %s reported error", u.Component)
+					}
+					st.done = true
+					outcomes[u.Component] = OutcomeFailed
+					break
 				}
 				// Pre-existing error before we saw any install
 				// activity — treat as starting state, like idle.
@@ -105,6 +142,9 @@ func WaitForCompletion(ctx context.Context, source OTAStatusSource, q Queued, ti
 			default:
 				st.sawNonPendingReboot = true
 			}
+			if allDone(states) {
+				return outcomes, nil
+			}
 		}
 	}
 }
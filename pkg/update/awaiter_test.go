@@ -269,3 +269,59 @@ func TestWaitForCompletion_SourceClosed(t *testing.T) {
 		t.Fatal("timed out")
 	}
 }
+
+func TestAwaitOutcomes_OneFailsOtherInstalls(t *testing.T) {
+	src := newFakeOTASource(map[string]string{"mdb": "idle", "dbc": "idle"})
+	q := Queued{MDB: true, DBC: true}
+
+	type result struct {
+		outcomes map[string]Outcome
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		o, err := AwaitOutcomes(context.Background(), src, q, 2*time.Second)
+		done <- result{o, err}
+	}()
+
+	src.push("dbc", "installing")
+	src.push("dbc", "error")
+
+	// Unlike WaitForCompletion, a DBC error doesn't end the wait.
+	select {
+	case r := <-done:
+		t.Fatalf("returned before mdb finished: %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	src.push("mdb", "installing")
+	src.push("mdb", "pending-reboot")
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("expected nil, got %v", r.err)
+		}
+		if r.outcomes["mdb"] != OutcomeInstalled || r.outcomes["dbc"] != OutcomeFailed {
+			t.Errorf("outcomes = %v, want mdb installed, dbc failed", r.outcomes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+	}
+}
+
+func TestAwaitOutcomes_TimeoutLeavesUnknown(t *testing.T) {
+	src := newFakeOTASource(map[string]string{"mdb": "idle", "dbc": "idle"})
+	q := Queued{MDB: true, DBC: true}
+
+	src.push("dbc", "installing")
+	src.push("dbc", "pending-reboot")
+
+	outcomes, err := AwaitOutcomes(context.Background(), src, q, 100*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if outcomes["dbc"] != OutcomeInstalled || outcomes["mdb"] != OutcomeUnknown {
+		t.Errorf("outcomes = %v, want dbc installed, mdb unknown", outcomes)
+	}
+}
@@ -95,17 +95,7 @@ func TestCleanupStaleFiles(t *testing.T) {
 	must(filepath.Join(root, "some.service"))
 	must(filepath.Join(tmp, "scratch.txt"))
 
-	l := &Loader{
-		otaRootDir: root,
-		otaDir:     mdb,
-		dbcOtaDir:  dbc,
-		managedDirs: []managedDir{
-			{mdb, 1},
-			{dbc, 1},
-			{mdbBoot, 5},
-			{dbcBoot, 5},
-		},
-	}
+	l := New(nil, nil, root)
 
 	if err := l.CleanupStaleFiles(); err != nil {
 		t.Fatalf("CleanupStaleFiles: %v", err)
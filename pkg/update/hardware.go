@@ -0,0 +1,88 @@
+package update
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// deviceTypeFile is where mender records the board type, on the MDB and
+// the DBC alike, as a "device_type=librescoot-mdb" line.
+const deviceTypeFile = "/etc/mender/device_type"
+
+// ErrHardwareMismatch means an artifact was built for the other board.
+var ErrHardwareMismatch = errors.New("artifact is for different hardware")
+
+// DeviceTypeFunc returns the raw contents of a board's device_type file.
+type DeviceTypeFunc func(ctx context.Context) (string, error)
+
+func readLocalDeviceType(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(deviceTypeFile)
+	return string(data), err
+}
+
+func (l *Loader) readDBCDeviceType(ctx context.Context) (string, error) {
+	return l.dbcInterface.RunCommand(ctx, "cat "+deviceTypeFile)
+}
+
+// parseDeviceType extracts the device_type value, or "" if there is none.
+func parseDeviceType(contents string) string {
+	return menderField(contents, "device_type")
+}
+
+// menderField extracts the value of a key=value line from one of
+// mender's files in /etc/mender, or "" if there is none.
+func menderField(contents, key string) string {
+	sc := bufio.NewScanner(strings.NewReader(contents))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), key+"="); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// hardwareComponent maps a device type such as "librescoot-mdb" to "mdb"
+// or "dbc", or "" if it names neither.
+func hardwareComponent(deviceType string) string {
+	for _, part := range strings.FieldsFunc(deviceType, func(r rune) bool { return r == '-' || r == '_' }) {
+		if part == "mdb" || part == "dbc" {
+			return part
+		}
+	}
+	return ""
+}
+
+// checkHardware confirms that the board lookup describes is the one an
+// artifact for component ("mdb" or "dbc") is meant for, and, if artifact
+// names the file, that its header lists the board's device type; that
+// also catches an artifact for another revision of the same board. Only
+// a definite mismatch is refused: a board whose type can't be read or
+// isn't recognised is let through with a warning, leaving the decision
+// to mender's own compatibility check.
+func (l *Loader) checkHardware(ctx context.Context, component, artifact string, lookup DeviceTypeFunc) error {
+	contents, err := lookup(ctx)
+	if err != nil {
+		l.log.Warnf("cannot determine %s hardware type: %v", component, err)
+		return nil
+	}
+	deviceType := parseDeviceType(contents)
+	if deviceType == "" {
+		l.log.Warnf("no %s device type in %q", component, contents)
+		return nil
+	}
+	switch target := hardwareComponent(deviceType); target {
+	case "":
+		l.log.Warnf("unrecognised %s device type %q", component, deviceType)
+	case component:
+	default:
+		return fmt.Errorf("%w: %s artifact, target is %s (%s)", ErrHardwareMismatch, component, target, deviceType)
+	}
+	if artifact == "" {
+		return nil
+	}
+	return l.checkArtifactDeviceType(artifact, deviceType)
+}
@@ -0,0 +1,96 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func deviceType(contents string, err error) DeviceTypeFunc {
+	return func(context.Context) (string, error) { return contents, err }
+}
+
+func TestHardwareComponent(t *testing.T) {
+	cases := map[string]string{
+		"device_type=librescoot-mdb\n":         "mdb",
+		"artifact_name=x\ndevice_type=unu-dbc": "dbc",
+		"device_type=librescoot_mdb":           "mdb",
+		"device_type=raspberrypi4":             "",
+		"device_type=mdbx":                     "",
+		"":                                     "",
+	}
+	for contents, want := range cases {
+		if got := hardwareComponent(parseDeviceType(contents)); got != want {
+			t.Errorf("component of %q = %q, want %q", contents, got, want)
+		}
+	}
+}
+
+func TestCheckHardware(t *testing.T) {
+	cases := []struct {
+		name      string
+		component string
+		lookup    DeviceTypeFunc
+		wantErr   bool
+	}{
+		{"mdb on mdb", "mdb", deviceType("device_type=librescoot-mdb\n", nil), false},
+		{"dbc on dbc", "dbc", deviceType("device_type=librescoot-dbc\n", nil), false},
+		{"mdb on dbc", "mdb", deviceType("device_type=librescoot-dbc\n", nil), true},
+		{"dbc on mdb", "dbc", deviceType("device_type=librescoot-mdb\n", nil), true},
+		{"unreadable", "mdb", deviceType("", errors.New("no such file")), false},
+		{"unrecognised", "dbc", deviceType("device_type=generic\n", nil), false},
+	}
+	for _, c := range cases {
+		err := (&Loader{}).checkHardware(context.Background(), c.component, "", c.lookup)
+		if c.wantErr != errors.Is(err, ErrHardwareMismatch) {
+			t.Errorf("%s: err = %v, want mismatch %v", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestProcessUpdatesChecksMDBHardware(t *testing.T) {
+	const artifact = "librescoot-foo-mdb-stable-v1.0.0.mender"
+
+	cases := []struct {
+		name       string
+		deviceType string
+		wantStaged bool
+	}{
+		{"matching board proceeds", "device_type=librescoot-mdb\n", true},
+		{"wrong board is refused", "device_type=librescoot-dbc\n", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			usbDir := t.TempDir()
+			otaDir := t.TempDir()
+			updateDir := filepath.Join(usbDir, "system-update")
+			if err := os.MkdirAll(updateDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(updateDir, artifact), []byte("artifact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			l := &Loader{otaDir: otaDir, localDeviceType: deviceType(c.deviceType, nil)}
+			queued, err := l.ProcessUpdates(context.Background(), time.Minute, nil, usbDir)
+			if err != nil {
+				t.Fatalf("ProcessUpdates: %v", err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(otaDir, artifact))
+			if staged := statErr == nil; staged != c.wantStaged {
+				t.Errorf("artifact staged = %v, want %v", staged, c.wantStaged)
+			}
+			wantPushes := 0
+			if c.wantStaged {
+				wantPushes = 1
+			}
+			if queued.MDB != c.wantStaged || len(queued.PendingPushes) != wantPushes {
+				t.Errorf("queued = %+v, want MDB=%v", queued, c.wantStaged)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/librescoot/ums-service/pkg/umslog"
+)
+
+// artifactInfoFile is where mender records the installed artifact, on
+// the MDB and the DBC alike, as an "artifact_name=..." line.
+const artifactInfoFile = "/etc/mender/artifact_info"
+
+// errAlreadyInstalled means an artifact is the one its board runs.
+var errAlreadyInstalled = errors.New("already installed")
+
+// ArtifactInfoFunc returns the raw contents of a board's artifact_info
+// file.
+type ArtifactInfoFunc func(ctx context.Context) (string, error)
+
+func readLocalArtifactInfo(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(artifactInfoFile)
+	return string(data), err
+}
+
+func (l *Loader) readDBCArtifactInfo(ctx context.Context) (string, error) {
+	return l.dbcInterface.RunCommand(ctx, "cat "+artifactInfoFile)
+}
+
+// alreadyInstalled reports whether the artifact at path is the one the
+// board lookup reads artifact_info from is running, so leaving the same
+// update on the drive doesn't reinstall it, and reboot, every session.
+// Anything that can't be read counts as not installed.
+func (l *Loader) alreadyInstalled(ctx context.Context, component, path string, lookup ArtifactInfoFunc) bool {
+	if lookup == nil {
+		return false
+	}
+	name, err := artifactName(path)
+	if err != nil {
+		l.log.Warnf("cannot read artifact name of %s: %v", path, err)
+		return false
+	}
+	contents, err := lookup(ctx)
+	if err != nil {
+		l.log.Warnf("cannot determine installed %s artifact: %v", component, err)
+		return false
+	}
+	return menderField(contents, "artifact_name") == name
+}
+
+// skipInstalled reports an artifact skipped because its board already
+// runs it.
+func (l *Loader) skipInstalled(logger *umslog.Logger, filename string) {
+	l.log.Infof("Update %s already installed, skipping", filename)
+	if logger != nil {
+		logger.Logf("updates", "%s already installed, skipping", filename)
+	}
+}
@@ -0,0 +1,54 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessUpdatesSkipsInstalled(t *testing.T) {
+	const artifact = "librescoot-foo-mdb-stable-v1.2.0.mender"
+
+	cases := []struct {
+		name       string
+		info       string
+		infoErr    error
+		wantStaged bool
+	}{
+		{"same version is skipped", "artifact_name=librescoot-mdb-v1.2.0\n", nil, false},
+		{"other version is staged", "artifact_name=librescoot-mdb-v1.1.0\n", nil, true},
+		{"unreadable artifact_info", "", errors.New("no such file"), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			usbDir := t.TempDir()
+			otaDir := t.TempDir()
+			updateDir := filepath.Join(usbDir, "system-update")
+			if err := os.MkdirAll(updateDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeArtifact(t, filepath.Join(updateDir, artifact),
+				`{"artifact_provides":{"artifact_name":"librescoot-mdb-v1.2.0"},"artifact_depends":{"device_type":["librescoot-mdb"]}}`)
+
+			l := &Loader{
+				otaDir:          otaDir,
+				localDeviceType: deviceType("device_type=librescoot-mdb\n", nil),
+				localArtifactInfo: func(context.Context) (string, error) {
+					return c.info, c.infoErr
+				},
+			}
+			queued, err := l.ProcessUpdates(context.Background(), time.Minute, nil, usbDir)
+			if err != nil {
+				t.Fatalf("ProcessUpdates: %v", err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(otaDir, artifact))
+			if staged := statErr == nil; staged != c.wantStaged || queued.MDB != c.wantStaged {
+				t.Errorf("staged = %v, queued = %+v; want staged %v", staged, queued, c.wantStaged)
+			}
+		})
+	}
+}
@@ -71,6 +71,6 @@ func (s *ipcOTASource) Changes() <-chan StatusUpdate {
 
 func (s *ipcOTASource) Stop() {
 	if err := s.watcher.Stop(); err != nil {
-		log.Printf("ota source: stop watcher: %v", err)
+		log.Printf("Warning: ota source: stop watcher: %v", err)
 	}
 }
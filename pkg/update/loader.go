@@ -2,9 +2,9 @@ package update
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,6 +14,7 @@ import (
 
 	ipc "github.com/librescoot/redis-ipc"
 	"github.com/librescoot/ums-service/pkg/dbc"
+	"github.com/librescoot/ums-service/pkg/logging"
 	"github.com/librescoot/ums-service/pkg/umslog"
 )
 
@@ -21,9 +22,38 @@ type Loader struct {
 	otaRootDir   string
 	otaDir       string
 	dbcOtaDir    string
+	remoteOtaDir string // where DBC updates go on the DBC
 	managedDirs  []managedDir
 	client       *ipc.Client
 	dbcInterface *dbc.Interface
+	// localDeviceType and remoteDeviceType identify the MDB and the DBC,
+	// so an artifact is never installed on the wrong board.
+	localDeviceType  DeviceTypeFunc
+	remoteDeviceType DeviceTypeFunc
+	// localArtifactInfo and remoteArtifactInfo identify what each board
+	// runs, so an update it already has is skipped.
+	localArtifactInfo  ArtifactInfoFunc
+	remoteArtifactInfo ArtifactInfoFunc
+	// dryRun stages and queues nothing; see SetDryRun.
+	dryRun bool
+	// lockPath is written while ProcessUpdates runs; empty disables it.
+	lockPath string
+
+	log *logging.Logger
+}
+
+// SetLogger sets the logger the loader writes to.
+func (l *Loader) SetLogger(logger *logging.Logger) {
+	l.log = logger
+}
+
+// SetDryRun makes the loader check updates as usual but only log where
+// it would stage them and what it would queue for update-service, so
+// nothing is installed and no reboot follows. Stale artifacts are
+// reported rather than removed. A DBC update still goes through
+// dbc.Interface, which logs the transfer if it is in a dry run too.
+func (l *Loader) SetDryRun(dryRun bool) {
+	l.dryRun = dryRun
 }
 
 // managedDir is a subdirectory under /data/ota that ums-service is allowed to
@@ -47,6 +77,10 @@ type Queued struct {
 	MDB           bool
 	DBC           bool
 	PendingPushes []PendingPush
+	// MDBArtifact and DBCArtifact are the file names of the staged
+	// artifacts, empty for a board with none.
+	MDBArtifact string
+	DBCArtifact string
 }
 
 // PendingPush is an LPush operation deferred so the caller can subscribe
@@ -56,22 +90,35 @@ type PendingPush struct {
 	Value   string
 }
 
-func New(client *ipc.Client, dbcInterface *dbc.Interface) *Loader {
-	otaDir := "/data/ota/mdb"
-	dbcOtaDir := "/data/ota/dbc"
-	return &Loader{
-		otaRootDir: "/data/ota",
-		otaDir:     otaDir,
-		dbcOtaDir:  dbcOtaDir,
+// dbcOTADir is update-service's OTA directory on the DBC.
+const dbcOTADir = "/data/ota/dbc"
+
+// New stages updates under otaRootDir, normally /data/ota: MDB updates
+// in its mdb/ subdirectory, which update-service installs from.
+func New(client *ipc.Client, dbcInterface *dbc.Interface, otaRootDir string) *Loader {
+	otaDir := filepath.Join(otaRootDir, "mdb")
+	dbcOtaDir := filepath.Join(otaRootDir, "dbc")
+	l := &Loader{
+		otaRootDir:   otaRootDir,
+		otaDir:       otaDir,
+		dbcOtaDir:    dbcOtaDir,
+		remoteOtaDir: dbcOTADir,
 		managedDirs: []managedDir{
 			{otaDir, 1},
 			{dbcOtaDir, 1},
-			{"/data/ota/mdb-boot", 5},
-			{"/data/ota/dbc-boot", 5},
+			{filepath.Join(otaRootDir, "mdb-boot"), 5},
+			{filepath.Join(otaRootDir, "dbc-boot"), 5},
 		},
-		client:       client,
-		dbcInterface: dbcInterface,
-	}
+		client:            client,
+		dbcInterface:      dbcInterface,
+		localDeviceType:   readLocalDeviceType,
+		localArtifactInfo: readLocalArtifactInfo,
+		lockPath:          LockFile,
+		log:               logging.For("update"),
+	}
+	l.remoteDeviceType = l.readDBCDeviceType
+	l.remoteArtifactInfo = l.readDBCArtifactInfo
+	return l
 }
 
 // CleanupStaleFiles removes orphaned update artifacts under /data/ota:
@@ -94,7 +141,7 @@ func (l *Loader) CleanupStaleFiles() error {
 	}
 
 	if err := l.removeOrphanedUpdateFiles(); err != nil {
-		log.Printf("ota cleanup: orphan sweep failed: %v", err)
+		l.log.Warnf("ota cleanup: orphan sweep failed: %v", err)
 	}
 
 	skipPrune := map[string]bool{
@@ -106,7 +153,7 @@ func (l *Loader) CleanupStaleFiles() error {
 			continue
 		}
 		if err := l.pruneOldVersions(md.path, md.keep); err != nil {
-			log.Printf("ota cleanup: pruning %s failed: %v", md.path, err)
+			l.log.Warnf("ota cleanup: pruning %s failed: %v", md.path, err)
 		}
 	}
 	return nil
@@ -131,11 +178,15 @@ func (l *Loader) removeOrphanedUpdateFiles() error {
 		if allowedDirs[filepath.Clean(filepath.Dir(path))] {
 			return nil
 		}
+		if l.dryRun {
+			l.log.Infof("ota cleanup: would remove orphaned update file %s", path)
+			return nil
+		}
 		if rmErr := os.Remove(path); rmErr != nil {
-			log.Printf("ota cleanup: failed to remove orphaned %s: %v", path, rmErr)
+			l.log.Warnf("ota cleanup: failed to remove orphaned %s: %v", path, rmErr)
 			return nil
 		}
-		log.Printf("ota cleanup: removed orphaned update file %s", path)
+		l.log.Infof("ota cleanup: removed orphaned update file %s", path)
 		return nil
 	})
 }
@@ -177,11 +228,15 @@ func (l *Loader) pruneOldVersions(dir string, keep int) error {
 		// Keep `keep` newest (tail of the sorted slice); remove the rest.
 		for _, old := range files[:len(files)-keep] {
 			path := filepath.Join(dir, old)
+			if l.dryRun {
+				l.log.Infof("ota cleanup: would remove old update %s", path)
+				continue
+			}
 			if err := os.Remove(path); err != nil {
-				log.Printf("ota cleanup: failed to remove old %s: %v", path, err)
+				l.log.Warnf("ota cleanup: failed to remove old %s: %v", path, err)
 				continue
 			}
-			log.Printf("ota cleanup: removed old update %s", path)
+			l.log.Infof("ota cleanup: removed old update %s", path)
 		}
 	}
 	return nil
@@ -258,18 +313,29 @@ func (l *Loader) PrepareUSB(usbMountPath string) error {
 	if err := os.MkdirAll(updateDir, 0755); err != nil {
 		return fmt.Errorf("failed to create system-update directory: %w", err)
 	}
-	log.Println("Created system-update directory on USB drive")
+	l.log.Infof("Created system-update directory on USB drive")
 	return nil
 }
 
+// ProcessUpdates checks each librescoot-*.mender / *.delta artifact in
+// the drive's system-update/ and stages it for its board: MDB artifacts
+// are copied to the OTA directory, DBC artifacts transferred to the DBC.
+// Nothing is installed here. Installing is update-service's job, as for
+// updates from any other source; the returned Queued carries the
+// update-from-file:<path> requests for scooter:update:mdb and
+// scooter:update:dbc, which the caller pushes once it is watching for
+// the outcome.
 func (l *Loader) ProcessUpdates(ctx context.Context, perFileTimeout time.Duration, logger *umslog.Logger, usbMountPath string) (Queued, error) {
 	var queued Queued
 	updateDir := filepath.Join(usbMountPath, "system-update")
 
+	l.acquireLock()
+	defer l.releaseLock()
+
 	entries, err := os.ReadDir(updateDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Println("No system-update directory found")
+			l.log.Infof("No system-update directory found")
 			return queued, nil
 		}
 		return queued, fmt.Errorf("failed to read update directory: %w", err)
@@ -287,19 +353,52 @@ func (l *Loader) ProcessUpdates(ctx context.Context, perFileTimeout time.Duratio
 
 		srcPath := filepath.Join(updateDir, filename)
 
+		// Checked on the drive's copy, before anything is staged or sent
+		// to the DBC.
+		if err := l.verifyArtifact(srcPath); err != nil {
+			l.refuseUpdate(logger, filename, err)
+			continue
+		}
+
 		if strings.Contains(filename, "-mdb") {
+			if err := l.checkHardware(ctx, "mdb", srcPath, l.localDeviceType); err != nil {
+				l.refuseUpdate(logger, filename, err)
+				continue
+			}
+			if l.alreadyInstalled(ctx, "mdb", srcPath, l.localArtifactInfo) {
+				l.skipInstalled(logger, filename)
+				continue
+			}
+			if l.dryRun {
+				l.log.Infof("would stage %s in %s and queue it on scooter:update:mdb", filename, l.otaDir)
+				continue
+			}
 			push, err := l.processMDBUpdate(logger, srcPath)
 			if err != nil {
 				return queued, fmt.Errorf("failed to process MDB update: %w", err)
 			}
 			queued.MDB = true
+			queued.MDBArtifact = filename
 			queued.PendingPushes = append(queued.PendingPushes, push)
 		} else if strings.Contains(filename, "-dbc") {
 			push, err := l.processDBCUpdate(ctx, perFileTimeout, logger, srcPath)
+			if errors.Is(err, ErrHardwareMismatch) {
+				l.refuseUpdate(logger, filename, err)
+				continue
+			}
+			if errors.Is(err, errAlreadyInstalled) {
+				l.skipInstalled(logger, filename)
+				continue
+			}
 			if err != nil {
 				return queued, fmt.Errorf("failed to process DBC update: %w", err)
 			}
+			if l.dryRun {
+				l.log.Infof("would queue %s on %s", push.Value, push.Channel)
+				continue
+			}
 			queued.DBC = true
+			queued.DBCArtifact = filename
 			queued.PendingPushes = append(queued.PendingPushes, push)
 		}
 	}
@@ -307,9 +406,18 @@ func (l *Loader) ProcessUpdates(ctx context.Context, perFileTimeout time.Duratio
 	return queued, nil
 }
 
+// refuseUpdate reports an artifact skipped because it doesn't match the
+// board it would be installed on or its checksum.
+func (l *Loader) refuseUpdate(logger *umslog.Logger, filename string, err error) {
+	l.log.Warnf("Refusing update %s: %v", filename, err)
+	if logger != nil {
+		logger.Error("updates", "refusing %s: %v", filename, err)
+	}
+}
+
 func (l *Loader) processMDBUpdate(logger *umslog.Logger, srcPath string) (PendingPush, error) {
 	filename := filepath.Base(srcPath)
-	log.Printf("Processing MDB update: %s", filename)
+	l.log.Infof("Processing MDB update: %s", filename)
 	if logger != nil {
 		logger.Logf("updates", "copying MDB update %s", filename)
 	}
@@ -320,12 +428,12 @@ func (l *Loader) processMDBUpdate(logger *umslog.Logger, srcPath string) (Pendin
 
 	dstPath := filepath.Join(l.otaDir, filename)
 
-	// Copy instead of rename — source is on vfat, destination on ext4
+	// Copy instead of rename — source is on the USB drive, destination on /data
 	if err := copyFile(srcPath, dstPath); err != nil {
 		return PendingPush{}, fmt.Errorf("failed to copy update file: %w", err)
 	}
 
-	log.Printf("Successfully staged MDB update: %s", filename)
+	l.log.Infof("Successfully staged MDB update: %s", filename)
 	if logger != nil {
 		logger.Logf("updates", "staged MDB update %s -> %s", filename, dstPath)
 	}
@@ -357,7 +465,7 @@ func copyFile(src, dst string) error {
 
 func (l *Loader) processDBCUpdate(ctx context.Context, timeout time.Duration, logger *umslog.Logger, srcPath string) (PendingPush, error) {
 	filename := filepath.Base(srcPath)
-	log.Printf("Processing DBC update: %s", filename)
+	l.log.Infof("Processing DBC update: %s", filename)
 
 	if !l.dbcInterface.IsEnabled() {
 		return PendingPush{}, fmt.Errorf("DBC interface not enabled for update")
@@ -366,9 +474,16 @@ func (l *Loader) processDBCUpdate(ctx context.Context, timeout time.Duration, lo
 	opCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	remotePath := filepath.Join(l.dbcOtaDir, filename)
+	if err := l.checkHardware(opCtx, "dbc", srcPath, l.remoteDeviceType); err != nil {
+		return PendingPush{}, err
+	}
+	if l.alreadyInstalled(opCtx, "dbc", srcPath, l.remoteArtifactInfo) {
+		return PendingPush{}, errAlreadyInstalled
+	}
+
+	remotePath := filepath.Join(l.remoteOtaDir, filename)
 
-	if _, err := l.dbcInterface.RunCommand(opCtx, fmt.Sprintf("mkdir -p %s", l.dbcOtaDir)); err != nil {
+	if _, err := l.dbcInterface.RunCommand(opCtx, "mkdir -p "+dbc.ShellQuote(l.remoteOtaDir)); err != nil {
 		return PendingPush{}, fmt.Errorf("failed to create remote OTA directory: %w", err)
 	}
 
@@ -380,8 +495,19 @@ func (l *Loader) processDBCUpdate(ctx context.Context, timeout time.Duration, lo
 	if err := l.dbcInterface.TransferFile(opCtx, srcPath, remotePath, progress); err != nil {
 		return PendingPush{}, fmt.Errorf("failed to transfer update to DBC: %w", err)
 	}
+	if err := l.dbcInterface.ApplyOwnership(opCtx, remotePath); err != nil {
+		l.log.Warnf("%v", err)
+	}
+	// A rejected artifact is removed rather than queued, so update-service
+	// never installs it.
+	if err := l.dbcInterface.Verify(opCtx, "update", remotePath); err != nil {
+		if _, rerr := l.dbcInterface.RunCommand(opCtx, "rm -f "+dbc.ShellQuote(remotePath)); rerr != nil {
+			l.log.Warnf("failed to remove rejected update %s: %v", remotePath, rerr)
+		}
+		return PendingPush{}, err
+	}
 
-	log.Printf("Copied DBC update to %s", remotePath)
+	l.log.Infof("Copied DBC update to %s", remotePath)
 
 	// Tell the dbc.Interface to leave the vehicle-service update lock
 	// held after Disable(). update-service runs the actual mender
@@ -391,7 +517,7 @@ func (l *Loader) processDBCUpdate(ctx context.Context, timeout time.Duration, lo
 	// power before the installation finishes.
 	l.dbcInterface.MarkDBCUpdateQueued()
 
-	log.Printf("Successfully staged DBC update: %s", filename)
+	l.log.Infof("Successfully staged DBC update: %s", filename)
 	if logger != nil {
 		logger.Logf("updates", "staged DBC update %s -> %s", filename, remotePath)
 	}
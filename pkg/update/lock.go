@@ -0,0 +1,54 @@
+package update
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LockFile exists while ProcessUpdates runs and holds the service's PID,
+// so an operator can see that updates are being staged or sent to the
+// DBC and a reboot can wait for it.
+const LockFile = "/run/ums-update.lock"
+
+// acquireLock writes the lock file. Failing to is only logged: the lock
+// is advisory and must not stop the updates themselves.
+func (l *Loader) acquireLock() {
+	if l.lockPath == "" {
+		return
+	}
+	if err := os.WriteFile(l.lockPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		l.log.Warnf("failed to create %s: %v", l.lockPath, err)
+	}
+}
+
+func (l *Loader) releaseLock() {
+	if l.lockPath == "" {
+		return
+	}
+	if err := os.Remove(l.lockPath); err != nil && !os.IsNotExist(err) {
+		l.log.Warnf("failed to remove %s: %v", l.lockPath, err)
+	}
+}
+
+// Locked reports whether the lock file at path is held by a running
+// process. A lock left behind by a process that has since exited is
+// ignored; one whose PID can't be read is taken as held.
+func Locked(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return true, fmt.Errorf("%s: bad pid %q", path, strings.TrimSpace(string(data)))
+	}
+	if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package update
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLocked(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string // "" means no lock file
+		want    bool
+		wantErr bool
+	}{
+		{name: "no lock"},
+		{name: "held", content: strconv.Itoa(os.Getpid()) + "\n", want: true},
+		{name: "stale", content: "2147483646\n"},
+		{name: "garbled", content: "ums\n", want: true, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ums-update.lock")
+			if c.content != "" {
+				if err := os.WriteFile(path, []byte(c.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := Locked(path)
+			if got != c.want || (err != nil) != c.wantErr {
+				t.Errorf("Locked = %v, %v; want %v, err %v", got, err, c.want, c.wantErr)
+			}
+		})
+	}
+}
+
+// TestProcessUpdatesLock checks that the lock is held while updates are
+// processed and released afterwards.
+func TestProcessUpdatesLock(t *testing.T) {
+	usbDir := t.TempDir()
+	updateDir := filepath.Join(usbDir, "system-update")
+	if err := os.MkdirAll(updateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	artifact := filepath.Join(updateDir, "librescoot-foo-mdb-stable-v1.0.0.mender")
+	if err := os.WriteFile(artifact, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := filepath.Join(t.TempDir(), "ums-update.lock")
+	var heldDuring bool
+	l := &Loader{
+		otaDir:   t.TempDir(),
+		lockPath: lock,
+		localDeviceType: func(context.Context) (string, error) {
+			heldDuring, _ = Locked(lock)
+			return "device_type=librescoot-mdb\n", nil
+		},
+	}
+	if _, err := l.ProcessUpdates(context.Background(), time.Minute, nil, usbDir); err != nil {
+		t.Fatalf("ProcessUpdates: %v", err)
+	}
+	if !heldDuring {
+		t.Error("lock not held while processing")
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("lock left behind: %v", err)
+	}
+}
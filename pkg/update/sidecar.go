@@ -0,0 +1,44 @@
+package update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/librescoot/ums-service/pkg/checksum"
+)
+
+// checksumSuffix names the sidecar holding an artifact's SHA256, as
+// written by `sha256sum <artifact> > <artifact>.sha256`.
+const checksumSuffix = ".sha256"
+
+// ErrChecksumMismatch means an artifact doesn't match its .sha256 sidecar,
+// typically because the copy onto the drive was cut short or corrupted.
+var ErrChecksumMismatch = errors.New("artifact checksum mismatch")
+
+// verifyArtifact checks the artifact at path against path.sha256. A
+// missing sidecar only logs a warning, so drives prepared without one keep
+// working. The sidecar holds the hex digest, optionally followed by the
+// file name.
+func (l *Loader) verifyArtifact(path string) error {
+	name := filepath.Base(path)
+	data, err := os.ReadFile(path + checksumSuffix)
+	if os.IsNotExist(err) {
+		l.log.Warnf("no %s%s, installing %s unverified", name, checksumSuffix, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: cannot read %s%s: %v", ErrChecksumMismatch, name, checksumSuffix, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: %s%s is empty", ErrChecksumMismatch, name, checksumSuffix)
+	}
+	if err := checksum.SHA256.Verify(path, fields[0]); err != nil {
+		return fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	l.log.Infof("Verified SHA256 of %s", name)
+	return nil
+}
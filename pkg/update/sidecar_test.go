@@ -0,0 +1,93 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	// sha256("artifact"), the test artifacts' contents.
+	artifactSHA256 = "c7c5c1d70c5dec4416ab6158afd0b223ef40c29b1dc1f97ed9428b94d4cadb1c"
+	// sha256(""), as left by a truncated copy.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+func TestVerifyArtifact(t *testing.T) {
+	cases := []struct {
+		name    string
+		sidecar string // "-" for none
+		wantErr bool
+	}{
+		{"no sidecar", "-", false},
+		{"bare digest", artifactSHA256 + "\n", false},
+		{"sha256sum format", artifactSHA256 + "  librescoot-x.mender\n", false},
+		{"upper case", strings.ToUpper(artifactSHA256), false},
+		{"mismatch", emptySHA256, true},
+		{"empty", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "librescoot-x.mender")
+			if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if c.sidecar != "-" {
+				if err := os.WriteFile(path+checksumSuffix, []byte(c.sidecar), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := (&Loader{}).verifyArtifact(path)
+			if c.wantErr != errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("verifyArtifact = %v, want mismatch %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessUpdatesVerifiesChecksums(t *testing.T) {
+	cases := []struct {
+		name       string
+		artifact   string
+		sidecar    string
+		wantStaged bool
+	}{
+		{"matching MDB artifact is staged", "librescoot-foo-mdb-stable-v1.0.0.mender", artifactSHA256, true},
+		{"corrupt MDB artifact is refused", "librescoot-foo-mdb-stable-v1.0.0.mender", emptySHA256, false},
+		// No DBC is set up: reaching the transfer would fail the call.
+		{"corrupt DBC artifact is refused before transfer", "librescoot-foo-dbc-stable-v1.0.0.mender", emptySHA256, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			usbDir := t.TempDir()
+			otaDir := t.TempDir()
+			updateDir := filepath.Join(usbDir, "system-update")
+			if err := os.MkdirAll(updateDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			src := filepath.Join(updateDir, c.artifact)
+			if err := os.WriteFile(src, []byte("artifact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(src+checksumSuffix, []byte(c.sidecar+"  "+c.artifact+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			l := &Loader{otaDir: otaDir, localDeviceType: deviceType("device_type=librescoot-mdb\n", nil)}
+			queued, err := l.ProcessUpdates(context.Background(), time.Minute, nil, usbDir)
+			if err != nil {
+				t.Fatalf("ProcessUpdates: %v", err)
+			}
+			if staged := queued.MDB || queued.DBC; staged != c.wantStaged {
+				t.Errorf("queued = %+v, want staged %v", queued, c.wantStaged)
+			}
+			if _, err := os.Stat(filepath.Join(otaDir, c.artifact)); (err == nil) != c.wantStaged {
+				t.Errorf("artifact in OTA dir: %v, want staged %v", err == nil, c.wantStaged)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package usb
+
+import (
+	"os"
+	"time"
+)
+
+// driveStamp is the drive image's modification time and size. The
+// mass-storage gadget writes through to the image file, so a host write
+// changes its mtime; comparing stamps taken before and after a session
+// tells whether the host wrote anything.
+type driveStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statDrive(path string) (driveStamp, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return driveStamp{}, false
+	}
+	return driveStamp{modTime: info.ModTime(), size: info.Size()}, true
+}
+
+// markExport records the drive image's stamp as it is handed to the host.
+func (c *Controller) markExport() {
+	c.exportStamp, c.exportMarked = statDrive(c.driveFile)
+}
+
+// HadActivity reports whether the host wrote to the drive during the
+// last UMS session. It errs towards true: when there is nothing to
+// compare against, e.g. because the session was started by a previous
+// run of the service, or the image can't be read, it can't tell and says
+// the host did. Anything that mounts the filesystem read-write, even
+// without copying files, counts as a write.
+func (c *Controller) HadActivity() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.exportMarked {
+		return true
+	}
+	now, ok := statDrive(c.driveFile)
+	if !ok {
+		return true
+	}
+	return !now.modTime.Equal(c.exportStamp.modTime) || now.size != c.exportStamp.size
+}
@@ -0,0 +1,68 @@
+package usb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestHadActivity(t *testing.T) {
+	tests := []struct {
+		name    string
+		session bool
+		host    func(t *testing.T, path string)
+		want    bool
+	}{
+		{name: "untouched", session: true, want: false},
+		{
+			name:    "written",
+			session: true,
+			host: func(t *testing.T, path string) {
+				later := time.Now().Add(time.Minute)
+				if err := os.Chtimes(path, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{
+			name:    "image gone",
+			session: true,
+			host: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: true,
+		},
+		{name: "session from a previous run", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "usb.drive")
+			if err := os.WriteFile(path, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c := NewController(path, Identity{}, &commandtest.Recorder{})
+			if tt.session {
+				if err := c.SwitchMode("ums"); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				c.currentMode = "ums"
+			}
+			if tt.host != nil {
+				tt.host(t, path)
+			}
+			if err := c.SwitchMode("normal"); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.HadActivity(); got != tt.want {
+				t.Errorf("HadActivity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
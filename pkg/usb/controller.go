@@ -1,40 +1,208 @@
 package usb
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/librescoot/ums-service/pkg/command"
+	"github.com/librescoot/ums-service/pkg/logging"
 )
 
 const (
 	udcStatePath = "/sys/class/udc/ci_hdrc.0/state"
 
+	// procModulesPath lists the loaded kernel modules, one per line with
+	// the name first.
+	procModulesPath = "/proc/modules"
+
+	// lunFileGlob matches the backing-file attribute of each LUN of a
+	// bound mass-storage gadget.
+	lunFileGlob = "/sys/class/udc/*/device/gadget*/lun*/file"
+
 	// UDC states
 	udcStateConfigured = "configured"
+	udcStateSuspended  = "suspended"
+
+	defaultLinkCheckInterval = 10 * time.Second
 )
 
+// Gadget is the kernel module loaded for normal mode. An empty Module
+// means no gadget: normal mode just unloads mass storage.
+type Gadget struct {
+	Module string
+	Params []string
+}
+
+var moduleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseGadget parses a normal-mode gadget spec: a module name followed by
+// space-separated parameters ("g_serial use_acm=1"), or "none".
+func ParseGadget(spec string) (Gadget, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return Gadget{}, fmt.Errorf("empty gadget spec (use \"none\" for no gadget)")
+	}
+	if fields[0] == "none" {
+		if len(fields) > 1 {
+			return Gadget{}, fmt.Errorf("\"none\" takes no parameters")
+		}
+		return Gadget{}, nil
+	}
+	if !moduleNamePattern.MatchString(fields[0]) {
+		return Gadget{}, fmt.Errorf("invalid module name %q", fields[0])
+	}
+	for _, p := range fields[1:] {
+		if !strings.Contains(p, "=") {
+			return Gadget{}, fmt.Errorf("parameter %q: expected name=value", p)
+		}
+	}
+	return Gadget{Module: fields[0], Params: fields[1:]}, nil
+}
+
+func (g Gadget) String() string {
+	if g.Module == "" {
+		return "none"
+	}
+	return strings.Join(append([]string{g.Module}, g.Params...), " ")
+}
+
 type Controller struct {
 	currentMode     string
 	mu              sync.Mutex
 	driveFile       string
+	identity        Identity
+	normalGadget    Gadget
+	runner          command.Runner
+	dryRun          bool
+	lunGlob         string
+	udcStatePath    string
+	modulesPath     string
 	stopMonitor     chan struct{}
 	monitorRunning  bool
 	detachCh        chan struct{}
+	recoveredCh     chan string
 	monitorInterval time.Duration
+	linkInterval    time.Duration
+	// exportStamp is the drive image as the last UMS session began, for
+	// HadActivity; exportMarked is false if it couldn't be taken.
+	exportStamp  driveStamp
+	exportMarked bool
+	// readOnly exports the drive read-only; see SetReadOnly.
+	readOnly bool
+	// extraLUNs are presented after the drive; see SetExtraLUNs.
+	extraLUNs []LUN
+	// observeState, if set, is called with each UDC state the monitor
+	// reads, so tests can wait for it to have seen one.
+	observeState func(state string)
+
+	log *logging.Logger
 }
 
-func NewController(driveFile string) *Controller {
-	return &Controller{
+// SetLogger sets the logger the controller writes to.
+func (c *Controller) SetLogger(logger *logging.Logger) {
+	c.log = logger
+}
+
+// NewController manages the gadget that exposes driveFile, presenting
+// it to hosts as id and loading and unloading modules through runner. The
+// current mode is taken from the loaded modules, so a controller created
+// while a previous run left mass storage loaded starts out in UMS mode.
+func NewController(driveFile string, id Identity, runner command.Runner) *Controller {
+	c := &Controller{
 		currentMode:     "normal",
 		driveFile:       driveFile,
+		identity:        id,
+		normalGadget:    Gadget{Module: "g_ether"},
+		runner:          runner,
+		lunGlob:         lunFileGlob,
+		udcStatePath:    udcStatePath,
+		modulesPath:     procModulesPath,
 		stopMonitor:     make(chan struct{}),
 		detachCh:        make(chan struct{}, 1),
+		recoveredCh:     make(chan string, 1),
 		monitorInterval: 2 * time.Second,
+		linkInterval:    defaultLinkCheckInterval,
+		log:             logging.For("usb"),
+	}
+	if _, err := c.DetectCurrentMode(); err != nil {
+		c.log.Warnf("cannot detect current USB mode, assuming normal: %v", err)
 	}
+	return c
+}
+
+// DetectCurrentMode sets the current mode from the loaded kernel modules:
+// "ums" if g_mass_storage is loaded, "normal" otherwise. A kernel without
+// module support has nothing loaded and is in normal mode.
+func (c *Controller) DetectCurrentMode() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded, err := massStorageLoaded(c.modulesPath)
+	if err != nil {
+		return c.currentMode, err
+	}
+	c.currentMode = "normal"
+	if loaded {
+		c.currentMode = "ums"
+	}
+	return c.currentMode, nil
+}
+
+// massStorageLoaded reports whether g_mass_storage is listed in the
+// modules file at path.
+func massStorageLoaded(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "g_mass_storage" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetDryRun makes the controller log the modprobe and rmmod calls a mode
+// switch would make instead of running them; the mode still changes.
+func (c *Controller) SetDryRun(dryRun bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dryRun = dryRun
+}
+
+func (c *Controller) run(name string, args ...string) ([]byte, error) {
+	if c.dryRun {
+		c.log.WouldRun(name, args...)
+		return nil, nil
+	}
+	return c.runner.Run(context.Background(), name, args...)
+}
+
+// SetReadOnly sets whether UMS mode presents the drive to the host
+// read-only (ro=1). It takes effect the next time mass storage is
+// loaded.
+func (c *Controller) SetReadOnly(readOnly bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readOnly = readOnly
+}
+
+// SetNormalGadget replaces the default g_ether normal-mode gadget.
+func (c *Controller) SetNormalGadget(g Gadget) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.normalGadget = g
 }
 
 func (c *Controller) SwitchMode(mode string) error {
@@ -42,11 +210,11 @@ func (c *Controller) SwitchMode(mode string) error {
 	defer c.mu.Unlock()
 
 	if c.currentMode == mode {
-		log.Printf("Already in %s mode", mode)
+		c.log.Debugf("Already in %s mode", mode)
 		return nil
 	}
 
-	log.Printf("Switching from %s to %s mode", c.currentMode, mode)
+	c.log.Infof("Switching from %s to %s mode", c.currentMode, mode)
 
 	switch mode {
 	case "ums":
@@ -66,33 +234,48 @@ func (c *Controller) SwitchMode(mode string) error {
 }
 
 func (c *Controller) switchToUMS() error {
-	if err := c.unloadModule("g_ether"); err != nil {
-		log.Printf("Warning: failed to unload g_ether: %v", err)
+	// Checked before the normal gadget goes, so a bad config leaves the
+	// network link up.
+	if err := c.identity.Validate(); err != nil {
+		return fmt.Errorf("refusing to load g_mass_storage: %w", err)
 	}
 
-	if err := c.loadModule("g_mass_storage",
-		fmt.Sprintf("file=%s", c.driveFile),
-		"removable=1",
-		"ro=0",
-		"stall=0",
-		"iSerialNumber=1234567890"); err != nil {
-		return fmt.Errorf("failed to load g_mass_storage: %w", err)
+	if g := c.normalGadget; g.Module != "" {
+		if err := c.unloadModule(g.Module); err != nil {
+			c.log.Warnf("failed to unload %s: %v", g.Module, err)
+		}
+	}
+
+	c.markExport()
+	if err := c.loadMassStorage(); err != nil {
+		return err
 	}
 
-	log.Println("Switched to UMS mode")
+	c.log.Infof("Switched to UMS mode")
+	return nil
+}
+
+func (c *Controller) loadMassStorage() error {
+	params := append(c.lunParams(), "stall=0")
+	params = append(params, c.identity.params()...)
+	if err := c.loadModule("g_mass_storage", params...); err != nil {
+		return fmt.Errorf("failed to load g_mass_storage: %w", err)
+	}
 	return nil
 }
 
 func (c *Controller) switchToNormal() error {
 	if err := c.unloadModule("g_mass_storage"); err != nil {
-		log.Printf("Warning: failed to unload g_mass_storage: %v", err)
+		c.log.Warnf("failed to unload g_mass_storage: %v", err)
 	}
 
-	if err := c.loadModule("g_ether"); err != nil {
-		return fmt.Errorf("failed to load g_ether: %w", err)
+	if g := c.normalGadget; g.Module != "" {
+		if err := c.loadModule(g.Module, g.Params...); err != nil {
+			return fmt.Errorf("failed to load %s: %w", g.Module, err)
+		}
 	}
 
-	log.Println("Switched to normal mode")
+	c.log.Infof("Switched to normal mode")
 	return nil
 }
 
@@ -100,8 +283,7 @@ func (c *Controller) loadModule(module string, params ...string) error {
 	args := []string{module}
 	args = append(args, params...)
 
-	cmd := exec.Command("modprobe", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := c.run("modprobe", args...)
 	if err != nil {
 		return fmt.Errorf("modprobe %s failed: %v, output: %s", module, err, string(output))
 	}
@@ -109,8 +291,7 @@ func (c *Controller) loadModule(module string, params ...string) error {
 }
 
 func (c *Controller) unloadModule(module string) error {
-	cmd := exec.Command("rmmod", module)
-	output, err := cmd.CombinedOutput()
+	output, err := c.run("rmmod", module)
 	if err != nil {
 		if strings.Contains(string(output), "not currently loaded") {
 			return nil
@@ -120,6 +301,73 @@ func (c *Controller) unloadModule(module string) error {
 	return nil
 }
 
+// ReconcileGadget checks for a mass-storage gadget left bound by a
+// previous run. If any of its LUNs is backed by something other than the
+// configured drive file (the config changed while UMS was active), the
+// gadget is torn down and the normal-mode gadget loaded, so the next
+// switch can't expose the wrong image.
+func (c *Controller) ReconcileGadget() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	luns, err := readLUNFiles(c.lunGlob)
+	if err != nil {
+		return err
+	}
+	if len(luns) == 0 {
+		return nil
+	}
+
+	stale := mismatchedLUNs(luns, c.lunFiles())
+	if len(stale) == 0 {
+		c.log.Infof("Mass-storage gadget from a previous run is still bound to %s", c.driveFile)
+		return nil
+	}
+	for _, lun := range stale {
+		c.log.Warnf("%s backs %q, expected %q", lun, luns[lun], c.lunFile(lun))
+	}
+	if err := c.switchToNormal(); err != nil {
+		return fmt.Errorf("failed to unbind stale mass-storage gadget: %w", err)
+	}
+	c.currentMode = "normal"
+	return nil
+}
+
+// readLUNFiles returns the trimmed contents of every LUN file attribute
+// matching glob, keyed by path. No matches means no gadget is bound.
+func readLUNFiles(glob string) (map[string]string, error) {
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	luns := make(map[string]string, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		luns[p] = strings.TrimSpace(string(data))
+	}
+	return luns, nil
+}
+
+// mismatchedLUNs returns, sorted, the LUNs backed by a file not in
+// want. A LUN with no medium (empty file) is not a mismatch.
+func mismatchedLUNs(luns map[string]string, want []string) []string {
+	ok := make(map[string]bool, len(want))
+	for _, file := range want {
+		ok[filepath.Clean(file)] = true
+	}
+	var stale []string
+	for lun, file := range luns {
+		if file != "" && !ok[filepath.Clean(file)] {
+			stale = append(stale, lun)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
 func (c *Controller) GetCurrentMode() string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -160,35 +408,68 @@ func (c *Controller) StopMonitoring() {
 
 // monitorLoop polls UDC state to detect when the USB host disconnects.
 // It watches for transitions from "configured" (host present) to any other
-// state while in UMS mode, and signals via detachCh.
+// state while in UMS mode, and signals via detachCh. "suspended" is not a
+// disconnect: the host went to sleep with the cable still in, and the link
+// is checked (and recovered if need be) once it's back. Between those, the
+// link is checked every linkInterval.
 func (c *Controller) monitorLoop() {
 	ticker := time.NewTicker(c.monitorInterval)
 	defer ticker.Stop()
 
+	var linkTick <-chan time.Time
+	if c.linkInterval > 0 {
+		linkTicker := time.NewTicker(c.linkInterval)
+		defer linkTicker.Stop()
+		linkTick = linkTicker.C
+	}
+
 	wasConfigured := false
+	suspended := false
 
 	for {
 		select {
 		case <-c.stopMonitor:
-			log.Println("USB monitoring stopped")
+			c.log.Infof("USB monitoring stopped")
 			return
+		case <-linkTick:
+			if c.GetCurrentMode() == "ums" && !suspended && c.udcState() == udcStateConfigured {
+				c.checkLink(false)
+			}
 		case <-ticker.C:
 			if c.GetCurrentMode() != "ums" {
 				wasConfigured = false
+				suspended = false
 				continue
 			}
 
-			configured := c.isHostConnected()
+			state := c.udcState()
+			if c.observeState != nil {
+				c.observeState(state)
+			}
 
-			if configured {
+			if state == udcStateConfigured {
+				if suspended {
+					suspended = false
+					c.log.Infof("USB host resumed")
+					c.checkLink(true)
+				}
 				wasConfigured = true
 				continue
 			}
 
+			if state == udcStateSuspended && wasConfigured {
+				if !suspended {
+					suspended = true
+					c.log.Infof("USB host suspended")
+				}
+				continue
+			}
+
 			// Transition: configured → not configured = detach event
 			if wasConfigured {
 				wasConfigured = false
-				log.Println("USB host disconnected (UDC state left configured)")
+				suspended = false
+				c.log.Infof("USB host disconnected (UDC state left configured)")
 				select {
 				case c.detachCh <- struct{}{}:
 				default:
@@ -198,13 +479,12 @@ func (c *Controller) monitorLoop() {
 	}
 }
 
-// isHostConnected checks whether a USB host is actively connected by
-// reading the UDC state from sysfs. The "configured" state means the
-// host has completed enumeration and is using the gadget.
-func (c *Controller) isHostConnected() bool {
-	data, err := os.ReadFile(udcStatePath)
+// udcState reads the UDC state from sysfs. "configured" means the host
+// has completed enumeration and is using the gadget.
+func (c *Controller) udcState() string {
+	data, err := os.ReadFile(c.udcStatePath)
 	if err != nil {
-		return false
+		return ""
 	}
-	return strings.TrimSpace(string(data)) == udcStateConfigured
+	return strings.TrimSpace(string(data))
 }
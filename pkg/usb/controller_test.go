@@ -0,0 +1,243 @@
+package usb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func newTestController(g Gadget) (*Controller, *commandtest.Recorder) {
+	r := &commandtest.Recorder{}
+	c := NewController("/data/usb.drive", Identity{}, r)
+	c.SetNormalGadget(g)
+	return c, r
+}
+
+func TestParseGadget(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Gadget
+		wantErr bool
+	}{
+		{spec: "g_ether", want: Gadget{Module: "g_ether", Params: []string{}}},
+		{spec: "g_serial use_acm=1 n_ports=2", want: Gadget{Module: "g_serial", Params: []string{"use_acm=1", "n_ports=2"}}},
+		{spec: "none", want: Gadget{}},
+		{spec: "", wantErr: true},
+		{spec: "none foo=1", wantErr: true},
+		{spec: "g_serial;reboot", wantErr: true},
+		{spec: "g_serial use_acm", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseGadget(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseGadget(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseGadget(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchModeUsesConfiguredGadget(t *testing.T) {
+	c, r := newTestController(Gadget{Module: "g_serial", Params: []string{"use_acm=1"}})
+
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SwitchMode("normal"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"rmmod g_serial",
+		"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=0 stall=0 iSerialNumber=1234567890",
+		"rmmod g_mass_storage",
+		"modprobe g_serial use_acm=1",
+	}
+	if !reflect.DeepEqual(r.Calls(), want) {
+		t.Errorf("commands:\n got %q\nwant %q", r.Calls(), want)
+	}
+}
+
+func TestSwitchModeReadOnly(t *testing.T) {
+	c, r := newTestController(Gadget{})
+
+	c.SetReadOnly(true)
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SwitchMode("normal"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadOnly(false)
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=1 stall=0 iSerialNumber=1234567890",
+		"rmmod g_mass_storage",
+		"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=0 stall=0 iSerialNumber=1234567890",
+	}
+	if !reflect.DeepEqual(r.Calls(), want) {
+		t.Errorf("commands:\n got %q\nwant %q", r.Calls(), want)
+	}
+}
+
+func TestSwitchModeNoneGadget(t *testing.T) {
+	c, r := newTestController(Gadget{})
+
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SwitchMode("normal"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=0 stall=0 iSerialNumber=1234567890",
+		"rmmod g_mass_storage",
+	}
+	if !reflect.DeepEqual(r.Calls(), want) {
+		t.Errorf("commands:\n got %q\nwant %q", r.Calls(), want)
+	}
+	if mode := c.GetCurrentMode(); mode != "normal" {
+		t.Errorf("mode = %q, want normal", mode)
+	}
+}
+
+func writeLUN(t *testing.T, dir, lun, file string) {
+	t.Helper()
+	lunDir := filepath.Join(dir, "gadget", lun)
+	if err := os.MkdirAll(lunDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lunDir, "file"), []byte(file+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMismatchedLUNs(t *testing.T) {
+	luns := map[string]string{
+		"lun0": "/data/usb.drive",
+		"lun1": "",
+		"lun2": "/data/old.drive",
+		"lun3": "/data/./usb.drive",
+	}
+	got := mismatchedLUNs(luns, []string{"/data/usb.drive"})
+	if want := []string{"lun2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatchedLUNs = %q, want %q", got, want)
+	}
+}
+
+func TestReconcileGadget(t *testing.T) {
+	tests := []struct {
+		name      string
+		lunFile   string
+		noGadget  bool
+		wantCalls []string
+	}{
+		{name: "no gadget bound", noGadget: true},
+		{name: "matching image", lunFile: "/data/usb.drive"},
+		{name: "no medium", lunFile: ""},
+		{
+			name:      "different image",
+			lunFile:   "/data/old.drive",
+			wantCalls: []string{"rmmod g_mass_storage", "modprobe g_ether"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if !tt.noGadget {
+				writeLUN(t, dir, "lun0", tt.lunFile)
+			}
+			c, r := newTestController(Gadget{Module: "g_ether"})
+			c.lunGlob = filepath.Join(dir, "gadget*", "lun*", "file")
+
+			if err := c.ReconcileGadget(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Calls(), tt.wantCalls) {
+				t.Errorf("commands = %q, want %q", r.Calls(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDetectCurrentMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules string // empty: no modules file
+		want    string
+	}{
+		{name: "no module support", want: "normal"},
+		{name: "ethernet gadget", modules: "g_ether 16384 0 - Live 0x00000000\nu_ether 20480 1 g_ether, Live 0x00000000\n", want: "normal"},
+		{name: "mass storage", modules: "g_mass_storage 16384 0 - Live 0x00000000\nusb_f_mass_storage 36864 2 g_mass_storage, Live 0x00000000\n", want: "ums"},
+		{name: "function only", modules: "usb_f_mass_storage 36864 0 - Live 0x00000000\n", want: "normal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestController(Gadget{Module: "g_ether"})
+			c.currentMode = "ums"
+			c.modulesPath = filepath.Join(t.TempDir(), "modules")
+			if tt.modules != "" {
+				if err := os.WriteFile(c.modulesPath, []byte(tt.modules), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := c.DetectCurrentMode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || c.GetCurrentMode() != tt.want {
+				t.Errorf("DetectCurrentMode() = %q, current mode %q; want %q", got, c.GetCurrentMode(), tt.want)
+			}
+		})
+	}
+}
+
+// TestSwitchModeDryRun checks that a dry run changes the mode without
+// running any command.
+func TestSwitchModeDryRun(t *testing.T) {
+	c, r := newTestController(Gadget{Module: "g_ether"})
+	c.SetDryRun(true)
+
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Calls()) != 0 {
+		t.Errorf("ran %q in dry run", r.Calls())
+	}
+	if got := c.GetCurrentMode(); got != "ums" {
+		t.Errorf("mode = %q, want ums", got)
+	}
+}
+
+func TestUnloadModule(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		name    string
+		reply   commandtest.Reply
+		wantErr bool
+	}{
+		{name: "unloaded"},
+		{name: "not loaded", reply: commandtest.Reply{Output: "rmmod: ERROR: Module g_ether is not currently loaded\n", Err: exit}},
+		{name: "in use", reply: commandtest.Reply{Output: "rmmod: ERROR: Module g_ether is in use\n", Err: exit}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, r := newTestController(Gadget{Module: "g_ether"})
+			r.Replies = map[string]commandtest.Reply{"rmmod g_ether": tt.reply}
+			if err := c.unloadModule("g_ether"); (err != nil) != tt.wantErr {
+				t.Errorf("unloadModule = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package usb
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultSerial is the iSerialNumber presented when none is configured.
+const defaultSerial = "1234567890"
+
+// Identity is what the mass-storage gadget tells the host about itself,
+// e.g. so udev rules on a paired laptop can recognise the scooter. Empty
+// IDs keep the kernel's defaults; an empty Serial keeps defaultSerial.
+type Identity struct {
+	VendorID  string // 4 hex digits, e.g. "1d6b"
+	ProductID string // 4 hex digits
+	Serial    string
+}
+
+var (
+	usbIDPattern = regexp.MustCompile(`^[0-9A-Fa-f]{4}$`)
+	// serialPattern keeps the serial a single modprobe parameter that
+	// fits a USB string descriptor.
+	serialPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,126}$`)
+)
+
+// Validate reports an ID that isn't 4 hex digits or a serial modprobe
+// couldn't pass through intact.
+func (id Identity) Validate() error {
+	if id.VendorID != "" && !usbIDPattern.MatchString(id.VendorID) {
+		return fmt.Errorf("invalid vendor ID %q: expected 4 hex digits", id.VendorID)
+	}
+	if id.ProductID != "" && !usbIDPattern.MatchString(id.ProductID) {
+		return fmt.Errorf("invalid product ID %q: expected 4 hex digits", id.ProductID)
+	}
+	if id.Serial != "" && !serialPattern.MatchString(id.Serial) {
+		return fmt.Errorf("invalid serial %q: expected up to 126 letters, digits, '.', '_' or '-'", id.Serial)
+	}
+	return nil
+}
+
+// params returns the g_mass_storage parameters for id.
+func (id Identity) params() []string {
+	var params []string
+	if id.VendorID != "" {
+		params = append(params, "idVendor=0x"+id.VendorID)
+	}
+	if id.ProductID != "" {
+		params = append(params, "idProduct=0x"+id.ProductID)
+	}
+	serial := id.Serial
+	if serial == "" {
+		serial = defaultSerial
+	}
+	return append(params, "iSerialNumber="+serial)
+}
@@ -0,0 +1,63 @@
+package usb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/command/commandtest"
+)
+
+func TestIdentityValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      Identity
+		wantErr bool
+	}{
+		{name: "defaults", id: Identity{}},
+		{name: "all set", id: Identity{VendorID: "1d6b", ProductID: "0104", Serial: "LS-00042"}},
+		{name: "upper case hex", id: Identity{VendorID: "1D6B", ProductID: "ABCD"}},
+		{name: "short vendor", id: Identity{VendorID: "d6b"}, wantErr: true},
+		{name: "0x prefix", id: Identity{VendorID: "0x1d6b"}, wantErr: true},
+		{name: "non-hex product", id: Identity{ProductID: "01g4"}, wantErr: true},
+		{name: "serial with space", id: Identity{Serial: "LS 42"}, wantErr: true},
+		{name: "serial injecting a parameter", id: Identity{Serial: "1 ro=1"}, wantErr: true},
+		{name: "serial too long", id: Identity{Serial: strings.Repeat("a", 127)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.id.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSwitchModeUsesIdentity(t *testing.T) {
+	r := &commandtest.Recorder{}
+	c := NewController("/data/usb.drive", Identity{VendorID: "1d6b", ProductID: "0104", Serial: "LS-00042"}, r)
+
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"rmmod g_ether",
+		"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=0 stall=0 idVendor=0x1d6b idProduct=0x0104 iSerialNumber=LS-00042",
+	}
+	if !reflect.DeepEqual(r.Calls(), want) {
+		t.Errorf("commands:\n got %q\nwant %q", r.Calls(), want)
+	}
+}
+
+func TestSwitchModeRefusesInvalidIdentity(t *testing.T) {
+	r := &commandtest.Recorder{}
+	c := NewController("/data/usb.drive", Identity{VendorID: "xyz"}, r)
+
+	if err := c.SwitchMode("ums"); err == nil {
+		t.Fatal("SwitchMode(ums) accepted an invalid vendor ID")
+	}
+	if len(r.Calls()) != 0 {
+		t.Errorf("gadget touched despite invalid identity: %q", r.Calls())
+	}
+	if mode := c.GetCurrentMode(); mode != "normal" {
+		t.Errorf("mode = %q, want normal", mode)
+	}
+}
@@ -0,0 +1,112 @@
+package usb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// linkFault is what a UMS link health check found wrong.
+type linkFault string
+
+const (
+	linkOK linkFault = ""
+	// linkUnbound: the UDC is configured but no mass-storage LUN is
+	// bound any more, so the host talks to a function that isn't there.
+	linkUnbound linkFault = "function unbound"
+	// linkNoMedium: the LUN lost its backing file across a host
+	// suspend, so the host sees an empty drive.
+	linkNoMedium linkFault = "medium lost"
+)
+
+// SetLinkCheckInterval sets how often the UMS link is health-checked
+// while the host is connected. 0 disables the periodic check; the check
+// after a host resume always runs. Call before StartMonitoring.
+func (c *Controller) SetLinkCheckInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.linkInterval = d
+}
+
+// RecoveredCh returns a channel that receives a description of each
+// degraded UMS link the monitor detected and recovered.
+func (c *Controller) RecoveredCh() <-chan string {
+	return c.recoveredCh
+}
+
+// diagnoseLink looks at the LUNs of a configured UDC. An empty LUN only
+// counts after a resume: outside of one it's the host ejecting the drive,
+// which is the user's call.
+func diagnoseLink(luns map[string]string, afterResume bool) linkFault {
+	if len(luns) == 0 {
+		return linkUnbound
+	}
+	if afterResume {
+		for _, file := range luns {
+			if file == "" {
+				return linkNoMedium
+			}
+		}
+	}
+	return linkOK
+}
+
+// checkLink diagnoses the UMS link and recovers a degraded one: a lost
+// medium is re-inserted, an unbound function is reloaded. Recoveries are
+// reported on recoveredCh.
+func (c *Controller) checkLink(afterResume bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.currentMode != "ums" {
+		return
+	}
+
+	luns, err := readLUNFiles(c.lunGlob)
+	if err != nil {
+		c.log.Warnf("UMS link check failed: %v", err)
+		return
+	}
+	fault := diagnoseLink(luns, afterResume)
+	if fault == linkOK {
+		return
+	}
+
+	c.log.Warnf("UMS link degraded (%s), recovering", fault)
+	if err := c.recoverLink(fault, luns); err != nil {
+		c.log.Errorf("Error recovering UMS link: %v", err)
+		return
+	}
+	c.log.Infof("UMS link recovered (%s)", fault)
+	select {
+	case c.recoveredCh <- string(fault):
+	default:
+	}
+}
+
+func (c *Controller) recoverLink(fault linkFault, luns map[string]string) error {
+	switch fault {
+	case linkNoMedium:
+		// Writing the backing file to the LUN signals a media change,
+		// which makes the host re-read the drive.
+		var empty []string
+		for lun, file := range luns {
+			if file == "" {
+				empty = append(empty, lun)
+			}
+		}
+		sort.Strings(empty)
+		for _, lun := range empty {
+			if err := os.WriteFile(lun, []byte(c.lunFile(lun)), 0644); err != nil {
+				return fmt.Errorf("failed to re-insert medium in %s: %w", lun, err)
+			}
+		}
+		return nil
+	case linkUnbound:
+		if err := c.unloadModule("g_mass_storage"); err != nil {
+			c.log.Warnf("failed to unload g_mass_storage: %v", err)
+		}
+		return c.loadMassStorage()
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package usb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiagnoseLink(t *testing.T) {
+	tests := []struct {
+		name        string
+		luns        map[string]string
+		afterResume bool
+		want        linkFault
+	}{
+		{name: "healthy", luns: map[string]string{"lun0": "/data/usb.drive"}, want: linkOK},
+		{name: "unbound", luns: map[string]string{}, want: linkUnbound},
+		{name: "ejected by host", luns: map[string]string{"lun0": ""}, want: linkOK},
+		{name: "medium lost on resume", luns: map[string]string{"lun0": ""}, afterResume: true, want: linkNoMedium},
+		{name: "healthy after resume", luns: map[string]string{"lun0": "/data/usb.drive"}, afterResume: true, want: linkOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diagnoseLink(tt.luns, tt.afterResume); got != tt.want {
+				t.Errorf("diagnoseLink = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+	tests := []struct {
+		name        string
+		lunFile     string
+		noGadget    bool
+		afterResume bool
+		wantFile    string
+		wantCalls   []string
+		wantEvent   string
+	}{
+		{name: "healthy", lunFile: "/data/usb.drive", wantFile: "/data/usb.drive"},
+		{name: "ejected by host", lunFile: "", wantFile: ""},
+		{
+			name:        "medium lost on resume",
+			lunFile:     "",
+			afterResume: true,
+			wantFile:    "/data/usb.drive",
+			wantEvent:   string(linkNoMedium),
+		},
+		{
+			name:     "function unbound",
+			noGadget: true,
+			wantCalls: []string{
+				"rmmod g_mass_storage",
+				"modprobe g_mass_storage file=/data/usb.drive removable=1 ro=0 stall=0 iSerialNumber=1234567890",
+			},
+			wantEvent: string(linkUnbound),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if !tt.noGadget {
+				writeLUN(t, dir, "lun0", tt.lunFile)
+			}
+			c, r := newTestController(Gadget{Module: "g_ether"})
+			c.lunGlob = filepath.Join(dir, "gadget*", "lun*", "file")
+			c.currentMode = "ums"
+
+			c.checkLink(tt.afterResume)
+
+			if !reflect.DeepEqual(r.Calls(), tt.wantCalls) {
+				t.Errorf("commands = %q, want %q", r.Calls(), tt.wantCalls)
+			}
+			if !tt.noGadget {
+				data, err := os.ReadFile(filepath.Join(dir, "gadget", "lun0", "file"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.TrimSpace(string(data)); got != tt.wantFile {
+					t.Errorf("lun0 file = %q, want %q", got, tt.wantFile)
+				}
+			}
+			var event string
+			select {
+			case event = <-c.RecoveredCh():
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("recovered event = %q, want %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+// TestMonitorRecoversAfterResume drives the monitor through a host
+// suspend and resume on a fake sysfs: the suspend must not count as a
+// detach, and the medium lost meanwhile must be re-inserted on resume.
+func TestMonitorRecoversAfterResume(t *testing.T) {
+	dir := t.TempDir()
+	writeLUN(t, dir, "lun0", "/data/usb.drive")
+	lunFile := filepath.Join(dir, "gadget", "lun0", "file")
+	state := filepath.Join(dir, "state")
+	// Replaced rather than rewritten in place: the monitor polls it
+	// and would otherwise catch it truncated, which reads as a detach.
+	setState := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(state+".tmp", []byte(s+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(state+".tmp", state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	observed := make(chan string, 16)
+	waitObserved := func(s string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case got := <-observed:
+				if got == s {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("monitor never saw state %q", s)
+			}
+		}
+	}
+
+	c, _ := newTestController(Gadget{Module: "g_ether"})
+	c.lunGlob = filepath.Join(dir, "gadget*", "lun*", "file")
+	c.udcStatePath = state
+	c.currentMode = "ums"
+	c.monitorInterval = 5 * time.Millisecond
+	c.SetLinkCheckInterval(0)
+	c.observeState = func(s string) {
+		select {
+		case observed <- s:
+		default:
+		}
+	}
+
+	setState(udcStateConfigured)
+	c.StartMonitoring()
+	defer c.StopMonitoring()
+	waitObserved(udcStateConfigured)
+
+	setState(udcStateSuspended)
+	waitObserved(udcStateSuspended)
+	if err := os.WriteFile(lunFile, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setState(udcStateConfigured)
+
+	select {
+	case event := <-c.RecoveredCh():
+		if event != string(linkNoMedium) {
+			t.Errorf("recovered event = %q, want %q", event, linkNoMedium)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no recovery after resume")
+	}
+	select {
+	case <-c.DetachCh():
+		t.Error("host suspend reported as a detach")
+	default:
+	}
+	if data, _ := os.ReadFile(lunFile); string(data) != "/data/usb.drive" {
+		t.Errorf("lun0 file = %q after recovery", data)
+	}
+}
@@ -0,0 +1,103 @@
+package usb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LUN is a drive image presented to the host alongside the service's
+// own drive, which is always the first LUN. The service doesn't look at
+// what is on it.
+type LUN struct {
+	File     string
+	ReadOnly bool
+}
+
+// ParseLUNs parses a comma-separated list of extra drive images, each an
+// absolute path optionally followed by ":ro" (the default) or ":rw".
+func ParseLUNs(spec string) ([]LUN, error) {
+	var luns []LUN
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lun := LUN{File: item, ReadOnly: true}
+		if path, flag, ok := strings.Cut(item, ":"); ok {
+			switch flag {
+			case "ro":
+			case "rw":
+				lun.ReadOnly = false
+			default:
+				return nil, fmt.Errorf("%q: expected :ro or :rw, got :%s", item, flag)
+			}
+			lun.File = path
+		}
+		if !filepath.IsAbs(lun.File) {
+			return nil, fmt.Errorf("%q: not an absolute path", lun.File)
+		}
+		lun.File = filepath.Clean(lun.File)
+		for _, other := range luns {
+			if other.File == lun.File {
+				return nil, fmt.Errorf("%s listed twice", lun.File)
+			}
+		}
+		luns = append(luns, lun)
+	}
+	return luns, nil
+}
+
+// SetExtraLUNs sets drive images to present after the service's own
+// drive. They take effect the next time mass storage is loaded.
+func (c *Controller) SetExtraLUNs(luns []LUN) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, lun := range luns {
+		if filepath.Clean(lun.File) == filepath.Clean(c.driveFile) {
+			return fmt.Errorf("%s is the main drive", lun.File)
+		}
+	}
+	c.extraLUNs = luns
+	return nil
+}
+
+// lunFiles returns the backing file of each LUN, in order.
+func (c *Controller) lunFiles() []string {
+	files := []string{c.driveFile}
+	for _, lun := range c.extraLUNs {
+		files = append(files, lun.File)
+	}
+	return files
+}
+
+// lunParams returns the g_mass_storage parameters that describe the
+// LUNs. Each takes a comma-separated value per LUN.
+func (c *Controller) lunParams() []string {
+	files := c.lunFiles()
+	removable := make([]string, len(files))
+	ro := make([]string, len(files))
+	for n := range files {
+		removable[n] = "1"
+		ro[n] = "0"
+		if (n == 0 && c.readOnly) || (n > 0 && c.extraLUNs[n-1].ReadOnly) {
+			ro[n] = "1"
+		}
+	}
+	return []string{
+		"file=" + strings.Join(files, ","),
+		"removable=" + strings.Join(removable, ","),
+		"ro=" + strings.Join(ro, ","),
+	}
+}
+
+// lunFile returns the file that should back the LUN whose file attribute
+// is at path (".../lun<N>/file").
+func (c *Controller) lunFile(path string) string {
+	files := c.lunFiles()
+	if n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "lun")); err == nil && n >= 0 && n < len(files) {
+		return files[n]
+	}
+	return c.driveFile
+}
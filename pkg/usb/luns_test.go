@@ -0,0 +1,94 @@
+package usb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLUNs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []LUN
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "/data/docs.img", want: []LUN{{File: "/data/docs.img", ReadOnly: true}}},
+		{spec: "/data/docs.img:ro, /data/uploads.img:rw", want: []LUN{
+			{File: "/data/docs.img", ReadOnly: true},
+			{File: "/data/uploads.img"},
+		}},
+		{spec: "docs.img", wantErr: true},
+		{spec: "/data/docs.img:rx", wantErr: true},
+		{spec: "/data/docs.img,/data/./docs.img:rw", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLUNs(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLUNs(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLUNs(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestSwitchModeExtraLUNs(t *testing.T) {
+	c, r := newTestController(Gadget{})
+	if err := c.SetExtraLUNs([]LUN{{File: "/data/usb.drive"}}); err == nil {
+		t.Error("SetExtraLUNs accepted the main drive")
+	}
+	if err := c.SetExtraLUNs([]LUN{
+		{File: "/data/docs.img", ReadOnly: true},
+		{File: "/data/uploads.img"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadOnly(true)
+	if err := c.SwitchMode("ums"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"modprobe g_mass_storage file=/data/usb.drive,/data/docs.img,/data/uploads.img removable=1,1,1 ro=1,1,0 stall=0 iSerialNumber=1234567890",
+	}
+	if !reflect.DeepEqual(r.Calls(), want) {
+		t.Errorf("commands:\n got %q\nwant %q", r.Calls(), want)
+	}
+}
+
+// TestCheckLinkExtraLUNs checks each LUN that lost its medium gets its
+// own file back, and that the extra images don't count as stale.
+func TestCheckLinkExtraLUNs(t *testing.T) {
+	dir := t.TempDir()
+	writeLUN(t, dir, "lun0", "")
+	writeLUN(t, dir, "lun1", "")
+	c, _ := newTestController(Gadget{})
+	c.lunGlob = filepath.Join(dir, "gadget*", "lun*", "file")
+	c.currentMode = "ums"
+	if err := c.SetExtraLUNs([]LUN{{File: "/data/docs.img", ReadOnly: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.checkLink(true)
+	for lun, want := range map[string]string{"lun0": "/data/usb.drive", "lun1": "/data/docs.img"} {
+		data, err := os.ReadFile(filepath.Join(dir, "gadget", lun, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(data)); got != want {
+			t.Errorf("%s file = %q, want %q", lun, got, want)
+		}
+	}
+
+	luns, err := readLUNFiles(c.lunGlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale := mismatchedLUNs(luns, c.lunFiles()); len(stale) != 0 {
+		t.Errorf("stale LUNs %q", stale)
+	}
+}
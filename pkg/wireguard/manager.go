@@ -1,21 +1,61 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"github.com/librescoot/ums-service/pkg/fsutil"
+	"github.com/librescoot/ums-service/pkg/logging"
+	"github.com/librescoot/ums-service/pkg/metrics"
 )
 
 type Manager struct {
-	configDir string
+	configDir    string
+	templateVars VarsFunc
+	// maxSize is the largest config read from the drive; see SetMaxSize.
+	maxSize int64
+	// changes counts configs written or removed; see SetChangeCounter.
+	changes *metrics.Counter
+
+	log *logging.Logger
 }
 
-func New() *Manager {
+// SetLogger sets the logger the manager writes to.
+func (m *Manager) SetLogger(logger *logging.Logger) {
+	m.log = logger
+}
+
+// SetChangeCounter sets a counter SyncFromUSB increments for each config
+// it writes or removes.
+func (m *Manager) SetChangeCounter(c *metrics.Counter) {
+	m.changes = c
+}
+
+// New manages the WireGuard configs in configDir, normally
+// /data/wireguard.
+func New(configDir string) *Manager {
 	return &Manager{
-		configDir: "/data/wireguard",
+		configDir: configDir,
+		maxSize:   defaultMaxSize,
+		log:       logging.For("wireguard"),
+	}
+}
+
+// defaultMaxSize is far more than any WireGuard config needs.
+const defaultMaxSize = 64 << 10
+
+// SetMaxSize sets the largest config or template, in bytes, SyncFromUSB
+// reads; bigger ones are skipped like invalid ones. 0 means no limit.
+func (m *Manager) SetMaxSize(n int64) {
+	if n < 0 {
+		n = 0
 	}
+	m.maxSize = n
 }
 
 func (m *Manager) PrepareUSB(usbMountPath string) error {
@@ -23,64 +63,79 @@ func (m *Manager) PrepareUSB(usbMountPath string) error {
 	if err := os.MkdirAll(wgDir, 0755); err != nil {
 		return fmt.Errorf("failed to create wireguard directory: %w", err)
 	}
-	log.Println("Created wireguard directory on USB drive")
+	m.log.Infof("Created wireguard directory on USB drive")
 	return nil
 }
 
+// Validate reports a config directory path that exists but isn't a
+// directory.
+func (m *Manager) Validate() error {
+	return fsutil.CheckDir(m.configDir)
+}
+
 func (m *Manager) CopyToUSB(usbMountPath string) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
 	// Ensure config directory exists
 	if _, err := os.Stat(m.configDir); os.IsNotExist(err) {
-		log.Printf("WireGuard config directory %s does not exist, skipping", m.configDir)
+		m.log.Infof("WireGuard config directory %s does not exist, skipping", m.configDir)
 		return nil
 	}
 
 	destDir := filepath.Join(usbMountPath, "wireguard")
 
-	// Read all .conf files
-	entries, err := os.ReadDir(m.configDir)
+	configs, err := listFiles(m.configDir, func(name string) bool {
+		return strings.HasSuffix(name, ".conf")
+	})
 	if err != nil {
 		return fmt.Errorf("failed to read wireguard directory: %w", err)
 	}
 
 	copiedCount := 0
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
-			continue
-		}
-
-		srcPath := filepath.Join(m.configDir, entry.Name())
-		destPath := filepath.Join(destDir, entry.Name())
+	for _, rel := range sortedKeys(configs) {
+		srcPath := filepath.Join(m.configDir, rel)
+		destPath := filepath.Join(destDir, rel)
 
 		input, err := os.ReadFile(srcPath)
 		if err != nil {
-			log.Printf("Failed to read %s: %v", srcPath, err)
+			m.log.Errorf("Failed to read %s: %v", srcPath, err)
 			continue
 		}
 
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			m.log.Errorf("Failed to create %s: %v", filepath.Dir(destPath), err)
+			continue
+		}
 		if err := os.WriteFile(destPath, input, 0644); err != nil {
-			log.Printf("Failed to write %s: %v", destPath, err)
+			m.log.Errorf("Failed to write %s: %v", destPath, err)
 			continue
 		}
 
 		copiedCount++
-		log.Printf("Copied WireGuard config: %s", entry.Name())
+		m.log.Infof("Copied WireGuard config: %s", rel)
 	}
 
 	if copiedCount > 0 {
-		log.Printf("Copied %d WireGuard config file(s) to USB drive", copiedCount)
+		m.log.Infof("Copied %d WireGuard config file(s) to USB drive", copiedCount)
 	} else {
-		log.Println("No WireGuard config files found to copy")
+		m.log.Infof("No WireGuard config files found to copy")
 	}
 
 	return nil
 }
 
 func (m *Manager) SyncFromUSB(usbMountPath string) (bool, error) {
+	if err := m.Validate(); err != nil {
+		return false, err
+	}
+
 	srcDir := filepath.Join(usbMountPath, "wireguard")
 
 	// Check if USB wireguard directory exists
 	if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-		log.Printf("No wireguard directory found on USB drive")
+		m.log.Infof("No wireguard directory found on USB drive")
 		return false, nil
 	}
 
@@ -93,88 +148,184 @@ func (m *Manager) SyncFromUSB(usbMountPath string) (bool, error) {
 	changed := false
 
 	// Get list of existing files
-	existingFiles := make(map[string]bool)
-	if entries, err := os.ReadDir(m.configDir); err == nil {
-		for _, entry := range entries {
-			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".conf") {
-				existingFiles[entry.Name()] = true
-			}
-		}
+	existingFiles, err := listFiles(m.configDir, func(name string) bool {
+		return strings.HasSuffix(name, ".conf")
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read wireguard config directory: %w", err)
 	}
 
 	// Read files from USB
-	usbEntries, err := os.ReadDir(srcDir)
+	usbFiles, err := listFiles(srcDir, func(name string) bool {
+		return strings.HasSuffix(name, ".conf") || strings.HasSuffix(name, ".conf"+templateSuffix)
+	})
 	if err != nil {
 		return false, fmt.Errorf("failed to read USB wireguard directory: %w", err)
 	}
 
+	// A template wins over a plain .conf of the same name: the plain
+	// one is usually the rendered copy CopyToUSB put on the drive.
+	templates := make(map[string]bool)
+	for rel := range usbFiles {
+		if strings.HasSuffix(rel, templateSuffix) {
+			templates[strings.TrimSuffix(rel, templateSuffix)] = true
+		}
+	}
+
+	var vars map[string]string
+	var varsErr error
+	varsLoaded := false
+
 	// Process files from USB
 	processedFiles := make(map[string]bool)
-	for _, entry := range usbEntries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".conf") {
+	for _, rel := range sortedKeys(usbFiles) {
+		isTemplate := strings.HasSuffix(rel, templateSuffix)
+		filename := strings.TrimSuffix(rel, templateSuffix)
+		if !isTemplate && templates[filename] {
+			m.log.Warnf("Ignoring %s in favour of %s%s", filename, filename, templateSuffix)
 			continue
 		}
-
-		filename := entry.Name()
 		processedFiles[filename] = true
 
-		srcPath := filepath.Join(srcDir, filename)
+		srcPath := filepath.Join(srcDir, rel)
 		destPath := filepath.Join(m.configDir, filename)
 
+		// Sized up first, so a stray huge file isn't read into memory.
+		// As with an invalid one, the existing config stays in place.
+		if info, err := os.Stat(srcPath); err == nil && m.maxSize > 0 && info.Size() > m.maxSize {
+			m.log.Errorf("Skipping %s: %d bytes, over the %d byte limit", rel, info.Size(), m.maxSize)
+			continue
+		}
+
 		// Read the file content
 		input, err := os.ReadFile(srcPath)
 		if err != nil {
-			log.Printf("Failed to read %s: %v", srcPath, err)
+			m.log.Errorf("Failed to read %s: %v", srcPath, err)
 			continue
 		}
 
-		// Check if file exists and has different content
-		needUpdate := true
-		if existing, err := os.ReadFile(destPath); err == nil {
-			needUpdate = string(existing) != string(input)
+		if isTemplate {
+			if !varsLoaded {
+				vars, varsErr = m.loadTemplateVars()
+				varsLoaded = true
+			}
+			if varsErr != nil {
+				m.log.Warnf("Skipping %s: %v", rel, varsErr)
+				continue
+			}
+			// On failure the existing config (if any) stays in place.
+			if input, err = renderConfig(rel, input, vars); err != nil {
+				m.log.Warnf("Skipping %s: %v", rel, err)
+				continue
+			}
+		} else if err := validateConfig(input); err != nil {
+			// As with a template, the existing config stays in place.
+			m.log.Errorf("Skipping invalid %s: %v", rel, err)
+			continue
 		}
 
-		if needUpdate {
+		// Check if file exists and has different content, streaming it
+		// rather than reading it whole
+		if !fsutil.SameContent(destPath, input) {
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				m.log.Errorf("Failed to create %s: %v", filepath.Dir(destPath), err)
+				continue
+			}
 			if err := os.WriteFile(destPath, input, 0644); err != nil {
-				log.Printf("Failed to write %s: %v", destPath, err)
+				m.log.Errorf("Failed to write %s: %v", destPath, err)
 				continue
 			}
 			changed = true
-			log.Printf("Updated WireGuard config: %s", filename)
+			m.changes.Inc()
+			m.log.Infof("Updated WireGuard config: %s", filename)
 		}
 	}
 
-	// Remove files that don't exist on USB
-	for filename := range existingFiles {
+	// Remove files that don't exist on USB, and any profile directory
+	// that leaves empty
+	for _, filename := range sortedKeys(existingFiles) {
 		if !processedFiles[filename] {
 			filePath := filepath.Join(m.configDir, filename)
 			if err := os.Remove(filePath); err != nil {
-				log.Printf("Failed to remove %s: %v", filePath, err)
+				m.log.Errorf("Failed to remove %s: %v", filePath, err)
 			} else {
 				changed = true
-				log.Printf("Removed WireGuard config: %s", filename)
+				m.changes.Inc()
+				m.log.Infof("Removed WireGuard config: %s", filename)
+				removeEmptyParents(m.configDir, filePath)
 			}
 		}
 	}
 
-	// If no files on USB, remove all local configs
-	if len(processedFiles) == 0 && len(existingFiles) > 0 {
-		for filename := range existingFiles {
-			filePath := filepath.Join(m.configDir, filename)
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("Failed to remove %s: %v", filePath, err)
-			} else {
-				changed = true
-				log.Printf("Removed WireGuard config: %s", filename)
+	if changed {
+		m.log.Infof("WireGuard configs changed")
+	} else {
+		m.log.Infof("No WireGuard config changes detected")
+	}
+
+	return changed, nil
+}
+
+// listFiles returns the regular files under root, recursively, whose
+// name match accepts, keyed by their path relative to root. Symlinks are
+// neither followed nor returned, so nothing outside root is read or
+// written through them, and hidden directories (.Trashes and the like on
+// a drive a Mac has seen) are skipped. A missing root has no files.
+func listFiles(root string, match func(name string) bool) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
 			}
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !match(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	return files, err
+}
+
+// removeEmptyParents removes the directories between path and root that
+// are left empty, stopping at the first that isn't.
+func removeEmptyParents(root, path string) {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
 		}
 	}
+}
 
-	if changed {
-		log.Println("WireGuard configs changed")
-	} else {
-		log.Println("No WireGuard config changes detected")
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	return changed, nil
+func (m *Manager) loadTemplateVars() (map[string]string, error) {
+	if m.templateVars == nil {
+		return map[string]string{}, nil
+	}
+	vars, err := m.templateVars()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template variables: %w", err)
+	}
+	return vars, nil
 }
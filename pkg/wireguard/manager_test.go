@@ -0,0 +1,296 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/ums-service/pkg/metrics"
+)
+
+const (
+	privKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	pubKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+)
+
+const plainConf = `[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.2/32
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = vpn.example.org:51820
+`
+
+const templateConf = `# scooter {{.serial}}
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = {{.ip}}/32
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = vpn.example.org:51820
+`
+
+func setup(t *testing.T, usbFiles map[string]string) (*Manager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	usb := filepath.Join(dir, "usb")
+	if err := os.MkdirAll(filepath.Join(usb, "wireguard"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range usbFiles {
+		path := filepath.Join(usb, "wireguard", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Manager{configDir: filepath.Join(dir, "data")}, usb
+}
+
+func readConf(t *testing.T, m *Manager, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(m.configDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSyncFromUSBPassthrough(t *testing.T) {
+	m, usb := setup(t, map[string]string{"wg0.conf": plainConf})
+	m.SetTemplateVars(func() (map[string]string, error) {
+		t.Error("vars loaded without any template")
+		return nil, nil
+	})
+
+	changed, err := m.SyncFromUSB(usb)
+	if err != nil || !changed {
+		t.Fatalf("SyncFromUSB = %v, %v", changed, err)
+	}
+	if got := readConf(t, m, "wg0.conf"); got != plainConf {
+		t.Errorf("wg0.conf = %q, want unchanged copy", got)
+	}
+}
+
+func TestSyncFromUSBRendersTemplate(t *testing.T) {
+	m, usb := setup(t, map[string]string{
+		"wg0.conf.tmpl": templateConf,
+		// Stale rendered copy from a previous session; the template wins.
+		"wg0.conf": plainConf,
+	})
+	m.SetTemplateVars(func() (map[string]string, error) {
+		return map[string]string{"serial": "LS123", "ip": "10.8.0.42"}, nil
+	})
+
+	if _, err := m.SyncFromUSB(usb); err != nil {
+		t.Fatalf("SyncFromUSB: %v", err)
+	}
+	got := readConf(t, m, "wg0.conf")
+	if !strings.Contains(got, "# scooter LS123") || !strings.Contains(got, "Address = 10.8.0.42/32") {
+		t.Errorf("rendered config:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(m.configDir, "wg0.conf.tmpl")); !os.IsNotExist(err) {
+		t.Errorf("template itself installed: %v", err)
+	}
+}
+
+func TestSyncFromUSBTemplateFailureKeepsExisting(t *testing.T) {
+	cases := []struct {
+		name string
+		vars VarsFunc
+	}{
+		{"missing variable", func() (map[string]string, error) {
+			return map[string]string{"serial": "LS123"}, nil
+		}},
+		{"vars unavailable", func() (map[string]string, error) {
+			return nil, errors.New("redis down")
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, usb := setup(t, map[string]string{"wg0.conf.tmpl": templateConf})
+			if err := os.MkdirAll(m.configDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(m.configDir, "wg0.conf"), []byte(plainConf), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m.SetTemplateVars(c.vars)
+
+			changed, err := m.SyncFromUSB(usb)
+			if err != nil {
+				t.Fatalf("SyncFromUSB: %v", err)
+			}
+			if changed {
+				t.Error("reported a change for a template that failed to render")
+			}
+			if got := readConf(t, m, "wg0.conf"); got != plainConf {
+				t.Errorf("existing config replaced: %q", got)
+			}
+		})
+	}
+}
+
+func TestSyncFromUSBSkipsInvalid(t *testing.T) {
+	m, usb := setup(t, map[string]string{
+		"wg0.conf": plainConf,
+		"wg1.conf": "[Interface]\nPrivateKey = " + privKey + "\n",
+	})
+	if err := os.MkdirAll(m.configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(m.configDir, "wg0.conf"), []byte(plainConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := m.SyncFromUSB(usb)
+	if err != nil {
+		t.Fatalf("SyncFromUSB: %v", err)
+	}
+	if changed {
+		t.Error("an invalid config counted as a change")
+	}
+	if _, err := os.Stat(filepath.Join(m.configDir, "wg1.conf")); !os.IsNotExist(err) {
+		t.Errorf("invalid wg1.conf installed: %v", err)
+	}
+}
+
+func TestSyncFromUSBSkipsOversized(t *testing.T) {
+	m, usb := setup(t, map[string]string{
+		"wg0.conf": plainConf,
+		"wg1.conf": plainConf + "# " + strings.Repeat("x", 1024) + "\n",
+	})
+	m.SetMaxSize(int64(len(plainConf)))
+
+	changed, err := m.SyncFromUSB(usb)
+	if err != nil {
+		t.Fatalf("SyncFromUSB: %v", err)
+	}
+	if !changed || readConf(t, m, "wg0.conf") != plainConf {
+		t.Error("wg0.conf within the limit not installed")
+	}
+	if _, err := os.Stat(filepath.Join(m.configDir, "wg1.conf")); !os.IsNotExist(err) {
+		t.Errorf("oversized wg1.conf installed: %v", err)
+	}
+}
+
+func TestSyncFromUSBProfiles(t *testing.T) {
+	m, usb := setup(t, map[string]string{
+		"wg0.conf":      plainConf,
+		"home/wg1.conf": plainConf,
+		"work/wg2.conf": plainConf,
+	})
+	var changes metrics.Counter
+	m.SetChangeCounter(&changes)
+	if changed, err := m.SyncFromUSB(usb); err != nil || !changed {
+		t.Fatalf("first SyncFromUSB = %v, %v", changed, err)
+	}
+	for _, name := range []string{"wg0.conf", "home/wg1.conf", "work/wg2.conf"} {
+		if got := readConf(t, m, name); got != plainConf {
+			t.Errorf("%s = %q, want copy", name, got)
+		}
+	}
+
+	if changed, err := m.SyncFromUSB(usb); err != nil || changed {
+		t.Errorf("unchanged SyncFromUSB = %v, %v; want no change", changed, err)
+	}
+
+	// Dropping the work profile removes it, and its directory, locally
+	// without touching the others.
+	if err := os.RemoveAll(filepath.Join(usb, "wireguard", "work")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := m.SyncFromUSB(usb); err != nil || !changed {
+		t.Fatalf("SyncFromUSB after removal = %v, %v", changed, err)
+	}
+	if _, err := os.Stat(filepath.Join(m.configDir, "work")); !os.IsNotExist(err) {
+		t.Errorf("work profile still present: %v", err)
+	}
+	if got := readConf(t, m, "home/wg1.conf"); got != plainConf {
+		t.Errorf("home/wg1.conf = %q, want it kept", got)
+	}
+	// Three written, one removed.
+	if n := changes.Load(); n != 4 {
+		t.Errorf("counted %d changes, want 4", n)
+	}
+}
+
+func TestSyncFromUSBIgnoresSymlinks(t *testing.T) {
+	m, usb := setup(t, map[string]string{"wg0.conf": plainConf})
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "evil.conf"), []byte(plainConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wg := filepath.Join(usb, "wireguard")
+	if err := os.Symlink(outside, filepath.Join(wg, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "evil.conf"), filepath.Join(wg, "wg1.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.SyncFromUSB(usb); err != nil {
+		t.Fatalf("SyncFromUSB: %v", err)
+	}
+	for _, name := range []string{"linked/evil.conf", "wg1.conf"} {
+		if _, err := os.Stat(filepath.Join(m.configDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s installed through a symlink: %v", name, err)
+		}
+	}
+}
+
+func TestCopyToUSBProfiles(t *testing.T) {
+	m, usb := setup(t, nil)
+	for _, name := range []string{"wg0.conf", "home/wg1.conf"} {
+		path := filepath.Join(m.configDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(plainConf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.CopyToUSB(usb); err != nil {
+		t.Fatalf("CopyToUSB: %v", err)
+	}
+	for _, name := range []string{"wg0.conf", "home/wg1.conf"} {
+		if data, err := os.ReadFile(filepath.Join(usb, "wireguard", name)); err != nil || string(data) != plainConf {
+			t.Errorf("%s on USB = %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		conf    string
+		wantErr bool
+	}{
+		{"valid", plainConf, false},
+		{"no interface", "[Peer]\nPublicKey = x\n", true},
+		{"empty private key", "[Interface]\nPrivateKey =\n", true},
+		{"garbage line", "[Interface]\nPrivateKey = x\nnot a setting\n", true},
+		{"no peer", "[Interface]\nPrivateKey = " + privKey + "\n", true},
+		{"peer without public key", "[Interface]\nPrivateKey = " + privKey + "\n[Peer]\nEndpoint = a:1\n", true},
+		{"no endpoint", "[Interface]\nPrivateKey = " + privKey + "\n[Peer]\nPublicKey = " + pubKey + "\n", true},
+		{"short key", "[Interface]\nPrivateKey = aGVsbG8=\n[Peer]\nPublicKey = " + pubKey + "\nEndpoint = a:1\n", true},
+		{"not base64", "[Interface]\nPrivateKey = " + privKey + "\n[Peer]\nPublicKey = not-a-key!\nEndpoint = a:1\n", true},
+		{"bad preshared key", plainConf + "PresharedKey = aGVsbG8=\n", true},
+		{"second peer without endpoint", plainConf + "\n[Peer]\nPublicKey = " + pubKey + "\n", false},
+	}
+	for _, c := range cases {
+		if err := validateConfig([]byte(c.conf)); (err != nil) != c.wantErr {
+			t.Errorf("%s: validateConfig err = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+	}
+}
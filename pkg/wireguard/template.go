@@ -0,0 +1,39 @@
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// templateSuffix marks a config on the drive that is rendered with device
+// variables before being installed under its name minus the suffix.
+const templateSuffix = ".tmpl"
+
+// VarsFunc returns the variables available to templates, keyed by the
+// name used in the template ({{.serial}}).
+type VarsFunc func() (map[string]string, error)
+
+// SetTemplateVars configures where template variables come from. Without
+// it, templates can only use literal text.
+func (m *Manager) SetTemplateVars(fn VarsFunc) {
+	m.templateVars = fn
+}
+
+// renderConfig executes a .conf.tmpl. A reference to a variable that has
+// no value is an error rather than an empty string, so a half-rendered
+// config never reaches /data/wireguard.
+func renderConfig(name string, tmpl []byte, vars map[string]string) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(string(tmpl))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("render %s: %w", name, err)
+	}
+	if err := validateConfig(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("rendered %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,98 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// keyLen is the length of a decoded Curve25519 key.
+const keyLen = 32
+
+// validateConfig does the checks wg-quick would fail on, or that would
+// leave the tunnel unable to come up: every non-comment line a section
+// header or key = value, an [Interface] section with a PrivateKey, at
+// least one [Peer], each with a PublicKey, and an Endpoint to connect to.
+// Keys must be base64 of 32 bytes.
+func validateConfig(data []byte) error {
+	var (
+		section      string
+		hasInterface bool
+		hasKey       bool
+		peers        int
+		peerHasKey   bool
+		hasEndpoint  bool
+	)
+	endPeer := func() error {
+		if section == "[Peer]" && !peerHasKey {
+			return fmt.Errorf("[Peer] %d: missing PublicKey", peers)
+		}
+		return nil
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			if err := endPeer(); err != nil {
+				return err
+			}
+			section = line
+			switch section {
+			case "[Interface]":
+				hasInterface = true
+			case "[Peer]":
+				peers++
+				peerHasKey = false
+			}
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || key == "" {
+				return fmt.Errorf("line %d: expected key = value", i+1)
+			}
+			switch {
+			case section == "[Interface]" && key == "PrivateKey",
+				section == "[Peer]" && (key == "PublicKey" || key == "PresharedKey"):
+				if err := checkKey(value); err != nil {
+					return fmt.Errorf("line %d: %s: %w", i+1, key, err)
+				}
+			}
+			switch {
+			case section == "[Interface]" && key == "PrivateKey":
+				hasKey = true
+			case section == "[Peer]" && key == "PublicKey":
+				peerHasKey = true
+			case section == "[Peer]" && key == "Endpoint" && value != "":
+				hasEndpoint = true
+			}
+		}
+	}
+	if err := endPeer(); err != nil {
+		return err
+	}
+	if !hasInterface {
+		return fmt.Errorf("missing [Interface] section")
+	}
+	if !hasKey {
+		return fmt.Errorf("missing PrivateKey")
+	}
+	if peers == 0 {
+		return fmt.Errorf("missing [Peer] section")
+	}
+	if !hasEndpoint {
+		return fmt.Errorf("no [Peer] has an Endpoint")
+	}
+	return nil
+}
+
+func checkKey(value string) error {
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("not valid base64")
+	}
+	if len(key) != keyLen {
+		return fmt.Errorf("%d bytes, want %d", len(key), keyLen)
+	}
+	return nil
+}